@@ -31,6 +31,8 @@ var (
 	metrics  bool
 	config   string
 	clusters clustersFlag
+	keys     string
+	balance  bool
 )
 
 type clustersFlag []string
@@ -61,6 +63,8 @@ func init() {
 	flag.BoolVar(&metrics, "metrics", false, "proxy support prometheus metrics and reuse pprof port.")
 	flag.StringVar(&config, "conf", "", "run with the specific configuration.")
 	flag.Var(&clusters, "cluster", "specify cache cluster configuration.")
+	flag.BoolVar(&balance, "balance", false, "print the share of keyspace of each node against its weight in each cluster and exit.")
+	flag.StringVar(&keys, "keys", "", "print the hash tag, slot and node of comma separated keys in each cluster and exit.")
 }
 
 func main() {
@@ -73,6 +77,16 @@ func main() {
 		parseConfig()
 		os.Exit(0)
 	}
+	if balance {
+		_, ccs := parseConfig()
+		printBalance(ccs)
+		os.Exit(0)
+	}
+	if keys != "" {
+		_, ccs := parseConfig()
+		locateKeys(ccs, strings.Split(keys, ","))
+		os.Exit(0)
+	}
 	c, ccs := parseConfig()
 	if initLog(c) {
 		defer log.Close()
@@ -92,6 +106,13 @@ func main() {
 		panic(err)
 	}
 	defer p.Close()
+	if c.Pprof != "" {
+		http.HandleFunc("/canary", p.HandleCanary)
+		http.HandleFunc("/migration", p.HandleMigration)
+		http.HandleFunc("/reshard", p.HandleReshard)
+		http.HandleFunc("/locate", p.HandleLocate)
+		http.HandleFunc("/balance", p.HandleBalance)
+	}
 	go p.Serve(ccs)
 	// hanlde signal
 	signalHandler()
@@ -156,6 +177,29 @@ func parseConfig() (c *proxy.Config, ccs []*proxy.ClusterConfig) {
 	return
 }
 
+func locateKeys(ccs []*proxy.ClusterConfig, keys []string) {
+	for _, cc := range ccs {
+		for _, loc := range proxy.LocateKeys(cc, keys) {
+			fmt.Printf("cluster:%s key:%s tag:%s slot:%d pool:%s node:%s addr:%s\n", cc.Name, loc.Key, loc.Tag, loc.Slot, loc.Pool, loc.Node, loc.Addr)
+		}
+	}
+}
+
+func printBalance(ccs []*proxy.ClusterConfig) {
+	for _, cc := range ccs {
+		for _, pb := range proxy.BalanceReports(cc) {
+			fmt.Printf("cluster:%s pool:%s distribution:%s", cc.Name, pb.Pool, pb.Distribution)
+			if pb.Points > 0 {
+				fmt.Printf(" points:%d", pb.Points)
+			}
+			fmt.Printf(" stddev:%.4f max:%.4f min:%.4f\n", pb.Stddev, pb.Max, pb.Min)
+			for _, nb := range pb.Nodes {
+				fmt.Printf("\tnode:%s weight:%d expect:%.4f share:%.4f ratio:%.4f\n", nb.Node, nb.Weight, nb.Expect, nb.Share, nb.Ratio)
+			}
+		}
+	}
+}
+
 func signalHandler() {
 	var ch = make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
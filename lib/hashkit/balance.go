@@ -0,0 +1,79 @@
+package hashkit
+
+import (
+	"math"
+)
+
+// NodeBalance is the share of keyspace owned by node against its weight.
+type NodeBalance struct {
+	Node   string `json:"node"`
+	Weight int    `json:"weight"`
+	// Expect is the share by weight, and Share is the share owned by distribution.
+	Expect float64 `json:"expect"`
+	Share  float64 `json:"share"`
+	// Ratio is share over expect, 1 means balanced.
+	Ratio float64 `json:"ratio"`
+}
+
+// BalanceReport is the balance of nodes on ring.
+type BalanceReport struct {
+	Distribution string `json:"distribution"`
+	// Points is the ketama points per server, 0 for the other distributions.
+	Points int            `json:"points,omitempty"`
+	Nodes  []*NodeBalance `json:"nodes"`
+	// Stddev is the standard deviation of ratios, Max and Min are the max and min ratios.
+	Stddev float64 `json:"stddev"`
+	Max    float64 `json:"max"`
+	Min    float64 `json:"min"`
+}
+
+// Balance analyzes the share of keyspace owned by each node against its weight, the ejected nodes
+// are still counted.
+func (h *HashRing) Balance() *BalanceReport {
+	h.lock.Lock()
+	nodes, spots := h.nodes, h.spots
+	h.lock.Unlock()
+	des := h.des
+	if des == "" {
+		des = DistributionKetama
+	}
+	br := &BalanceReport{Distribution: des}
+	if des == DistributionKetama {
+		br.Points = h.points
+	}
+	d, ok := h.dist.Load().(distribution)
+	if !ok || len(nodes) == 0 {
+		return br
+	}
+	shares := d.shares()
+	if h.slots {
+		shares = slotShares(d)
+	}
+	var total int
+	for _, sp := range spots {
+		total += sp
+	}
+	for i, node := range nodes {
+		nb := &NodeBalance{Node: node, Weight: spots[i], Share: shares[node]}
+		if total > 0 {
+			nb.Expect = float64(spots[i]) / float64(total)
+		}
+		if nb.Expect > 0 {
+			nb.Ratio = nb.Share / nb.Expect
+		}
+		br.Nodes = append(br.Nodes, nb)
+	}
+	var sum, sq float64
+	br.Min = math.MaxFloat64
+	for _, nb := range br.Nodes {
+		sum += nb.Ratio
+		br.Max = math.Max(br.Max, nb.Ratio)
+		br.Min = math.Min(br.Min, nb.Ratio)
+	}
+	mean := sum / float64(len(br.Nodes))
+	for _, nb := range br.Nodes {
+		sq += (nb.Ratio - mean) * (nb.Ratio - mean)
+	}
+	br.Stddev = math.Sqrt(sq / float64(len(br.Nodes)))
+	return br
+}
@@ -0,0 +1,93 @@
+package hashkit_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"overlord/lib/hashkit"
+)
+
+func TestBalanceReport(t *testing.T) {
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		if br := r.Balance(); len(br.Nodes) != 0 {
+			t.Errorf("%s: expect no nodes of empty ring but got %v", des, br.Nodes)
+		}
+		r.Init(nodes, sis)
+		br := r.Balance()
+		if br.Distribution != des || len(br.Nodes) != len(nodes) {
+			t.Fatalf("%s: expect report of %d nodes but got %+v", des, len(nodes), br)
+		}
+		if (des == hashkit.DistributionKetama) != (br.Points == 160) {
+			t.Errorf("%s: expect points only reported for ketama but got %d", des, br.Points)
+		}
+		var sum float64
+		for i, nb := range br.Nodes {
+			if nb.Node != nodes[i] || nb.Weight != sis[i] || nb.Expect != float64(sis[i])/9 {
+				t.Errorf("%s: expect node %s weight %d but got %+v", des, nodes[i], sis[i], nb)
+			}
+			if math.Abs(nb.Ratio-1) > 0.2 {
+				t.Errorf("%s: expect node %s balanced but got ratio %v", des, nb.Node, nb.Ratio)
+			}
+			sum += nb.Share
+		}
+		if math.Abs(sum-1) > 1e-6 {
+			t.Errorf("%s: expect shares sum to 1 but got %v", des, sum)
+		}
+		// NOTE: jump and rendezvous are exactly balanced by weights.
+		if br.Min > 1+1e-9 || br.Max < 1-1e-9 || des == hashkit.DistributionKetama && br.Stddev <= 0 {
+			t.Errorf("%s: expect min <= 1 <= max but got %+v", des, br)
+		}
+	}
+}
+
+func TestBalanceOfPoints(t *testing.T) {
+	var (
+		ns    []string
+		spots []int
+	)
+	for i := 0; i < 200; i++ {
+		ns = append(ns, fmt.Sprintf("10.0.%d.%d:6379", i/256, i%256))
+		spots = append(spots, 1)
+	}
+	r := hashkit.NewRing(hashkit.DistributionKetama, hashkit.HashMethodFnv1a)
+	r.Init(ns, spots)
+	def := r.Balance()
+	if def.Points != 160 {
+		t.Errorf("expect default 160 points but got %d", def.Points)
+	}
+	r = hashkit.NewRingWithPoints(hashkit.DistributionKetama, hashkit.HashMethodFnv1a, 1000)
+	r.Init(ns, spots)
+	more := r.Balance()
+	t.Logf("stddev of 160 points %.3f, 1000 points %.3f", def.Stddev, more.Stddev)
+	if more.Points != 1000 || more.Stddev >= def.Stddev {
+		t.Errorf("expect more points more balanced but got %+v", more)
+	}
+}
+
+func TestBalanceOfSlots(t *testing.T) {
+	keys := make([][]byte, hashkit.SlotCount)
+	for i, found := 0, 0; found < hashkit.SlotCount; i++ {
+		key := []byte("key" + fmt.Sprint(i))
+		if s := hashkit.Slot(key); keys[s] == nil {
+			keys[s] = key
+			found++
+		}
+	}
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodCrc16)
+		r.Init(nodes, sis)
+		cnt := map[string]int{}
+		for _, key := range keys {
+			node, _ := r.GetNode(key)
+			cnt[node]++
+		}
+		// NOTE: the shares are the counts of slots owned, since only the hash values of slots are hashed to.
+		for _, nb := range r.Balance().Nodes {
+			if expect := float64(cnt[nb.Node]) / hashkit.SlotCount; math.Abs(nb.Share-expect) > 1e-9 {
+				t.Errorf("%s: expect node %s share %v of slots but got %v", des, nb.Node, expect, nb.Share)
+			}
+		}
+	}
+}
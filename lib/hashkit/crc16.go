@@ -0,0 +1,75 @@
+package hashkit
+
+import (
+	"crypto/md5"
+	"strconv"
+	"sync"
+)
+
+// SlotCount is the count of slots of Redis Cluster.
+const SlotCount = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	for i := range crc16Table {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// Crc16 returns the CRC16/XMODEM checksum of key, which is the one of Redis Cluster.
+func Crc16(key []byte) uint16 {
+	var crc uint16
+	for _, b := range key {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Slot returns the Redis Cluster slot of key.
+// NOTE: the hash tag is not extracted, key must be the part used for hashing.
+func Slot(key []byte) int {
+	return int(Crc16(key) % SlotCount)
+}
+
+var (
+	slotHashes     [SlotCount]uint
+	slotHashesOnce sync.Once
+)
+
+// slotHash hashes key to its slot by crc16 and the slot to ring by md5, so the keys of one slot,
+// e.g. the keys of the same Redis Cluster hash tag, are always on the same node.
+func slotHash(key []byte) uint {
+	initSlotHashes()
+	return slotHashes[Slot(key)]
+}
+
+func initSlotHashes() {
+	slotHashesOnce.Do(func() {
+		for i := range slotHashes {
+			bs := md5.Sum([]byte(strconv.Itoa(i)))
+			slotHashes[i] = uint(bs[3])<<24 | uint(bs[2])<<16 | uint(bs[1])<<8 | uint(bs[0])
+		}
+	})
+}
+
+// slotShares returns the shares of nodes of d by the count of slots, since only the hash values of
+// slots are hashed to by crc16.
+func slotShares(d distribution) map[string]float64 {
+	initSlotHashes()
+	shares := make(map[string]float64)
+	for _, v := range slotHashes {
+		if node, ok := d.getNode(v); ok {
+			shares[node] += 1.0 / SlotCount
+		}
+	}
+	return shares
+}
@@ -0,0 +1,438 @@
+package hashkit
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// distributions of hash ring.
+const (
+	// DistributionKetama maps keys to the points of nodes on ring, it is the default.
+	DistributionKetama = "ketama"
+	// DistributionJump maps keys to the buckets of nodes by jump consistent hash.
+	DistributionJump = "jump"
+	// DistributionRendezvous maps keys to the node of highest weighted random score.
+	DistributionRendezvous = "rendezvous"
+	// DistributionMaglev maps keys to nodes by the lookup table of Maglev.
+	DistributionMaglev = "maglev"
+)
+
+// maglevTableSize is the prime size of maglev lookup table, which is far more than the count of nodes.
+const maglevTableSize = 65537
+
+// distribution maps the hash value of key to nodes.
+type distribution interface {
+	// getNode returns the node of value, false means no node.
+	getNode(value uint) (string, bool)
+	// getNodes returns at most n distinct nodes of value, the first is the result of getNode.
+	getNodes(value uint, n int) []string
+	// without returns the distribution of the nodes not ejected, which the keys of ejected nodes are
+	// rehashed to.
+	without(ejected map[string]bool) distribution
+	// shares returns the share of keyspace owned by each node, the sum is 1.
+	shares() map[string]float64
+}
+
+// newDistribution returns the distribution des of nodes weighted by spots, prev is the distribution
+// before nodes changed, which keeps the keys of unchanged nodes from moving. points is only used by ketama.
+func newDistribution(des string, prev distribution, nodes []string, spots []int, points int) distribution {
+	switch des {
+	case DistributionJump:
+		pj, _ := prev.(*jump)
+		return newJump(pj, nodes, spots)
+	case DistributionRendezvous:
+		return newRendezvous(nodes, spots)
+	case DistributionMaglev:
+		return newMaglev(nodes, spots)
+	default:
+		return newTicks(nodes, spots, points)
+	}
+}
+
+// ValidDistribution reports whether des is a supported distribution, empty means ketama.
+func ValidDistribution(des string) bool {
+	switch des {
+	case "", DistributionKetama, DistributionJump, DistributionRendezvous, DistributionMaglev:
+		return true
+	}
+	return false
+}
+
+// mix64 is the finalizer of murmur3, which spreads the bits of hash value.
+func mix64(v uint64) uint64 {
+	v ^= v >> 33
+	v *= 0xff51afd7ed558ccd
+	v ^= v >> 33
+	v *= 0xc4ceb9fe1a85ec53
+	v ^= v >> 33
+	return v
+}
+
+func nodeSeed(node string) uint64 {
+	bs := md5.Sum([]byte(node))
+	return binary.LittleEndian.Uint64(bs[:8])
+}
+
+// jumpHash returns the bucket of key in [0, n) by jump consistent hash.
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// jump maps keys to buckets by jump consistent hash, each node owns spot buckets.
+// NOTE: the buckets of nodes are fixed by the order of nodes when they are first added, and kept as holes
+// after the nodes are deleted, which are restored to exactly the same nodes when added again. The keys of
+// holes are rehashed to the live buckets, so only the keys of changed nodes move.
+type jump struct {
+	// buckets are the nodes of buckets, empty means hole.
+	buckets []string
+	live    []int
+	// homes are the buckets of nodes, including the deleted ones, whose holes are filled by new nodes
+	// only when there is no free hole.
+	homes map[string][]int
+}
+
+func newJump(prev *jump, nodes []string, spots []int) *jump {
+	want := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		want[node] += spots[i]
+	}
+	j := &jump{homes: make(map[string][]int)}
+	owners := make(map[int]string)
+	size := 0
+	if prev != nil {
+		for node, home := range prev.homes {
+			j.homes[node] = append([]int(nil), home...)
+			for _, b := range home {
+				owners[b] = node
+				if b >= size {
+					size = b + 1
+				}
+			}
+		}
+	}
+	for node, w := range want {
+		if home := j.homes[node]; len(home) > w {
+			for _, b := range home[w:] {
+				delete(owners, b)
+			}
+			j.homes[node] = home[:w]
+		}
+	}
+	free, stolen := 0, 0
+	for _, node := range nodes {
+		for len(j.homes[node]) < want[node] {
+			for free < size && owners[free] != "" {
+				free++
+			}
+			b := free
+			if b == size {
+				// NOTE: the holes of deleted nodes are taken only when no free hole is left.
+				for stolen < size && want[owners[stolen]] > 0 {
+					stolen++
+				}
+				if b = stolen; b == size {
+					size++
+				} else {
+					old := j.homes[owners[b]]
+					for k, ob := range old {
+						if ob == b {
+							j.homes[owners[b]] = append(old[:k:k], old[k+1:]...)
+							break
+						}
+					}
+				}
+			}
+			owners[b] = node
+			j.homes[node] = append(j.homes[node], b)
+		}
+	}
+	for node, home := range j.homes {
+		if len(home) == 0 {
+			delete(j.homes, node)
+		}
+	}
+	j.buckets = make([]string, size)
+	for _, node := range nodes {
+		for _, b := range j.homes[node] {
+			j.buckets[b] = node
+		}
+	}
+	// NOTE: removing the last buckets only moves the keys of them.
+	for len(j.buckets) > 0 && j.buckets[len(j.buckets)-1] == "" {
+		j.buckets = j.buckets[:len(j.buckets)-1]
+	}
+	j.initLive()
+	return j
+}
+
+func (j *jump) initLive() {
+	j.live = j.live[:0]
+	for i, node := range j.buckets {
+		if node != "" {
+			j.live = append(j.live, i)
+		}
+	}
+}
+
+func (j *jump) bucket(value uint) (key uint64, b int) {
+	key = mix64(uint64(value))
+	b = jumpHash(key, len(j.buckets))
+	if j.buckets[b] == "" {
+		key = mix64(key)
+		b = j.live[jumpHash(key, len(j.live))]
+	}
+	return
+}
+
+func (j *jump) getNode(value uint) (string, bool) {
+	if len(j.live) == 0 {
+		return "", false
+	}
+	_, b := j.bucket(value)
+	return j.buckets[b], true
+}
+
+// getNodes returns the node of value and the nodes of the next live buckets.
+func (j *jump) getNodes(value uint, n int) (nodes []string) {
+	if len(j.live) == 0 {
+		return
+	}
+	_, b := j.bucket(value)
+	i := sort.SearchInts(j.live, b)
+	for k := 0; k < len(j.live) && len(nodes) < n; k++ {
+		node := j.buckets[j.live[(i+k)%len(j.live)]]
+		if !containsNode(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
+func (j *jump) without(ejected map[string]bool) distribution {
+	nj := &jump{buckets: make([]string, len(j.buckets)), homes: j.homes}
+	for i, node := range j.buckets {
+		if !ejected[node] {
+			nj.buckets[i] = node
+		}
+	}
+	nj.initLive()
+	return nj
+}
+
+// shares returns the shares of nodes by the count of live buckets, since the keys of holes are
+// rehashed to the live buckets evenly.
+func (j *jump) shares() map[string]float64 {
+	shares := make(map[string]float64)
+	for _, b := range j.live {
+		shares[j.buckets[b]] += 1 / float64(len(j.live))
+	}
+	return shares
+}
+
+// rendezvous maps keys to the node of highest score weighted by spot, so only the keys of changed
+// nodes move.
+type rendezvous struct {
+	names   []string
+	spots   []int
+	seeds   []uint64
+	weights []float64
+}
+
+func newRendezvous(nodes []string, spots []int) *rendezvous {
+	r := &rendezvous{}
+	for i, node := range nodes {
+		if spots[i] <= 0 || containsNode(r.names, node) {
+			continue
+		}
+		r.names = append(r.names, node)
+		r.spots = append(r.spots, spots[i])
+		r.seeds = append(r.seeds, nodeSeed(node))
+		r.weights = append(r.weights, float64(spots[i]))
+	}
+	return r
+}
+
+// score is the weighted score -w/ln(u) of node i, u is uniform in (0, 1) by key and node.
+func (r *rendezvous) score(i int, key uint64) float64 {
+	u := (float64(mix64(key^r.seeds[i])>>11) + 0.5) / (1 << 53)
+	return -r.weights[i] / math.Log(u)
+}
+
+func (r *rendezvous) getNode(value uint) (string, bool) {
+	if len(r.names) == 0 {
+		return "", false
+	}
+	key := mix64(uint64(value))
+	best, bs := 0, r.score(0, key)
+	for i := 1; i < len(r.names); i++ {
+		if s := r.score(i, key); s > bs {
+			best, bs = i, s
+		}
+	}
+	return r.names[best], true
+}
+
+// getNodes returns the n nodes of highest scores.
+func (r *rendezvous) getNodes(value uint, n int) (nodes []string) {
+	if n > len(r.names) {
+		n = len(r.names)
+	}
+	if n <= 0 {
+		return
+	}
+	key := mix64(uint64(value))
+	idxs := make([]int, len(r.names))
+	scores := make([]float64, len(r.names))
+	for i := range r.names {
+		idxs[i] = i
+		scores[i] = r.score(i, key)
+	}
+	sort.Slice(idxs, func(a, b int) bool { return scores[idxs[a]] > scores[idxs[b]] })
+	for _, i := range idxs[:n] {
+		nodes = append(nodes, r.names[i])
+	}
+	return
+}
+
+func (r *rendezvous) without(ejected map[string]bool) distribution {
+	var (
+		nodes []string
+		spots []int
+	)
+	for i, node := range r.names {
+		if !ejected[node] {
+			nodes = append(nodes, node)
+			spots = append(spots, r.spots[i])
+		}
+	}
+	return newRendezvous(nodes, spots)
+}
+
+// shares returns the shares of nodes by weights, which is exact for rendezvous.
+func (r *rendezvous) shares() map[string]float64 {
+	var total float64
+	for _, w := range r.weights {
+		total += w
+	}
+	shares := make(map[string]float64, len(r.names))
+	for i, node := range r.names {
+		shares[node] = r.weights[i] / total
+	}
+	return shares
+}
+
+// maglev maps keys to nodes by the lookup table filled by the preference of nodes in turn, each node
+// fills spot entries in one turn. The preference is decided by the name of node, so few entries of
+// unchanged nodes are moved when nodes changed.
+type maglev struct {
+	names []string
+	spots []int
+	table []int32
+}
+
+func newMaglev(nodes []string, spots []int) *maglev {
+	m := &maglev{}
+	for i, node := range nodes {
+		if spots[i] <= 0 || containsNode(m.names, node) {
+			continue
+		}
+		m.names = append(m.names, node)
+		m.spots = append(m.spots, spots[i])
+	}
+	if len(m.names) == 0 {
+		return m
+	}
+	offsets := make([]uint64, len(m.names))
+	skips := make([]uint64, len(m.names))
+	next := make([]uint64, len(m.names))
+	for i, node := range m.names {
+		seed := nodeSeed(node)
+		offsets[i] = seed % maglevTableSize
+		skips[i] = mix64(seed)%(maglevTableSize-1) + 1
+	}
+	m.table = make([]int32, maglevTableSize)
+	for i := range m.table {
+		m.table[i] = -1
+	}
+	for filled := 0; ; {
+		for i := range m.names {
+			for k := 0; k < m.spots[i]; k++ {
+				c := (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				for m.table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % maglevTableSize
+				}
+				m.table[c] = int32(i)
+				next[i]++
+				if filled++; filled == maglevTableSize {
+					return m
+				}
+			}
+		}
+	}
+}
+
+func (m *maglev) getNode(value uint) (string, bool) {
+	if len(m.names) == 0 {
+		return "", false
+	}
+	return m.names[m.table[mix64(uint64(value))%maglevTableSize]], true
+}
+
+// getNodes returns the node of value and the nodes of the next entries of table.
+func (m *maglev) getNodes(value uint, n int) (nodes []string) {
+	if n > len(m.names) {
+		n = len(m.names)
+	}
+	if n <= 0 {
+		return
+	}
+	c := mix64(uint64(value)) % maglevTableSize
+	for k := uint64(0); k < maglevTableSize && len(nodes) < n; k++ {
+		node := m.names[m.table[(c+k)%maglevTableSize]]
+		if !containsNode(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
+func (m *maglev) without(ejected map[string]bool) distribution {
+	var (
+		nodes []string
+		spots []int
+	)
+	for i, node := range m.names {
+		if !ejected[node] {
+			nodes = append(nodes, node)
+			spots = append(spots, m.spots[i])
+		}
+	}
+	return newMaglev(nodes, spots)
+}
+
+// shares returns the shares of nodes by the entries of table.
+func (m *maglev) shares() map[string]float64 {
+	shares := make(map[string]float64, len(m.names))
+	for _, i := range m.table {
+		shares[m.names[i]] += 1.0 / maglevTableSize
+	}
+	return shares
+}
+
+func containsNode(nodes []string, node string) bool {
+	for _, nd := range nodes {
+		if nd == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package hashkit_test
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+
+	"overlord/lib/hashkit"
+)
+
+var distributions = []string{hashkit.DistributionKetama, hashkit.DistributionJump, hashkit.DistributionRendezvous, hashkit.DistributionMaglev}
+
+func keysOfNodes(r *hashkit.HashRing, cnt int) map[string]string {
+	m := make(map[string]string, cnt)
+	for i := 0; i < cnt; i++ {
+		key := "test value" + strconv.Itoa(i)
+		m[key], _ = r.GetNode([]byte(key))
+	}
+	return m
+}
+
+func TestDistributionGetNodes(t *testing.T) {
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		if _, ok := r.GetNode([]byte("key")); ok {
+			t.Errorf("%s: expect no node of empty ring", des)
+		}
+		r.Init(nodes, sis)
+		for i := 0; i < 1000; i++ {
+			key := []byte("test value" + strconv.Itoa(i))
+			ns := r.GetNodes(key, 3)
+			if len(ns) != 3 || ns[0] == ns[1] || ns[0] == ns[2] || ns[1] == ns[2] {
+				t.Fatalf("%s: expect 3 distinct nodes but got %v", des, ns)
+			}
+			if n, _ := r.GetNode(key); n != ns[0] {
+				t.Fatalf("%s: expect first node %s but got %s", des, n, ns[0])
+			}
+		}
+		if ns := r.GetNodes([]byte("key"), 10); len(ns) != len(nodes) {
+			t.Errorf("%s: expect all %d nodes but got %v", des, len(nodes), ns)
+		}
+	}
+}
+
+func TestDistributionWeights(t *testing.T) {
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		r.Init(nodes, sis)
+		cnt := map[string]int{}
+		for _, node := range keysOfNodes(r, 90000) {
+			cnt[node]++
+		}
+		// NOTE: the spots are 1 1 2 5, so the nodes own 1/9 1/9 2/9 5/9 of keys.
+		for i, node := range nodes {
+			expect := 90000 * sis[i] / 9
+			if math.Abs(float64(cnt[node]-expect)) > float64(expect)*0.2 {
+				t.Errorf("%s: expect node %s owns about %d keys but got %d", des, node, expect, cnt[node])
+			}
+		}
+	}
+}
+
+func TestDistributionMinimalDisruption(t *testing.T) {
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		r.Init(nodes, sis)
+		before := keysOfNodes(r, 10000)
+
+		r.AddNode(node5, 2)
+		added := keysOfNodes(r, 10000)
+		moved, stray := 0, 0
+		for key, node := range before {
+			if added[key] == node {
+				continue
+			}
+			moved++
+			if added[key] != node5 {
+				stray++
+			}
+		}
+		if moved == 0 {
+			t.Errorf("%s: expect keys moved to added node", des)
+		}
+		// NOTE: the points of ketama are reallocated by the total weight and the table of maglev is
+		// refilled, so some keys move between the other nodes.
+		if des == hashkit.DistributionMaglev && stray > moved/20 || (des == hashkit.DistributionJump || des == hashkit.DistributionRendezvous) && stray > 0 {
+			t.Errorf("%s: expect keys only moved to added node but %d of %d moved to the others", des, stray, moved)
+		}
+
+		r.DelNode(nodes[1])
+		deleted := keysOfNodes(r, 10000)
+		kept := 0
+		for key, node := range added {
+			if node == nodes[1] {
+				continue
+			}
+			if deleted[key] == node {
+				kept++
+			}
+		}
+		if total := 10000 - moved; kept < total*95/100 {
+			t.Errorf("%s: expect keys of the other nodes kept after deleting but only %d kept", des, kept)
+		}
+
+		r.DelNode(node5)
+		r.AddNode(nodes[1], sis[1])
+		if des == hashkit.DistributionKetama || des == hashkit.DistributionRendezvous {
+			for key, node := range keysOfNodes(r, 10000) {
+				if before[key] != node {
+					t.Fatalf("%s: expect key %s back to %s but got %s", des, key, before[key], node)
+				}
+			}
+		}
+	}
+}
+
+func TestDistributionEject(t *testing.T) {
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		r.Init(nodes, sis)
+		before := keysOfNodes(r, 10000)
+		r.Eject(nodes[3])
+		for key, node := range keysOfNodes(r, 10000) {
+			if node == nodes[3] || node == "" {
+				t.Fatalf("%s: expect key %s rehashed from ejected node but got %s", des, key, node)
+			}
+			if before[key] != nodes[3] && before[key] != node {
+				t.Fatalf("%s: expect key %s stay on %s but got %s", des, key, before[key], node)
+			}
+		}
+		r.Recover(nodes[3])
+		for key, node := range keysOfNodes(r, 10000) {
+			if before[key] != node {
+				t.Fatalf("%s: expect key %s back to %s but got %s", des, key, before[key], node)
+			}
+		}
+	}
+}
+
+func TestDistributionJumpRestoreBuckets(t *testing.T) {
+	r := hashkit.NewRing(hashkit.DistributionJump, hashkit.HashMethodFnv1a)
+	r.Init(nodes, sis)
+	before := keysOfNodes(r, 10000)
+	// NOTE: the nodes are deleted and added again in the other order, like the flaps by rebuild eject mode.
+	r.DelNode(nodes[0])
+	r.DelNode(nodes[2])
+	r.AddNode(nodes[2], sis[2])
+	r.AddNode(nodes[0], sis[0])
+	for key, node := range keysOfNodes(r, 10000) {
+		if before[key] != node {
+			t.Fatalf("expect key %s back to %s but got %s", key, before[key], node)
+		}
+	}
+
+	// NOTE: the new node fills the holes of deleted node only when no free hole is left.
+	r.DelNode(nodes[1])
+	r.AddNode(node5, 1)
+	for key, node := range keysOfNodes(r, 10000) {
+		if before[key] != nodes[1] && before[key] != node {
+			t.Fatalf("expect key %s stay on %s but got %s", key, before[key], node)
+		}
+	}
+}
+
+// balance returns the max and the standard deviation of keys per node over the mean.
+func balance(des string, nodeCnt, keyCnt int) (max, stddev float64) {
+	var (
+		ns    []string
+		spots []int
+	)
+	for i := 0; i < nodeCnt; i++ {
+		ns = append(ns, fmt.Sprintf("10.0.%d.%d:6379", i/256, i%256))
+		spots = append(spots, 1)
+	}
+	r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+	r.Init(ns, spots)
+	cnt := map[string]int{}
+	for _, node := range keysOfNodes(r, keyCnt) {
+		cnt[node]++
+	}
+	mean := float64(keyCnt) / float64(nodeCnt)
+	for _, node := range ns {
+		d := float64(cnt[node]) - mean
+		stddev += d * d
+		if v := float64(cnt[node]) / mean; v > max {
+			max = v
+		}
+	}
+	stddev = math.Sqrt(stddev/float64(nodeCnt)) / mean
+	return
+}
+
+func TestDistributionBalanceOf200Nodes(t *testing.T) {
+	_, ketama := balance(hashkit.DistributionKetama, 200, 200000)
+	for _, des := range distributions {
+		max, stddev := balance(des, 200, 200000)
+		t.Logf("%s: max %.3f stddev %.3f of mean", des, max, stddev)
+		if des != hashkit.DistributionKetama && stddev >= ketama {
+			t.Errorf("%s: expect lower variance than ketama %.3f but got %.3f", des, ketama, stddev)
+		}
+	}
+}
+
+func BenchmarkDistributionGetNode(b *testing.B) {
+	var (
+		ns    []string
+		spots []int
+	)
+	for i := 0; i < 200; i++ {
+		ns = append(ns, fmt.Sprintf("10.0.%d.%d:6379", i/256, i%256))
+		spots = append(spots, 1)
+	}
+	key := []byte("test value")
+	for _, des := range distributions {
+		r := hashkit.NewRing(des, hashkit.HashMethodFnv1a)
+		r.Init(ns, spots)
+		b.Run(des, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				r.GetNode(key)
+			}
+		})
+	}
+}
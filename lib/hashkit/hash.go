@@ -3,16 +3,32 @@ package hashkit
 // constants defines
 const (
 	HashMethodFnv1a = "fnv1a_64"
+	// HashMethodCrc16 maps keys to the slots of Redis Cluster by crc16 and the slots to ring.
+	HashMethodCrc16 = "crc16"
 )
 
 // NewRing will create new and need init method.
 func NewRing(des, method string) *HashRing {
+	return NewRingWithPoints(des, method, 0)
+}
+
+// NewRingWithPoints new a ring whose ketama points per server is points, 0 means 160 like twemproxy.
+// NOTE: the points are put by groups of 4, so points should be a multiple of 4.
+func NewRingWithPoints(des, method string, points int) *HashRing {
 	var hash func([]byte) uint
 	switch method {
+	case HashMethodCrc16:
+		hash = slotHash
 	case HashMethodFnv1a:
 		fallthrough
 	default:
 		hash = NewFnv1a64().fnv1a64
 	}
-	return newRingWithHash(hash)
+	h := newRingWithHash(hash)
+	h.des = des
+	h.slots = method == HashMethodCrc16
+	if points > 0 {
+		h.points = points
+	}
+	return h
 }
@@ -1,6 +1,7 @@
 package hashkit
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -13,3 +14,39 @@ func TestNewRingOk(t *testing.T) {
 	ring = NewRing("ketama", "fnv1a_64")
 	assert.NotNil(t, ring)
 }
+
+func TestSlotCompatibleWithRedisCluster(t *testing.T) {
+	assert.Equal(t, uint16(0x31c3), Crc16([]byte("123456789")))
+	assert.Equal(t, 12182, Slot([]byte("foo")))
+	assert.Equal(t, 5061, Slot([]byte("bar")))
+	assert.Equal(t, 0, Slot([]byte("")))
+}
+
+func TestRingCrc16SlotOk(t *testing.T) {
+	ring := NewRing("ketama", HashMethodCrc16)
+	ring.Init([]string{"n1", "n2", "n3"}, []int{1, 1, 1})
+	cnt := map[string]int{}
+	for i := 0; i < SlotCount; i++ {
+		node, ok := ring.GetNode([]byte(strconv.Itoa(i)))
+		assert.True(t, ok)
+		cnt[node]++
+	}
+	assert.Len(t, cnt, 3)
+	for _, n := range cnt {
+		assert.True(t, n > SlotCount/6, "expect slots spread among nodes")
+	}
+	// NOTE: the keys of the same slot are always on the same node.
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		var same []byte
+		for j := 0; ; j++ {
+			same = []byte("k" + strconv.Itoa(j))
+			if Slot(same) == Slot(key) {
+				break
+			}
+		}
+		n1, _ := ring.GetNode(key)
+		n2, _ := ring.GetNode(same)
+		assert.Equal(t, n1, n2)
+	}
+}
@@ -30,13 +30,27 @@ func (p *tickArray) Less(i, j int) bool { return p.nodes[i].hash < p.nodes[j].ha
 func (p *tickArray) Swap(i, j int)      { p.nodes[i], p.nodes[j] = p.nodes[j], p.nodes[i] }
 func (p *tickArray) Sort()              { sort.Sort(p) }
 
-// HashRing ketama hash ring.
+// HashRing is the hash ring of nodes, which maps keys to nodes by ketama or the other distributions.
 type HashRing struct {
 	nodes []string
 	spots []int
-	ticks atomic.Value
-	lock  sync.Mutex
-	hash  func([]byte) uint
+	des   string
+	// points is the ketama points per server.
+	points int
+	// dist is the distribution of nodes.
+	dist atomic.Value
+	lock sync.Mutex
+	hash func([]byte) uint
+	// slots is whether keys are hashed to the slots by crc16.
+	slots bool
+	// ejected is the nodes ejected by Eject and the distribution of the others, which the keys of
+	// ejected nodes are rehashed to.
+	ejected atomic.Value
+}
+
+type ejectedDist struct {
+	nodes map[string]bool
+	dist  distribution
 }
 
 // Ketama new a hash ring with ketama consistency.
@@ -44,6 +58,7 @@ type HashRing struct {
 func Ketama() (h *HashRing) {
 	h = new(HashRing)
 	h.hash = NewFnv1a64().fnv1a64
+	h.points = _pointsPerServer
 	return
 }
 
@@ -60,9 +75,19 @@ func (h *HashRing) Init(nodes []string, spots []int) {
 		panic("nodes length not equal spots length")
 	}
 	h.lock.Lock()
+	defer h.lock.Unlock()
 	h.nodes = nodes
 	h.spots = spots
-	h.lock.Unlock()
+	prev, _ := h.dist.Load().(distribution)
+	h.dist.Store(newDistribution(h.des, prev, nodes, spots, h.points))
+	if et, ok := h.ejected.Load().(*ejectedDist); ok {
+		h.eject(et.nodes)
+	}
+}
+
+// newTicks returns the ketama ticks of nodes, each node has points in proportion to its spot, and
+// the average of points is points.
+func newTicks(nodes []string, spots []int, points int) *tickArray {
 	var (
 		ticks          []nodeHash
 		svrn           = len(nodes)
@@ -76,14 +101,14 @@ func (h *HashRing) Init(nodes []string, spots []int) {
 	}
 	for idx, node := range nodes {
 		pct := float64(spots[idx]) / float64(totalw)
-		pointerPerSvr = int((pct*_pointsPerServer/4*float64(svrn) + 0.0000000001) * 4)
+		pointerPerSvr = int((pct*float64(points)/4*float64(svrn) + 0.0000000001) * 4)
 		for pidx := 1; pidx <= pointerPerSvr/pointerPerHash; pidx++ {
 			host := fmt.Sprintf("%s-%d", node, pidx-1)
 			if len(host) > _maxHostLen {
 				host = host[:_maxHostLen]
 			}
 			for x := 0; x < pointerPerHash; x++ {
-				value := h.ketamaHash(host, len(host), x)
+				value := ketamaHash(host, len(host), x)
 				n := &nodeHash{
 					node: node,
 					hash: value,
@@ -95,10 +120,10 @@ func (h *HashRing) Init(nodes []string, spots []int) {
 	}
 	ts := &tickArray{nodes: ticks, length: len(ticks)}
 	ts.Sort()
-	h.ticks.Store(ts)
+	return ts
 }
 
-func (h *HashRing) ketamaHash(key string, kl, alignment int) (v uint) {
+func ketamaHash(key string, kl, alignment int) (v uint) {
 	hs := md5.New()
 	hs.Write([]byte(key))
 	bs := hs.Sum(nil)
@@ -161,17 +186,170 @@ func (h *HashRing) DelNode(n string) {
 	}
 }
 
+// Eject ejects the node by double hashing, unlike DelNode the ring is kept intact and only the keys
+// of node are rehashed to the other nodes by a secondary hash, so the other keys never move.
+func (h *HashRing) Eject(node string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	nodes := map[string]bool{}
+	if et, ok := h.ejected.Load().(*ejectedDist); ok {
+		if et.nodes[node] {
+			return
+		}
+		for nd := range et.nodes {
+			nodes[nd] = true
+		}
+	}
+	nodes[node] = true
+	log.Info("ketama eject node ", node)
+	h.eject(nodes)
+}
+
+// Recover recovers the node ejected by Eject, whose keys are hashed back to it.
+func (h *HashRing) Recover(node string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	et, ok := h.ejected.Load().(*ejectedDist)
+	if !ok || !et.nodes[node] {
+		return
+	}
+	nodes := map[string]bool{}
+	for nd := range et.nodes {
+		if nd != node {
+			nodes[nd] = true
+		}
+	}
+	log.Info("ketama recover node ", node)
+	h.eject(nodes)
+}
+
+// eject stores the ejected nodes and the distribution of the others, the lock must be held.
+func (h *HashRing) eject(nodes map[string]bool) {
+	et := &ejectedDist{nodes: nodes}
+	if d, ok := h.dist.Load().(distribution); ok {
+		et.dist = d.without(nodes)
+	}
+	h.ejected.Store(et)
+}
+
+// ejectedNodes returns the ejected nodes and the distribution of the others, nil means no node is ejected.
+func (h *HashRing) ejectedNodes() *ejectedDist {
+	if et, ok := h.ejected.Load().(*ejectedDist); ok && len(et.nodes) > 0 && et.dist != nil {
+		return et
+	}
+	return nil
+}
+
+// rehash returns the secondary hash of value, which rehashes the keys of ejected nodes.
+func (h *HashRing) rehash(value uint) uint {
+	var bs [8]byte
+	for i := range bs {
+		bs[i] = byte(value >> (8 * uint(i)))
+	}
+	return h.hash(bs[:])
+}
+
+func search(ts *tickArray, value uint) int {
+	i := sort.Search(ts.length, func(i int) bool { return ts.nodes[i].hash >= value })
+	if i == ts.length {
+		i = 0
+	}
+	return i
+}
+
 // GetNode returns result node by given key.
 func (h *HashRing) GetNode(key []byte) (string, bool) {
-	ts, ok := h.ticks.Load().(*tickArray)
-	if !ok || ts.length == 0 {
+	d, ok := h.dist.Load().(distribution)
+	if !ok {
 		return "", false
 	}
 	value := h.hash(key)
+	node, ok := d.getNode(value)
+	if !ok {
+		return "", false
+	}
+	if et := h.ejectedNodes(); et != nil && et.nodes[node] {
+		return et.dist.getNode(h.rehash(value))
+	}
+	return node, true
+}
 
-	i := sort.Search(ts.length, func(i int) bool { return ts.nodes[i].hash >= value })
-	if i == ts.length {
-		i = 0
+// GetNodes returns at most n distinct nodes by given key, the first is the result of GetNode
+// and the others are the next nodes of distribution, e.g. clockwise on the ring of ketama.
+func (h *HashRing) GetNodes(key []byte, n int) (nodes []string) {
+	d, ok := h.dist.Load().(distribution)
+	if !ok || n <= 0 {
+		return
+	}
+	value := h.hash(key)
+	et := h.ejectedNodes()
+	if et == nil {
+		return d.getNodes(value, n)
+	}
+	// NOTE: the first node is the rehashed one when the node of key is ejected.
+	first, ok := h.GetNode(key)
+	if !ok {
+		return
+	}
+	nodes = append(nodes, first)
+	for _, node := range d.getNodes(value, n+len(et.nodes)+1) {
+		if len(nodes) == n {
+			break
+		}
+		if !et.nodes[node] && node != first {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
+// getNode returns the node of the first tick not less than value.
+func (p *tickArray) getNode(value uint) (string, bool) {
+	if p.length == 0 {
+		return "", false
+	}
+	return p.nodes[search(p, value)].node, true
+}
+
+// getNodes returns at most n distinct nodes clockwise from the tick of value.
+func (p *tickArray) getNodes(value uint, n int) (nodes []string) {
+	if p.length == 0 {
+		return
+	}
+	i := search(p, value)
+	for j := 0; j < p.length && len(nodes) < n; j++ {
+		if node := p.nodes[(i+j)%p.length].node; !containsNode(nodes, node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return
+}
+
+// without returns the ticks of the nodes not ejected, so only the keys of ejected nodes move.
+func (p *tickArray) without(ejected map[string]bool) distribution {
+	ts := &tickArray{}
+	for _, nh := range p.nodes[:p.length] {
+		if !ejected[nh.node] {
+			ts.nodes = append(ts.nodes, nh)
+		}
+	}
+	ts.length = len(ts.nodes)
+	return ts
+}
+
+// shares returns the shares of nodes by the arcs of ticks, the values between the previous tick
+// and a tick belong to the node of the tick.
+// NOTE: the hash values of keys are 32 bits, the shares of crc16 are counted by slots, see slotShares.
+func (p *tickArray) shares() map[string]float64 {
+	shares := make(map[string]float64)
+	if p.length == 0 {
+		return shares
+	}
+	const space = float64(1 << 32)
+	prev := float64(p.nodes[p.length-1].hash) - space
+	for _, nh := range p.nodes[:p.length] {
+		shares[nh.node] += (float64(nh.hash) - prev) / space
+		prev = float64(nh.hash)
 	}
-	return ts.nodes[i].node, true
+	return shares
 }
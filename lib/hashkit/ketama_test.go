@@ -74,3 +74,71 @@ func testHash(t *testing.T) {
 	}
 	t.Log(node5, m[node5])
 }
+
+func TestGetNodes(t *testing.T) {
+	r := hashkit.Ketama()
+	if nodes := r.GetNodes([]byte("key"), 2); len(nodes) != 0 {
+		t.Errorf("expect no nodes of empty ring but got %v", nodes)
+	}
+	r.Init(nodes, sis)
+	for i := 0; i < 1000; i++ {
+		key := []byte("test value" + strconv.Itoa(i))
+		ns := r.GetNodes(key, 3)
+		if len(ns) != 3 || ns[0] == ns[1] || ns[0] == ns[2] || ns[1] == ns[2] {
+			t.Fatalf("expect 3 distinct nodes but got %v", ns)
+		}
+		if n, _ := r.GetNode(key); n != ns[0] {
+			t.Fatalf("expect first node %s but got %s", n, ns[0])
+		}
+	}
+	if ns := r.GetNodes([]byte("key"), 10); len(ns) != len(nodes) {
+		t.Errorf("expect all %d nodes but got %v", len(nodes), ns)
+	}
+}
+
+func TestEjectByDoubleHashing(t *testing.T) {
+	r := hashkit.Ketama()
+	r.Init(nodes, sis)
+	before := map[string]string{}
+	for i := 0; i < 10000; i++ {
+		key := "test value" + strconv.Itoa(i)
+		before[key], _ = r.GetNode([]byte(key))
+	}
+	r.Eject(nodes[3])
+	r.Eject(nodes[3])
+	moved := map[string]int{}
+	for key, node := range before {
+		n, ok := r.GetNode([]byte(key))
+		if !ok {
+			t.Fatalf("expect node of key %s", key)
+		}
+		if node != nodes[3] {
+			if n != node {
+				t.Fatalf("expect key %s stay on %s but got %s", key, node, n)
+			}
+			continue
+		}
+		if n == nodes[3] {
+			t.Fatalf("expect key %s rehashed from ejected node", key)
+		}
+		if ns := r.GetNodes([]byte(key), 2); len(ns) != 2 || ns[0] != n || ns[1] == nodes[3] {
+			t.Fatalf("expect nodes of key %s start with %s and skip ejected but got %v", key, n, ns)
+		}
+		moved[n]++
+	}
+	if len(moved) != 3 {
+		t.Errorf("expect keys of ejected node rehashed to all the others but got %v", moved)
+	}
+	r.Recover(nodes[3])
+	for key, node := range before {
+		if n, _ := r.GetNode([]byte(key)); n != node {
+			t.Fatalf("expect key %s back to %s but got %s", key, node, n)
+		}
+	}
+	for _, node := range nodes {
+		r.Eject(node)
+	}
+	if _, ok := r.GetNode([]byte("key")); ok {
+		t.Error("expect no node when all nodes ejected")
+	}
+}
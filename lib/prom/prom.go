@@ -13,8 +13,18 @@ const (
 	statHit   = "overlord_proxy_hit"
 	statMiss  = "overlord_proxy_miss"
 
+	statFailover  = "overlord_proxy_failover"
+	statShadow    = "overlord_proxy_shadow"
+	statMigrate   = "overlord_proxy_migrate"
+	statReshard   = "overlord_proxy_reshard"
+	statReplica   = "overlord_proxy_replica"
+	statBroadcast = "overlord_proxy_broadcast"
+	statQuorum    = "overlord_proxy_quorum"
+	statHedge     = "overlord_proxy_hedge"
+
 	statProxyTimer   = "overlord_proxy_timer"
 	statHandlerTimer = "overlord_proxy_handler_timer"
+	statShadowTimer  = "overlord_proxy_shadow_timer"
 )
 
 var (
@@ -22,6 +32,15 @@ var (
 	gerr         *prometheus.GaugeVec
 	hit          *prometheus.CounterVec
 	miss         *prometheus.CounterVec
+	failover     *prometheus.CounterVec
+	shadow       *prometheus.CounterVec
+	shadowTimer  *prometheus.HistogramVec
+	migrate      *prometheus.CounterVec
+	reshard      *prometheus.CounterVec
+	replica      *prometheus.CounterVec
+	broadcast    *prometheus.CounterVec
+	quorum       *prometheus.CounterVec
+	hedge        *prometheus.CounterVec
 	proxyTimer   *prometheus.HistogramVec
 	handlerTimer *prometheus.HistogramVec
 
@@ -30,6 +49,9 @@ var (
 	clusterNodeErrLabels = []string{"cluster", "node", "cmd", "error"}
 	clusterCmdLabels     = []string{"cluster", "cmd"}
 	clusterNodeCmdLabels = []string{"cluster", "node", "cmd"}
+	clusterRouteLabels   = []string{"cluster", "route", "cmd"}
+	clusterResultLabels  = []string{"cluster", "cmd", "result"}
+	clusterNodeResLabels = []string{"cluster", "node", "result"}
 	// On Prom switch
 	On = true
 )
@@ -60,6 +82,61 @@ func Init() {
 			Help: statMiss,
 		}, clusterNodeLabels)
 	prometheus.MustRegister(miss)
+	failover = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statFailover,
+			Help: statFailover,
+		}, clusterRouteLabels)
+	prometheus.MustRegister(failover)
+	shadow = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statShadow,
+			Help: statShadow,
+		}, clusterResultLabels)
+	prometheus.MustRegister(shadow)
+	shadowTimer = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    statShadowTimer,
+			Help:    statShadowTimer,
+			Buckets: prometheus.LinearBuckets(0, 10, 1),
+		}, clusterCmdLabels)
+	prometheus.MustRegister(shadowTimer)
+	migrate = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statMigrate,
+			Help: statMigrate,
+		}, clusterResultLabels)
+	prometheus.MustRegister(migrate)
+	reshard = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statReshard,
+			Help: statReshard,
+		}, clusterNodeResLabels)
+	prometheus.MustRegister(reshard)
+	replica = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statReplica,
+			Help: statReplica,
+		}, clusterResultLabels)
+	prometheus.MustRegister(replica)
+	broadcast = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statBroadcast,
+			Help: statBroadcast,
+		}, clusterResultLabels)
+	prometheus.MustRegister(broadcast)
+	quorum = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statQuorum,
+			Help: statQuorum,
+		}, clusterResultLabels)
+	prometheus.MustRegister(quorum)
+	hedge = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: statHedge,
+			Help: statHedge,
+		}, clusterResultLabels)
+	prometheus.MustRegister(hedge)
 	proxyTimer = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    statProxyTimer,
@@ -140,3 +217,75 @@ func Miss(cluster, node string) {
 	}
 	miss.WithLabelValues(cluster, node).Inc()
 }
+
+// Failover increments one stat failover counter.
+func Failover(cluster, route, cmd string) {
+	if failover == nil {
+		return
+	}
+	failover.WithLabelValues(cluster, route, cmd).Inc()
+}
+
+// Shadow increments one stat shadow counter by result compared with primary.
+func Shadow(cluster, cmd, result string) {
+	if shadow == nil {
+		return
+	}
+	shadow.WithLabelValues(cluster, cmd, result).Inc()
+}
+
+// ShadowTime log timing information of shadow (in milliseconds).
+func ShadowTime(cluster, cmd string, ts int64) {
+	if shadowTimer == nil {
+		return
+	}
+	shadowTimer.WithLabelValues(cluster, cmd).Observe(float64(ts))
+}
+
+// Migrate increments one stat migrate counter by result of write copy or read fallback.
+func Migrate(cluster, cmd, result string) {
+	if migrate == nil {
+		return
+	}
+	migrate.WithLabelValues(cluster, cmd, result).Inc()
+}
+
+// Reshard adds n to stat reshard counter of node by result of scanned, moved or failed keys.
+func Reshard(cluster, node, result string, n int) {
+	if reshard == nil {
+		return
+	}
+	reshard.WithLabelValues(cluster, node, result).Add(float64(n))
+}
+
+// Replica increments one stat replica counter by result of write copy.
+func Replica(cluster, cmd, result string) {
+	if replica == nil {
+		return
+	}
+	replica.WithLabelValues(cluster, cmd, result).Inc()
+}
+
+// Broadcast increments one stat broadcast counter by result of write.
+func Broadcast(cluster, cmd, result string) {
+	if broadcast == nil {
+		return
+	}
+	broadcast.WithLabelValues(cluster, cmd, result).Inc()
+}
+
+// Quorum increments one stat quorum counter by result of quorum read, e.g. the diverged copies.
+func Quorum(cluster, cmd, result string) {
+	if quorum == nil {
+		return
+	}
+	quorum.WithLabelValues(cluster, cmd, result).Inc()
+}
+
+// Hedge increments one stat hedge counter by result of sent or won hedge.
+func Hedge(cluster, cmd, result string) {
+	if hedge == nil {
+		return
+	}
+	hedge.WithLabelValues(cluster, cmd, result).Inc()
+}
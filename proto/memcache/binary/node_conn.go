@@ -56,22 +56,22 @@ func (n *nodeConn) Ping() (err error) {
 }
 
 func (n *nodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
-	var (
-		m   *proto.Message
-		idx int
-	)
-	for {
-		m = mb.Nth(idx)
-		if m == nil {
-			break
+	msgs := mb.Msgs()
+	for i := 0; i < len(msgs); {
+		gn := groupLen(msgs, i)
+		if gn == 1 {
+			err = n.write(msgs[i])
+		} else {
+			err = n.writeGroup(msgs[i : i+gn])
 		}
-		err = n.write(m)
 		if err != nil {
-			m.DoneWithError(err)
+			msgs[i].DoneWithError(err)
 			return err
 		}
-		m.MarkWrite()
-		idx++
+		for _, m := range msgs[i : i+gn] {
+			m.MarkWrite()
+		}
+		i += gn
 	}
 	if err = n.bw.Flush(); err != nil {
 		err = errors.Wrap(err, "MC Writer flush message bytes")
@@ -89,12 +89,29 @@ func (n *nodeConn) write(m *proto.Message) (err error) {
 		err = errors.Wrap(ErrAssertReq, "MC Writer assert request")
 		return
 	}
-	_ = n.bw.Write(magicReqBytes)
-
 	cmd := mcr.rTp
 	if cmd == RequestTypeGetQ || cmd == RequestTypeGetKQ {
 		cmd = RequestTypeGetK
 	}
+	n.writeRequest(cmd, mcr)
+	return
+}
+
+// writeGroup writes the get requests split from the same msg as GetKQ and ends with Noop,
+// the node replies the hits only and the Noop reply ends the group.
+func (n *nodeConn) writeGroup(msgs []*proto.Message) (err error) {
+	if n.Closed() {
+		err = errors.Wrap(ErrClosed, "MC Writer write")
+		return
+	}
+	for _, m := range msgs {
+		n.writeRequest(RequestTypeGetKQ, m.Request().(*MCRequest))
+	}
+	return n.bw.Write(pingBs)
+}
+
+func (n *nodeConn) writeRequest(cmd RequestType, mcr *MCRequest) {
+	_ = n.bw.Write(magicReqBytes)
 	_ = n.bw.Write(cmd.Bytes())
 	_ = n.bw.Write(mcr.keyLen)
 	_ = n.bw.Write(mcr.extraLen)
@@ -106,7 +123,30 @@ func (n *nodeConn) write(m *proto.Message) (err error) {
 	if !bytes.Equal(mcr.bodyLen, zeroFourBytes) {
 		_ = n.bw.Write(mcr.data)
 	}
-	return
+}
+
+// groupLen returns the count of get msgs from i which are split from the same msg.
+func groupLen(msgs []*proto.Message, i int) int {
+	parent := msgs[i].Parent()
+	if parent == nil {
+		return 1
+	}
+	n := 0
+	for i+n < len(msgs) && msgs[i+n].Parent() == parent {
+		mcr, ok := msgs[i+n].Request().(*MCRequest)
+		if !ok || !isGet(mcr.rTp) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+func isGet(rTp RequestType) bool {
+	return rTp == RequestTypeGet || rTp == RequestTypeGetQ || rTp == RequestTypeGetK || rTp == RequestTypeGetKQ
 }
 
 func (n *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
@@ -119,48 +159,101 @@ func (n *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 	var (
 		size   int
 		cursor int
-		nth    int
-		m      *proto.Message
-
-		mcr *MCRequest
-		ok  bool
+		msgs   = mb.Msgs()
 	)
-	m = mb.Nth(nth)
-	mcr, ok = m.Request().(*MCRequest)
-	if !ok {
-		err = errors.Wrap(ErrAssertReq, "MC Reader assert request")
-		return
-	}
-	for {
-		err = n.br.Read()
-		if err != nil {
-			err = errors.Wrap(err, "MC Reader while read")
-			return
-		}
-		for {
+	for i := 0; i < len(msgs); {
+		gn := groupLen(msgs, i)
+		if gn == 1 {
+			mcr, ok := msgs[i].Request().(*MCRequest)
+			if !ok {
+				err = errors.Wrap(ErrAssertReq, "MC Reader assert request")
+				return
+			}
 			size, err = n.fillMCRequest(mcr, n.br.Buffer().Bytes()[cursor:])
-			if err == bufio.ErrBufferFull {
-				err = nil
-				break
-			} else if err != nil {
+		} else {
+			size, err = n.fillGroup(msgs[i:i+gn], n.br.Buffer().Bytes()[cursor:])
+		}
+		if err == bufio.ErrBufferFull {
+			if err = n.br.Read(); err != nil {
+				err = errors.Wrap(err, "MC Reader while read")
 				return
 			}
+			continue
+		} else if err != nil {
+			return
+		}
+		for _, m := range msgs[i : i+gn] {
 			m.MarkRead()
+		}
+		cursor += size
+		i += gn
+	}
+	return
+}
 
-			cursor += size
-			nth++
-
-			m = mb.Nth(nth)
-			if m == nil {
-				return
+// fillGroup reads the replies of grouped GetKQ until the Noop reply, each hit is set back
+// to the request of its key in order and the missed ones are set not found.
+func (n *nodeConn) fillGroup(msgs []*proto.Message, data []byte) (size int, err error) {
+	var next int
+	for {
+		if len(data[size:]) < requestHeaderLen {
+			return 0, bufio.ErrBufferFull
+		}
+		head := data[size : size+requestHeaderLen]
+		bl := int(binary.BigEndian.Uint32(head[8:12]))
+		end := size + requestHeaderLen + bl
+		if len(data) < end {
+			return 0, bufio.ErrBufferFull
+		}
+		if RequestType(head[1]) == RequestTypeNoop {
+			for ; next < len(msgs); next++ {
+				notFound(msgs[next].Request().(*MCRequest))
 			}
-			mcr, ok = m.Request().(*MCRequest)
-			if !ok {
-				err = errors.Wrap(ErrAssertReq, "MC Reader assert request")
-				return
+			size = end
+			return
+		}
+		body := data[size+requestHeaderLen : end]
+		el := int(head[4])
+		kl := int(binary.BigEndian.Uint16(head[2:4]))
+		if el+kl > len(body) {
+			err = errors.Wrap(ErrBadResponse, "MC Reader group reply key")
+			return
+		}
+		key := body[el : el+kl]
+		errStatus := binary.BigEndian.Uint16(head[6:8]) != ResponseStatusNoErr
+		for ; next < len(msgs); next++ {
+			mcr := msgs[next].Request().(*MCRequest)
+			// NOTE: error reply may carry no key, it belongs to the first unreplied request.
+			if errStatus || bytes.Equal(mcr.key, key) {
+				parseHeader(head, mcr, false)
+				mcr.data = body
+				next++
+				if !errStatus && prom.On {
+					prom.Hit(n.cluster, n.addr)
+				}
+				break
 			}
+			notFound(mcr)
 		}
+		size = end
+	}
+}
+
+// notFound sets the reply of missed key same as the node replies to not quiet get,
+// GetQ and GetKQ are written as GetK when not grouped, so they carry the key too.
+func notFound(mcr *MCRequest) {
+	mcr.status = keyNotFoundBytes
+	mcr.extraLen = zeroBytes
+	mcr.cas = zeroEightBytes
+	body := notFoundBytes
+	if mcr.rTp == RequestTypeGet {
+		mcr.keyLen = zeroTwoBytes
+	} else {
+		body = mcr.key
 	}
+	mcr.bodyLen = make([]byte, 4)
+	binary.BigEndian.PutUint32(mcr.bodyLen, uint32(len(body)))
+	mcr.data = body
 }
 
 func (n *nodeConn) fillMCRequest(mcr *MCRequest, data []byte) (size int, err error) {
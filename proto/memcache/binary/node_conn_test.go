@@ -142,6 +142,18 @@ func (*mockReq) Resp() []byte {
 	return nil
 }
 
+func (*mockReq) IsRead() bool {
+	return false
+}
+
+func (*mockReq) IsMiss() bool {
+	return false
+}
+
+func (m *mockReq) Clone() proto.Request {
+	return m
+}
+
 func (*mockReq) Put() {
 
 }
@@ -260,3 +272,45 @@ func TestNewNodeConnWithClosedBinder(t *testing.T) {
 	nc := NewNodeConn("anyName", addr.String(), time.Second, time.Second, time.Second)
 	assert.NotNil(t, nc)
 }
+
+func TestNodeConnGroupGetOk(t *testing.T) {
+	getKQ := func(cmd byte, key string) []byte {
+		bs := []byte{0x80, cmd, 0x00, byte(len(key)), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(len(key)),
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+		return append(bs, key...)
+	}
+	var req []byte
+	req = append(req, getKQ(0x0d, "ABC")...)
+	req = append(req, getKQ(0x0d, "XYZ")...)
+	req = append(req, getKQ(0x0c, "abc")...)
+	p := NewProxyConn(_createConn(req))
+	msgs, err := p.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	mb := proto.NewMsgBatch()
+	for _, sub := range msgs[0].Batch() {
+		mb.AddMsg(sub)
+	}
+	resp := append([]byte{}, getQRespTestData[1]...)
+	resp = append(resp, pongBs...)
+	nc := _createNodeConn(resp)
+	assert.NoError(t, nc.WriteBatch(mb))
+	wbuf := make([]byte, 1024)
+	size, err := nc.conn.Conn.(*mockConn).wbuf.Read(wbuf)
+	assert.NoError(t, err)
+	except := append(getKQ(0x0d, "ABC"), getKQ(0x0d, "XYZ")...)
+	except = append(except, getKQ(0x0d, "abc")...)
+	assert.Equal(t, append(except, pingBs...), wbuf[:size])
+	assert.NoError(t, nc.ReadBatch(mb))
+
+	subs := mb.Msgs()
+	for i, key := range []string{"ABC", "XYZ", "abc"} {
+		mcr := subs[i].Request().(*MCRequest)
+		if key == "XYZ" {
+			assert.Equal(t, []byte{0x00, 0x00}, mcr.status)
+			assert.Equal(t, "VWXYZ", string(mcr.data[4+3:]))
+			continue
+		}
+		assert.Equal(t, keyNotFoundBytes, mcr.status)
+		assert.Equal(t, key, string(mcr.data))
+	}
+}
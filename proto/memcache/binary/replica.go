@@ -0,0 +1,61 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"overlord/proto"
+)
+
+// ReplicaRequest returns the request applying the write req to the other replicas of its key, which owns
+// its data. The cas unique is local to each node, so the storage with cas is copied without cas, which is
+// deferred until req is stored on its node. The results of incr, decr, append and prepend depend on the
+// value of each node, so they are translated into delete, and the replicas miss the key until it is written
+// again. The other writes are copied.
+func ReplicaRequest(req proto.Request) (rr proto.Request, deferred bool) {
+	mcr := req.(*MCRequest)
+	switch mcr.rTp {
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace:
+		if len(mcr.cas) != 0 && !bytes.Equal(mcr.cas, zeroEightBytes) {
+			n := mcr.Clone().(*MCRequest)
+			n.cas = zeroEightBytes
+			return n, true
+		}
+	case RequestTypeIncr, RequestTypeDecr, RequestTypeAppend, RequestTypePrepend:
+		return deleteRequest(mcr.key), false
+	}
+	return mcr.Clone(), false
+}
+
+// Stored reports whether the storage request req is replied with no error.
+func Stored(req proto.Request) bool {
+	mcr, ok := req.(*MCRequest)
+	return ok && len(mcr.status) == 2 && binary.BigEndian.Uint16(mcr.status) == ResponseStatusNoErr
+}
+
+// SameReply reports whether the requests a and b are replied the same, the cas of replies is ignored.
+func SameReply(a, b proto.Request) bool {
+	ma, ok := a.(*MCRequest)
+	if !ok {
+		return false
+	}
+	mb, ok := b.(*MCRequest)
+	return ok && bytes.Equal(ma.status, mb.status) && bytes.Equal(ma.data, mb.data)
+}
+
+// deleteRequest returns the delete request of key, which owns a copy of key.
+func deleteRequest(key []byte) *MCRequest {
+	del := GetReq()
+	del.magic = magicReq
+	del.rTp = RequestTypeDelete
+	del.key = append([]byte(nil), key...)
+	del.keyLen = make([]byte, 2)
+	binary.BigEndian.PutUint16(del.keyLen, uint16(len(key)))
+	del.extraLen = zeroBytes
+	del.bodyLen = make([]byte, 4)
+	binary.BigEndian.PutUint32(del.bodyLen, uint32(len(key)))
+	del.opaque = zeroFourBytes
+	del.cas = zeroEightBytes
+	del.data = del.key
+	return del
+}
@@ -1,9 +1,12 @@
 package binary
 
 import (
+	"bytes"
 	errs "errors"
 	"fmt"
 	"sync"
+
+	"overlord/proto"
 )
 
 const (
@@ -233,6 +236,8 @@ const (
 
 var (
 	resopnseStatusInternalErrBytes = []byte{0x00, 0x84}
+	keyNotFoundBytes               = []byte{0x00, 0x01}
+	notFoundBytes                  = []byte("Not found")
 )
 
 // errors
@@ -258,6 +263,7 @@ var (
 	ErrPingerPong  = errs.New("SERVER_ERROR Pinger pong unexpected")
 	ErrAssertReq   = errs.New("SERVER_ERROR assert request not ok")
 	ErrBadResponse = errs.New("SERVER_ERROR bad response")
+	ErrTranslate   = errs.New("SERVER_ERROR command can not be translated to node protocol")
 )
 
 // MCRequest is the mc client Msg type and data.
@@ -322,6 +328,36 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
+// Clone returns a copy of the request which owns its data.
+func (r *MCRequest) Clone() proto.Request {
+	n := GetReq()
+	n.magic = r.magic
+	n.rTp = r.rTp
+	n.keyLen = append([]byte(nil), r.keyLen...)
+	n.extraLen = append([]byte(nil), r.extraLen...)
+	n.status = append([]byte(nil), r.status...)
+	n.bodyLen = append([]byte(nil), r.bodyLen...)
+	n.opaque = append([]byte(nil), r.opaque...)
+	n.cas = append([]byte(nil), r.cas...)
+	n.key = append([]byte(nil), r.key...)
+	n.data = append([]byte(nil), r.data...)
+	return n
+}
+
+// IsRead reports whether the request only reads.
+func (r *MCRequest) IsRead() bool {
+	switch r.rTp {
+	case RequestTypeGet, RequestTypeGetQ, RequestTypeGetK, RequestTypeGetKQ, RequestTypeNoop:
+		return true
+	}
+	return false
+}
+
+// IsMiss reports whether the get request is replied with key not found.
+func (r *MCRequest) IsMiss() bool {
+	return r.IsRead() && r.rTp != RequestTypeNoop && bytes.Equal(r.status, keyNotFoundBytes)
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.rTp.String(), r.key, r.data)
 }
@@ -0,0 +1,368 @@
+package binary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"overlord/lib/bufio"
+	libnet "overlord/lib/net"
+	"overlord/proto"
+
+	"github.com/pkg/errors"
+)
+
+// memcached protocol: https://github.com/memcached/memcached/blob/master/doc/protocol.txt
+var (
+	textCrlfBytes  = []byte("\r\n")
+	textSpaceBytes = []byte(" ")
+	textValueBytes = []byte("VALUE ")
+	textEndBytes   = []byte("END\r\n")
+
+	textStoredBytes     = []byte("STORED\r\n")
+	textNotStoredBytes  = []byte("NOT_STORED\r\n")
+	textExistsBytes     = []byte("EXISTS\r\n")
+	textNotFoundBytes   = []byte("NOT_FOUND\r\n")
+	textDeletedBytes    = []byte("DELETED\r\n")
+	textTouchedBytes    = []byte("TOUCHED\r\n")
+	textErrorBytes      = []byte("ERROR")
+	textClientErrBytes  = []byte("CLIENT_ERROR ")
+	textNonNumericBytes = []byte("CLIENT_ERROR cannot increment or decrement non-numeric value")
+
+	textPingBytes = []byte("set _ping 0 0 4\r\npong\r\n")
+
+	notFoundBody = []byte("Not found")
+)
+
+// textNodeConn speaks the text protocol with node for binary protocol clients.
+// Requests are translated into text commands and replies back into binary
+// response fields, so the proxyConn of binary protocol never knows about it.
+type textNodeConn struct {
+	cluster string
+	addr    string
+	conn    *libnet.Conn
+	bw      *bufio.Writer
+	br      *bufio.Reader
+	pr      *bufio.Reader
+	closed  int32
+}
+
+// NewTextNodeConn returns node conn which translates binary requests into text protocol.
+func NewTextNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	nc = newTextNodeConn(cluster, addr, conn)
+	return
+}
+
+func newTextNodeConn(cluster, addr string, conn *libnet.Conn) *textNodeConn {
+	return &textNodeConn{
+		cluster: cluster,
+		addr:    addr,
+		conn:    conn,
+		bw:      bufio.NewWriter(conn),
+		br:      bufio.NewReader(conn, nil),
+		pr:      bufio.NewReader(conn, bufio.NewBuffer(pingBufferSize)),
+	}
+}
+
+// Ping stores the _ping key by checking mc node is alive.
+func (n *textNodeConn) Ping() (err error) {
+	if n.Closed() {
+		err = io.EOF
+		return
+	}
+	_ = n.bw.Write(textPingBytes)
+	if err = n.bw.Flush(); err != nil {
+		err = errors.Wrap(err, "MC text ping flush")
+		return
+	}
+	var line []byte
+	for {
+		if line, err = n.pr.ReadLine(); err == bufio.ErrBufferFull {
+			if err = n.pr.Read(); err != nil {
+				err = errors.Wrap(err, "MC text ping read response")
+				return
+			}
+			continue
+		} else if err != nil {
+			return
+		}
+		break
+	}
+	if !bytes.Equal(line, textStoredBytes) {
+		err = ErrPingerPong
+	}
+	return
+}
+
+func (n *textNodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
+	for _, m := range mb.Msgs() {
+		if n.Closed() {
+			err = errors.Wrap(ErrClosed, "MC text Writer conn closed")
+			return
+		}
+		mcr, ok := m.Request().(*MCRequest)
+		if !ok {
+			err = errors.Wrap(ErrAssertReq, "MC text Writer assert request")
+			m.DoneWithError(err)
+			return
+		}
+		var cmd []byte
+		if cmd, err = binaryToText(mcr); err != nil {
+			// NOTE: untranslatable request never reaches the node, ReadBatch skips it.
+			m.DoneWithError(err)
+			err = nil
+			continue
+		}
+		_ = n.bw.Write(cmd)
+		m.MarkWrite()
+	}
+	if err = n.bw.Flush(); err != nil {
+		err = errors.Wrap(err, "MC text Writer flush message bytes")
+	}
+	return
+}
+
+func (n *textNodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
+	if n.Closed() {
+		err = errors.Wrap(ErrClosed, "MC text Reader read batch message")
+		return
+	}
+	defer n.br.ResetBuffer(nil)
+	n.br.ResetBuffer(mb.Buffer())
+	for _, m := range mb.Msgs() {
+		mcr, ok := m.Request().(*MCRequest)
+		if !ok {
+			err = errors.Wrap(ErrAssertReq, "MC text Reader assert request")
+			return
+		}
+		if m.Err() != nil {
+			continue
+		}
+		for {
+			if err = n.fillReply(mcr); err == bufio.ErrBufferFull {
+				if err = n.br.Read(); err != nil {
+					err = errors.Wrap(err, "MC text Reader while read")
+					return
+				}
+				continue
+			} else if err != nil {
+				return
+			}
+			break
+		}
+		m.MarkRead()
+	}
+	return
+}
+
+// fillReply reads one text reply and fills it into the binary response fields.
+func (n *textNodeConn) fillReply(mcr *MCRequest) (err error) {
+	mark := n.br.Mark()
+	line, err := n.br.ReadLine()
+	if err != nil {
+		return
+	}
+	if !bytes.HasPrefix(line, textValueBytes) {
+		textToBinary(mcr, line)
+		return
+	}
+	// VALUE <key> <flags> <bytes> <cas unique>\r\n<data block>\r\nEND\r\n
+	fields := bytes.Fields(line)
+	if len(fields) < 4 {
+		err = errors.Wrap(ErrBadResponse, "MC text Reader bad value line")
+		return
+	}
+	var flags, length, cas uint64
+	flags, err = strconv.ParseUint(string(fields[2]), 10, 32)
+	if err == nil {
+		length, err = strconv.ParseUint(string(fields[3]), 10, 32)
+	}
+	if err == nil && len(fields) > 4 {
+		cas, err = strconv.ParseUint(string(fields[4]), 10, 64)
+	}
+	if err != nil {
+		err = errors.Wrap(ErrBadResponse, "MC text Reader parse value line")
+		return
+	}
+	data, err := n.br.ReadExact(int(length) + 2 + len(textEndBytes))
+	if err == bufio.ErrBufferFull {
+		n.br.AdvanceTo(mark)
+		return
+	} else if err != nil {
+		return
+	}
+	extras := make([]byte, 4)
+	binary.BigEndian.PutUint32(extras, uint32(flags))
+	var key []byte
+	if mcr.rTp == RequestTypeGetK || mcr.rTp == RequestTypeGetKQ {
+		key = mcr.key
+	}
+	fillBinaryReply(mcr, ResponseStatusNoErr, extras, key, data[:length], cas)
+	return
+}
+
+// binaryToText encodes the binary request into one text command.
+func binaryToText(mcr *MCRequest) (cmd []byte, err error) {
+	var (
+		el     = int(mcr.extraLen[0])
+		kl     = len(mcr.key)
+		extras []byte
+		value  []byte
+	)
+	if len(mcr.data) >= el+kl {
+		extras = mcr.data[:el]
+		value = mcr.data[el+kl:]
+	}
+	cmd = make([]byte, 0, len(mcr.key)+len(value)+64)
+	switch mcr.rTp {
+	case RequestTypeGet, RequestTypeGetQ, RequestTypeGetK, RequestTypeGetKQ:
+		cmd = append(cmd, "gets "...)
+		cmd = append(cmd, mcr.key...)
+	case RequestTypeGat:
+		if len(extras) != 4 {
+			err = ErrBadRequest
+			return
+		}
+		cmd = append(cmd, "gats "...)
+		cmd = strconv.AppendUint(cmd, uint64(binary.BigEndian.Uint32(extras)), 10)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = append(cmd, mcr.key...)
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace:
+		if len(extras) != 8 {
+			err = ErrBadRequest
+			return
+		}
+		cas := binary.BigEndian.Uint64(mcr.cas)
+		if mcr.rTp == RequestTypeSet && cas != 0 {
+			cmd = append(cmd, "cas"...)
+		} else {
+			cmd = append(cmd, mcr.rTp.String()...)
+		}
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = append(cmd, mcr.key...)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = strconv.AppendUint(cmd, uint64(binary.BigEndian.Uint32(extras[0:4])), 10)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = strconv.AppendUint(cmd, uint64(binary.BigEndian.Uint32(extras[4:8])), 10)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = strconv.AppendInt(cmd, int64(len(value)), 10)
+		if mcr.rTp == RequestTypeSet && cas != 0 {
+			cmd = append(cmd, textSpaceBytes...)
+			cmd = strconv.AppendUint(cmd, cas, 10)
+		}
+		cmd = append(cmd, textCrlfBytes...)
+		cmd = append(cmd, value...)
+	case RequestTypeAppend, RequestTypePrepend:
+		cmd = append(cmd, mcr.rTp.String()...)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = append(cmd, mcr.key...)
+		cmd = append(cmd, " 0 0 "...)
+		cmd = strconv.AppendInt(cmd, int64(len(value)), 10)
+		cmd = append(cmd, textCrlfBytes...)
+		cmd = append(cmd, value...)
+	case RequestTypeDelete:
+		cmd = append(cmd, "delete "...)
+		cmd = append(cmd, mcr.key...)
+	case RequestTypeIncr, RequestTypeDecr:
+		// NOTE: text protocol has no initial value, a missing key is always NOT_FOUND.
+		if len(extras) != 20 {
+			err = ErrBadRequest
+			return
+		}
+		cmd = append(cmd, mcr.rTp.String()...)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = append(cmd, mcr.key...)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = strconv.AppendUint(cmd, binary.BigEndian.Uint64(extras[0:8]), 10)
+	case RequestTypeTouch:
+		if len(extras) != 4 {
+			err = ErrBadRequest
+			return
+		}
+		cmd = append(cmd, "touch "...)
+		cmd = append(cmd, mcr.key...)
+		cmd = append(cmd, textSpaceBytes...)
+		cmd = strconv.AppendUint(cmd, uint64(binary.BigEndian.Uint32(extras)), 10)
+	default:
+		err = ErrTranslate
+		return
+	}
+	cmd = append(cmd, textCrlfBytes...)
+	return
+}
+
+// textToBinary fills the one line text reply into the binary response fields.
+func textToBinary(mcr *MCRequest, line []byte) {
+	var status uint16 = ResponseStatusNoErr
+	switch {
+	case bytes.Equal(line, textStoredBytes), bytes.Equal(line, textDeletedBytes), bytes.Equal(line, textTouchedBytes):
+	case bytes.Equal(line, textEndBytes), bytes.Equal(line, textNotFoundBytes):
+		fillBinaryReply(mcr, ResponseStatusKeyNotFound, nil, nil, notFoundBody, 0)
+		return
+	case bytes.Equal(line, textExistsBytes):
+		status = ResponseStatusKeyExists
+	case bytes.Equal(line, textNotStoredBytes):
+		switch mcr.rTp {
+		case RequestTypeAdd:
+			status = ResponseStatusKeyExists
+		case RequestTypeReplace:
+			status = ResponseStatusKeyNotFound
+		default:
+			status = ResponseStatusItemNotStored
+		}
+	case bytes.HasPrefix(line, textNonNumericBytes):
+		status = ResponseStatusNonNumeric
+	case bytes.HasPrefix(line, textClientErrBytes):
+		status = ResponseStatusInvalidArg
+	case bytes.HasPrefix(line, textErrorBytes):
+		status = ResponseStatusUnknownCmd
+	default:
+		if mcr.rTp == RequestTypeIncr || mcr.rTp == RequestTypeDecr {
+			if v, err := strconv.ParseUint(string(bytes.TrimSuffix(line, textCrlfBytes)), 10, 64); err == nil {
+				value := make([]byte, 8)
+				binary.BigEndian.PutUint64(value, v)
+				fillBinaryReply(mcr, ResponseStatusNoErr, nil, nil, value, 0)
+				return
+			}
+		}
+		status = ResponseStatusInternalErr
+	}
+	var body []byte
+	if status != ResponseStatusNoErr {
+		body = append(body, bytes.TrimSuffix(line, textCrlfBytes)...)
+	}
+	fillBinaryReply(mcr, status, nil, nil, body, 0)
+}
+
+func fillBinaryReply(mcr *MCRequest, status uint16, extras, key, value []byte, cas uint64) {
+	head := make([]byte, requestHeaderLen)
+	binary.BigEndian.PutUint16(head[2:4], uint16(len(key)))
+	head[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(head[6:8], status)
+	binary.BigEndian.PutUint32(head[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint64(head[16:24], cas)
+	mcr.keyLen = head[2:4]
+	mcr.extraLen = head[4:5]
+	mcr.status = head[6:8]
+	mcr.bodyLen = head[8:12]
+	mcr.cas = head[16:24]
+	body := make([]byte, 0, len(extras)+len(key)+len(value))
+	body = append(body, extras...)
+	body = append(body, key...)
+	mcr.data = append(body, value...)
+}
+
+func (n *textNodeConn) Close() error {
+	if atomic.CompareAndSwapInt32(&n.closed, handlerOpening, handlerClosed) {
+		return n.conn.Close()
+	}
+	return nil
+}
+
+func (n *textNodeConn) Closed() bool {
+	return atomic.LoadInt32(&n.closed) == handlerClosed
+}
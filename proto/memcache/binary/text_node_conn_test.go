@@ -0,0 +1,82 @@
+package binary
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createTextNodeConn(data []byte) *textNodeConn {
+	return newTextNodeConn("clusterA", "127.0.0.1:5000", _createConn(data))
+}
+
+func _binReq(cmd RequestType, cas uint64, extras, key, value []byte) []byte {
+	bs := make([]byte, 24)
+	bs[0] = 0x80
+	bs[1] = byte(cmd)
+	binary.BigEndian.PutUint16(bs[2:4], uint16(len(key)))
+	bs[4] = byte(len(extras))
+	binary.BigEndian.PutUint32(bs[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint64(bs[16:24], cas)
+	bs = append(bs, extras...)
+	bs = append(bs, key...)
+	return append(bs, value...)
+}
+
+func TestTextNodeConnTranslateOk(t *testing.T) {
+	ts := []struct {
+		name   string
+		req    []byte
+		cmd    string
+		resp   string
+		status uint16
+		cas    uint64
+		body   []byte
+	}{
+		{name: "getk", req: getTestData, cmd: "gets ABC\r\n",
+			resp: "VALUE ABC 7 2 42\r\nab\r\nEND\r\n", status: ResponseStatusNoErr, cas: 42,
+			body: []byte{0, 0, 0, 7, 'A', 'B', 'C', 'a', 'b'}},
+		{name: "get miss", req: _binReq(RequestTypeGet, 0, nil, []byte("ABC"), nil), cmd: "gets ABC\r\n",
+			resp: "END\r\n", status: ResponseStatusKeyNotFound, body: []byte("Not found")},
+		{name: "set", req: _binReq(RequestTypeSet, 0, []byte{0, 0, 0, 1, 0, 0, 0, 9}, []byte("ABC"), []byte("ab")), cmd: "set ABC 1 9 2\r\nab\r\n",
+			resp: "STORED\r\n", status: ResponseStatusNoErr},
+		{name: "cas", req: _binReq(RequestTypeSet, 5, []byte{0, 0, 0, 1, 0, 0, 0, 9}, []byte("ABC"), []byte("ab")), cmd: "cas ABC 1 9 2 5\r\nab\r\n",
+			resp: "EXISTS\r\n", status: ResponseStatusKeyExists, body: []byte("EXISTS")},
+		{name: "add", req: _binReq(RequestTypeAdd, 0, []byte{0, 0, 0, 1, 0, 0, 0, 9}, []byte("ABC"), []byte("ab")), cmd: "add ABC 1 9 2\r\nab\r\n",
+			resp: "NOT_STORED\r\n", status: ResponseStatusKeyExists, body: []byte("NOT_STORED")},
+		{name: "decr", req: _binReq(RequestTypeDecr, 0, make([]byte, 20), []byte("ABC"), nil), cmd: "decr ABC 0\r\n",
+			resp: "3\r\n", status: ResponseStatusNoErr, body: []byte{0, 0, 0, 0, 0, 0, 0, 3}},
+		{name: "delete", req: _binReq(RequestTypeDelete, 0, nil, []byte("ABC"), nil), cmd: "delete ABC\r\n",
+			resp: "DELETED\r\n", status: ResponseStatusNoErr},
+	}
+	for _, tt := range ts {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := _createReqMsg(tt.req)
+			nc := _createTextNodeConn([]byte(tt.resp))
+			batch := proto.NewMsgBatch()
+			batch.AddMsg(msg)
+			assert.NoError(t, nc.WriteBatch(batch))
+			buf := make([]byte, 1024)
+			size, err := nc.conn.Conn.(*mockConn).wbuf.Read(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.cmd, string(buf[:size]))
+
+			assert.NoError(t, nc.ReadBatch(batch))
+			mcr := msg.Request().(*MCRequest)
+			assert.Equal(t, tt.status, binary.BigEndian.Uint16(mcr.status))
+			assert.Equal(t, tt.cas, binary.BigEndian.Uint64(mcr.cas))
+			assert.Equal(t, uint32(len(tt.body)), binary.BigEndian.Uint32(mcr.bodyLen))
+			assert.Equal(t, string(tt.body), string(mcr.data))
+		})
+	}
+}
+
+func TestTextNodeConnPingOk(t *testing.T) {
+	nc := _createTextNodeConn([]byte("STORED\r\n"))
+	assert.NoError(t, nc.Ping())
+	assert.NoError(t, nc.Close())
+	assert.Error(t, nc.Ping())
+}
@@ -0,0 +1,369 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"overlord/lib/bufio"
+	"overlord/lib/conv"
+	libnet "overlord/lib/net"
+	"overlord/proto"
+	mcbin "overlord/proto/memcache/binary"
+
+	"github.com/pkg/errors"
+)
+
+// memcached binary protocol: https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped
+const (
+	binHeaderLen = 24
+	binMagicReq  = 0x80
+	binMagicResp = 0x81
+
+	// binNoCreate tells incr/decr to fail instead of creating the key, same as text protocol.
+	binNoCreate = uint32(0xffffffff)
+)
+
+var (
+	nonNumericBytes = []byte("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+	tooLargeBytes   = []byte("SERVER_ERROR object too large for cache\r\n")
+)
+
+// binaryNodeConn speaks the binary protocol with node for text protocol clients.
+// Requests are translated into binary frames and replies back into text lines,
+// so the proxyConn of text protocol never knows about the node protocol.
+type binaryNodeConn struct {
+	cluster string
+	addr    string
+	conn    *libnet.Conn
+	bw      *bufio.Writer
+	br      *bufio.Reader
+	pr      *bufio.Reader
+	closed  int32
+
+	noreply []bool
+}
+
+// NewBinaryNodeConn returns node conn which translates text requests into binary protocol.
+func NewBinaryNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	nc = newBinaryNodeConn(cluster, addr, conn)
+	return
+}
+
+func newBinaryNodeConn(cluster, addr string, conn *libnet.Conn) *binaryNodeConn {
+	return &binaryNodeConn{
+		cluster: cluster,
+		addr:    addr,
+		conn:    conn,
+		bw:      bufio.NewWriter(conn),
+		br:      bufio.NewReader(conn, nil),
+		pr:      bufio.NewReader(conn, bufio.NewBuffer(binHeaderLen)),
+	}
+}
+
+// Ping sends binary noop by checking mc node is alive.
+func (n *binaryNodeConn) Ping() (err error) {
+	if n.Closed() {
+		err = io.EOF
+		return
+	}
+	head := make([]byte, binHeaderLen)
+	head[0] = binMagicReq
+	head[1] = byte(mcbin.RequestTypeNoop)
+	_ = n.bw.Write(head)
+	if err = n.bw.Flush(); err != nil {
+		err = errors.Wrap(err, "MC binary ping flush")
+		return
+	}
+	var pong []byte
+	for {
+		if pong, err = n.pr.ReadExact(binHeaderLen); err == bufio.ErrBufferFull {
+			if err = n.pr.Read(); err != nil {
+				err = errors.Wrap(err, "MC binary ping read response")
+				return
+			}
+			continue
+		} else if err != nil {
+			return
+		}
+		break
+	}
+	if pong[0] != binMagicResp || pong[1] != byte(mcbin.RequestTypeNoop) || binary.BigEndian.Uint16(pong[6:8]) != mcbin.ResponseStatusNoErr {
+		err = ErrPingerPong
+	}
+	return
+}
+
+func (n *binaryNodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
+	n.noreply = n.noreply[:0]
+	for _, m := range mb.Msgs() {
+		if n.Closed() {
+			err = errors.Wrap(ErrClosed, "MC binary Writer conn closed")
+			return
+		}
+		mcr, ok := m.Request().(*MCRequest)
+		if !ok {
+			err = errors.Wrap(ErrAssertReq, "MC binary Writer assert request")
+			m.DoneWithError(err)
+			return
+		}
+		if mcr.rTp == RequestTypeMetaNoop {
+			n.noreply = append(n.noreply, false)
+			continue // NOTE: mn is answered by proxy
+		}
+		frame, noreply, terr := textToBinary(mcr)
+		n.noreply = append(n.noreply, noreply)
+		if terr != nil {
+			// NOTE: untranslatable request never reaches the node, ReadBatch skips it.
+			m.DoneWithError(terr)
+			continue
+		}
+		_ = n.bw.Write(frame)
+		m.MarkWrite()
+	}
+	if err = n.bw.Flush(); err != nil {
+		err = errors.Wrap(err, "MC binary Writer flush message bytes")
+	}
+	return
+}
+
+func (n *binaryNodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
+	if n.Closed() {
+		err = errors.Wrap(ErrClosed, "MC binary Reader read batch message")
+		return
+	}
+	defer n.br.ResetBuffer(nil)
+	n.br.ResetBuffer(mb.Buffer())
+	for i, m := range mb.Msgs() {
+		mcr, ok := m.Request().(*MCRequest)
+		if !ok {
+			err = errors.Wrap(ErrAssertReq, "MC binary Reader assert request")
+			return
+		}
+		if mcr.rTp == RequestTypeMetaNoop || m.Err() != nil {
+			continue
+		}
+		var head, body []byte
+		if head, body, err = n.readFrame(); err != nil {
+			return
+		}
+		mcr.data = binaryToText(mcr, head, body)
+		if i < len(n.noreply) && n.noreply[i] {
+			mcr.data = nil
+		}
+		m.MarkRead()
+	}
+	return
+}
+
+func (n *binaryNodeConn) readFrame() (head, body []byte, err error) {
+	for {
+		if head, err = n.br.ReadExact(binHeaderLen); err == bufio.ErrBufferFull {
+			if err = n.br.Read(); err != nil {
+				err = errors.Wrap(err, "MC binary Reader while read")
+				return
+			}
+			continue
+		} else if err != nil {
+			return
+		}
+		if head[0] != binMagicResp {
+			err = errors.Wrap(ErrBadResponse, "MC binary Reader bad magic")
+			return
+		}
+		bl := int(binary.BigEndian.Uint32(head[8:12]))
+		if body, err = n.br.ReadExact(bl); err == bufio.ErrBufferFull {
+			n.br.Advance(-binHeaderLen)
+			if err = n.br.Read(); err != nil {
+				err = errors.Wrap(err, "MC binary Reader while read")
+				return
+			}
+			continue
+		}
+		return
+	}
+}
+
+func (n *binaryNodeConn) Close() error {
+	if atomic.CompareAndSwapInt32(&n.closed, handlerOpening, handlerClosed) {
+		return n.conn.Close()
+	}
+	return nil
+}
+
+func (n *binaryNodeConn) Closed() bool {
+	return atomic.LoadInt32(&n.closed) == handlerClosed
+}
+
+// textToBinary encodes the text request into one binary request frame.
+func textToBinary(mcr *MCRequest) (frame []byte, noreply bool, err error) {
+	var (
+		cmd    mcbin.RequestType
+		extras []byte
+		value  []byte
+		cas    uint64
+	)
+	switch mcr.rTp {
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace, RequestTypeAppend, RequestTypePrepend, RequestTypeCas:
+		var (
+			sl            storageLine
+			flags, expire int64
+		)
+		if sl, err = parseStorageLine(mcr); err != nil {
+			return
+		}
+		cas, value, noreply = sl.cas, sl.value, sl.noreply
+		switch mcr.rTp {
+		case RequestTypeSet, RequestTypeCas:
+			cmd = mcbin.RequestTypeSet
+		case RequestTypeAdd:
+			cmd = mcbin.RequestTypeAdd
+		case RequestTypeReplace:
+			cmd = mcbin.RequestTypeReplace
+		case RequestTypeAppend:
+			cmd = mcbin.RequestTypeAppend
+		case RequestTypePrepend:
+			cmd = mcbin.RequestTypePrepend
+		}
+		if cmd != mcbin.RequestTypeAppend && cmd != mcbin.RequestTypePrepend {
+			if flags, err = conv.Btoi(sl.flags); err != nil {
+				err = ErrBadFlags
+				return
+			}
+			if expire, err = conv.Btoi(sl.exptime); err != nil {
+				err = ErrBadExptime
+				return
+			}
+			extras = make([]byte, 8)
+			binary.BigEndian.PutUint32(extras[0:4], uint32(flags))
+			binary.BigEndian.PutUint32(extras[4:8], uint32(expire))
+		}
+	case RequestTypeGet, RequestTypeGets:
+		cmd = mcbin.RequestTypeGet
+	case RequestTypeGat, RequestTypeGats:
+		var exptime int64
+		if exptime, err = conv.Btoi(mcr.data); err != nil {
+			err = ErrBadExptime
+			return
+		}
+		cmd = mcbin.RequestTypeGat
+		extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(extras, uint32(exptime))
+	case RequestTypeDelete:
+		cmd = mcbin.RequestTypeDelete
+	case RequestTypeIncr, RequestTypeDecr:
+		var (
+			al    argsLine
+			delta uint64
+		)
+		if al, err = parseArgsLine(mcr); err != nil {
+			return
+		}
+		if delta, err = strconv.ParseUint(string(al.arg), 10, 64); err != nil {
+			err = ErrBadRequest
+			return
+		}
+		noreply = al.noreply
+		cmd = mcbin.RequestTypeIncr
+		if mcr.rTp == RequestTypeDecr {
+			cmd = mcbin.RequestTypeDecr
+		}
+		extras = make([]byte, 20)
+		binary.BigEndian.PutUint64(extras[0:8], delta)
+		binary.BigEndian.PutUint32(extras[16:20], binNoCreate)
+	case RequestTypeTouch:
+		var (
+			al     argsLine
+			expire int64
+		)
+		if al, err = parseArgsLine(mcr); err != nil {
+			return
+		}
+		if expire, err = conv.Btoi(al.arg); err != nil {
+			err = ErrBadExptime
+			return
+		}
+		noreply = al.noreply
+		cmd = mcbin.RequestTypeTouch
+		extras = make([]byte, 4)
+		binary.BigEndian.PutUint32(extras, uint32(expire))
+	default:
+		err = ErrTranslate
+		return
+	}
+	el, kl, vl := len(extras), len(mcr.key), len(value)
+	frame = make([]byte, binHeaderLen+el+kl+vl)
+	frame[0] = binMagicReq
+	frame[1] = byte(cmd)
+	binary.BigEndian.PutUint16(frame[2:4], uint16(kl))
+	frame[4] = byte(el)
+	binary.BigEndian.PutUint32(frame[8:12], uint32(el+kl+vl))
+	binary.BigEndian.PutUint64(frame[16:24], cas)
+	copy(frame[binHeaderLen:], extras)
+	copy(frame[binHeaderLen+el:], mcr.key)
+	copy(frame[binHeaderLen+el+kl:], value)
+	return
+}
+
+// binaryToText decodes the binary response frame into the text reply of the request.
+func binaryToText(mcr *MCRequest, head, body []byte) []byte {
+	var (
+		status = binary.BigEndian.Uint16(head[6:8])
+		kl     = int(binary.BigEndian.Uint16(head[2:4]))
+		el     = int(head[4])
+	)
+	if el+kl > len(body) {
+		return []byte(ErrBadResponse.Error() + "\r\n")
+	}
+	extras, value := body[:el], body[el+kl:]
+	switch status {
+	case mcbin.ResponseStatusNoErr:
+	case mcbin.ResponseStatusKeyNotFound:
+		switch mcr.rTp {
+		case RequestTypeGet, RequestTypeGets, RequestTypeGat, RequestTypeGats:
+			return endBytes
+		case RequestTypeAdd, RequestTypeReplace, RequestTypeAppend, RequestTypePrepend:
+			return notStoredBytes
+		}
+		return notFoundBytes
+	case mcbin.ResponseStatusKeyExists:
+		if mcr.rTp == RequestTypeCas {
+			return existsBytes
+		}
+		return notStoredBytes
+	case mcbin.ResponseStatusItemNotStored:
+		return notStoredBytes
+	case mcbin.ResponseStatusNonNumeric:
+		return nonNumericBytes
+	case mcbin.ResponseStatusValueTooLarge:
+		return tooLargeBytes
+	default:
+		bs := append([]byte(serverErrorPrefix), value...)
+		if len(value) == 0 {
+			bs = strconv.AppendUint(append(bs, "status "...), uint64(status), 16)
+		}
+		return append(bs, crlfBytes...)
+	}
+	switch mcr.rTp {
+	case RequestTypeGet, RequestTypeGets, RequestTypeGat, RequestTypeGats:
+		var flags uint32
+		if len(extras) >= 4 {
+			flags = binary.BigEndian.Uint32(extras[0:4])
+		}
+		return valueReply(mcr, flags, value, binary.BigEndian.Uint64(head[16:24]))
+	case RequestTypeIncr, RequestTypeDecr:
+		if len(value) != 8 {
+			return []byte(ErrBadResponse.Error() + "\r\n")
+		}
+		bs := strconv.AppendUint(nil, binary.BigEndian.Uint64(value), 10)
+		return append(bs, crlfBytes...)
+	case RequestTypeDelete:
+		return deletedBytes
+	case RequestTypeTouch:
+		return touchedBytes
+	}
+	return storedBytes
+}
@@ -0,0 +1,114 @@
+package memcache
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"overlord/proto"
+	mcbin "overlord/proto/memcache/binary"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createBinaryNodeConn(data []byte) *binaryNodeConn {
+	return newBinaryNodeConn("clusterA", "127.0.0.1:5000", _createConn(data))
+}
+
+func _binaryFrame(magic byte, cmd mcbin.RequestType, status uint16, cas uint64, extras, key, value []byte) []byte {
+	bs := make([]byte, 24)
+	bs[0] = magic
+	bs[1] = byte(cmd)
+	binary.BigEndian.PutUint16(bs[2:4], uint16(len(key)))
+	bs[4] = byte(len(extras))
+	binary.BigEndian.PutUint16(bs[6:8], status)
+	binary.BigEndian.PutUint32(bs[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint64(bs[16:24], cas)
+	bs = append(bs, extras...)
+	bs = append(bs, key...)
+	return append(bs, value...)
+}
+
+func TestBinaryNodeConnTranslateOk(t *testing.T) {
+	flags := []byte{0, 0, 0, 7}
+	ts := []struct {
+		Name   string
+		Req    string
+		Frame  []byte
+		Resp   []byte
+		Except string
+	}{
+		{Name: "SetOk", Req: "set mykey 7 10 2\r\nab\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeSet, 0, 0, []byte{0, 0, 0, 7, 0, 0, 0, 10}, []byte("mykey"), []byte("ab")),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeSet, mcbin.ResponseStatusNoErr, 1, nil, nil, nil),
+			Except: "STORED\r\n"},
+		{Name: "CasExists", Req: "cas mykey 7 10 2 99\r\nab\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeSet, 0, 99, []byte{0, 0, 0, 7, 0, 0, 0, 10}, []byte("mykey"), []byte("ab")),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeSet, mcbin.ResponseStatusKeyExists, 0, nil, nil, nil),
+			Except: "EXISTS\r\n"},
+		{Name: "GetsOk", Req: "gets mykey\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeGet, 0, 0, nil, []byte("mykey"), nil),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeGet, mcbin.ResponseStatusNoErr, 42, flags, nil, []byte("abc")),
+			Except: "VALUE mykey 7 3 42\r\nabc\r\nEND\r\n"},
+		{Name: "GetMiss", Req: "get mykey\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeGet, 0, 0, nil, []byte("mykey"), nil),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeGet, mcbin.ResponseStatusKeyNotFound, 0, nil, nil, []byte("Not found")),
+			Except: "END\r\n"},
+		{Name: "IncrOk", Req: "incr mykey 5\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeIncr, 0, 0, []byte{0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}, []byte("mykey"), nil),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeIncr, mcbin.ResponseStatusNoErr, 0, nil, nil, []byte{0, 0, 0, 0, 0, 0, 0, 15}),
+			Except: "15\r\n"},
+		{Name: "DeleteMiss", Req: "delete mykey\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeDelete, 0, 0, nil, []byte("mykey"), nil),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeDelete, mcbin.ResponseStatusKeyNotFound, 0, nil, nil, nil),
+			Except: "NOT_FOUND\r\n"},
+		{Name: "TouchNoreply", Req: "touch mykey 10 noreply\r\n",
+			Frame:  _binaryFrame(0x80, mcbin.RequestTypeTouch, 0, 0, []byte{0, 0, 0, 10}, []byte("mykey"), nil),
+			Resp:   _binaryFrame(0x81, mcbin.RequestTypeTouch, mcbin.ResponseStatusNoErr, 0, nil, nil, nil),
+			Except: ""},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			p := NewProxyConn(_createConn([]byte(tt.Req)))
+			msgs, err := p.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 1)
+
+			nc := _createBinaryNodeConn(tt.Resp)
+			batch := proto.NewMsgBatch()
+			batch.AddMsg(msgs[0])
+			assert.NoError(t, nc.WriteBatch(batch))
+			buf := make([]byte, 1024)
+			size, err := nc.conn.Conn.(*mockConn).wbuf.Read(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Frame, buf[:size])
+
+			assert.NoError(t, nc.ReadBatch(batch))
+			mcr := msgs[0].Request().(*MCRequest)
+			assert.Equal(t, tt.Except, string(mcr.data))
+		})
+	}
+}
+
+func TestBinaryNodeConnNotTranslate(t *testing.T) {
+	p := NewProxyConn(_createConn([]byte("mg mykey v\r\nget mykey\r\n")))
+	msgs, err := p.Decode(proto.GetMsgs(2))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 2)
+
+	nc := _createBinaryNodeConn(_binaryFrame(0x81, mcbin.RequestTypeGet, mcbin.ResponseStatusKeyNotFound, 0, nil, nil, nil))
+	batch := proto.NewMsgBatch()
+	batch.AddMsg(msgs[0])
+	batch.AddMsg(msgs[1])
+	assert.NoError(t, nc.WriteBatch(batch))
+	assert.NoError(t, nc.ReadBatch(batch))
+	_causeEqual(t, ErrTranslate, msgs[0].Err())
+	assert.Equal(t, "END\r\n", string(msgs[1].Request().(*MCRequest).data))
+}
+
+func TestBinaryNodeConnPingOk(t *testing.T) {
+	nc := _createBinaryNodeConn(_binaryFrame(0x81, mcbin.RequestTypeNoop, mcbin.ResponseStatusNoErr, 0, nil, nil, nil))
+	assert.NoError(t, nc.Ping())
+	assert.NoError(t, nc.Close())
+	assert.Error(t, nc.Ping())
+	assert.Error(t, nc.ReadBatch(proto.NewMsgBatch()))
+}
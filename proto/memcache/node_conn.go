@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"overlord/lib/bufio"
+	"overlord/lib/conv"
 	libnet "overlord/lib/net"
 	"overlord/proto"
 
@@ -54,22 +55,22 @@ func (n *nodeConn) Ping() (err error) {
 }
 
 func (n *nodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
-	var (
-		m   *proto.Message
-		idx int
-	)
-	for {
-		m = mb.Nth(idx)
-		if m == nil {
-			break
+	msgs := mb.Msgs()
+	for i := 0; i < len(msgs); {
+		gn := groupLen(msgs, i)
+		if gn == 1 {
+			err = n.write(msgs[i])
+		} else {
+			err = n.writeGroup(msgs[i : i+gn])
 		}
-		err = n.write(m)
 		if err != nil {
-			m.DoneWithError(err)
+			msgs[i].DoneWithError(err)
 			return err
 		}
-		m.MarkWrite()
-		idx++
+		for _, m := range msgs[i : i+gn] {
+			m.MarkWrite()
+		}
+		i += gn
 	}
 
 	if err = n.bw.Flush(); err != nil {
@@ -88,6 +89,9 @@ func (n *nodeConn) write(m *proto.Message) (err error) {
 		err = errors.Wrap(ErrAssertReq, "MC Writer assert request")
 		return
 	}
+	if mcr.rTp == RequestTypeMetaNoop {
+		return // NOTE: mn is answered by proxy
+	}
 	_ = n.bw.Write(mcr.rTp.Bytes())
 	_ = n.bw.Write(spaceBytes)
 	if mcr.rTp == RequestTypeGat || mcr.rTp == RequestTypeGats {
@@ -102,6 +106,46 @@ func (n *nodeConn) write(m *proto.Message) (err error) {
 	return
 }
 
+// writeGroup writes the retrieval requests split from the same msg as one line,
+// e.g. "get k1 k2 k3\r\n".
+func (n *nodeConn) writeGroup(msgs []*proto.Message) (err error) {
+	if n.Closed() {
+		err = errors.Wrap(ErrClosed, "MC Writer conn closed")
+		return
+	}
+	mcr := msgs[0].Request().(*MCRequest)
+	_ = n.bw.Write(mcr.rTp.Bytes())
+	if mcr.rTp == RequestTypeGat || mcr.rTp == RequestTypeGats {
+		_ = n.bw.Write(spaceBytes)
+		_ = n.bw.Write(mcr.data) // NOTE: exp time
+	}
+	for _, m := range msgs {
+		_ = n.bw.Write(spaceBytes)
+		_ = n.bw.Write(m.Request().(*MCRequest).key)
+	}
+	return n.bw.Write(crlfBytes)
+}
+
+// groupLen returns the count of retrieval msgs from i which are split from the same msg.
+func groupLen(msgs []*proto.Message, i int) int {
+	parent := msgs[i].Parent()
+	if parent == nil {
+		return 1
+	}
+	mcr, ok := msgs[i].Request().(*MCRequest)
+	if !ok {
+		return 1
+	}
+	if _, ok = withValueTypes[mcr.rTp]; !ok {
+		return 1
+	}
+	n := 1
+	for i+n < len(msgs) && msgs[i+n].Parent() == parent {
+		n++
+	}
+	return n
+}
+
 func (n *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 	if n.Closed() {
 		err = errors.Wrap(ErrClosed, "MC Reader read batch message")
@@ -112,52 +156,93 @@ func (n *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 	var (
 		size   int
 		cursor int
-		nth    int
-		m      *proto.Message
-
-		mcr *MCRequest
-		ok  bool
+		msgs   = mb.Msgs()
 	)
-	m = mb.Nth(nth)
-
-	mcr, ok = m.Request().(*MCRequest)
-	if !ok {
-		err = errors.Wrap(ErrAssertReq, "MC Writer assert request")
-		return
-	}
-	for {
-		err = n.br.Read()
-		if err != nil {
-			err = errors.Wrap(err, "MC Reader node conn while read")
-			return
-		}
-		for {
+	for i := 0; i < len(msgs); {
+		gn := groupLen(msgs, i)
+		if gn == 1 {
+			mcr, ok := msgs[i].Request().(*MCRequest)
+			if !ok {
+				err = errors.Wrap(ErrAssertReq, "MC Writer assert request")
+				return
+			}
 			size, err = n.fillMCRequest(mcr, n.br.Buffer().Bytes()[cursor:])
-			if err == bufio.ErrBufferFull {
-				break
-			} else if err != nil {
+		} else {
+			size, err = n.fillGroup(msgs[i:i+gn], n.br.Buffer().Bytes()[cursor:])
+		}
+		if err == bufio.ErrBufferFull {
+			if err = n.br.Read(); err != nil {
+				err = errors.Wrap(err, "MC Reader node conn while read")
 				return
 			}
+			continue
+		} else if err != nil {
+			return
+		}
+		for _, m := range msgs[i : i+gn] {
 			m.MarkRead()
+		}
+		cursor += size
+		i += gn
+	}
+	return
+}
 
-			cursor += size
-			nth++
-
-			m = mb.Nth(nth)
-			if m == nil {
-				return
+// fillGroup reads the reply of grouped retrieval requests until END,
+// each value is set back to the request of its key in order and the missed ones are set END.
+func (n *nodeConn) fillGroup(msgs []*proto.Message, data []byte) (size int, err error) {
+	var (
+		next int
+		mcr  = msgs[0].Request().(*MCRequest)
+		cas  = mcr.rTp == RequestTypeGets || mcr.rTp == RequestTypeGats
+	)
+	for _, m := range msgs {
+		m.Request().(*MCRequest).data = endBytes
+	}
+	for {
+		pos := bytes.IndexByte(data[size:], delim)
+		if pos == -1 {
+			return 0, bufio.ErrBufferFull
+		}
+		line := data[size : size+pos+1]
+		if bytes.Equal(line, endBytes) {
+			size += len(line)
+			return
+		}
+		if !bytes.HasPrefix(line, valueBytes) {
+			// NOTE: error line is replied to every request same as not grouped.
+			for _, m := range msgs {
+				m.Request().(*MCRequest).data = line
 			}
-
-			mcr, ok = m.Request().(*MCRequest)
-			if !ok {
-				err = errors.Wrap(ErrAssertReq, "MC Writer assert request")
-				return
+			size += len(line)
+			return
+		}
+		var length int
+		if length, err = findLength(line, cas); err != nil {
+			err = errors.Wrap(err, "MC Handler while parse length")
+			return
+		}
+		end := size + len(line) + length + 2
+		if len(data) < end {
+			return 0, bufio.ErrBufferFull
+		}
+		kB, kE := nextField(line[len(valueBytes):])
+		key := line[len(valueBytes)+kB : len(valueBytes)+kE]
+		for ; next < len(msgs); next++ {
+			if mcr = msgs[next].Request().(*MCRequest); bytes.Equal(mcr.key, key) {
+				mcr.data = data[size:end]
+				next++
+				break
 			}
 		}
+		size = end
 	}
 }
 
 func (n *nodeConn) fillMCRequest(mcr *MCRequest, data []byte) (size int, err error) {
+	if mcr.rTp == RequestTypeMetaNoop {
+		return
+	}
 	pos := bytes.IndexByte(data, delim)
 	if pos == -1 {
 		return 0, bufio.ErrBufferFull
@@ -166,6 +251,9 @@ func (n *nodeConn) fillMCRequest(mcr *MCRequest, data []byte) (size int, err err
 	bs := data[:pos+1]
 	size = len(bs)
 	mcr.data = bs
+	if _, ok := metaTypes[mcr.rTp]; ok {
+		return n.fillMetaReply(mcr, data, size)
+	}
 	if _, ok := withValueTypes[mcr.rTp]; !ok {
 		return
 	}
@@ -187,6 +275,28 @@ func (n *nodeConn) fillMCRequest(mcr *MCRequest, data []byte) (size int, err err
 	return
 }
 
+// fillMetaReply reads the data block of a meta "VA <size> <flag>*" reply,
+// all other meta return codes are one line without END.
+func (n *nodeConn) fillMetaReply(mcr *MCRequest, data []byte, lineLen int) (size int, err error) {
+	size = lineLen
+	if !bytes.HasPrefix(data, metaValueBytes) {
+		return
+	}
+	line := data[len(metaValueBytes):lineLen]
+	lB, lE := nextField(line)
+	length, err := conv.Btoi(line[lB:lE])
+	if err != nil {
+		err = errors.Wrap(ErrBadLength, "MC Handler while parse meta value length")
+		return
+	}
+	size += int(length) + 2
+	if len(data) < size {
+		return 0, bufio.ErrBufferFull
+	}
+	mcr.data = data[:size]
+	return
+}
+
 func (n *nodeConn) Close() error {
 	if atomic.CompareAndSwapInt32(&n.closed, handlerOpening, handlerClosed) {
 		_ = n.pinger.Close()
@@ -140,6 +140,18 @@ func (*mockReq) Key() []byte {
 	return []byte{}
 }
 
+func (*mockReq) IsRead() bool {
+	return false
+}
+
+func (*mockReq) IsMiss() bool {
+	return false
+}
+
+func (m *mockReq) Clone() proto.Request {
+	return m
+}
+
 func (*mockReq) Put() {
 
 }
@@ -191,6 +203,16 @@ func TestNodeConnReadOk(t *testing.T) {
 			rtype:  RequestTypeSet, key: "mykey", data: " 0 0 1\r\nb\r\n",
 			cData: "STORED\r\n", except: "STORED\r\n",
 		},
+		{
+			suffix: "Ok",
+			rtype:  RequestTypeMetaGet, key: "mykey", data: " v\r\n",
+			cData: "VA 3 t-1\r\nabc\r\n", except: "VA 3 t-1\r\nabc\r\n",
+		},
+		{
+			suffix: "Miss",
+			rtype:  RequestTypeMetaGet, key: "mykey", data: " v\r\n",
+			cData: "EN\r\n", except: "EN\r\n",
+		},
 	}
 	for _, tt := range ts {
 
@@ -244,3 +266,25 @@ func TestNewNodeConnWithClosedBinder(t *testing.T) {
 	nc := NewNodeConn("anyName", addr.String(), time.Second, time.Second, time.Second)
 	assert.NotNil(t, nc)
 }
+
+func TestNodeConnGroupRetrievalOk(t *testing.T) {
+	p := NewProxyConn(_createConn([]byte("gets a b c a\r\n")))
+	msgs, err := p.Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	mb := proto.NewMsgBatch()
+	for _, sub := range msgs[0].Batch() {
+		mb.AddMsg(sub)
+	}
+	nc := _createNodeConn([]byte("VALUE a 0 1 11\r\n1\r\nVALUE c 0 1 33\r\n3\r\nVALUE a 0 1 11\r\n1\r\nEND\r\n"))
+	assert.NoError(t, nc.WriteBatch(mb))
+	wbuf := make([]byte, 1024)
+	size, err := nc.conn.Conn.(*mockConn).wbuf.Read(wbuf)
+	assert.NoError(t, err)
+	assert.Equal(t, "gets a b c a\r\n", string(wbuf[:size]))
+	assert.NoError(t, nc.ReadBatch(mb))
+
+	expects := []string{"VALUE a 0 1 11\r\n1\r\n", "END\r\n", "VALUE c 0 1 33\r\n3\r\n", "VALUE a 0 1 11\r\n1\r\n"}
+	for i, sub := range mb.Msgs() {
+		assert.Equal(t, expects[i], string(sub.Request().(*MCRequest).data))
+	}
+}
@@ -20,6 +20,13 @@ const (
 
 var (
 	serverErrorBytes = []byte(serverErrorPrefix)
+
+	metaQuietFlag  = []byte("q")
+	metaNoopBytes  = []byte("MN\r\n")
+	metaValueBytes = []byte("VA ")
+	metaHitBytes   = []byte("HD")
+	metaMissBytes  = []byte("EN")
+	metaNFoundByte = []byte("NF")
 )
 
 type proxyConn struct {
@@ -115,6 +122,18 @@ func (p *proxyConn) decode(m *proto.Message) (err error) {
 		return p.decodeGetAndTouch(m, line[ed:], RequestTypeGat)
 	case "gats":
 		return p.decodeGetAndTouch(m, line[ed:], RequestTypeGats)
+	// Meta commands:
+	case "mg":
+		return p.decodeMeta(m, line[ed:], RequestTypeMetaGet)
+	case "ms":
+		return p.decodeMetaSet(m, line[ed:], RequestTypeMetaSet)
+	case "md":
+		return p.decodeMeta(m, line[ed:], RequestTypeMetaDelete)
+	case "ma":
+		return p.decodeMeta(m, line[ed:], RequestTypeMetaArithmetic)
+	case "mn":
+		p.withReq(m, RequestTypeMetaNoop, nil, crlfBytes)
+		return
 	}
 	err = errors.Wrapf(ErrBadRequest, "MC decoder unsupport command")
 	return
@@ -250,6 +269,55 @@ func (p *proxyConn) decodeGetAndTouch(m *proto.Message, bs []byte, reqType Reque
 	return
 }
 
+func (p *proxyConn) decodeMeta(m *proto.Message, bs []byte, reqType RequestType) (err error) {
+	keyB, keyE := nextField(bs)
+	key := bs[keyB:keyE]
+	if len(key) == 0 || !legalKey(key) {
+		err = errors.Wrap(ErrBadKey, "MC decoder meta request legal key")
+		return
+	}
+	ns := bs[keyE:]
+	quiet := stripQuietFlag(ns)
+	p.withReq(m, reqType, key, ns)
+	m.Request().(*MCRequest).quiet = quiet
+	return
+}
+
+func (p *proxyConn) decodeMetaSet(m *proto.Message, bs []byte, reqType RequestType) (err error) {
+	keyB, keyE := nextField(bs)
+	key := bs[keyB:keyE]
+	if len(key) == 0 || !legalKey(key) {
+		err = errors.Wrap(ErrBadKey, "MC decoder meta set request legal key")
+		return
+	}
+	ns := bs[keyE:]
+	lB, lE := nextField(ns)
+	length, err := conv.Btoi(ns[lB:lE])
+	if err != nil || length < 0 {
+		err = errors.Wrap(ErrBadLength, "MC decoder while parse meta set length")
+		return
+	}
+	keyOffset := len(bs) - keyE
+	p.br.Advance(-keyOffset) // NOTE: data contains "<datalen> <flag>*\r\n<data block>\r\n"
+	data, err := p.br.ReadExact(keyOffset + int(length) + 2)
+	if err == bufio.ErrBufferFull {
+		p.br.Advance(-(keyE + len(reqType.Bytes())))
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "MC decoder while read meta set data by length")
+		return
+	}
+	if !bytes.HasSuffix(data, crlfBytes) {
+		err = errors.Wrap(ErrBadRequest, "MC decoder meta set data not end with CRLF")
+		return
+	}
+	// NOTE: strip only after the whole data block is buffered, a retry would lose the flag.
+	quiet := stripQuietFlag(data[:keyOffset])
+	p.withReq(m, reqType, key, data)
+	m.Request().(*MCRequest).quiet = quiet
+	return
+}
+
 func (p *proxyConn) withReq(m *proto.Message, rtype RequestType, key []byte, data []byte) {
 	req := m.NextReq()
 	if req == nil {
@@ -263,7 +331,46 @@ func (p *proxyConn) withReq(m *proto.Message, rtype RequestType, key []byte, dat
 		mcreq.rTp = rtype
 		mcreq.key = key
 		mcreq.data = data
+		mcreq.quiet = false
+	}
+}
+
+// stripQuietFlag blanks the q flag of a meta flags line in place.
+// The proxy splits one client pipeline across many nodes, so every node must
+// reply and the suppression of quiet return codes is done by Encode.
+func stripQuietFlag(flags []byte) bool {
+	var (
+		b, e int
+		ns   = flags
+	)
+	for {
+		ns = ns[e:]
+		b, e = nextField(ns)
+		if b == e {
+			return false
+		}
+		if bytes.Equal(ns[b:e], metaQuietFlag) {
+			ns[b] = spaceByte
+			return true
+		}
+	}
+}
+
+// metaQuietReply reports whether the reply is one the client asked to hide with the q flag.
+func metaQuietReply(rtype RequestType, reply []byte) bool {
+	if len(reply) < 2 {
+		return false
+	}
+	code := reply[:2]
+	switch rtype {
+	case RequestTypeMetaGet:
+		return bytes.Equal(code, metaMissBytes)
+	case RequestTypeMetaSet:
+		return bytes.Equal(code, metaHitBytes)
+	case RequestTypeMetaDelete, RequestTypeMetaArithmetic:
+		return bytes.Equal(code, metaHitBytes) || bytes.Equal(code, metaNFoundByte)
 	}
+	return false
 }
 
 func nextField(bs []byte) (begin, end int) {
@@ -354,6 +461,10 @@ func (p *proxyConn) Encode(m *proto.Message) (err error) {
 				_ = p.bw.Write(serverErrorBytes)
 				_ = p.bw.Write([]byte(ErrAssertReq.Error()))
 				_ = p.bw.Write(crlfBytes)
+			} else if mcr.rTp == RequestTypeMetaNoop {
+				_ = p.bw.Write(metaNoopBytes)
+			} else if mcr.quiet && metaQuietReply(mcr.rTp, mcr.data) {
+				continue
 			} else {
 				_, ok := withValueTypes[mcr.rTp]
 				if ok && m.IsBatch() {
@@ -97,6 +97,15 @@ func TestProxyConnDecodeOk(t *testing.T) {
 		{"GatBadExpire", "gat abcdef mykey\r\n", ErrBadRequest, "", ""},
 		{"GatsOk", "gats 10 mykey\r\n", nil, "mykey", "gats"},
 		{"GatsMultiKeyOk", "gats 10 mykey yourkey yuki\r\n", nil, "mykey", "gats"},
+		// Meta
+		{"MetaGetOk", "mg mykey v k O123\r\n", nil, "mykey", "mg"},
+		{"MetaGetNoKey", "mg \r\n", ErrBadKey, "", ""},
+		{"MetaSetOk", "ms mykey 2 T10 q\r\nab\r\n", nil, "mykey", "ms"},
+		{"MetaSetBadLength", "ms mykey abc\r\nab\r\n", ErrBadLength, "", ""},
+		{"MetaSetWithNoCRLF", "ms mykey 2\r\nabba", ErrBadRequest, "", ""},
+		{"MetaDeleteOk", "md mykey q\r\n", nil, "mykey", "md"},
+		{"MetaArithmeticOk", "ma mykey MI D5\r\n", nil, "mykey", "ma"},
+		{"MetaNoopOk", "mn\r\n", nil, "", "mn"},
 		// Not support
 		{"NotSupportCmd", "baka 10 mykey\r\n", ErrBadRequest, "", ""},
 		// {"NotFullLine", "baka 10", ErrBadRequest, "", ""},
@@ -177,6 +186,15 @@ func TestProxyConnEncodeOk(t *testing.T) {
 		{Name: "GetMultiAllMiss", Req: "get nokey1 nokey\r\n",
 			Resp:   [][]byte{[]byte("END\r\n"), []byte("END\r\n")},
 			Except: "END\r\n"},
+
+		{Name: "MetaGetOk", Req: "mg mykey v k O9\r\n",
+			Resp:   [][]byte{[]byte("VA 2 kmykey O9\r\nab\r\n")},
+			Except: "VA 2 kmykey O9\r\nab\r\n"},
+		{Name: "MetaGetQuietHit", Req: "mg mykey v q\r\n",
+			Resp:   [][]byte{[]byte("VA 2\r\nab\r\n")},
+			Except: "VA 2\r\nab\r\n"},
+		{Name: "MetaSetOk", Req: "ms mykey 2 O7\r\nab\r\n", Resp: [][]byte{[]byte("HD O7\r\n")}, Except: "HD O7\r\n"},
+		{Name: "MetaDeleteQuietMiss", Req: "md mykey O1 q\r\n", Resp: [][]byte{[]byte("EX O1\r\n")}, Except: "EX O1\r\n"},
 	}
 
 	for _, tt := range ts {
@@ -216,3 +234,37 @@ func TestEncodeErr(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, string(buf[:size]), "SERVER_ERR")
 }
+
+func TestProxyConnMetaQuietPipelineOk(t *testing.T) {
+	conn := _createConn([]byte("mg a v q O1\r\nms b 1 q\r\nx\r\nmg c v q O3\r\nmn\r\n"))
+	p := NewProxyConn(conn)
+	msgs, err := p.Decode(proto.GetMsgs(4))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 4)
+	// NOTE: q flag must not reach the node, or it will never reply.
+	nc := _createNodeConn(nil)
+	assert.NoError(t, nc.write(msgs[0]))
+	assert.NoError(t, nc.write(msgs[3]))
+	nc.bw.Flush()
+	wbuf := make([]byte, 1024)
+	size, err := nc.conn.Conn.(*mockConn).wbuf.Read(wbuf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mg a v   O1\r\n", string(wbuf[:size]))
+	resps := []string{"EN\r\n", "HD\r\n", "VA 1 O3\r\nz\r\n"}
+	for i, resp := range resps {
+		mcr := msgs[i].Request().(*MCRequest)
+		assert.True(t, mcr.quiet)
+		nc := _createNodeConn([]byte(resp))
+		batch := proto.NewMsgBatch()
+		batch.AddMsg(msgs[i])
+		assert.NoError(t, nc.ReadBatch(batch))
+	}
+	for _, msg := range msgs {
+		assert.NoError(t, p.Encode(msg))
+	}
+	assert.NoError(t, p.Flush())
+	buf := make([]byte, 1024)
+	size, err = conn.Conn.(*mockConn).wbuf.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "VA 1 O3\r\nz\r\nMN\r\n", string(buf[:size]))
+}
@@ -0,0 +1,348 @@
+package memcache
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	libnet "overlord/lib/net"
+	"overlord/proto"
+	"overlord/proto/redis"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// relativeExptimeMax is the max relative exptime of memcache, larger one is an unix timestamp.
+	relativeExptimeMax = 60 * 60 * 24 * 30
+)
+
+var (
+	redisNonInteger = []byte("not an integer")
+
+	// redisItemFields are the fields of the redis hash storing an item: value, flags and cas unique.
+	redisItemFields = [][]byte{[]byte("v"), []byte("f"), []byte("c")}
+
+	// redisVersionLua bumps the cas unique of KEYS[1] by every write, which is the larger one of the
+	// unique stored plus 1 and the microseconds of redis time, so it never repeats after the item is
+	// deleted or expired.
+	redisVersionLua = `redis.replicate_commands()
+local function version()
+	local t = redis.call('TIME')
+	local c = tonumber(redis.pcall('HGET', KEYS[1], 'c')) or 0
+	return string.format('%.0f', math.max(c + 1, t[1] * 1000000 + t[2]))
+end
+local function store()
+	local c = version()
+	redis.call('DEL', KEYS[1])
+	redis.call('HSET', KEYS[1], 'v', ARGV[1], 'f', ARGV[2], 'c', c)
+	if ARGV[4] ~= '' then redis.call(ARGV[4], KEYS[1], ARGV[5]) end
+end
+`
+	// redisSetScript stores the item of value ARGV[1] and flags ARGV[2] with the expire command ARGV[4]
+	// of ttl ARGV[5], only when the key is missing if ARGV[3] is NX or exists if XX. It replies 1 when
+	// stored, otherwise 0.
+	redisSetScript = []byte(redisVersionLua + `local e = redis.call('EXISTS', KEYS[1]) == 1
+if ARGV[3] == 'NX' and e or ARGV[3] == 'XX' and not e then return 0 end
+store()
+return 1`)
+	// redisCasScript stores the item as redisSetScript when the cas unique stored matches ARGV[3], and
+	// replies 0 when the key is missing, 1 when the item is changed and 2 when stored.
+	redisCasScript = []byte(redisVersionLua + `if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+if redis.call('TYPE', KEYS[1]).ok ~= 'hash' or redis.call('HGET', KEYS[1], 'c') ~= ARGV[3] then return 1 end
+store()
+return 2`)
+	// redisIncrScript runs INCRBY or DECRBY of ARGV[2] by ARGV[1] on the value only when the key exists,
+	// and decrements to 0 at most, it replies nil when the key is missing.
+	redisIncrScript = []byte(redisVersionLua + `local v = redis.call('HGET', KEYS[1], 'v')
+if not v then return false end
+local n = 0
+if ARGV[2] == 'DECRBY' and tonumber(v) and tonumber(v) < tonumber(ARGV[1]) then
+	redis.call('HSET', KEYS[1], 'v', '0')
+elseif ARGV[2] == 'DECRBY' then
+	n = redis.call('HINCRBY', KEYS[1], 'v', '-' .. ARGV[1])
+else
+	n = redis.call('HINCRBY', KEYS[1], 'v', ARGV[1])
+end
+redis.call('HSET', KEYS[1], 'c', version())
+return n`)
+)
+
+// redisPlan records how the reply of client message comes from redis replies.
+type redisPlan struct {
+	m   *proto.Message
+	mcr *MCRequest
+	// idx is the shadow message deciding the reply, -1 means no reply from redis.
+	idx     int
+	noreply bool
+}
+
+// redisNodeConn stores data of memcache text protocol clients in redis.
+// Requests are translated into redis commands and sent by redis node conn
+// with shadow messages, replies are translated back into text lines.
+//
+// NOTE: the translation is not exactly same as memcached:
+// the item is stored as redis hash of value, flags and cas unique, whose writes are run by lua scripts;
+// incr/decr is limited to int64 of redis;
+// append/prepend and meta commands are not supported.
+type redisNodeConn struct {
+	cluster string
+	addr    string
+	nc      proto.NodeConn
+
+	mb      *proto.MsgBatch
+	shadows []*proto.Message
+	plans   []redisPlan
+}
+
+// NewRedisNodeConn returns node conn which translates text requests into redis commands.
+func NewRedisNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout time.Duration) (nc proto.NodeConn) {
+	conn := libnet.DialWithTimeout(addr, dialTimeout, readTimeout, writeTimeout)
+	nc = newRedisNodeConn(cluster, addr, conn)
+	return
+}
+
+func newRedisNodeConn(cluster, addr string, conn *libnet.Conn) *redisNodeConn {
+	return &redisNodeConn{
+		cluster: cluster,
+		addr:    addr,
+		nc:      redis.WrapNodeConn(cluster, addr, conn),
+		mb:      proto.NewMsgBatch(),
+	}
+}
+
+// Ping sends redis PING by checking redis node is alive.
+func (n *redisNodeConn) Ping() error {
+	return n.nc.Ping()
+}
+
+func (n *redisNodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
+	n.release()
+	msgs := mb.Msgs()
+	for i := 0; i < len(msgs); i++ {
+		mcr, ok := msgs[i].Request().(*MCRequest)
+		if !ok {
+			err = errors.Wrap(ErrAssertReq, "MC redis Writer assert request")
+			msgs[i].DoneWithError(err)
+			return
+		}
+		p := redisPlan{m: msgs[i], mcr: mcr, idx: -1}
+		if mcr.rTp != RequestTypeMetaNoop { // NOTE: mn is answered by proxy
+			if terr := n.planCmd(&p); terr != nil {
+				// NOTE: untranslatable request never reaches the node, ReadBatch skips it.
+				msgs[i].DoneWithError(terr)
+				p.idx = -1
+			}
+		}
+		n.plans = append(n.plans, p)
+	}
+	if err = n.nc.WriteBatch(n.mb); err != nil {
+		err = errors.Wrap(err, "MC redis Writer write batch")
+		return
+	}
+	for _, p := range n.plans {
+		if p.idx >= 0 {
+			p.m.MarkWrite()
+		}
+	}
+	return
+}
+
+// planCmd translates the request into shadow messages.
+func (n *redisNodeConn) planCmd(p *redisPlan) (err error) {
+	mcr := p.mcr
+	switch mcr.rTp {
+	case RequestTypeGet, RequestTypeGets:
+		p.idx = n.shadow(redis.NewRequest("HMGET", append([][]byte{mcr.key}, redisItemFields...)...))
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace, RequestTypeCas:
+		var (
+			sl             storageLine
+			flags, exptime int64
+		)
+		if sl, err = parseStorageLine(mcr); err != nil {
+			return
+		}
+		if flags, err = strconv.ParseInt(string(sl.flags), 10, 64); err != nil {
+			return ErrBadFlags
+		}
+		if exptime, err = strconv.ParseInt(string(sl.exptime), 10, 64); err != nil {
+			return ErrBadExptime
+		}
+		p.noreply = sl.noreply
+		var mode []byte
+		switch mcr.rTp {
+		case RequestTypeAdd:
+			mode = []byte("NX")
+		case RequestTypeReplace:
+			mode = []byte("XX")
+		case RequestTypeCas:
+			mode = strconv.AppendUint(nil, sl.cas, 10)
+		}
+		expire := redisExpireArgs(exptime)
+		if expire == nil {
+			expire = [][]byte{nil, nil}
+		}
+		args := [][]byte{sl.value, strconv.AppendUint(nil, uint64(flags), 10), mode, expire[0], expire[1]}
+		script := redisSetScript
+		if mcr.rTp == RequestTypeCas {
+			script = redisCasScript
+		}
+		p.idx = n.shadow(redis.NewScriptRequest(script, mcr.key, args...))
+	case RequestTypeIncr, RequestTypeDecr:
+		var al argsLine
+		if al, err = parseArgsLine(mcr); err != nil {
+			return
+		}
+		if _, err = strconv.ParseUint(string(al.arg), 10, 64); err != nil {
+			return ErrBadRequest
+		}
+		p.noreply = al.noreply
+		cmd := "INCRBY"
+		if mcr.rTp == RequestTypeDecr {
+			cmd = "DECRBY"
+		}
+		p.idx = n.shadow(redis.NewScriptRequest(redisIncrScript, mcr.key, al.arg, []byte(cmd)))
+	case RequestTypeTouch:
+		var (
+			al      argsLine
+			exptime int64
+		)
+		if al, err = parseArgsLine(mcr); err != nil {
+			return
+		}
+		if exptime, err = strconv.ParseInt(string(al.arg), 10, 64); err != nil {
+			return ErrBadExptime
+		}
+		p.noreply = al.noreply
+		if args := redisExpireArgs(exptime); len(args) == 0 {
+			// NOTE: PERSIST replies 0 for key without ttl, so EXISTS decides the reply.
+			n.shadow(redis.NewRequest("PERSIST", mcr.key))
+			p.idx = n.shadow(redis.NewRequest("EXISTS", mcr.key))
+		} else {
+			p.idx = n.shadow(redis.NewRequest(string(args[0]), mcr.key, args[1]))
+		}
+	case RequestTypeDelete:
+		p.idx = n.shadow(redis.NewRequest("DEL", mcr.key))
+	default:
+		err = ErrTranslate
+	}
+	return
+}
+
+func (n *redisNodeConn) shadow(req *redis.Request) int {
+	m := proto.NewMessage()
+	m.Type = proto.CacheTypeRedis
+	m.WithRequest(req)
+	n.shadows = append(n.shadows, m)
+	n.mb.AddMsg(m)
+	return len(n.shadows) - 1
+}
+
+func (n *redisNodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
+	if err = n.nc.ReadBatch(n.mb); err != nil {
+		err = errors.Wrap(err, "MC redis Reader read batch")
+		return
+	}
+	for i := range n.plans {
+		p := &n.plans[i]
+		if p.idx < 0 {
+			continue
+		}
+		p.mcr.data = redisToText(p, n.shadows[p.idx].Request().(*redis.Request))
+		n.done(p)
+	}
+	return
+}
+
+func (n *redisNodeConn) done(p *redisPlan) {
+	if p.noreply {
+		p.mcr.data = nil
+	}
+	p.m.MarkRead()
+}
+
+// release puts back the shadow messages of last batch.
+func (n *redisNodeConn) release() {
+	proto.PutMsgs(n.shadows)
+	n.shadows = n.shadows[:0]
+	n.plans = n.plans[:0]
+	n.mb.Reset()
+}
+
+func (n *redisNodeConn) Close() error {
+	return n.nc.Close()
+}
+
+// redisToText translates the redis reply into the text reply of request.
+func redisToText(p *redisPlan, req *redis.Request) []byte {
+	rt := req.ReplyType()
+	if rt == redis.ReplyError {
+		return redisErrorText(p, req)
+	}
+	switch p.mcr.rTp {
+	case RequestTypeGet, RequestTypeGets:
+		items := req.ReplyArray()
+		if len(items) != len(redisItemFields) || items[0] == nil {
+			return endBytes
+		}
+		flags, _ := strconv.ParseUint(string(items[1]), 10, 32)
+		cas, _ := strconv.ParseUint(string(items[2]), 10, 64)
+		return valueReply(p.mcr, uint32(flags), items[0], cas)
+	case RequestTypeSet, RequestTypeAdd, RequestTypeReplace:
+		if bytes.Equal(req.ReplyData(), []byte("1")) {
+			return storedBytes
+		}
+		return notStoredBytes
+	case RequestTypeCas:
+		switch string(req.ReplyData()) {
+		case "2":
+			return storedBytes
+		case "1":
+			return existsBytes
+		}
+		return notFoundBytes
+	case RequestTypeIncr, RequestTypeDecr:
+		data := req.ReplyData()
+		if data == nil {
+			return notFoundBytes
+		}
+		bs := make([]byte, 0, len(data)+2)
+		bs = append(bs, data...)
+		return append(bs, crlfBytes...)
+	case RequestTypeTouch:
+		if bytes.Equal(req.ReplyData(), []byte("1")) {
+			return touchedBytes
+		}
+		return notFoundBytes
+	case RequestTypeDelete:
+		if bytes.Equal(req.ReplyData(), []byte("0")) {
+			return notFoundBytes
+		}
+		return deletedBytes
+	}
+	return []byte(ErrBadResponse.Error() + "\r\n")
+}
+
+func redisErrorText(p *redisPlan, req *redis.Request) []byte {
+	data := req.ReplyData()
+	if (p.mcr.rTp == RequestTypeIncr || p.mcr.rTp == RequestTypeDecr) && bytes.Contains(data, redisNonInteger) {
+		return nonNumericBytes
+	}
+	bs := append([]byte(serverErrorPrefix), data...)
+	return append(bs, crlfBytes...)
+}
+
+// redisExpireArgs returns the expire command and ttl of key by memcache exptime, nil means no expire.
+func redisExpireArgs(exptime int64) [][]byte {
+	if exptime == 0 {
+		return nil
+	}
+	if exptime > relativeExptimeMax {
+		exptime -= time.Now().Unix()
+	}
+	if exptime <= 0 {
+		// NOTE: expired item is invisible immediately in memcache.
+		return [][]byte{[]byte("PEXPIRE"), []byte("1")}
+	}
+	return [][]byte{[]byte("EXPIRE"), []byte(strconv.FormatInt(exptime, 10))}
+}
@@ -0,0 +1,155 @@
+package memcache
+
+import (
+	"strconv"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createRedisNodeConn(data []byte) (*redisNodeConn, *mockConn) {
+	conn := _createConn(data)
+	return newRedisNodeConn("clusterA", "127.0.0.1:5000", conn), conn.Conn.(*mockConn)
+}
+
+// _respArray returns the resp array of args sent to redis.
+func _respArray(args ...string) string {
+	s := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		s += "$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n"
+	}
+	return s
+}
+
+func TestRedisNodeConnTranslateOk(t *testing.T) {
+	setScript, casScript, incrScript := string(redisSetScript), string(redisCasScript), string(redisIncrScript)
+	ts := []struct {
+		Name   string
+		Req    string
+		Cmd    string
+		Resp   string
+		Except string
+	}{
+		{Name: "SetOk", Req: "set mykey 0 10 2\r\nab\r\n",
+			Cmd:    _respArray("EVAL", setScript, "1", "mykey", "ab", "0", "", "EXPIRE", "10"),
+			Resp:   ":1\r\n",
+			Except: "STORED\r\n"},
+		{Name: "SetFlags", Req: "set mykey 7 0 3\r\nabc\r\n",
+			Cmd:    _respArray("EVAL", setScript, "1", "mykey", "abc", "7", "", "", ""),
+			Resp:   ":1\r\n",
+			Except: "STORED\r\n"},
+		{Name: "AddNotStored", Req: "add mykey 0 0 2\r\nab\r\n",
+			Cmd:    _respArray("EVAL", setScript, "1", "mykey", "ab", "0", "NX", "", ""),
+			Resp:   ":0\r\n",
+			Except: "NOT_STORED\r\n"},
+		{Name: "ReplaceNotStored", Req: "replace mykey 0 -1 2\r\nab\r\n",
+			Cmd:    _respArray("EVAL", setScript, "1", "mykey", "ab", "0", "XX", "PEXPIRE", "1"),
+			Resp:   ":0\r\n",
+			Except: "NOT_STORED\r\n"},
+		{Name: "CasOk", Req: "cas mykey 0 10 2 42\r\nxy\r\n",
+			Cmd:    _respArray("EVAL", casScript, "1", "mykey", "xy", "0", "42", "EXPIRE", "10"),
+			Resp:   ":2\r\n",
+			Except: "STORED\r\n"},
+		{Name: "CasExists", Req: "cas mykey 0 0 2 99\r\nab\r\n",
+			Cmd:    _respArray("EVAL", casScript, "1", "mykey", "ab", "0", "99", "", ""),
+			Resp:   ":1\r\n",
+			Except: "EXISTS\r\n"},
+		{Name: "CasNotFound", Req: "cas mykey 0 0 2 99\r\nab\r\n",
+			Cmd:    _respArray("EVAL", casScript, "1", "mykey", "ab", "0", "99", "", ""),
+			Resp:   ":0\r\n",
+			Except: "NOT_FOUND\r\n"},
+		{Name: "GetFlags", Req: "get mykey\r\n",
+			Cmd:    _respArray("HMGET", "mykey", "v", "f", "c"),
+			Resp:   "*3\r\n$3\r\nabc\r\n$1\r\n7\r\n$2\r\n42\r\n",
+			Except: "VALUE mykey 7 3\r\nabc\r\nEND\r\n"},
+		{Name: "GetsOk", Req: "gets mykey\r\n",
+			Cmd:    _respArray("HMGET", "mykey", "v", "f", "c"),
+			Resp:   "*3\r\n$2\r\nab\r\n$1\r\n0\r\n$2\r\n42\r\n",
+			Except: "VALUE mykey 0 2 42\r\nab\r\nEND\r\n"},
+		{Name: "GetMiss", Req: "get mykey\r\n",
+			Cmd:    _respArray("HMGET", "mykey", "v", "f", "c"),
+			Resp:   "*3\r\n$-1\r\n$-1\r\n$-1\r\n",
+			Except: "END\r\n"},
+		{Name: "IncrOk", Req: "incr mykey 5\r\n",
+			Cmd:    _respArray("EVAL", incrScript, "1", "mykey", "5", "INCRBY"),
+			Resp:   ":15\r\n",
+			Except: "15\r\n"},
+		{Name: "IncrNotFound", Req: "incr mykey 5\r\n",
+			Cmd:    _respArray("EVAL", incrScript, "1", "mykey", "5", "INCRBY"),
+			Resp:   "$-1\r\n",
+			Except: "NOT_FOUND\r\n"},
+		{Name: "DecrClamped", Req: "decr mykey 5\r\n",
+			Cmd:    _respArray("EVAL", incrScript, "1", "mykey", "5", "DECRBY"),
+			Resp:   ":0\r\n",
+			Except: "0\r\n"},
+		{Name: "DecrNonNumeric", Req: "decr mykey 5\r\n",
+			Cmd:    _respArray("EVAL", incrScript, "1", "mykey", "5", "DECRBY"),
+			Resp:   "-ERR Error running script: @user_script:20: ERR hash value is not an integer\r\n",
+			Except: "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{Name: "TouchOk", Req: "touch mykey 10\r\n",
+			Cmd:    "*3\r\n$6\r\nEXPIRE\r\n$5\r\nmykey\r\n$2\r\n10\r\n",
+			Resp:   ":1\r\n",
+			Except: "TOUCHED\r\n"},
+		{Name: "TouchPersist", Req: "touch mykey 0\r\n",
+			Cmd:    "*2\r\n$7\r\nPERSIST\r\n$5\r\nmykey\r\n*2\r\n$6\r\nEXISTS\r\n$5\r\nmykey\r\n",
+			Resp:   ":0\r\n:1\r\n",
+			Except: "TOUCHED\r\n"},
+		{Name: "TouchExpired", Req: "touch mykey -1\r\n",
+			Cmd:    "*3\r\n$7\r\nPEXPIRE\r\n$5\r\nmykey\r\n$1\r\n1\r\n",
+			Resp:   ":0\r\n",
+			Except: "NOT_FOUND\r\n"},
+		{Name: "DeleteOk", Req: "delete mykey\r\n",
+			Cmd:    "*2\r\n$3\r\nDEL\r\n$5\r\nmykey\r\n",
+			Resp:   ":1\r\n",
+			Except: "DELETED\r\n"},
+		{Name: "DeleteMiss", Req: "delete mykey\r\n",
+			Cmd:    "*2\r\n$3\r\nDEL\r\n$5\r\nmykey\r\n",
+			Resp:   ":0\r\n",
+			Except: "NOT_FOUND\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			p := NewProxyConn(_createConn([]byte(tt.Req)))
+			msgs, err := p.Decode(proto.GetMsgs(1))
+			assert.NoError(t, err)
+			assert.Len(t, msgs, 1)
+
+			nc, mconn := _createRedisNodeConn([]byte(tt.Resp))
+			batch := proto.NewMsgBatch()
+			batch.AddMsg(msgs[0])
+			assert.NoError(t, nc.WriteBatch(batch))
+			assert.Equal(t, tt.Cmd, mconn.wbuf.String())
+
+			assert.NoError(t, nc.ReadBatch(batch))
+			mcr := msgs[0].Request().(*MCRequest)
+			assert.Equal(t, tt.Except, string(mcr.data))
+		})
+	}
+}
+
+func TestRedisNodeConnGets(t *testing.T) {
+	p := NewProxyConn(_createConn([]byte("get a b c\r\nmn\r\nappend a 0 0 1\r\nx\r\n")))
+	msgs, err := p.Decode(proto.GetMsgs(3))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+
+	nc, mconn := _createRedisNodeConn([]byte("*3\r\n$1\r\n1\r\n$1\r\n0\r\n$1\r\n5\r\n*3\r\n$-1\r\n$-1\r\n$-1\r\n*3\r\n$1\r\n3\r\n$1\r\n2\r\n$1\r\n6\r\n"))
+	batch := proto.NewMsgBatch()
+	for _, sub := range msgs[0].Batch() {
+		batch.AddMsg(sub)
+	}
+	batch.AddMsg(msgs[1])
+	batch.AddMsg(msgs[2])
+	assert.NoError(t, nc.WriteBatch(batch))
+	assert.Equal(t, _respArray("HMGET", "a", "v", "f", "c")+_respArray("HMGET", "b", "v", "f", "c")+_respArray("HMGET", "c", "v", "f", "c"), mconn.wbuf.String())
+	assert.NoError(t, nc.ReadBatch(batch))
+
+	reqs := msgs[0].Requests()
+	assert.Equal(t, "VALUE a 0 1\r\n1\r\nEND\r\n", string(reqs[0].(*MCRequest).data))
+	assert.Equal(t, "END\r\n", string(reqs[1].(*MCRequest).data))
+	assert.Equal(t, "VALUE c 2 1\r\n3\r\nEND\r\n", string(reqs[2].(*MCRequest).data))
+	assert.NoError(t, msgs[1].Err())
+	_causeEqual(t, ErrTranslate, msgs[2].Err())
+}
@@ -0,0 +1,98 @@
+package memcache
+
+import (
+	"bytes"
+
+	"overlord/proto"
+)
+
+// ReplicaRequest returns the request applying the write req to the other replicas of its key, which owns
+// its data. The cas unique is local to each node, so cas is translated into set of the same value, which
+// is deferred until req is stored on its node. The results of incr, decr, append, prepend, meta arithmetic
+// and meta set with cas or append mode depend on the value of each node, so they are translated into
+// delete, and the replicas miss the key until it is written again. The other writes are copied.
+func ReplicaRequest(req proto.Request) (rr proto.Request, deferred bool) {
+	mcr := req.(*MCRequest)
+	switch mcr.rTp {
+	case RequestTypeCas:
+		if set := casToSet(mcr); set != nil {
+			return set, true
+		}
+		return deleteRequest(mcr.key), false
+	case RequestTypeIncr, RequestTypeDecr, RequestTypeAppend, RequestTypePrepend, RequestTypeMetaArithmetic:
+		return deleteRequest(mcr.key), false
+	case RequestTypeMetaSet:
+		if metaSetLocal(mcr.data) {
+			return deleteRequest(mcr.key), false
+		}
+	}
+	return mcr.Clone(), false
+}
+
+// Stored reports whether the storage request req is replied STORED.
+func Stored(req proto.Request) bool {
+	mcr, ok := req.(*MCRequest)
+	return ok && bytes.Equal(mcr.data, storedBytes)
+}
+
+// SameReply reports whether the requests a and b are replied the same.
+func SameReply(a, b proto.Request) bool {
+	ma, ok := a.(*MCRequest)
+	if !ok {
+		return false
+	}
+	mb, ok := b.(*MCRequest)
+	return ok && bytes.Equal(ma.data, mb.data)
+}
+
+// casToSet returns the set of the value of cas request mcr, nil when it is malformed.
+func casToSet(mcr *MCRequest) *MCRequest {
+	sl, err := parseStorageLine(mcr)
+	if err != nil || sl.noreply {
+		return nil
+	}
+	pos := bytes.IndexByte(mcr.data, delim)
+	fields := bytes.Fields(mcr.data[:pos])
+	set := GetReq()
+	set.rTp = RequestTypeSet
+	set.key = append([]byte(nil), mcr.key...)
+	bs := make([]byte, 0, len(mcr.data))
+	for _, f := range [][]byte{sl.flags, sl.exptime, fields[2]} {
+		bs = append(bs, spaceByte)
+		bs = append(bs, f...)
+	}
+	bs = append(bs, crlfBytes...)
+	bs = append(bs, sl.value...)
+	set.data = append(bs, crlfBytes...)
+	return set
+}
+
+// metaSetLocal reports whether the meta set flags line of data compares cas by C flag or appends
+// by MA or MP mode.
+func metaSetLocal(data []byte) bool {
+	pos := bytes.IndexByte(data, delim)
+	if pos == -1 {
+		return false
+	}
+	fields := bytes.Fields(data[:pos])
+	for _, f := range fields[1:] {
+		switch {
+		case f[0] == 'C':
+			return true
+		case f[0] == 'M' && len(f) > 1:
+			if m := f[1] | 0x20; m == 'a' || m == 'p' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deleteRequest returns the delete request of key, which owns a copy of key.
+func deleteRequest(key []byte) *MCRequest {
+	del := GetReq()
+	del.rTp = RequestTypeDelete
+	del.key = append([]byte(nil), key...)
+	del.data = crlfBytes
+	return del
+}
@@ -1,9 +1,13 @@
 package memcache
 
 import (
+	"bytes"
 	errs "errors"
 	"fmt"
+	"strconv"
 	"sync"
+
+	"overlord/proto"
 )
 
 const (
@@ -32,13 +36,21 @@ var (
 	touchBytes   = []byte("touch")
 	gatBytes     = []byte("gat")
 	gatsBytes    = []byte("gats")
+	mgBytes      = []byte("mg")
+	msBytes      = []byte("ms")
+	mdBytes      = []byte("md")
+	maBytes      = []byte("ma")
+	mnBytes      = []byte("mn")
 	unknownBytes = []byte("unknown")
-	// storedBytes = []byte("STORED\r\n")
-	// notStoredBytes = []byte("NOT_STORED\r\n")
-	// existsBytes    = []byte("EXISTS\r\n")
-	// notFoundBytes  = []byte("NOT_FOUND\r\n")
-	// deletedBytes   = []byte("DELETED\r\n")
-	// touchedBytes   = []byte("TOUCHED\r\n")
+
+	storedBytes    = []byte("STORED\r\n")
+	notStoredBytes = []byte("NOT_STORED\r\n")
+	existsBytes    = []byte("EXISTS\r\n")
+	notFoundBytes  = []byte("NOT_FOUND\r\n")
+	deletedBytes   = []byte("DELETED\r\n")
+	touchedBytes   = []byte("TOUCHED\r\n")
+	valueBytes     = []byte("VALUE ")
+	noreplyBytes   = []byte("noreply")
 )
 
 const (
@@ -56,6 +68,11 @@ const (
 	touchString   = "touch"
 	gatString     = "gat"
 	gatsString    = "gats"
+	mgString      = "mg"
+	msString      = "ms"
+	mdString      = "md"
+	maString      = "ma"
+	mnString      = "mn"
 	unknownString = "unknown"
 )
 
@@ -92,6 +109,16 @@ func (rt RequestType) String() string {
 		return gatString
 	case RequestTypeGats:
 		return gatsString
+	case RequestTypeMetaGet:
+		return mgString
+	case RequestTypeMetaSet:
+		return msString
+	case RequestTypeMetaDelete:
+		return mdString
+	case RequestTypeMetaArithmetic:
+		return maString
+	case RequestTypeMetaNoop:
+		return mnString
 	}
 	return unknownString
 }
@@ -127,10 +154,30 @@ func (rt RequestType) Bytes() []byte {
 		return gatBytes
 	case RequestTypeGats:
 		return gatsBytes
+	case RequestTypeMetaGet:
+		return mgBytes
+	case RequestTypeMetaSet:
+		return msBytes
+	case RequestTypeMetaDelete:
+		return mdBytes
+	case RequestTypeMetaArithmetic:
+		return maBytes
+	case RequestTypeMetaNoop:
+		return mnBytes
 	}
 	return unknownBytes
 }
 
+// IsCommand reports whether the cmd is a command name of text protocol.
+func IsCommand(cmd []byte) bool {
+	for rt := RequestTypeSet; rt <= RequestTypeMetaNoop; rt++ {
+		if bytes.Equal(rt.Bytes(), cmd) {
+			return true
+		}
+	}
+	return false
+}
+
 // all memcache Msg type
 const (
 	RequestTypeUnknown RequestType = iota
@@ -148,6 +195,11 @@ const (
 	RequestTypeTouch
 	RequestTypeGat
 	RequestTypeGats
+	RequestTypeMetaGet
+	RequestTypeMetaSet
+	RequestTypeMetaDelete
+	RequestTypeMetaArithmetic
+	RequestTypeMetaNoop
 )
 
 var (
@@ -157,6 +209,14 @@ var (
 		RequestTypeGat:  struct{}{},
 		RequestTypeGats: struct{}{},
 	}
+
+	metaTypes = map[RequestType]struct{}{
+		RequestTypeMetaGet:        struct{}{},
+		RequestTypeMetaSet:        struct{}{},
+		RequestTypeMetaDelete:     struct{}{},
+		RequestTypeMetaArithmetic: struct{}{},
+		RequestTypeMetaNoop:       struct{}{},
+	}
 )
 
 // errors
@@ -186,6 +246,7 @@ var (
 	ErrPingerPong  = errs.New("SERVER_ERROR Pinger pong unexpected")
 	ErrAssertReq   = errs.New("SERVER_ERROR assert request not ok")
 	ErrBadResponse = errs.New("SERVER_ERROR bad response")
+	ErrTranslate   = errs.New("SERVER_ERROR command can not be translated to node protocol")
 )
 
 // MCRequest is the mc client Msg type and data.
@@ -202,10 +263,17 @@ var (
 // 	touch <key> <exptime> [noreply]\r\n
 // Get And Touch:
 // 	gat|gats <exptime> <key>*\r\n
+// Meta commands:
+// 	mg|md|ma <key> <flag>*\r\n
+// 	ms <key> <datalen> <flag>*\r\n<data block>\r\n
+// 	mn\r\n
 type MCRequest struct {
 	rTp  RequestType
 	key  []byte
 	data []byte
+	// quiet marks a meta request sent with the q flag, the flag is
+	// stripped before forwarding so that every node replies.
+	quiet bool
 }
 
 var msgPool = &sync.Pool{
@@ -229,6 +297,7 @@ func (r *MCRequest) Put() {
 	r.data = nil
 	r.rTp = RequestTypeUnknown
 	r.key = nil
+	r.quiet = false
 	msgPool.Put(r)
 }
 
@@ -247,6 +316,111 @@ func (r *MCRequest) Key() []byte {
 	return r.key
 }
 
+// Clone returns a copy of the request which owns its data.
+func (r *MCRequest) Clone() proto.Request {
+	n := GetReq()
+	n.rTp = r.rTp
+	n.key = append([]byte(nil), r.key...)
+	n.data = append([]byte(nil), r.data...)
+	n.quiet = r.quiet
+	return n
+}
+
+// IsRead reports whether the request only reads.
+func (r *MCRequest) IsRead() bool {
+	switch r.rTp {
+	case RequestTypeGet, RequestTypeGets, RequestTypeMetaGet, RequestTypeMetaNoop:
+		return true
+	}
+	return false
+}
+
+// IsMiss reports whether the get request is replied without value.
+func (r *MCRequest) IsMiss() bool {
+	switch r.rTp {
+	case RequestTypeGet, RequestTypeGets:
+		return bytes.Equal(r.data, endBytes)
+	case RequestTypeMetaGet:
+		return bytes.HasPrefix(r.data, metaMissBytes)
+	}
+	return false
+}
+
+// storageLine is the parsed "<flags> <exptime> <bytes> [<cas unique>] [noreply]" of storage request.
+type storageLine struct {
+	flags   []byte
+	exptime []byte
+	cas     uint64
+	value   []byte
+	noreply bool
+}
+
+// parseStorageLine parses the data of storage request for protocol translation.
+func parseStorageLine(mcr *MCRequest) (sl storageLine, err error) {
+	pos := bytes.IndexByte(mcr.data, delim)
+	if pos == -1 {
+		err = ErrBadRequest
+		return
+	}
+	fields := bytes.Fields(mcr.data[:pos+1])
+	if mcr.rTp == RequestTypeCas {
+		if len(fields) < 4 {
+			err = ErrBadRequest
+			return
+		}
+		if sl.cas, err = strconv.ParseUint(string(fields[3]), 10, 64); err != nil {
+			err = ErrBadCas
+			return
+		}
+		fields = append(fields[:3], fields[4:]...)
+	}
+	if len(fields) < 3 {
+		err = ErrBadRequest
+		return
+	}
+	sl.flags, sl.exptime = fields[0], fields[1]
+	sl.noreply = len(fields) > 3 && bytes.Equal(fields[3], noreplyBytes)
+	sl.value = mcr.data[pos+1 : len(mcr.data)-2]
+	return
+}
+
+// argsLine is the parsed "<value> [noreply]" of incr/decr and touch request.
+type argsLine struct {
+	arg     []byte
+	noreply bool
+}
+
+// parseArgsLine parses the data of incr/decr and touch request for protocol translation.
+func parseArgsLine(mcr *MCRequest) (al argsLine, err error) {
+	fields := bytes.Fields(mcr.data)
+	if len(fields) < 1 {
+		err = ErrBadRequest
+		return
+	}
+	al.arg = fields[0]
+	al.noreply = len(fields) > 1 && bytes.Equal(fields[1], noreplyBytes)
+	return
+}
+
+// valueReply builds the text reply "VALUE <key> <flags> <bytes> [<cas unique>]" of retrieval request.
+func valueReply(mcr *MCRequest, flags uint32, value []byte, cas uint64) []byte {
+	bs := make([]byte, 0, len(valueBytes)+len(mcr.key)+len(value)+64)
+	bs = append(bs, valueBytes...)
+	bs = append(bs, mcr.key...)
+	bs = append(bs, spaceByte)
+	bs = strconv.AppendUint(bs, uint64(flags), 10)
+	bs = append(bs, spaceByte)
+	bs = strconv.AppendInt(bs, int64(len(value)), 10)
+	if mcr.rTp == RequestTypeGets || mcr.rTp == RequestTypeGats {
+		bs = append(bs, spaceByte)
+		bs = strconv.AppendUint(bs, cas, 10)
+	}
+	bs = append(bs, crlfBytes...)
+	bs = append(bs, value...)
+	bs = append(bs, crlfBytes...)
+	return append(bs, endBytes...)
+}
+
 func (r *MCRequest) String() string {
 	return fmt.Sprintf("type:%s key:%s data:%s", r.rTp.Bytes(), r.key, r.data)
 }
@@ -23,6 +23,11 @@ var _allReqTypes = []RequestType{
 	RequestTypeTouch,
 	RequestTypeGat,
 	RequestTypeGats,
+	RequestTypeMetaGet,
+	RequestTypeMetaSet,
+	RequestTypeMetaDelete,
+	RequestTypeMetaArithmetic,
+	RequestTypeMetaNoop,
 }
 
 func TestRequestTypeString(t *testing.T) {
@@ -49,3 +54,32 @@ func TestMCRequestFuncsOk(t *testing.T) {
 	assert.Nil(t, req.key)
 	assert.Nil(t, req.data)
 }
+
+func TestMCRequestClone(t *testing.T) {
+	req := &MCRequest{rTp: RequestTypeSet, key: []byte("a"), data: []byte(" 0 0 1\r\nx\r\n")}
+	assert.False(t, req.IsRead())
+	clone := req.Clone().(*MCRequest)
+	req.key[0] = 'b'
+	req.data[1] = '1'
+	assert.Equal(t, RequestTypeSet, clone.rTp)
+	assert.Equal(t, "a", string(clone.key))
+	assert.Equal(t, " 0 0 1\r\nx\r\n", string(clone.data))
+	assert.True(t, (&MCRequest{rTp: RequestTypeGets}).IsRead())
+}
+
+func TestMCRequestIsMiss(t *testing.T) {
+	var tests = []struct {
+		rTp  RequestType
+		data string
+		miss bool
+	}{
+		{RequestTypeGet, "END\r\n", true},
+		{RequestTypeGets, "VALUE a 0 1 2\r\nx\r\nEND\r\n", false},
+		{RequestTypeMetaGet, "EN\r\n", true},
+		{RequestTypeMetaGet, "VA 1\r\nx\r\n", false},
+		{RequestTypeSet, "NOT_STORED\r\n", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.miss, (&MCRequest{rTp: tt.rTp, data: []byte(tt.data)}).IsMiss(), tt.data)
+	}
+}
@@ -0,0 +1,121 @@
+package memcache
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"overlord/lib/conv"
+	"overlord/proto"
+)
+
+// maxRelativeTTL is the max ttl taken as relative seconds by memcached, the larger is unix time.
+const maxRelativeTTL = 60 * 60 * 24 * 30
+
+var (
+	// warmFlagsBytes asks meta get for value, client flags and remaining ttl.
+	warmFlagsBytes = []byte(" v f t\r\n")
+)
+
+// IsGet reports whether req is a get or gets, and whether it missed when replied.
+func IsGet(req proto.Request) (miss, ok bool) {
+	mcr, ok := req.(*MCRequest)
+	if !ok || (mcr.rTp != RequestTypeGet && mcr.rTp != RequestTypeGets) {
+		return false, false
+	}
+	return bytes.Equal(mcr.data, endBytes), true
+}
+
+// NewWarmRequest returns the meta get request which fetches the key of get req with its flags and ttl
+// from the warm nodes, meta get needs memcached 1.6 or later.
+func NewWarmRequest(req proto.Request) proto.Request {
+	wr := GetReq()
+	wr.rTp = RequestTypeMetaGet
+	wr.key = req.(*MCRequest).key
+	wr.data = warmFlagsBytes
+	return wr
+}
+
+// Warm sets the reply of get req by the hit reply of warm request wr, and returns the add request
+// which writes the value back with the ttl of warm nodes, or defaultTTL when the ttl is not replied.
+// The add never overwrites the value written to the warming node meanwhile.
+// It returns nil when wr missed or failed. The returned request owns a copy of key and value.
+// NOTE: the cas unique replied to gets is 0, which never matches, so the cas of client fails and
+// gets again from the warming node.
+func Warm(req, wr proto.Request, defaultTTL int) proto.Request {
+	mcr := req.(*MCRequest)
+	mv, ok := parseMetaValue(wr.(*MCRequest).data, int64(defaultTTL))
+	if !ok {
+		return nil
+	}
+	mcr.data = valueReply(mcr, uint32(mv.flags), mv.value, 0)
+	return mv.addRequest(mcr.key)
+}
+
+// metaValue is the parsed hit reply of meta get with v, f and t flags.
+type metaValue struct {
+	value []byte
+	flags uint64
+	ttl   int64
+}
+
+// parseMetaValue parses the hit reply of meta get, ttl is defaultTTL when not replied.
+func parseMetaValue(data []byte, defaultTTL int64) (mv metaValue, ok bool) {
+	if !bytes.HasPrefix(data, metaValueBytes) {
+		return
+	}
+	pos := bytes.IndexByte(data, delim)
+	if pos == -1 {
+		return
+	}
+	fields := bytes.Fields(data[len(metaValueBytes):pos])
+	if len(fields) == 0 {
+		return
+	}
+	length, err := conv.Btoi(fields[0])
+	if err != nil || int(length)+pos+3 != len(data) {
+		return
+	}
+	mv.ttl = defaultTTL
+	for _, f := range fields[1:] {
+		switch f[0] {
+		case 'f':
+			if mv.flags, err = strconv.ParseUint(string(f[1:]), 10, 32); err != nil {
+				return
+			}
+		case 't':
+			if mv.ttl, err = conv.Btoi(f[1:]); err != nil {
+				return
+			}
+			if mv.ttl < 0 {
+				mv.ttl = 0 // NOTE: -1 means never expire.
+			}
+		}
+	}
+	mv.value = data[pos+1 : pos+1+int(length)]
+	ok = true
+	return
+}
+
+// addRequest returns the add request which writes the value of key with flags and ttl,
+// it owns a copy of key and value. The ttl over maxRelativeTTL is converted to unix time.
+func (mv *metaValue) addRequest(key []byte) *MCRequest {
+	add := GetReq()
+	add.rTp = RequestTypeAdd
+	add.key = append([]byte(nil), key...)
+	ttl := mv.ttl
+	if ttl > maxRelativeTTL {
+		ttl += time.Now().Unix()
+	}
+	bs := make([]byte, 0, len(mv.value)+64)
+	bs = append(bs, spaceByte)
+	bs = strconv.AppendUint(bs, mv.flags, 10)
+	bs = append(bs, spaceByte)
+	bs = strconv.AppendInt(bs, ttl, 10)
+	bs = append(bs, spaceByte)
+	bs = strconv.AppendInt(bs, int64(len(mv.value)), 10)
+	bs = append(bs, crlfBytes...)
+	bs = append(bs, mv.value...)
+	add.data = append(bs, crlfBytes...)
+	return add
+}
@@ -0,0 +1,70 @@
+package memcache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWarmOk(t *testing.T) {
+	req := &MCRequest{rTp: RequestTypeGet, key: []byte("a"), data: endBytes}
+	miss, ok := IsGet(req)
+	assert.True(t, ok)
+	assert.True(t, miss)
+	_, ok = IsGet(&MCRequest{rTp: RequestTypeGets, key: []byte("a"), data: endBytes})
+	assert.True(t, ok)
+	_, ok = IsGet(&MCRequest{rTp: RequestTypeGat, key: []byte("a"), data: endBytes})
+	assert.False(t, ok)
+
+	wr := NewWarmRequest(req).(*MCRequest)
+	assert.Equal(t, RequestTypeMetaGet, wr.rTp)
+	assert.Equal(t, "a v f t\r\n", string(wr.key)+string(wr.data))
+
+	ts := []struct {
+		Name   string
+		Reply  string
+		Value  string
+		Set    string
+		Exists bool
+	}{
+		{Name: "ttl", Reply: "VA 2 f5 t300\r\nxy\r\n", Value: "VALUE a 5 2\r\nxy\r\nEND\r\n", Set: " 5 300 2\r\nxy\r\n", Exists: true},
+		{Name: "never expire", Reply: "VA 2 t-1 f0\r\nxy\r\n", Value: "VALUE a 0 2\r\nxy\r\nEND\r\n", Set: " 0 0 2\r\nxy\r\n", Exists: true},
+		{Name: "default ttl", Reply: "VA 1 f1\r\nx\r\n", Value: "VALUE a 1 1\r\nx\r\nEND\r\n", Set: " 1 60 1\r\nx\r\n", Exists: true},
+		{Name: "miss", Reply: "EN\r\n"},
+		{Name: "error", Reply: "ERROR\r\n"},
+		{Name: "bad length", Reply: "VA 3 f1\r\nx\r\n"},
+	}
+	for _, tt := range ts {
+		req.data = endBytes
+		wr.data = []byte(tt.Reply)
+		set := Warm(req, wr, 60)
+		if !tt.Exists {
+			assert.Nil(t, set, tt.Name)
+			assert.Equal(t, endBytes, req.data, tt.Name)
+			continue
+		}
+		if assert.NotNil(t, set, tt.Name) {
+			smcr := set.(*MCRequest)
+			assert.Equal(t, RequestTypeAdd, smcr.rTp)
+			assert.Equal(t, "a", string(smcr.key))
+			assert.Equal(t, tt.Set, string(smcr.data), tt.Name)
+		}
+		assert.Equal(t, tt.Value, string(req.data), tt.Name)
+	}
+}
+
+func TestWarmGetsAndLongTTL(t *testing.T) {
+	req := &MCRequest{rTp: RequestTypeGets, key: []byte("a"), data: endBytes}
+	wr := NewWarmRequest(req).(*MCRequest)
+	wr.data = []byte("VA 1 f0 t5184000\r\nx\r\n")
+	add := Warm(req, wr, 0).(*MCRequest)
+	assert.Equal(t, "VALUE a 0 1 0\r\nx\r\nEND\r\n", string(req.data))
+	// NOTE: the ttl over 30 days is written as unix time.
+	fs := strings.Fields(string(add.data))
+	ttl, err := strconv.ParseInt(fs[1], 10, 64)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().Unix()+5184000, ttl, 5)
+}
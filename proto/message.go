@@ -66,6 +66,8 @@ type Message struct {
 	req  []Request
 	reqn int
 	subs []*Message
+	// parent is the msg which sub msg split from.
+	parent *Message
 	// Start Time, Write Time, ReadTime, EndTime
 	st, wt, rt, et time.Time
 	err            error
@@ -90,6 +92,7 @@ func (m *Message) clear() {
 	m.Reset()
 	m.req = nil
 	m.subs = nil
+	m.parent = nil
 }
 
 // TotalDur will return the total duration of a command.
@@ -112,6 +115,11 @@ func (m *Message) MarkWrite() {
 	m.wt = time.Now()
 }
 
+// Replied reports whether the reply of msg was read from node.
+func (m *Message) Replied() bool {
+	return !m.rt.IsZero() && !m.rt.Equal(defaultTime)
+}
+
 // MarkRead will set the read time of the command to now.
 func (m *Message) MarkRead() {
 	m.rt = time.Now()
@@ -156,6 +164,21 @@ func (m *Message) setRequest(req Request) {
 	m.WithRequest(req)
 }
 
+// ReplaceRequest replaces the request of msg by req and returns the replaced one, which
+// is owned by caller. The request of parent is replaced too, so req is encoded and put with it.
+func (m *Message) ReplaceRequest(req Request) (old Request) {
+	old = m.Request()
+	m.req[0] = req
+	if m.parent != nil {
+		for i, r := range m.parent.req {
+			if r == old {
+				m.parent.req[i] = req
+			}
+		}
+	}
+	return
+}
+
 // Request returns proto Msg.
 func (m *Message) Request() Request {
 	if m.req != nil && len(m.req) > 0 {
@@ -172,6 +195,17 @@ func (m *Message) Requests() []Request {
 	return m.req[:m.reqn]
 }
 
+// Parent returns the msg which sub msg split from, nil if not sub msg.
+func (m *Message) Parent() *Message {
+	return m.parent
+}
+
+// WithParent sets the msg which sub msg is grouped with, e.g. the copies of subs split from the same msg
+// share one parent, so they are sent as one multi keys command as well.
+func (m *Message) WithParent(parent *Message) {
+	m.parent = parent
+}
+
 // IsBatch returns whether or not batch.
 func (m *Message) IsBatch() bool {
 	return m.reqn > 1
@@ -186,12 +220,14 @@ func (m *Message) Batch() []*Message {
 	var min = minInt(len(m.subs), slen)
 	for i := 0; i < min; i++ {
 		m.subs[i].Type = m.Type
+		m.subs[i].parent = m
 		m.subs[i].setRequest(m.req[i])
 	}
 	delta := slen - len(m.subs)
 	for i := 0; i < delta; i++ {
 		msg := getMsg()
 		msg.Type = m.Type
+		msg.parent = m
 		msg.setRequest(m.req[min+i])
 		m.subs = append(m.subs, msg)
 	}
@@ -0,0 +1,281 @@
+package redis
+
+import (
+	"strconv"
+)
+
+// cmdFlag describes how proxy handles the command.
+type cmdFlag uint8
+
+// command flags
+const (
+	cmdFlagRead cmdFlag = 1 << iota
+	cmdFlagWrite
+	// cmdFlagAdmin is the command of server administration, which is never forwarded to nodes.
+	cmdFlagAdmin
+	// cmdFlagCtl is the command answered by proxy.
+	cmdFlagCtl
+	// cmdFlagCross is the command whose keys may span nodes, it is emulated by proxy when they do.
+	cmdFlagCross
+	// cmdFlagHash is the command of hash, whose version field can be read, e.g. quorum read.
+	cmdFlagHash
+)
+
+// command is the spec of redis command. Arity and key positions are same as COMMAND INFO of redis:
+// positive arity is the exact count of arguments including command name, negative one is the minimum,
+// and negative lastKey counts from the end of arguments.
+type command struct {
+	name     string
+	arity    int
+	flags    cmdFlag
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// numKeys is the index of argument counting the keys following it, e.g. numkeys of ZUNIONSTORE.
+	numKeys int
+
+	// mType is how to merge replies of sub requests split by keys, mergeTypeNo means never split.
+	mType mergeType
+	// sub is the command of sub requests.
+	sub string
+	// resp3 is the reply type for RESP3 clients, zero means same as RESP2.
+	resp3 respType
+
+	// bulk is the "<len>\r\n<name>" form same as bulk data.
+	bulk []byte
+	// subLen is the count of arguments of sub request.
+	subLen []byte
+}
+
+func newCommand(name string, arity int, flags cmdFlag, firstKey, lastKey, keyStep int) *command {
+	return &command{
+		name:     name,
+		arity:    arity,
+		flags:    flags,
+		firstKey: firstKey,
+		lastKey:  lastKey,
+		keyStep:  keyStep,
+	}
+}
+
+// split sets the command split into sub requests by keys.
+func (c *command) split(mType mergeType, sub string) *command {
+	c.mType = mType
+	c.sub = sub
+	return c
+}
+
+// movable sets the keys counted by the argument at index numKeys.
+func (c *command) movable(numKeys int) *command {
+	c.numKeys = numKeys
+	return c
+}
+
+// reply3 sets the reply type for RESP3 clients.
+func (c *command) reply3(rTp respType) *command {
+	c.resp3 = rTp
+	return c
+}
+
+// isForward reports whether the command is forwarded to nodes.
+func (c *command) isForward() bool {
+	return c.flags&(cmdFlagRead|cmdFlagWrite) != 0
+}
+
+// validate checks the count of arguments including command name.
+func (c *command) validate(argc int) bool {
+	if c.arity >= 0 && argc != c.arity {
+		return false
+	}
+	if c.arity < 0 && argc < -c.arity {
+		return false
+	}
+	if c.mType != mergeTypeNo && c.lastKey < 0 && c.keyStep > 1 {
+		return (argc-c.firstKey)%c.keyStep == 0
+	}
+	return true
+}
+
+// keyIndex returns the index range of keys in arguments, keys are at first, first+keyStep... until last.
+func (c *command) keyIndex(argc int) (first, last int) {
+	first, last = c.firstKey, c.lastKey
+	if last < 0 {
+		last += argc
+	}
+	if last >= argc {
+		last = argc - 1
+	}
+	return
+}
+
+var (
+	commands = map[string]*command{}
+
+	commandTable = []*command{
+		// control
+		newCommand("PING", -1, cmdFlagCtl, 0, 0, 0),
+		newCommand("HELLO", -1, cmdFlagCtl, 0, 0, 0),
+		// keys
+		newCommand("DEL", -2, cmdFlagWrite, 1, -1, 1).split(mergeTypeCount, "DEL"),
+		newCommand("UNLINK", -2, cmdFlagWrite, 1, -1, 1).split(mergeTypeCount, "UNLINK"),
+		newCommand("EXISTS", -2, cmdFlagRead, 1, -1, 1).split(mergeTypeCount, "EXISTS"),
+		newCommand("TOUCH", -2, cmdFlagRead, 1, -1, 1).split(mergeTypeCount, "TOUCH"),
+		newCommand("DUMP", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("RESTORE", -4, cmdFlagWrite, 1, 1, 1),
+		newCommand("RENAME", 3, cmdFlagWrite|cmdFlagCross, 1, 2, 1),
+		newCommand("RENAMENX", 3, cmdFlagWrite|cmdFlagCross, 1, 2, 1),
+		newCommand("EXPIRE", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("EXPIREAT", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("PEXPIRE", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("PEXPIREAT", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("PERSIST", 2, cmdFlagWrite, 1, 1, 1),
+		newCommand("TTL", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("PTTL", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("TYPE", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("SORT", -2, cmdFlagWrite, 1, 1, 1),
+		// strings
+		newCommand("GET", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("MGET", -2, cmdFlagRead, 1, -1, 1).split(mergeTypeJoin, "GET"),
+		newCommand("SET", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("MSET", -3, cmdFlagWrite, 1, -1, 2).split(mergeTypeOK, "MSET"),
+		newCommand("MSETNX", -3, cmdFlagWrite|cmdFlagCross, 1, -1, 2),
+		newCommand("SETNX", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("SETEX", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("PSETEX", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("GETSET", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("GETDEL", 2, cmdFlagWrite, 1, 1, 1),
+		newCommand("GETEX", -2, cmdFlagWrite, 1, 1, 1),
+		newCommand("APPEND", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("STRLEN", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("GETRANGE", 4, cmdFlagRead, 1, 1, 1),
+		newCommand("SETRANGE", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("INCR", 2, cmdFlagWrite, 1, 1, 1),
+		newCommand("INCRBY", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("INCRBYFLOAT", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("DECR", 2, cmdFlagWrite, 1, 1, 1),
+		newCommand("DECRBY", 3, cmdFlagWrite, 1, 1, 1),
+		newCommand("GETBIT", 3, cmdFlagRead, 1, 1, 1),
+		newCommand("SETBIT", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("BITCOUNT", -2, cmdFlagRead, 1, 1, 1),
+		newCommand("BITPOS", -3, cmdFlagRead, 1, 1, 1),
+		newCommand("BITFIELD", -2, cmdFlagWrite, 1, 1, 1),
+		// hashes
+		newCommand("HDEL", -3, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HEXISTS", 3, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HGET", 3, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HGETALL", 2, cmdFlagRead|cmdFlagHash, 1, 1, 1).reply3(respMap),
+		newCommand("HINCRBY", 4, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HINCRBYFLOAT", 4, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HKEYS", 2, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HLEN", 2, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HMGET", -3, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HMSET", -4, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HSET", -4, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HSETNX", 4, cmdFlagWrite|cmdFlagHash, 1, 1, 1),
+		newCommand("HSTRLEN", 3, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HVALS", 2, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		newCommand("HSCAN", -3, cmdFlagRead|cmdFlagHash, 1, 1, 1),
+		// lists
+		newCommand("LINDEX", 3, cmdFlagRead, 1, 1, 1),
+		newCommand("LINSERT", 5, cmdFlagWrite, 1, 1, 1),
+		newCommand("LLEN", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("LPOP", -2, cmdFlagWrite, 1, 1, 1),
+		newCommand("LPUSH", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("LPUSHX", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("LRANGE", 4, cmdFlagRead, 1, 1, 1),
+		newCommand("LREM", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("LSET", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("LTRIM", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("RPOP", -2, cmdFlagWrite, 1, 1, 1),
+		newCommand("RPOPLPUSH", 3, cmdFlagWrite, 1, 2, 1),
+		newCommand("RPUSH", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("RPUSHX", -3, cmdFlagWrite, 1, 1, 1),
+		// sets
+		newCommand("SADD", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("SCARD", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("SDIFF", -2, cmdFlagRead, 1, -1, 1).reply3(respSet),
+		newCommand("SDIFFSTORE", -3, cmdFlagWrite|cmdFlagCross, 1, -1, 1),
+		newCommand("SINTER", -2, cmdFlagRead, 1, -1, 1).reply3(respSet),
+		newCommand("SINTERSTORE", -3, cmdFlagWrite|cmdFlagCross, 1, -1, 1),
+		newCommand("SISMEMBER", 3, cmdFlagRead, 1, 1, 1),
+		newCommand("SMEMBERS", 2, cmdFlagRead, 1, 1, 1).reply3(respSet),
+		newCommand("SMOVE", 4, cmdFlagWrite, 1, 2, 1),
+		newCommand("SPOP", -2, cmdFlagWrite, 1, 1, 1),
+		newCommand("SRANDMEMBER", -2, cmdFlagRead, 1, 1, 1),
+		newCommand("SREM", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("SUNION", -2, cmdFlagRead, 1, -1, 1).reply3(respSet),
+		newCommand("SUNIONSTORE", -3, cmdFlagWrite|cmdFlagCross, 1, -1, 1),
+		newCommand("SSCAN", -3, cmdFlagRead, 1, 1, 1),
+		// sorted sets
+		newCommand("ZADD", -4, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZCARD", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("ZCOUNT", 4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZINCRBY", 4, cmdFlagWrite, 1, 1, 1).reply3(respDouble),
+		newCommand("ZINTERSTORE", -4, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZLEXCOUNT", 4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZRANGE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZRANGEBYLEX", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZRANGEBYSCORE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZRANK", 3, cmdFlagRead, 1, 1, 1),
+		newCommand("ZREM", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZREMRANGEBYLEX", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZREMRANGEBYRANK", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZREMRANGEBYSCORE", 4, cmdFlagWrite, 1, 1, 1),
+		newCommand("ZREVRANGE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZREVRANGEBYLEX", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZREVRANGEBYSCORE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("ZREVRANK", 3, cmdFlagRead, 1, 1, 1),
+		newCommand("ZSCORE", 3, cmdFlagRead, 1, 1, 1).reply3(respDouble),
+		newCommand("ZSCAN", -3, cmdFlagRead, 1, 1, 1),
+		newCommand("ZUNIONSTORE", -4, cmdFlagWrite|cmdFlagCross, 1, 1, 1).movable(2),
+		// hyperloglog
+		newCommand("PFADD", -2, cmdFlagWrite, 1, 1, 1),
+		newCommand("PFCOUNT", -2, cmdFlagRead, 1, -1, 1),
+		newCommand("PFMERGE", -2, cmdFlagWrite, 1, -1, 1),
+		// streams
+		newCommand("XADD", -5, cmdFlagWrite, 1, 1, 1),
+		newCommand("XDEL", -3, cmdFlagWrite, 1, 1, 1),
+		newCommand("XLEN", 2, cmdFlagRead, 1, 1, 1),
+		newCommand("XRANGE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("XREVRANGE", -4, cmdFlagRead, 1, 1, 1),
+		newCommand("XTRIM", -4, cmdFlagWrite, 1, 1, 1),
+		// administration
+		newCommand("AUTH", -2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("COMMAND", -1, cmdFlagAdmin, 0, 0, 0),
+		newCommand("CONFIG", -2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("ECHO", 2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("EVAL", -3, cmdFlagAdmin, 3, 3, 1),
+		newCommand("INFO", -1, cmdFlagAdmin, 0, 0, 0),
+		newCommand("KEYS", 2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("MEMORY", -2, cmdFlagAdmin, 2, 2, 1),
+		newCommand("MIGRATE", -6, cmdFlagAdmin, 0, 0, 0),
+		newCommand("MOVE", 3, cmdFlagAdmin, 1, 1, 1),
+		newCommand("OBJECT", -2, cmdFlagAdmin, 2, 2, 1),
+		newCommand("QUIT", 1, cmdFlagAdmin, 0, 0, 0),
+		newCommand("RANDOMKEY", 1, cmdFlagAdmin, 0, 0, 0),
+		newCommand("SCAN", -2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("SELECT", 2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("SLOWLOG", -2, cmdFlagAdmin, 0, 0, 0),
+		newCommand("TIME", 1, cmdFlagAdmin, 0, 0, 0),
+		newCommand("WAIT", 3, cmdFlagAdmin, 0, 0, 0),
+	}
+)
+
+func init() {
+	for _, c := range commandTable {
+		registerCommand(c)
+	}
+}
+
+func registerCommand(c *command) {
+	c.bulk = []byte(strconv.Itoa(len(c.name)) + "\r\n" + c.name)
+	if c.mType != mergeTypeNo {
+		c.subLen = []byte(strconv.Itoa(c.keyStep + 1))
+	}
+	commands[c.name] = c
+}
+
+// lookupCommand returns the command by uppercase name, nil means unknown command.
+func lookupCommand(name []byte) *command {
+	return commands[string(name)]
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandValidate(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Argc   int
+		Expect bool
+	}{
+		{Name: "GET", Argc: 2, Expect: true},
+		{Name: "GET", Argc: 3, Expect: false},
+		{Name: "SET", Argc: 5, Expect: true},
+		{Name: "SET", Argc: 2, Expect: false},
+		{Name: "MSET", Argc: 5, Expect: true},
+		{Name: "MSET", Argc: 4, Expect: false},
+		{Name: "UNLINK", Argc: 1, Expect: false},
+		{Name: "UNLINK", Argc: 4, Expect: true},
+	}
+	for _, tt := range ts {
+		c := lookupCommand([]byte(tt.Name))
+		if assert.NotNil(t, c, tt.Name) {
+			assert.Equal(t, tt.Expect, c.validate(tt.Argc), "%s %d", tt.Name, tt.Argc)
+		}
+	}
+	assert.Nil(t, lookupCommand([]byte("NOTEXISTS")))
+}
+
+func TestCommandKeyIndex(t *testing.T) {
+	first, last := lookupCommand([]byte("MSET")).keyIndex(5)
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 4, last)
+	first, last = lookupCommand([]byte("RPOPLPUSH")).keyIndex(3)
+	assert.Equal(t, 1, first)
+	assert.Equal(t, 2, last)
+	assert.Equal(t, []byte("4\r\nMGET"), lookupCommand([]byte("MGET")).bulk)
+}
+
+func TestRequestKeyPosition(t *testing.T) {
+	req := newRequest("OBJECT", "ENCODING", "mykey")
+	assert.Equal(t, "mykey", string(req.Key()))
+	req = newRequest("GET", "a")
+	assert.Equal(t, "a", string(req.Key()))
+	assert.True(t, req.isSupport())
+	assert.False(t, newRequest("CONFIG", "GET", "a").isSupport())
+	assert.True(t, newRequest("PING").isCtl())
+	assert.False(t, newRequest("EVAL", "return 1", "1", "a").isSupport())
+	req = NewScriptRequest([]byte("return 1"), []byte("a"))
+	assert.True(t, req.isSupport())
+	assert.Equal(t, "a", string(req.Key()))
+}
@@ -0,0 +1,488 @@
+package redis
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	okDataBytes         = []byte("OK")
+	noSuchKeyBytes      = []byte("ERR no such key")
+	syntaxErrBytes      = []byte("ERR syntax error")
+	weightErrBytes      = []byte("ERR weight value is not a float")
+	crossTooLargeBytes  = []byte("ERR cross node command exceeds the max size")
+	crossNotSupportData = []byte("ERR cross node command not support")
+	busyKeyPrefix       = []byte("BUSYKEY")
+	memoryUsageBytes    = []byte("USAGE")
+)
+
+// Executor executes the requests on the nodes of their keys and waits until all replied,
+// requests to the same node are executed in order. The requests are owned by executor.
+type Executor func(reqs ...*Request) error
+
+// Emulate evaluates the command whose keys span nodes by proxy: the source keys are read
+// from their nodes, the result is computed by proxy and written to the node of destination key.
+// RENAME and RENAMENX are emulated by DUMP, RESTORE and DEL.
+//
+// NOTE: it is best effort and not atomic. Other clients may observe the intermediate state,
+// writes to source keys between read and write are lost, and only MSETNX rolls back the keys
+// it set when it fails halfway. maxSize limits the bytes read from source keys, which is checked
+// by MEMORY USAGE before they are read.
+func Emulate(req *Request, exec Executor, maxSize int) (err error) {
+	e := &emulator{req: req, exec: exec, maxSize: maxSize}
+	switch req.command().name {
+	case "SDIFFSTORE", "SINTERSTORE", "SUNIONSTORE":
+		return e.setStore()
+	case "ZUNIONSTORE":
+		return e.zunionStore()
+	case "MSETNX":
+		return e.msetnx()
+	case "RENAME", "RENAMENX":
+		return e.rename()
+	}
+	e.setError(crossNotSupportData)
+	return
+}
+
+type emulator struct {
+	req     *Request
+	exec    Executor
+	maxSize int
+}
+
+func (e *emulator) arg(i int) []byte {
+	return e.req.resp.array[i].payload()
+}
+
+func (e *emulator) argc() int {
+	return e.req.resp.arrayn
+}
+
+func (e *emulator) setInt(n int) {
+	r := e.req.reply
+	r.reset()
+	r.rTp = respInt
+	r.data = []byte(strconv.Itoa(n))
+}
+
+func (e *emulator) setOK() {
+	r := e.req.reply
+	r.reset()
+	r.rTp = respString
+	r.data = okDataBytes
+}
+
+func (e *emulator) setError(bs []byte) {
+	r := e.req.reply
+	r.reset()
+	r.rTp = respError
+	r.data = append([]byte(nil), bs...) // NOTE: node replies are released after emulation.
+}
+
+// failed sets the first error replied by nodes as reply.
+func (e *emulator) failed(reqs []*Request) bool {
+	for _, r := range reqs {
+		if r.reply.rTp == respError {
+			e.setError(r.reply.data)
+			return true
+		}
+	}
+	return false
+}
+
+// fits checks the memory usage of source keys before they are read, and sets error reply when
+// it exceeds max size, so the large keys are never fetched.
+func (e *emulator) fits(keys ...[]byte) (ok bool, err error) {
+	if e.maxSize <= 0 {
+		return true, nil
+	}
+	reqs := make([]*Request, len(keys))
+	for i, key := range keys {
+		reqs[i] = NewRequest("MEMORY", memoryUsageBytes, key)
+		reqs[i].admin = true
+	}
+	if err = e.exec(reqs...); err != nil || e.failed(reqs) {
+		return
+	}
+	var size int64
+	for _, r := range reqs {
+		// NOTE: the missing key is replied with null.
+		n, _ := strconv.ParseInt(string(r.reply.data), 10, 64)
+		size += n
+	}
+	if size > int64(e.maxSize) {
+		e.setError(crossTooLargeBytes)
+		return
+	}
+	return true, nil
+}
+
+// tooLarge sets error reply when replies of source keys exceed max size, since the keys may grow
+// after their memory usage is checked.
+func (e *emulator) tooLarge(reqs []*Request) bool {
+	if e.maxSize <= 0 {
+		return false
+	}
+	var size int
+	for _, r := range reqs {
+		size += len(r.reply.data)
+		for i := 0; i < r.reply.arrayn; i++ {
+			size += len(r.reply.array[i].data)
+		}
+	}
+	if size > e.maxSize {
+		e.setError(crossTooLargeBytes)
+		return true
+	}
+	return false
+}
+
+// fetch executes the read requests and checks their replies.
+func (e *emulator) fetch(reqs ...*Request) (ok bool, err error) {
+	if err = e.exec(reqs...); err != nil {
+		return
+	}
+	ok = !e.failed(reqs) && !e.tooLarge(reqs)
+	return
+}
+
+// store executes the write requests and checks their replies.
+func (e *emulator) store(reqs ...*Request) (ok bool, err error) {
+	if err = e.exec(reqs...); err != nil {
+		return
+	}
+	ok = !e.failed(reqs)
+	return
+}
+
+// setStore emulates "SDIFFSTORE|SINTERSTORE|SUNIONSTORE destination key [key ...]".
+func (e *emulator) setStore() (err error) {
+	dst := e.arg(1)
+	srcs := make([][]byte, 0, e.argc()-2)
+	for i := 2; i < e.argc(); i++ {
+		srcs = append(srcs, e.arg(i))
+	}
+	if ok, err := e.fits(srcs...); !ok || err != nil {
+		return err
+	}
+	reqs := make([]*Request, len(srcs))
+	for i, src := range srcs {
+		reqs[i] = NewRequest("SMEMBERS", src)
+	}
+	if ok, err := e.fetch(reqs...); !ok || err != nil {
+		return err
+	}
+	sets := make([][][]byte, len(reqs))
+	for i, r := range reqs {
+		sets[i] = respPayloads(r.reply)
+	}
+	var members [][]byte
+	switch e.req.command().name {
+	case "SDIFFSTORE":
+		members = setDiff(sets)
+	case "SINTERSTORE":
+		members = setInter(sets)
+	default:
+		members = setUnion(sets)
+	}
+	writes := []*Request{NewRequest("DEL", dst)}
+	if len(members) > 0 {
+		writes = append(writes, NewRequest("SADD", append([][]byte{dst}, members...)...))
+	}
+	if ok, err := e.store(writes...); !ok || err != nil {
+		return err
+	}
+	e.setInt(len(members))
+	return
+}
+
+// zunionStore emulates "ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]]
+// [AGGREGATE SUM|MIN|MAX]", all source keys must be sorted sets.
+func (e *emulator) zunionStore() (err error) {
+	dst := e.arg(1)
+	n, _ := strconv.Atoi(string(e.arg(2))) // NOTE: numkeys is checked by CrossKeys.
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := "SUM"
+	for i := 3 + n; i < e.argc(); {
+		switch opt := strings.ToUpper(string(e.arg(i))); {
+		case opt == "WEIGHTS" && i+n < e.argc():
+			for j := 0; j < n; j++ {
+				w, perr := parseScore(e.arg(i + 1 + j))
+				if perr != nil {
+					e.setError(weightErrBytes)
+					return
+				}
+				weights[j] = w
+			}
+			i += n + 1
+		case opt == "AGGREGATE" && i+1 < e.argc():
+			aggregate = strings.ToUpper(string(e.arg(i + 1)))
+			if aggregate != "SUM" && aggregate != "MIN" && aggregate != "MAX" {
+				e.setError(syntaxErrBytes)
+				return
+			}
+			i += 2
+		default:
+			e.setError(syntaxErrBytes)
+			return
+		}
+	}
+	srcs := make([][]byte, n)
+	for i := range srcs {
+		srcs[i] = e.arg(3 + i)
+	}
+	if ok, err := e.fits(srcs...); !ok || err != nil {
+		return err
+	}
+	reqs := make([]*Request, n)
+	for i, src := range srcs {
+		reqs[i] = NewRequest("ZRANGE", src, []byte("0"), []byte("-1"), []byte("WITHSCORES"))
+	}
+	if ok, err := e.fetch(reqs...); !ok || err != nil {
+		return err
+	}
+	var (
+		members [][]byte
+		scores  = map[string]float64{}
+	)
+	for i, r := range reqs {
+		items := respPayloads(r.reply)
+		for j := 0; j+1 < len(items); j += 2 {
+			score, perr := parseScore(items[j+1])
+			if perr != nil {
+				e.setError(syntaxErrBytes)
+				return
+			}
+			score = zscore(score * weights[i])
+			old, ok := scores[string(items[j])]
+			if !ok {
+				members = append(members, items[j])
+				scores[string(items[j])] = score
+				continue
+			}
+			scores[string(items[j])] = zaggregate(aggregate, old, score)
+		}
+	}
+	writes := []*Request{NewRequest("DEL", dst)}
+	if len(members) > 0 {
+		args := make([][]byte, 0, 2*len(members)+1)
+		args = append(args, dst)
+		for _, m := range members {
+			args = append(args, []byte(strconv.FormatFloat(scores[string(m)], 'g', 17, 64)), m)
+		}
+		writes = append(writes, NewRequest("ZADD", args...))
+	}
+	if ok, err := e.store(writes...); !ok || err != nil {
+		return err
+	}
+	e.setInt(len(members))
+	return
+}
+
+// msetnx emulates "MSETNX key value [key value ...]" by EXISTS and SET NX,
+// the keys set are deleted if any SET NX fails.
+func (e *emulator) msetnx() (err error) {
+	var (
+		keys   [][]byte
+		values = map[string][]byte{}
+	)
+	for i := 1; i+1 < e.argc(); i += 2 {
+		if _, ok := values[string(e.arg(i))]; !ok {
+			keys = append(keys, e.arg(i))
+		}
+		values[string(e.arg(i))] = e.arg(i + 1) // NOTE: the last value wins same as redis.
+	}
+	exists := make([]*Request, len(keys))
+	for i, k := range keys {
+		exists[i] = NewRequest("EXISTS", k)
+	}
+	if ok, err := e.fetch(exists...); !ok || err != nil {
+		return err
+	}
+	for _, r := range exists {
+		if !bytes.Equal(r.reply.data, zeroBytes) {
+			e.setInt(0)
+			return
+		}
+	}
+	sets := make([]*Request, len(keys))
+	for i, k := range keys {
+		sets[i] = NewRequest("SET", k, values[string(k)], []byte("NX"))
+	}
+	if err = e.exec(sets...); err != nil {
+		return
+	}
+	var rollback []*Request
+	for i, r := range sets {
+		if r.reply.rTp == respString {
+			rollback = append(rollback, NewRequest("DEL", keys[i]))
+		}
+	}
+	if len(rollback) == len(sets) {
+		e.setInt(1)
+		return
+	}
+	if len(rollback) > 0 {
+		if err = e.exec(rollback...); err != nil {
+			return
+		}
+	}
+	if !e.failed(sets) {
+		e.setInt(0)
+	}
+	return
+}
+
+// rename emulates "RENAME|RENAMENX key newkey" by DUMP, RESTORE and DEL.
+func (e *emulator) rename() (err error) {
+	src, dst := e.arg(1), e.arg(2)
+	nx := e.req.command().name == "RENAMENX"
+	if ok, err := e.fits(src); !ok || err != nil {
+		return err
+	}
+	reqs := []*Request{NewRequest("DUMP", src), NewRequest("PTTL", src)}
+	if nx {
+		reqs = append(reqs, NewRequest("EXISTS", dst))
+	}
+	if ok, err := e.fetch(reqs...); !ok || err != nil {
+		return err
+	}
+	payload := reqs[0].reply.payload()
+	if payload == nil {
+		e.setError(noSuchKeyBytes)
+		return
+	}
+	if nx && !bytes.Equal(reqs[2].reply.data, zeroBytes) {
+		e.setInt(0)
+		return
+	}
+	// NOTE: the key expired between DUMP and PTTL is no such key, not a persistent one.
+	ttl, ok := restoreTTL(reqs[1])
+	if !ok {
+		e.setError(noSuchKeyBytes)
+		return
+	}
+	args := [][]byte{dst, ttl, payload}
+	if !nx {
+		args = append(args, []byte("REPLACE"))
+	}
+	restore := NewRequest("RESTORE", args...)
+	if err = e.exec(restore); err != nil {
+		return
+	}
+	if nx && restore.reply.rTp == respError && bytes.HasPrefix(restore.reply.data, busyKeyPrefix) {
+		e.setInt(0)
+		return
+	}
+	if e.failed([]*Request{restore}) {
+		return
+	}
+	if ok, err := e.store(NewRequest("DEL", src)); !ok || err != nil {
+		return err
+	}
+	if nx {
+		e.setInt(1)
+		return
+	}
+	e.setOK()
+	return
+}
+
+// respPayloads returns the payloads of array reply.
+func respPayloads(r *resp) [][]byte {
+	items := make([][]byte, r.arrayn)
+	for i := 0; i < r.arrayn; i++ {
+		items[i] = r.array[i].payload()
+	}
+	return items
+}
+
+func setUnion(sets [][][]byte) (members [][]byte) {
+	seen := map[string]struct{}{}
+	for _, set := range sets {
+		for _, m := range set {
+			if _, ok := seen[string(m)]; !ok {
+				seen[string(m)] = struct{}{}
+				members = append(members, m)
+			}
+		}
+	}
+	return
+}
+
+func setInter(sets [][][]byte) (members [][]byte) {
+	counts := map[string]int{}
+	for i, set := range sets {
+		for _, m := range set {
+			if counts[string(m)] == i {
+				counts[string(m)] = i + 1
+			}
+		}
+	}
+	for _, m := range sets[0] {
+		if counts[string(m)] == len(sets) {
+			members = append(members, m)
+		}
+	}
+	return
+}
+
+func setDiff(sets [][][]byte) (members [][]byte) {
+	others := map[string]struct{}{}
+	for _, set := range sets[1:] {
+		for _, m := range set {
+			others[string(m)] = struct{}{}
+		}
+	}
+	for _, m := range sets[0] {
+		if _, ok := others[string(m)]; !ok {
+			members = append(members, m)
+		}
+	}
+	return
+}
+
+// parseScore parses score same as redis, which accepts inf, +inf and -inf.
+func parseScore(bs []byte) (float64, error) {
+	f, err := strconv.ParseFloat(string(bs), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, ErrBadRequest
+	}
+	return f, nil
+}
+
+// zscore turns NaN into 0 same as redis, e.g. 0 * inf.
+func zscore(f float64) float64 {
+	if math.IsNaN(f) {
+		return 0
+	}
+	return f
+}
+
+func zaggregate(aggregate string, a, b float64) float64 {
+	switch aggregate {
+	case "MIN":
+		return math.Min(a, b)
+	case "MAX":
+		return math.Max(a, b)
+	}
+	return zscore(a + b)
+}
+
+// restoreTTL returns the ttl of RESTORE by the reply of PTTL, -1 means no expire, and false means
+// the key expired after DUMP, which must not be restored.
+func restoreTTL(pttl *Request) (ttl []byte, ok bool) {
+	n, err := strconv.ParseInt(string(pttl.reply.data), 10, 64)
+	switch {
+	case err != nil || n == -2:
+		return nil, false
+	case n == -1:
+		return zeroBytes, true
+	}
+	return pttl.reply.data, true
+}
@@ -0,0 +1,236 @@
+package redis
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNodes is the in memory nodes serving the requests of emulator.
+type fakeNodes struct {
+	strs  map[string]string
+	sets  map[string][]string
+	zsets map[string]map[string]string
+	// pttls are the replies of PTTL, which are set by the ttl of RESTORE, -1 when absent.
+	pttls map[string]string
+	// vers are the replies of HGET, which is the version field of key.
+	vers  map[string]string
+	execs int
+}
+
+func newFakeNodes() *fakeNodes {
+	return &fakeNodes{strs: map[string]string{}, sets: map[string][]string{}, zsets: map[string]map[string]string{}, pttls: map[string]string{}, vers: map[string]string{}}
+}
+
+func bulkResp(s string) *resp {
+	r := &resp{}
+	r.setBulk([]byte(s))
+	return r
+}
+
+func (f *fakeNodes) exists(k string) bool {
+	_, s := f.strs[k]
+	_, set := f.sets[k]
+	_, zset := f.zsets[k]
+	return s || set || zset
+}
+
+func (f *fakeNodes) exec(reqs ...*Request) error {
+	f.execs++
+	for _, r := range reqs {
+		args := make([]string, r.resp.arrayn)
+		for i := range args {
+			args[i] = string(r.resp.array[i].payload())
+		}
+		switch args[0] {
+		case "SMEMBERS":
+			var items []*resp
+			for _, m := range f.sets[args[1]] {
+				items = append(items, bulkResp(m))
+			}
+			r.reply = newrespArray(items)
+		case "ZRANGE":
+			var items []*resp
+			for m, s := range f.zsets[args[1]] {
+				items = append(items, bulkResp(m), bulkResp(s))
+			}
+			r.reply = newrespArray(items)
+		case "DEL":
+			delete(f.strs, args[1])
+			delete(f.sets, args[1])
+			delete(f.zsets, args[1])
+			r.reply = newresp(respInt, []byte("1"))
+		case "SADD":
+			f.sets[args[1]] = args[2:]
+			r.reply = newresp(respInt, []byte(strconv.Itoa(len(args)-2)))
+		case "ZADD":
+			f.zsets[args[1]] = map[string]string{}
+			for i := 2; i < len(args); i += 2 {
+				f.zsets[args[1]][args[i+1]] = args[i]
+			}
+			r.reply = newresp(respInt, []byte(strconv.Itoa(len(args)/2-1)))
+		case "EXISTS":
+			r.reply = newresp(respInt, []byte("0"))
+			if f.exists(args[1]) {
+				r.reply = newresp(respInt, []byte("1"))
+			}
+		case "SET":
+			r.reply = newresp(respBulk, nil)
+			if !f.exists(args[1]) {
+				f.strs[args[1]] = args[2]
+				r.reply = newresp(respString, []byte("OK"))
+			}
+		case "DUMP":
+			r.reply = newresp(respBulk, nil)
+			if v, ok := f.strs[args[1]]; ok {
+				r.reply = bulkResp("dump:" + v)
+			}
+		case "PTTL":
+			r.reply = newresp(respInt, []byte("-1"))
+			if ttl, ok := f.pttls[args[1]]; ok {
+				r.reply = newresp(respInt, []byte(ttl))
+			}
+		case "RESTORE":
+			if f.exists(args[1]) && len(args) < 5 {
+				r.reply = newresp(respError, []byte("BUSYKEY Target key name already exists."))
+				continue
+			}
+			f.strs[args[1]] = args[3][len("dump:"):]
+			if args[2] != "0" {
+				f.pttls[args[1]] = args[2]
+			}
+			r.reply = newresp(respString, []byte("OK"))
+		case "MEMORY":
+			// NOTE: the memory usage is the bytes of value or members.
+			size := len(f.strs[args[2]])
+			for _, m := range f.sets[args[2]] {
+				size += len(m)
+			}
+			for m, score := range f.zsets[args[2]] {
+				size += len(m) + len(score)
+			}
+			r.reply = newresp(respBulk, nil)
+			if f.exists(args[2]) {
+				r.reply = newresp(respInt, []byte(strconv.Itoa(size)))
+			}
+		case "HGET":
+			r.reply = newresp(respBulk, nil)
+			if v, ok := f.vers[args[1]]; ok {
+				r.reply = bulkResp(v)
+			}
+		case "EVAL":
+			// NOTE: only the repair script is served, args are script, numkeys, key, field, version, payload and ttl.
+			key := args[3]
+			stored, _ := strconv.ParseUint(f.vers[key], 10, 64)
+			if version, _ := strconv.ParseUint(args[5], 10, 64); stored >= version {
+				r.reply = newresp(respInt, []byte("0"))
+				continue
+			}
+			f.strs[key], f.vers[key] = args[6][len("dump:"):], args[5]
+			if args[7] != "0" {
+				f.pttls[key] = args[7]
+			}
+			r.reply = newresp(respInt, []byte("1"))
+		default:
+			r.reply = newresp(respError, []byte("ERR unknown command"))
+		}
+	}
+	return nil
+}
+
+func TestEmulateSetStoreOk(t *testing.T) {
+	f := newFakeNodes()
+	f.sets["a"] = []string{"1", "2", "3"}
+	f.sets["b"] = []string{"2", "3", "4"}
+	ts := []struct {
+		Cmd    string
+		Expect []string
+	}{
+		{Cmd: "SUNIONSTORE", Expect: []string{"1", "2", "3", "4"}},
+		{Cmd: "SINTERSTORE", Expect: []string{"2", "3"}},
+		{Cmd: "SDIFFSTORE", Expect: []string{"1"}},
+	}
+	for _, tt := range ts {
+		req := newRequest(tt.Cmd, "dst", "a", "b")
+		assert.Len(t, req.CrossKeys(), 3)
+		assert.NoError(t, Emulate(req, f.exec, 0))
+		assert.Equal(t, strconv.Itoa(len(tt.Expect)), string(req.reply.data), tt.Cmd)
+		assert.Equal(t, tt.Expect, f.sets["dst"], tt.Cmd)
+	}
+	req := newRequest("SINTERSTORE", "dst", "a", "none")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, "0", string(req.reply.data))
+	assert.NotContains(t, f.sets, "dst")
+
+	// NOTE: the source keys exceeding max size are never read.
+	execs := f.execs
+	req = newRequest("SUNIONSTORE", "dst", "a", "b")
+	assert.NoError(t, Emulate(req, f.exec, 5))
+	assert.Equal(t, respError, req.reply.rTp)
+	assert.Equal(t, crossTooLargeBytes, req.reply.data)
+	assert.Equal(t, execs+1, f.execs)
+}
+
+func TestEmulateZunionStoreOk(t *testing.T) {
+	f := newFakeNodes()
+	f.zsets["a"] = map[string]string{"x": "1", "y": "2"}
+	f.zsets["b"] = map[string]string{"y": "3", "z": "inf"}
+	req := newRequest("ZUNIONSTORE", "dst", "2", "a", "b", "WEIGHTS", "2", "1", "AGGREGATE", "max")
+	assert.Equal(t, [][]byte{[]byte("dst"), []byte("a"), []byte("b")}, req.CrossKeys())
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, "3", string(req.reply.data))
+	assert.Equal(t, map[string]string{"x": "2", "y": "4", "z": "+Inf"}, f.zsets["dst"])
+
+	req = newRequest("ZUNIONSTORE", "dst", "2", "a", "b", "WEIGHTS", "x", "1")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, weightErrBytes, req.reply.data)
+}
+
+func TestEmulateMsetnxOk(t *testing.T) {
+	f := newFakeNodes()
+	req := newRequest("MSETNX", "a", "1", "b", "2", "a", "3")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, "1", string(req.reply.data))
+	assert.Equal(t, map[string]string{"a": "3", "b": "2"}, f.strs)
+
+	req = newRequest("MSETNX", "c", "1", "b", "2")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, "0", string(req.reply.data))
+	assert.NotContains(t, f.strs, "c")
+}
+
+func TestEmulateRenameOk(t *testing.T) {
+	f := newFakeNodes()
+	f.strs["a"] = "1"
+	f.strs["c"] = "3"
+	req := newRequest("RENAME", "a", "b")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, respString, req.reply.rTp)
+	assert.Equal(t, map[string]string{"b": "1", "c": "3"}, f.strs)
+
+	req = newRequest("RENAMENX", "b", "c")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, "0", string(req.reply.data))
+
+	req = newRequest("RENAME", "none", "c")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, noSuchKeyBytes, req.reply.data)
+
+	// NOTE: the key expired after DUMP is replied by PTTL -2, which is not renamed to a persistent key.
+	f.strs["e"] = "5"
+	f.pttls["e"] = "-2"
+	req = newRequest("RENAME", "e", "f")
+	assert.NoError(t, Emulate(req, f.exec, 0))
+	assert.Equal(t, noSuchKeyBytes, req.reply.data)
+	assert.NotContains(t, f.strs, "f")
+	delete(f.strs, "e")
+
+	keys := []string{}
+	for k := range f.strs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"b", "c"}, keys)
+}
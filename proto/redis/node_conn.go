@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -14,6 +15,11 @@ const (
 	closed = uint32(1)
 )
 
+var (
+	zeroBytes = []byte("0")
+	oneBytes  = []byte("1")
+)
+
 type nodeConn struct {
 	cluster string
 	addr    string
@@ -21,6 +27,8 @@ type nodeConn struct {
 	bw      *bufio.Writer
 	br      *bufio.Reader
 	state   uint32
+	// group is the reply of multi keys command merged by sub requests.
+	group *resp
 
 	p *pinger
 }
@@ -31,6 +39,11 @@ func NewNodeConn(cluster, addr string, dialTimeout, readTimeout, writeTimeout ti
 	return newNodeConn(cluster, addr, conn)
 }
 
+// WrapNodeConn create the node conn to redis over the given conn, used by protocol translation.
+func WrapNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
+	return newNodeConn(cluster, addr, conn)
+}
+
 func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
 	return &nodeConn{
 		cluster: cluster,
@@ -38,25 +51,38 @@ func newNodeConn(cluster, addr string, conn *libnet.Conn) proto.NodeConn {
 		br:      bufio.NewReader(conn, nil),
 		bw:      bufio.NewWriter(conn),
 		conn:    conn,
+		group:   &resp{},
 		p:       newPinger(conn),
 	}
 }
 
 func (nc *nodeConn) WriteBatch(mb *proto.MsgBatch) (err error) {
-	for _, m := range mb.Msgs() {
+	msgs := mb.Msgs()
+	for i := 0; i < len(msgs); {
+		m := msgs[i]
 		req, ok := m.Request().(*Request)
 		if !ok {
 			m.DoneWithError(ErrBadAssert)
 			return ErrBadAssert
 		}
 		if !req.isSupport() || req.isCtl() {
+			i++
 			continue
 		}
-		if err = req.resp.encode(nc.bw); err != nil {
+		n := groupLen(msgs, i)
+		if n == 1 {
+			err = req.resp.encode(nc.bw)
+		} else {
+			err = nc.encodeGroup(msgs[i : i+n])
+		}
+		if err != nil {
 			m.DoneWithError(err)
 			return err
 		}
-		m.MarkWrite()
+		for _, gm := range msgs[i : i+n] {
+			gm.MarkWrite()
+		}
+		i += n
 	}
 	return nc.bw.Flush()
 }
@@ -66,8 +92,9 @@ func (nc *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 	defer nc.br.ResetBuffer(nil)
 	begin := nc.br.Mark()
 	now := nc.br.Mark()
-	for i := 0; i < mb.Count(); {
-		m := mb.Nth(i)
+	msgs := mb.Msgs()
+	for i := 0; i < len(msgs); {
+		m := msgs[i]
 		req, ok := m.Request().(*Request)
 		if !ok {
 			return ErrBadAssert
@@ -76,7 +103,12 @@ func (nc *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 			i++
 			continue
 		}
-		if err = req.reply.decode(nc.br); err == bufio.ErrBufferFull {
+		n := groupLen(msgs, i)
+		reply := req.reply
+		if n > 1 {
+			reply = nc.group
+		}
+		if err = reply.decode(nc.br); err == bufio.ErrBufferFull {
 			nc.br.AdvanceTo(begin)
 			if err = nc.br.Read(); err != nil {
 				return
@@ -86,13 +118,77 @@ func (nc *nodeConn) ReadBatch(mb *proto.MsgBatch) (err error) {
 		} else if err != nil {
 			return
 		}
-		m.MarkRead()
+		if n > 1 {
+			nc.scatter(msgs[i : i+n])
+		}
+		for _, gm := range msgs[i : i+n] {
+			gm.MarkRead()
+		}
 		now = nc.br.Mark()
-		i++
+		i += n
 	}
 	return
 }
 
+// groupLen returns the count of msgs from i which are split from the same msg,
+// they are sent as one multi keys command, e.g. GETs into MGET.
+func groupLen(msgs []*proto.Message, i int) int {
+	parent := msgs[i].Parent()
+	if parent == nil {
+		return 1
+	}
+	if req, ok := msgs[i].Request().(*Request); !ok || req.batch == nil {
+		return 1
+	}
+	n := 1
+	for i+n < len(msgs) && msgs[i+n].Parent() == parent {
+		n++
+	}
+	return n
+}
+
+// encodeGroup encodes the sub requests as one multi keys command.
+func (nc *nodeConn) encodeGroup(msgs []*proto.Message) (err error) {
+	c := msgs[0].Request().(*Request).batch
+	_ = nc.bw.Write(respArrayBytes)
+	_ = nc.bw.Write([]byte(strconv.Itoa(len(msgs)*c.keyStep + 1)))
+	_ = nc.bw.Write(crlfBytes)
+	_ = nc.bw.Write(respBulkBytes)
+	_ = nc.bw.Write(c.bulk)
+	if err = nc.bw.Write(crlfBytes); err != nil {
+		return
+	}
+	for _, m := range msgs {
+		req := m.Request().(*Request)
+		for j := 1; j < req.resp.arrayn; j++ {
+			if err = req.resp.array[j].encode(nc.bw); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// scatter sets the reply of multi keys command back to the sub requests in order.
+func (nc *nodeConn) scatter(msgs []*proto.Message) {
+	g := nc.group
+	for i, m := range msgs {
+		req := m.Request().(*Request)
+		switch {
+		case g.rTp == respArray && g.arrayn == len(msgs):
+			req.reply.copy(g.array[i])
+		case g.rTp == respInt && req.mType == mergeTypeCount:
+			// NOTE: count is merged by sum, so the first sub request takes all.
+			req.reply.copy(g)
+			if i > 0 {
+				req.reply.data = zeroBytes
+			}
+		default:
+			req.reply.copy(g)
+		}
+	}
+}
+
 func (nc *nodeConn) Ping() (err error) {
 	return nc.p.ping()
 }
@@ -6,7 +6,10 @@ import (
 	"io"
 	"strconv"
 	"testing"
+	"time"
 
+	"overlord/lib/bufio"
+	libnet "overlord/lib/net"
 	"overlord/proto"
 
 	"github.com/stretchr/testify/assert"
@@ -27,6 +30,18 @@ func (*mockCmd) Key() []byte {
 	return []byte{}
 }
 
+func (*mockCmd) IsRead() bool {
+	return false
+}
+
+func (*mockCmd) IsMiss() bool {
+	return false
+}
+
+func (m *mockCmd) Clone() proto.Request {
+	return m
+}
+
 func (*mockCmd) Put() {
 }
 
@@ -93,8 +108,8 @@ func TestReadBatchWithNilError(t *testing.T) {
 	req.mType = mergeTypeJoin
 	req.reply = &resp{}
 	req.resp = newresp(respArray, []byte("2"))
-	req.resp.array = append(req.resp.array, newresp(respBulk, []byte("GET")))
-	req.resp.arrayn++
+	req.resp.array = append(req.resp.array, newresp(respBulk, []byte("3\r\nGET")), newresp(respBulk, []byte("1\r\na")))
+	req.resp.arrayn += 2
 	msg.WithRequest(req)
 	mb.AddMsg(msg)
 	err := nc.ReadBatch(mb)
@@ -102,6 +117,37 @@ func TestReadBatchWithNilError(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 }
 
+func TestNodeConnGroupBatchOk(t *testing.T) {
+	pc := NewProxyConn(_createConn([]byte("MGET a b c\r\nDEL a b\r\nMSET a 1\r\n"))).(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(3))
+	assert.NoError(t, err)
+	mb := proto.NewMsgBatch()
+	for _, msg := range msgs {
+		for _, sub := range msg.Batch() {
+			mb.AddMsg(sub)
+		}
+	}
+	mconn := &mockConn{
+		addr: "127.0.0.1:12345",
+		rbuf: bytes.NewBufferString("*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n3\r\n:2\r\n+OK\r\n"),
+		wbuf: new(bytes.Buffer),
+	}
+	nc := newNodeConn("baka", "127.0.0.1:12345", libnet.NewConn(mconn, time.Second, time.Second))
+	assert.NoError(t, nc.WriteBatch(mb))
+	assert.Equal(t, "*4\r\n$4\r\nMGET\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"+
+		"*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"+
+		"*3\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\n1\r\n", mconn.wbuf.String())
+	assert.NoError(t, nc.ReadBatch(mb))
+
+	conn, buf := _createDownStreamConn()
+	pc.bw = bufio.NewWriter(conn)
+	for _, msg := range msgs {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "*3\r\n$1\r\n1\r\n$-1\r\n$1\r\n3\r\n:2\r\n+OK\r\n", buf.String())
+}
+
 func TestPingOk(t *testing.T) {
 	nc := newNodeConn("baka", "127.0.0.1:12345", _createRepeatConn(pongBytes, 1))
 	err := nc.Ping()
@@ -127,20 +173,14 @@ func newRequest(cmd string, args ...string) *Request {
 	}
 }
 func getMergeType(cmd []byte) mergeType {
-	// fmt.Println("mtype :", strconv.Quote(string(cmd)))
-	// TODO: impl with tire tree to search quickly
-	if bytes.Equal(cmd, cmdMGetBytes) || bytes.Equal(cmd, cmdGetBytes) {
+	switch string(cmd) {
+	case "4\r\nMGET", "3\r\nGET":
 		return mergeTypeJoin
-	}
-
-	if bytes.Equal(cmd, cmdMSetBytes) {
+	case "4\r\nMSET":
 		return mergeTypeOK
-	}
-
-	if bytes.Equal(cmd, cmdExistsBytes) || bytes.Equal(cmd, cmdDelBytes) {
+	case "6\r\nEXISTS", "3\r\nDEL":
 		return mergeTypeCount
 	}
-
 	return mergeTypeNo
 }
 
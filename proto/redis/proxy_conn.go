@@ -1,8 +1,9 @@
 package redis
 
 import (
-	"bytes"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"overlord/lib/bufio"
 	"overlord/lib/conv"
@@ -15,16 +16,28 @@ import (
 var (
 	nullBytes           = []byte("-1\r\n")
 	okBytes             = []byte("OK\r\n")
-	pongDataBytes       = []byte("+PONG")
+	pongDataBytes       = []byte("PONG")
 	notSupportDataBytes = []byte("Error: command not support")
+
+	helloServerBytes  = []byte("overlord")
+	helloVersionBytes = []byte("6.0.0") // NOTE: the first redis version speaking RESP3.
+	helloModeBytes    = []byte("standalone")
+	helloRoleBytes    = []byte("master")
+	helloBadVerBytes  = []byte("ERR Protocol version is not an integer or out of range")
+	helloNoProtoBytes = []byte("NOPROTO sorry this protocol version is not supported")
 )
 
+var proxyConnID int64
+
 type proxyConn struct {
 	br        *bufio.Reader
 	bw        *bufio.Writer
 	completed bool
 
 	resp *resp
+	// id and resp3 are negotiated by HELLO.
+	id    int64
+	resp3 bool
 }
 
 // NewProxyConn creates new redis Encoder and Decoder.
@@ -34,6 +47,7 @@ func NewProxyConn(conn *libnet.Conn) proto.ProxyConn {
 		bw:        bufio.NewWriter(conn),
 		completed: true,
 		resp:      &resp{},
+		id:        atomic.AddInt64(&proxyConnID, 1),
 	}
 	return r
 }
@@ -62,7 +76,7 @@ func (pc *proxyConn) Decode(msgs []*proto.Message) ([]*proto.Message, error) {
 
 func (pc *proxyConn) decode(m *proto.Message) (err error) {
 	mark := pc.br.Mark()
-	if err = pc.resp.decode(pc.br); err != nil {
+	if err = pc.decodeResp(); err != nil {
 		if err == bufio.ErrBufferFull {
 			pc.br.AdvanceTo(mark)
 		}
@@ -74,68 +88,49 @@ func (pc *proxyConn) decode(m *proto.Message) (err error) {
 		return
 	}
 	conv.UpdateToUpper(pc.resp.array[0].data)
-	cmd := pc.resp.array[0].data // NOTE: when array, first is command
-	if bytes.Equal(cmd, cmdMSetBytes) {
-		if pc.resp.arrayn%2 == 0 {
-			err = ErrBadRequest
-			return
-		}
-		mid := pc.resp.arrayn / 2
-		for i := 0; i < mid; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeOK
-			r.resp.reset() // NOTE: *3\r\n
-			r.resp.rTp = respArray
-			r.resp.data = arrayLenThree
-			// array resp: mset
-			nre1 := r.resp.next() // NOTE: $4\r\nMSET\r\n
-			nre1.reset()
-			nre1.rTp = respBulk
-			nre1.data = cmdMSetBytes
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i*2+1])
-			// array resp: value
-			nre3 := r.resp.next() // NOTE: $vlen\r\nvalue\r\n
-			nre3.copy(pc.resp.array[i*2+2])
-		}
-	} else if bytes.Equal(cmd, cmdMGetBytes) {
-		for i := 1; i < pc.resp.arrayn; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeJoin
-			r.resp.reset() // NOTE: *2\r\n
-			r.resp.rTp = respArray
-			r.resp.data = arrayLenTwo
-			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nGET\r\n
-			nre1.reset()
-			nre1.rTp = respBulk
-			nre1.data = cmdGetBytes
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i])
-		}
-	} else if bytes.Equal(cmd, cmdDelBytes) || bytes.Equal(cmd, cmdExistsBytes) {
-		for i := 1; i < pc.resp.arrayn; i++ {
-			r := nextReq(m)
-			r.mType = mergeTypeCount
-			r.resp.reset() // NOTE: *2\r\n
-			r.resp.rTp = respArray
-			r.resp.data = arrayLenTwo
-			// array resp: get
-			nre1 := r.resp.next() // NOTE: $3\r\nDEL\r\n | $6\r\nEXISTS\r\n
-			nre1.copy(pc.resp.array[0])
-			// array resp: key
-			nre2 := r.resp.next() // NOTE: $klen\r\nkey\r\n
-			nre2.copy(pc.resp.array[i])
-		}
-	} else {
+	c := lookupCommand(pc.resp.array[0].payload()) // NOTE: when array, first is command
+	if c == nil || c.mType == mergeTypeNo || !c.validate(pc.resp.arrayn) {
 		r := nextReq(m)
 		r.resp.copy(pc.resp)
+		return
+	}
+	// NOTE: split multi keys command into sub requests, e.g. MGET into GET and MSET into MSET with one pair.
+	sub := lookupCommand([]byte(c.sub))
+	first, last := c.keyIndex(pc.resp.arrayn)
+	for i := first; i <= last; i += c.keyStep {
+		r := nextReq(m)
+		r.mType = c.mType
+		r.batch = c
+		r.resp.reset() // NOTE: *<subLen>\r\n
+		r.resp.rTp = respArray
+		r.resp.data = c.subLen
+		// array resp: sub command
+		nre := r.resp.next() // NOTE: $3\r\nGET\r\n
+		nre.reset()
+		nre.rTp = respBulk
+		nre.data = sub.bulk
+		// array resp: key and args
+		for j := 0; j < c.keyStep; j++ {
+			r.resp.next().copy(pc.resp.array[i+j]) // NOTE: $klen\r\nkey\r\n
+		}
 	}
 	return
 }
 
+// decodeResp decodes the next command, which is multi bulk or inline command.
+func (pc *proxyConn) decodeResp() (err error) {
+	bs, err := pc.br.ReadExact(1)
+	if err != nil {
+		return
+	}
+	pc.br.Advance(-1)
+	switch bs[0] {
+	case respString, respError, respInt, respBulk, respArray:
+		return pc.resp.decode(pc.br)
+	}
+	return pc.resp.decodeInline(pc.br)
+}
+
 func nextReq(m *proto.Message) *Request {
 	req := m.NextReq()
 	if req == nil {
@@ -159,15 +154,17 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	if !ok {
 		return ErrBadAssert
 	}
-	if !m.IsBatch() {
-		if !req.isSupport() {
+	if req.mType == mergeTypeNo {
+		if req.isCtl() {
+			pc.ctlReply(req)
+		} else if !req.isSupport() {
 			req.reply.rTp = respError
 			req.reply.data = notSupportDataBytes
-		} else if req.isCtl() {
-			if bytes.Equal(req.Cmd(), pingBytes) {
-				req.reply.rTp = respString
-				req.reply.data = pongDataBytes
+			if c := req.command(); c != nil && c.isForward() {
+				req.reply.data = []byte("ERR wrong number of arguments for '" + strings.ToLower(c.name) + "' command")
 			}
+		} else if pc.resp3 {
+			req.reply.toResp3(req.command().resp3)
 		}
 		err = req.reply.encode(pc.bw)
 	} else {
@@ -188,6 +185,63 @@ func (pc *proxyConn) Encode(m *proto.Message) (err error) {
 	return
 }
 
+// ctlReply replies the control command by proxy.
+func (pc *proxyConn) ctlReply(req *Request) {
+	switch req.command().name {
+	case "PING":
+		req.reply.rTp = respString
+		req.reply.data = pongDataBytes
+	case "HELLO":
+		pc.hello(req)
+	}
+}
+
+// hello negotiates the protocol version by "HELLO [protover [AUTH username password] [SETNAME clientname]]",
+// AUTH and SETNAME are ignored by proxy.
+func (pc *proxyConn) hello(req *Request) {
+	r := req.reply
+	r.reset()
+	if req.resp.arrayn > 1 {
+		ver, err := strconv.Atoi(string(req.resp.array[1].payload()))
+		if err != nil {
+			r.rTp = respError
+			r.data = helloBadVerBytes
+			return
+		}
+		if ver != 2 && ver != 3 {
+			r.rTp = respError
+			r.data = helloNoProtoBytes
+			return
+		}
+		pc.resp3 = ver == 3
+	}
+	ver := 2
+	r.rTp = respArray
+	r.data = []byte("14")
+	if pc.resp3 {
+		ver = 3
+		r.rTp = respMap
+		r.data = []byte("7")
+	}
+	r.next().setBulk([]byte("server"))
+	r.next().setBulk(helloServerBytes)
+	r.next().setBulk([]byte("version"))
+	r.next().setBulk(helloVersionBytes)
+	r.next().setBulk([]byte("proto"))
+	nr := r.next()
+	nr.rTp, nr.data = respInt, []byte(strconv.Itoa(ver))
+	r.next().setBulk([]byte("id"))
+	nr = r.next()
+	nr.rTp, nr.data = respInt, []byte(strconv.FormatInt(pc.id, 10))
+	r.next().setBulk([]byte("mode"))
+	r.next().setBulk(helloModeBytes)
+	r.next().setBulk([]byte("role"))
+	r.next().setBulk(helloRoleBytes)
+	r.next().setBulk([]byte("modules"))
+	nr = r.next()
+	nr.rTp, nr.data = respArray, []byte("0")
+}
+
 func (pc *proxyConn) mergeOK(m *proto.Message) (err error) {
 	_ = pc.bw.Write(respStringBytes)
 	err = pc.bw.Write(okBytes)
@@ -229,6 +283,9 @@ func (pc *proxyConn) mergeJoin(m *proto.Message) (err error) {
 		if !ok {
 			return ErrBadAssert
 		}
+		if pc.resp3 {
+			req.reply.toResp3(0)
+		}
 		if err = req.reply.encode(pc.bw); err != nil {
 			return
 		}
@@ -1,8 +1,11 @@
 package redis
 
 import (
+	"fmt"
+	"strconv"
 	"testing"
 
+	"overlord/lib/bufio"
 	"overlord/proto"
 
 	"github.com/stretchr/testify/assert"
@@ -29,6 +32,33 @@ func TestDecodeBasicOk(t *testing.T) {
 	assert.Equal(t, []byte("4\r\nbaka"), req.resp.array[1].data)
 }
 
+func TestDecodeInlineOk(t *testing.T) {
+	data := "get baka\r\nMGET a \"b c\"\r\n*1\r\n$4\r\nPING\r\n"
+	conn := _createConn([]byte(data))
+	pc := NewProxyConn(conn)
+
+	msgs := proto.GetMsgs(3)
+	nmsgs, err := pc.Decode(msgs)
+	assert.NoError(t, err)
+	assert.Len(t, nmsgs, 3)
+
+	req := msgs[0].Request().(*Request)
+	assert.Equal(t, mergeTypeNo, req.mType)
+	assert.Equal(t, "GET", req.CmdString())
+	assert.Equal(t, "baka", string(req.Key()))
+	assert.Equal(t, []byte("2"), req.resp.data)
+	assert.Equal(t, []byte("3\r\nGET"), req.resp.array[0].data)
+	assert.Equal(t, []byte("4\r\nbaka"), req.resp.array[1].data)
+
+	assert.Len(t, nmsgs[1].Batch(), 2)
+	req = msgs[1].Requests()[1].(*Request)
+	assert.Equal(t, mergeTypeJoin, req.mType)
+	assert.Equal(t, "b c", string(req.Key()))
+
+	req = msgs[2].Request().(*Request)
+	assert.Equal(t, "PING", req.CmdString())
+}
+
 func TestDecodeComplexOk(t *testing.T) {
 	data := "*3\r\n$4\r\nMGET\r\n$4\r\nbaka\r\n$4\r\nkaba\r\n*5\r\n$4\r\nMSET\r\n$1\r\na\r\n$1\r\nb\r\n$3\r\neee\r\n$5\r\n12345\r\n*3\r\n$4\r\nMGET\r\n$4\r\nenen\r\n$4\r\nnime\r\n*2\r\n$3\r\nGET\r\n$5\r\nabcde\r\n*3\r\n$3\r\nDEL\r\n$1\r\na\r\n$1\r\nb\r\n"
 	conn := _createConn([]byte(data))
@@ -250,3 +280,56 @@ func TestEncodeCmdOk(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeHelloOk(t *testing.T) {
+	data := "PING\r\nHELLO\r\nHELLO 4\r\nHELLO 3 SETNAME overlord\r\nHGETALL a\r\nGET b\r\n"
+	pc := NewProxyConn(_createConn([]byte(data))).(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(6))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 6)
+	msgs[4].Request().(*Request).reply = newresp(respArray, []byte("2"))
+	msgs[4].Request().(*Request).reply.next().setBulk([]byte("f"))
+	msgs[4].Request().(*Request).reply.next().setBulk([]byte("v"))
+	msgs[5].Request().(*Request).reply = newresp(respBulk, nil)
+
+	conn, buf := _createDownStreamConn()
+	pc.bw = bufio.NewWriter(conn)
+	for _, msg := range msgs {
+		assert.NoError(t, pc.Encode(msg))
+	}
+	assert.NoError(t, pc.Flush())
+	id := strconv.FormatInt(pc.id, 10)
+	hello := "$6\r\nserver\r\n$8\r\noverlord\r\n$7\r\nversion\r\n$5\r\n6.0.0\r\n$5\r\nproto\r\n:%d\r\n$2\r\nid\r\n:" + id +
+		"\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+	assert.Equal(t, "+PONG\r\n"+
+		"*14\r\n"+fmt.Sprintf(hello, 2)+
+		"-NOPROTO sorry this protocol version is not supported\r\n"+
+		"%7\r\n"+fmt.Sprintf(hello, 3)+
+		"%1\r\n$1\r\nf\r\n$1\r\nv\r\n"+
+		"_\r\n", buf.String())
+	assert.True(t, pc.resp3)
+}
+
+func TestDecodeSplitByCommandTable(t *testing.T) {
+	data := "UNLINK a b\r\nMSET a 1 b\r\nMGET a\r\n"
+	pc := NewProxyConn(_createConn([]byte(data))).(*proxyConn)
+	msgs, err := pc.Decode(proto.GetMsgs(3))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+
+	reqs := msgs[0].Requests()
+	assert.Len(t, reqs, 2)
+	assert.Equal(t, mergeTypeCount, reqs[1].(*Request).mType)
+	assert.Equal(t, "UNLINK", reqs[1].CmdString())
+	assert.Equal(t, "b", string(reqs[1].Key()))
+	assert.Len(t, msgs[1].Requests(), 1)
+	assert.False(t, msgs[1].Request().(*Request).isSupport())
+	msgs[2].Request().(*Request).reply = newresp(respBulk, []byte("1\r\n1"))
+
+	conn, buf := _createDownStreamConn()
+	pc.bw = bufio.NewWriter(conn)
+	assert.NoError(t, pc.Encode(msgs[1]))
+	assert.NoError(t, pc.Encode(msgs[2]))
+	assert.NoError(t, pc.Flush())
+	assert.Equal(t, "-ERR wrong number of arguments for 'mset' command\r\n*1\r\n$1\r\n1\r\n", buf.String())
+}
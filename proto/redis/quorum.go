@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// NewVersionRequest returns the HGET request of the version field of hash key.
+func NewVersionRequest(key, field []byte) *Request {
+	return NewRequest("HGET", key, field)
+}
+
+// IsHashRead reports whether req is a read of hash, whose version can be read by version request.
+func IsHashRead(req *Request) bool {
+	c := req.command()
+	return c != nil && c.flags&cmdFlagRead != 0 && c.flags&cmdFlagHash != 0
+}
+
+// Version returns the integer of version field replied to version request vr, the missing key
+// or field is version 0. ok is false when vr is replied with error or not an integer.
+func Version(vr *Request) (version uint64, ok bool) {
+	r := vr.reply
+	if r.rTp != respBulk {
+		return
+	}
+	payload := r.payload()
+	if payload == nil {
+		return 0, true
+	}
+	version, err := strconv.ParseUint(string(payload), 10, 64)
+	return version, err == nil
+}
+
+// repairScript restores the dumped copy of ARGV[3] with ttl ARGV[4] only when the version field
+// ARGV[1] of stored key is an integer lower than ARGV[2], so a newer write is never overwritten. It
+// replies 1 when restored, otherwise 0.
+var repairScript = []byte(`local v = redis.call('HGET', KEYS[1], ARGV[1])
+if not v then v = 0 else v = tonumber(v) end
+if not v or v >= tonumber(ARGV[2]) then return 0 end
+redis.call('RESTORE', KEYS[1], ARGV[4], ARGV[3], 'REPLACE')
+return 1`)
+
+// RepairKey copies key from node src to the nodes dsts whose version field is lower by HGET, DUMP,
+// PTTL and a script of conditional RESTORE REPLACE. The version is read before DUMP, so the copy is
+// never older than the version it is restored by. Nothing is repaired when key is deleted before DUMP
+// or expired before PTTL.
+func RepairKey(exec NodeExecutor, src int, key, field []byte, dsts []int) (repaired, failed int, err error) {
+	vr, dump, pttl := NewVersionRequest(key, field), NewRequest("DUMP", key), NewRequest("PTTL", key)
+	if err = exec([]int{src, src, src}, []*Request{vr, dump, pttl}); err != nil {
+		return
+	}
+	if dump.reply.rTp == respError || pttl.reply.rTp == respError {
+		failed = len(dsts)
+		return
+	}
+	version, ok := Version(vr)
+	if !ok {
+		failed = len(dsts)
+		return
+	}
+	payload := dump.reply.payload()
+	if payload == nil || version == 0 {
+		return
+	}
+	ttl, ok := restoreTTL(pttl)
+	if !ok {
+		return
+	}
+	v := []byte(strconv.FormatUint(version, 10))
+	restores := make([]*Request, len(dsts))
+	for i := range dsts {
+		restores[i] = NewScriptRequest(repairScript, key, field, v, payload, ttl)
+	}
+	if err = exec(dsts, restores); err != nil {
+		return
+	}
+	for _, restore := range restores {
+		if restore.reply.rTp == respError {
+			failed++
+			continue
+		}
+		if bytes.Equal(restore.reply.data, oneBytes) {
+			repaired++
+		}
+	}
+	return
+}
@@ -0,0 +1,73 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuorumVersion(t *testing.T) {
+	vr := NewVersionRequest([]byte("session"), []byte("ver"))
+	assert.Equal(t, "HGET", string(vr.resp.array[0].payload()))
+	ts := []struct {
+		Name    string
+		Reply   *resp
+		Version uint64
+		Ok      bool
+	}{
+		{Name: "version", Reply: bulkResp("12"), Version: 12, Ok: true},
+		{Name: "missing", Reply: newresp(respBulk, nil), Ok: true},
+		{Name: "not integer", Reply: bulkResp("x")},
+		{Name: "wrong type", Reply: newresp(respError, []byte("WRONGTYPE"))},
+	}
+	for _, tt := range ts {
+		vr.reply = tt.Reply
+		version, ok := Version(vr)
+		assert.Equal(t, tt.Ok, ok, tt.Name)
+		assert.Equal(t, tt.Version, version, tt.Name)
+	}
+}
+
+func TestIsHashRead(t *testing.T) {
+	ts := []struct {
+		Cmd  string
+		Hash bool
+	}{
+		{Cmd: "HGETALL", Hash: true},
+		{Cmd: "HMGET", Hash: true},
+		{Cmd: "HSET"},
+		{Cmd: "GET"},
+		// NOTE: HELLO begins with H but is not a command of hash.
+		{Cmd: "HELLO"},
+	}
+	for _, tt := range ts {
+		assert.Equal(t, tt.Hash, IsHashRead(NewRequest(tt.Cmd, []byte("k"))), tt.Cmd)
+	}
+}
+
+func TestRepairKey(t *testing.T) {
+	fs := []*fakeNodes{newFakeNodes(), newFakeNodes(), newFakeNodes()}
+	fs[0].strs["k"], fs[0].vers["k"] = "new", "3"
+	fs[1].strs["k"], fs[1].vers["k"] = "old", "2"
+	// NOTE: the copy of node 2 is written after the version read, it must not be overwritten.
+	fs[2].strs["k"], fs[2].vers["k"] = "newer", "4"
+	exec := nodesExec(fs)
+	field := []byte("ver")
+	repaired, failed, err := RepairKey(exec, 0, []byte("k"), field, []int{1, 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, "new", fs[1].strs["k"])
+	assert.Equal(t, "3", fs[1].vers["k"])
+	assert.Equal(t, "newer", fs[2].strs["k"])
+
+	repaired, _, err = RepairKey(exec, 0, []byte("none"), field, []int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, repaired)
+	// NOTE: the key expired between DUMP and PTTL is not restored as persistent.
+	fs[0].strs["expired"], fs[0].vers["expired"], fs[0].pttls["expired"] = "new", "1", "-2"
+	repaired, _, err = RepairKey(exec, 0, []byte("expired"), field, []int{1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, repaired)
+	assert.NotContains(t, fs[1].strs, "expired")
+}
@@ -3,158 +3,17 @@ package redis
 import (
 	"bytes"
 	errs "errors"
+	"strconv"
 	"sync"
+
+	"overlord/proto"
 )
 
 var (
 	emptyBytes = []byte("")
 	crlfBytes  = []byte("\r\n")
-
-	arrayLenTwo   = []byte("2")
-	arrayLenThree = []byte("3")
-
-	cmdPingBytes   = []byte("4\r\nPING")
-	cmdMSetBytes   = []byte("4\r\nMSET")
-	cmdMGetBytes   = []byte("4\r\nMGET")
-	cmdGetBytes    = []byte("3\r\nGET")
-	cmdDelBytes    = []byte("3\r\nDEL")
-	cmdExistsBytes = []byte("6\r\nEXISTS")
-
-	reqReadCmdsBytes = []byte("" +
-		"4\r\nDUMP" +
-		"6\r\nEXISTS" +
-		"4\r\nPTTL" +
-		"3\r\nTTL" +
-		"4\r\nTYPE" +
-		"8\r\nBITCOUNT" +
-		"6\r\nBITPOS" +
-		"3\r\nGET" +
-		"6\r\nGETBIT" +
-		"8\r\nGETRANGE" +
-		"4\r\nMGET" +
-		"6\r\nSTRLEN" +
-		"7\r\nHEXISTS" +
-		"4\r\nHGET" +
-		"7\r\nHGETALL" +
-		"5\r\nHKEYS" +
-		"4\r\nHLEN" +
-		"5\r\nHMGET" +
-		"7\r\nHSTRLEN" +
-		"5\r\nHVALS" +
-		"5\r\nHSCAN" +
-		"5\r\nSCARD" +
-		"5\r\nSDIFF" +
-		"6\r\nSINTER" +
-		"9\r\nSISMEMBER" +
-		"8\r\nSMEMBERS" +
-		"11\r\nSRANDMEMBER" +
-		"6\r\nSUNION" +
-		"5\r\nSSCAN" +
-		"5\r\nZCARD" +
-		"6\r\nZCOUNT" +
-		"9\r\nZLEXCOUNT" +
-		"6\r\nZRANGE" +
-		"11\r\nZRANGEBYLEX" +
-		"13\r\nZRANGEBYSCORE" +
-		"5\r\nZRANK" +
-		"9\r\nZREVRANGE" +
-		"14\r\nZREVRANGEBYLEX" +
-		"16\r\nZREVRANGEBYSCORE" +
-		"8\r\nZREVRANK" +
-		"6\r\nZSCORE" +
-		"5\r\nZSCAN" +
-		"6\r\nLINDEX" +
-		"4\r\nLLEN" +
-		"6\r\nLRANGE" +
-		"7\r\nPFCOUNT")
-
-	reqWriteCmdsBytes = []byte("" +
-		"3\r\nDEL" +
-		"6\r\nEXPIRE" +
-		"8\r\nEXPIREAT" +
-		"7\r\nPERSIST" +
-		"7\r\nPEXPIRE" +
-		"9\r\nPEXPIREAT" +
-		"7\r\nRESTORE" +
-		"4\r\nSORT" +
-		"6\r\nAPPEND" +
-		"4\r\nDECR" +
-		"6\r\nDECRBY" +
-		"6\r\nGETSET" +
-		"4\r\nINCR" +
-		"6\r\nINCRBY" +
-		"11\r\nINCRBYFLOAT" +
-		"4\r\nMSET" +
-		"6\r\nPSETEX" +
-		"3\r\nSET" +
-		"6\r\nSETBIT" +
-		"5\r\nSETEX" +
-		"5\r\nSETNX" +
-		"8\r\nSETRANGE" +
-		"4\r\nHDEL" +
-		"7\r\nHINCRBY" +
-		"12\r\nHINCRBYFLOAT" +
-		"5\r\nHMSET" +
-		"4\r\nHSET" +
-		"6\r\nHSETNX" +
-		"7\r\nLINSERT" +
-		"4\r\nLPOP" +
-		"5\r\nLPUSH" +
-		"6\r\nLPUSHX" +
-		"4\r\nLREM" +
-		"4\r\nLSET" +
-		"5\r\nLTRIM" +
-		"4\r\nRPOP" +
-		"9\r\nRPOPLPUSH" +
-		"5\r\nRPUSH" +
-		"6\r\nRPUSHX" +
-		"4\r\nSADD" +
-		"5\r\nSMOVE" +
-		"4\r\nSPOP" +
-		"4\r\nSREM" +
-		"4\r\nZADD" +
-		"7\r\nZINCRBY" +
-		"11\r\nZINTERSTORE" +
-		"4\r\nZREM" +
-		"14\r\nZREMRANGEBYLEX" +
-		"15\r\nZREMRANGEBYRANK" +
-		"16\r\nZREMRANGEBYSCORE" +
-		"5\r\nPFADD" +
-		"7\r\nPFMERGE")
-
-	reqNotSupportCmdsBytes = []byte("" +
-		"6\r\nMSETNX" +
-		"10\r\nSDIFFSTORE" +
-		"11\r\nSINTERSTORE" +
-		"11\r\nSUNIONSTORE" +
-		"11\r\nZUNIONSTORE" +
-		"5\r\nBLPOP" +
-		"5\r\nBRPOP" +
-		"10\r\nBRPOPLPUSH" +
-		"4\r\nKEYS" +
-		"7\r\nMIGRATE" +
-		"4\r\nMOVE" +
-		"6\r\nOBJECT" +
-		"9\r\nRANDOMKEY" +
-		"6\r\nRENAME" +
-		"8\r\nRENAMENX" +
-		"4\r\nSCAN" +
-		"4\r\nWAIT" +
-		"5\r\nBITOP" +
-		"4\r\nEVAL" +
-		"7\r\nEVALSHA" +
-		"4\r\nAUTH" +
-		"4\r\nECHO" +
-		"4\r\nINFO" +
-		"5\r\nPROXY" +
-		"7\r\nSLOWLOG" +
-		"4\r\nQUIT" +
-		"6\r\nSELECT" +
-		"4\r\nTIME" +
-		"6\r\nCONFIG" +
-		"8\r\nCOMMANDS")
-
-	reqCtlCmdsBytes = []byte("4\r\nPING")
+	noKeyTTL   = []byte("-2")
+	noneBytes  = []byte("none")
 )
 
 // errors
@@ -162,6 +21,8 @@ var (
 	ErrBadAssert  = errs.New("bad assert for redis")
 	ErrBadCount   = errs.New("bad count number")
 	ErrBadRequest = errs.New("bad request")
+
+	ErrUnbalancedQuotes = errs.New("unbalanced quotes in request")
 )
 
 // mergeType is used to decript the merge operation.
@@ -180,6 +41,10 @@ type Request struct {
 	resp  *resp
 	reply *resp
 	mType mergeType
+	// batch is the multi keys command which request split from, nil if not split.
+	batch *command
+	// admin forwards the admin command built by proxy to nodes, e.g. SCAN of resharding.
+	admin bool
 }
 
 var reqPool = &sync.Pool{
@@ -226,7 +91,11 @@ func (r *Request) Key() []byte {
 	if r.resp.arrayn == 1 {
 		return r.resp.array[0].data
 	}
-	k := r.resp.array[1]
+	ki := 1
+	if c := r.command(); c != nil && c.firstKey > 0 && c.firstKey < r.resp.arrayn {
+		ki = c.firstKey
+	}
+	k := r.resp.array[ki]
 	var pos int
 	if k.rTp == respBulk {
 		pos = bytes.Index(k.data, crlfBytes) + 2
@@ -234,26 +103,146 @@ func (r *Request) Key() []byte {
 	return k.data[pos:]
 }
 
+// CrossKeys returns all keys of the command emulated by proxy when its keys span nodes,
+// nil if the command is not the one.
+func (r *Request) CrossKeys() (keys [][]byte) {
+	c := r.command()
+	if c == nil || c.flags&cmdFlagCross == 0 || !c.validate(r.resp.arrayn) {
+		return nil
+	}
+	first, last := c.keyIndex(r.resp.arrayn)
+	for i := first; i <= last; i += c.keyStep {
+		keys = append(keys, r.resp.array[i].payload())
+	}
+	if c.numKeys > 0 {
+		n, err := strconv.Atoi(string(r.resp.array[c.numKeys].payload()))
+		if err != nil || n < 1 || c.numKeys+n >= r.resp.arrayn {
+			return // NOTE: bad numkeys is replied by node.
+		}
+		for i := c.numKeys + 1; i <= c.numKeys+n; i++ {
+			keys = append(keys, r.resp.array[i].payload())
+		}
+	}
+	return
+}
+
+// NewRequest returns a request built by command and arguments,
+// it is used to translate other protocols into redis.
+func NewRequest(cmd string, args ...[]byte) *Request {
+	r := getReq()
+	r.resp.reset()
+	r.resp.rTp = respArray
+	r.resp.data = []byte(strconv.Itoa(len(args) + 1))
+	r.resp.next().setBulk([]byte(cmd))
+	for _, arg := range args {
+		r.resp.next().setBulk(arg)
+	}
+	return r
+}
+
+// NewScriptRequest returns the EVAL request of script on key, which is built by proxy, e.g. the
+// translation of memcache cas, and forwarded to nodes though EVAL of clients is not supported.
+func NewScriptRequest(script, key []byte, args ...[]byte) *Request {
+	r := NewRequest("EVAL", append([][]byte{script, oneBytes, key}, args...)...)
+	r.admin = true
+	return r
+}
+
+// ReplyType returns the resp type of node reply.
+func (r *Request) ReplyType() byte {
+	return r.reply.rTp
+}
+
+// ReplyData returns the node reply payload, nil when reply is null bulk.
+func (r *Request) ReplyData() []byte {
+	return r.reply.payload()
+}
+
+// ReplyArray returns the payloads of array node reply.
+func (r *Request) ReplyArray() [][]byte {
+	if r.reply.rTp != respArray {
+		return nil
+	}
+	items := make([][]byte, r.reply.arrayn)
+	for i := 0; i < r.reply.arrayn; i++ {
+		items[i] = r.reply.array[i].payload()
+	}
+	return items
+}
+
 // Put the resource back to pool
 func (r *Request) Put() {
 	r.resp.reset()
 	r.reply.reset()
 	r.mType = mergeTypeNo
+	r.batch = nil
+	r.admin = false
 	reqPool.Put(r)
 }
 
-// isSupport check command support.
-func (r *Request) isSupport() bool {
-	if r.resp.arrayn < 1 {
+// IsRead reports whether the request only reads.
+func (r *Request) IsRead() bool {
+	c := r.command()
+	return c != nil && c.flags&cmdFlagRead != 0
+}
+
+// IsMiss reports whether the read request is replied as the key does not exist, which is null, empty
+// array, integer 0 of counts, -2 of TTL or none of TYPE.
+// NOTE: an existing key may be replied so as well, e.g. HGET of missing field or LRANGE out of range,
+// the key can be checked by NewExistsRequest.
+func (r *Request) IsMiss() bool {
+	if !r.IsRead() {
 		return false
 	}
-	return bytes.Index(reqReadCmdsBytes, r.resp.array[0].data) > -1 || bytes.Index(reqWriteCmdsBytes, r.resp.array[0].data) > -1
+	rp := r.reply
+	switch rp.rTp {
+	case respNull:
+		return true
+	case respBulk:
+		return rp.data == nil
+	case respArray, respSet, respMap:
+		return rp.data == nil || rp.arrayn == 0
+	case respInt:
+		return bytes.Equal(rp.data, zeroBytes) || bytes.Equal(rp.data, noKeyTTL)
+	case respString:
+		return bytes.Equal(rp.data, noneBytes)
+	}
+	return false
+}
+
+// NewExistsRequest returns the EXISTS request of key.
+func NewExistsRequest(key []byte) *Request {
+	return NewRequest("EXISTS", key)
+}
+
+// Exists reports whether the key of EXISTS request er exists, false when er is replied with error.
+func Exists(er *Request) bool {
+	return er.reply.rTp == respInt && !bytes.Equal(er.reply.data, zeroBytes)
+}
+
+// Clone returns a copy of the request which owns its data.
+func (r *Request) Clone() proto.Request {
+	n := getReq()
+	n.resp.clone(r.resp)
+	n.mType = r.mType
+	n.batch = r.batch
+	n.admin = r.admin
+	return n
+}
+
+// command returns the spec of command, nil means unknown command.
+func (r *Request) command() *command {
+	return lookupCommand(r.Cmd())
+}
+
+// isSupport check command support and forwarded to nodes.
+func (r *Request) isSupport() bool {
+	c := r.command()
+	return c != nil && (c.isForward() || r.admin) && c.validate(r.resp.arrayn)
 }
 
 // isCtl is control command.
 func (r *Request) isCtl() bool {
-	if r.resp.arrayn < 1 {
-		return false
-	}
-	return bytes.Index(reqCtlCmdsBytes, r.resp.array[0].data) > -1
+	c := r.command()
+	return c != nil && c.flags&cmdFlagCtl != 0
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"overlord/lib/bufio"
+	"overlord/proto"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,3 +24,34 @@ func TestRequestNewRequest(t *testing.T) {
 	assert.Equal(t, []byte("LLEN"), req.Cmd())
 	assert.Equal(t, "mylist", string(req.Key()))
 }
+
+func TestRequestClone(t *testing.T) {
+	var bs = []byte("*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nx\r\n")
+	conn := _createConn(bs)
+	br := bufio.NewReader(conn, bufio.Get(1024))
+	br.Read()
+	req := getReq()
+	assert.NoError(t, req.resp.decode(br))
+	assert.True(t, NewRequest("GET", []byte("a")).IsRead())
+	assert.False(t, req.IsRead())
+
+	clone := req.Clone().(*Request)
+	req.resp.array[1].data[3] = 'b'
+	assert.Equal(t, "SET", clone.CmdString())
+	assert.Equal(t, "a", string(clone.Key()))
+	assert.Equal(t, "x", string(clone.resp.array[2].payload()))
+}
+
+func TestRequestCloneGrouped(t *testing.T) {
+	// NOTE: the clones of subs split from the same msg are still sent as one multi keys command.
+	parent := proto.NewMessage()
+	msgs := make([]*proto.Message, 2)
+	for i, key := range []string{"a", "b"} {
+		sub := NewRequest("GET", []byte(key))
+		sub.batch = lookupCommand([]byte("MGET"))
+		msgs[i] = proto.NewMessage()
+		msgs[i].WithRequest(sub.Clone())
+		msgs[i].WithParent(parent)
+	}
+	assert.Equal(t, 2, groupLen(msgs, 0))
+}
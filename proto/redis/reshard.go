@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+var (
+	scanCountBytes = []byte("COUNT")
+	scanDoneBytes  = []byte("0")
+)
+
+// NodeExecutor executes reqs[i] on the node nodes[i] and waits until all replied,
+// requests to the same node are executed in order. The requests are owned by executor.
+type NodeExecutor func(nodes []int, reqs []*Request) error
+
+// Scan scans the keys of node from cursor, next is nil when the scan is done.
+// The keys are copied from reply.
+func Scan(exec NodeExecutor, node int, cursor []byte, count int) (next []byte, keys [][]byte, err error) {
+	req := NewRequest("SCAN", cursor, scanCountBytes, []byte(strconv.Itoa(count)))
+	req.admin = true
+	if err = exec([]int{node}, []*Request{req}); err != nil {
+		return
+	}
+	r := req.reply
+	if r.rTp != respArray || r.arrayn != 2 {
+		err = ErrBadRequest
+		return
+	}
+	for _, key := range respPayloads(r.array[1]) {
+		keys = append(keys, append([]byte(nil), key...))
+	}
+	if cursor := r.array[0].payload(); !bytes.Equal(cursor, scanDoneBytes) {
+		next = append([]byte(nil), cursor...)
+	}
+	return
+}
+
+// MoveKeys moves keys[i] from node src to the node of address addrs[i] by MIGRATE, which is atomic
+// on src, so a key written or deleted by client while moving is never restored stale on dst. The keys
+// missing on src are skipped, and the keys failed by error reply are kept on src. timeout is the
+// milliseconds MIGRATE waits for dst.
+//
+// NOTE: MIGRATE does not replace, the key existing on dst is written after the move began,
+// so it is kept and the key on src is deleted as moved.
+func MoveKeys(exec NodeExecutor, src int, keys [][]byte, addrs []string, timeout int) (moved, failed int, err error) {
+	var (
+		nodes []int
+		reqs  []*Request
+	)
+	for i, key := range keys {
+		host, port, serr := net.SplitHostPort(addrs[i])
+		if serr != nil {
+			failed++
+			continue
+		}
+		req := NewRequest("MIGRATE", []byte(host), []byte(port), key, zeroBytes, []byte(strconv.Itoa(timeout)))
+		req.admin = true
+		nodes = append(nodes, src)
+		reqs = append(reqs, req)
+	}
+	if len(reqs) == 0 {
+		return
+	}
+	if err = exec(nodes, reqs); err != nil {
+		return
+	}
+	var (
+		dnodes []int
+		dels   []*Request
+	)
+	for _, req := range reqs {
+		r := req.reply
+		switch {
+		case r.rTp == respError && bytes.Contains(r.data, busyKeyPrefix):
+			dnodes = append(dnodes, src)
+			dels = append(dels, NewRequest("DEL", req.resp.array[3].payload()))
+		case r.rTp == respError:
+			failed++
+		case bytes.Equal(r.data, okDataBytes):
+			moved++
+		}
+	}
+	if len(dels) == 0 {
+		return
+	}
+	if err = exec(dnodes, dels); err != nil {
+		return
+	}
+	for _, del := range dels {
+		if del.reply.rTp == respError {
+			failed++
+			continue
+		}
+		moved++
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nodesExec executes the requests on the fake node of index, SCAN returns all keys by one page,
+// and MIGRATE moves the string to the fake node whose index is the port.
+func nodesExec(fs []*fakeNodes) NodeExecutor {
+	return func(nodes []int, reqs []*Request) error {
+		for i, r := range reqs {
+			cmd := string(r.resp.array[0].payload())
+			if cmd == "MIGRATE" {
+				src, key := fs[nodes[i]], string(r.resp.array[3].payload())
+				port, _ := strconv.Atoi(string(r.resp.array[2].payload()))
+				dst := fs[port]
+				switch v, ok := src.strs[key]; {
+				case !ok:
+					r.reply = newresp(respString, []byte("NOKEY"))
+				case dst.exists(key):
+					r.reply = newresp(respError, []byte("ERR Target instance replied with error: BUSYKEY Target key name already exists."))
+				default:
+					dst.strs[key] = v
+					if ttl, ok := src.pttls[key]; ok {
+						dst.pttls[key] = ttl
+					}
+					delete(src.strs, key)
+					delete(src.pttls, key)
+					r.reply = newresp(respString, okDataBytes)
+				}
+				continue
+			}
+			if cmd != "SCAN" {
+				if err := fs[nodes[i]].exec(r); err != nil {
+					return err
+				}
+				continue
+			}
+			var keys []string
+			for k := range fs[nodes[i]].strs {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			var items []*resp
+			for _, k := range keys {
+				items = append(items, bulkResp(k))
+			}
+			r.reply = newrespArray([]*resp{bulkResp("0"), newrespArray(items)})
+		}
+		return nil
+	}
+}
+
+func TestScanAndMoveKeys(t *testing.T) {
+	fs := []*fakeNodes{newFakeNodes(), newFakeNodes()}
+	for i := 0; i < 4; i++ {
+		fs[0].strs["k"+strconv.Itoa(i)] = "v" + strconv.Itoa(i)
+	}
+	// NOTE: k3 is written to new node after the move began.
+	fs[1].strs["k3"] = "new"
+	exec := nodesExec(fs)
+
+	next, keys, err := Scan(exec, 0, []byte("0"), 10)
+	assert.NoError(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, [][]byte{[]byte("k0"), []byte("k1"), []byte("k2"), []byte("k3")}, keys)
+
+	moved, failed, err := MoveKeys(exec, 0, [][]byte{[]byte("k1"), []byte("k3"), []byte("none")}, []string{"node:1", "node:1", "node:1"}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, map[string]string{"k0": "v0", "k2": "v2"}, fs[0].strs)
+	assert.Equal(t, map[string]string{"k1": "v1", "k3": "new"}, fs[1].strs)
+}
+
+func TestMoveKeysTTL(t *testing.T) {
+	fs := []*fakeNodes{newFakeNodes(), newFakeNodes()}
+	fs[0].strs["ttl"], fs[0].pttls["ttl"] = "v", "5000"
+	fs[0].strs["persist"] = "v"
+	moved, failed, err := MoveKeys(nodesExec(fs), 0, [][]byte{[]byte("ttl"), []byte("persist"), []byte("none")}, []string{"node:1", "node:1", "node:1"}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	assert.Equal(t, 0, failed)
+	assert.Equal(t, map[string]string{"ttl": "v", "persist": "v"}, fs[1].strs)
+	assert.Equal(t, map[string]string{"ttl": "5000"}, fs[1].pttls)
+}
@@ -1,6 +1,9 @@
 package redis
 
 import (
+	"bytes"
+	"strconv"
+
 	"overlord/lib/bufio"
 	"overlord/lib/conv"
 )
@@ -16,6 +19,27 @@ const (
 	respInt     respType = ':'
 	respBulk    respType = '$'
 	respArray   respType = '*'
+
+	// RESP3 types: https://github.com/antirez/RESP3/blob/master/spec.md
+	respMap       respType = '%'
+	respSet       respType = '~'
+	respDouble    respType = ','
+	respBool      respType = '#'
+	respNull      respType = '_'
+	respVerbatim  respType = '='
+	respBigNumber respType = '('
+	respPush      respType = '>'
+	respAttr      respType = '|'
+	respBlobError respType = '!'
+)
+
+// reply types used by protocol translation.
+const (
+	ReplyString = respString
+	ReplyError  = respError
+	ReplyInt    = respInt
+	ReplyBulk   = respBulk
+	ReplyArray  = respArray
 )
 
 var (
@@ -25,6 +49,17 @@ var (
 	respBulkBytes   = []byte("$")
 	respArrayBytes  = []byte("*")
 
+	respMapBytes       = []byte("%")
+	respSetBytes       = []byte("~")
+	respDoubleBytes    = []byte(",")
+	respBoolBytes      = []byte("#")
+	respNullBytes      = []byte("_")
+	respVerbatimBytes  = []byte("=")
+	respBigNumberBytes = []byte("(")
+	respPushBytes      = []byte(">")
+	respAttrBytes      = []byte("|")
+	respBlobErrorBytes = []byte("!")
+
 	nullDataBytes = []byte("-1")
 )
 
@@ -37,24 +72,55 @@ type resp struct {
 	array []*resp
 	// in order to reuse array.use arrayn to mark current obj.
 	arrayn int
+	// attr is the RESP3 attribute sent before this item.
+	attr *resp
 }
 
 func (r *resp) reset() {
 	r.rTp = respUnknown
 	r.data = nil
 	r.arrayn = 0
+	r.attr = nil
 }
 
 func (r *resp) copy(re *resp) {
 	r.reset()
 	r.rTp = re.rTp
 	r.data = re.data
+	r.attr = re.attr
 	for i := 0; i < re.arrayn; i++ {
 		nre := r.next()
 		nre.copy(re.array[i])
 	}
 }
 
+// clone copies re into r with its own data.
+func (r *resp) clone(re *resp) {
+	r.reset()
+	r.rTp = re.rTp
+	r.data = append([]byte(nil), re.data...)
+	for i := 0; i < re.arrayn; i++ {
+		r.next().clone(re.array[i])
+	}
+}
+
+// setBulk sets the bulk data as "<len>\r\n<data>" same as decodeBulk.
+func (r *resp) setBulk(bs []byte) {
+	r.rTp = respBulk
+	data := make([]byte, 0, len(bs)+12)
+	data = strconv.AppendInt(data, int64(len(bs)), 10)
+	data = append(data, crlfBytes...)
+	r.data = append(data, bs...)
+}
+
+// payload returns data without the bulk length.
+func (r *resp) payload() []byte {
+	if r.rTp != respBulk || r.data == nil {
+		return r.data
+	}
+	return r.data[bytes.Index(r.data, crlfBytes)+2:]
+}
+
 func (r *resp) next() *resp {
 	if r.arrayn < len(r.array) {
 		nr := r.array[r.arrayn]
@@ -79,18 +145,162 @@ func (r *resp) decode(br *bufio.Reader) (err error) {
 	rTp := line[0]
 	r.rTp = rTp
 	switch rTp {
-	case respString, respInt, respError:
+	case respString, respInt, respError, respDouble, respBool, respBigNumber:
 		r.data = line[1 : len(line)-2]
-	case respBulk:
+	case respNull:
+		r.data = nil
+	case respBulk, respVerbatim, respBlobError:
 		err = r.decodeBulk(line, br)
-	case respArray:
+	case respArray, respSet, respPush:
 		err = r.decodeArray(line, br)
+	case respMap:
+		err = r.decodeMap(line, br)
+	case respAttr:
+		attr := &resp{rTp: respAttr}
+		if err = attr.decodeMap(line, br); err != nil {
+			return
+		}
+		if err = r.decode(br); err != nil {
+			return
+		}
+		r.attr = attr
 	default:
 		err = ErrBadRequest
 	}
 	return
 }
 
+// decodeMap decodes the map which data is the count of pairs, keys and values are stored in array by turns.
+func (r *resp) decodeMap(line []byte, br *bufio.Reader) (err error) {
+	ls := len(line)
+	sBs := line[1 : ls-2]
+	size, err := conv.Btoi(sBs)
+	if err != nil {
+		return
+	}
+	r.data = sBs
+	for i := 0; i < int(size)*2; i++ {
+		if err = r.next().decode(br); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// decodeInline decodes the inline command into the array same as multi bulk.
+func (r *resp) decodeInline(br *bufio.Reader) (err error) {
+	r.reset()
+	var line []byte
+	for {
+		if line, err = br.ReadSlice('\n'); err != nil {
+			return
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			break // NOTE: empty lines are skipped same as redis
+		}
+	}
+	args, err := splitInline(line)
+	if err != nil {
+		return
+	}
+	r.rTp = respArray
+	r.data = []byte(strconv.Itoa(len(args)))
+	for _, arg := range args {
+		r.next().setBulk(arg)
+	}
+	return
+}
+
+// splitInline splits the inline command line into arguments same as sdssplitargs of redis:
+// arguments are separated by spaces, double quoted argument supports escapes like "\n" and
+// "\x41", single quoted argument only supports "\'".
+func splitInline(line []byte) (args [][]byte, err error) {
+	i, n := 0, len(line)
+	for {
+		for i < n && isInlineSpace(line[i]) {
+			i++
+		}
+		if i == n {
+			return
+		}
+		var (
+			arg   = []byte{}
+			inDq  = line[i] == '"'
+			inSq  = line[i] == '\''
+			done  bool
+			quote = inDq || inSq
+		)
+		if quote {
+			i++
+		}
+		for !done {
+			if i == n {
+				if quote {
+					err = ErrUnbalancedQuotes
+					return
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDq && c == '\\' && i+3 < n && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				arg = append(arg, unhex(line[i+2])<<4|unhex(line[i+3]))
+				i += 3
+			case inDq && c == '\\' && i+1 < n:
+				i++
+				switch line[i] {
+				case 'n':
+					arg = append(arg, '\n')
+				case 'r':
+					arg = append(arg, '\r')
+				case 't':
+					arg = append(arg, '\t')
+				case 'b':
+					arg = append(arg, '\b')
+				case 'a':
+					arg = append(arg, '\a')
+				default:
+					arg = append(arg, line[i])
+				}
+			case inSq && c == '\\' && i+1 < n && line[i+1] == '\'':
+				arg = append(arg, '\'')
+				i++
+			case (inDq && c == '"') || (inSq && c == '\''):
+				// NOTE: closing quote must be followed by a space or nothing at all.
+				if i+1 < n && !isInlineSpace(line[i+1]) {
+					err = ErrUnbalancedQuotes
+					return
+				}
+				done = true
+			case !quote && isInlineSpace(c):
+				done = true
+			default:
+				arg = append(arg, c)
+			}
+			i++
+		}
+		args = append(args, arg)
+	}
+}
+
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\v' || c == '\f'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
+
 func (r *resp) decodeBulk(line []byte, br *bufio.Reader) (err error) {
 	ls := len(line)
 	sBs := line[1 : ls-2]
@@ -140,26 +350,27 @@ func (r *resp) decodeArray(line []byte, br *bufio.Reader) (err error) {
 }
 
 func (r *resp) encode(w *bufio.Writer) (err error) {
+	if r.attr != nil {
+		if err = r.attr.encode(w); err != nil {
+			return
+		}
+	}
 	switch r.rTp {
-	case respInt, respString, respError:
+	case respInt, respString, respError, respDouble, respBool, respBigNumber:
 		err = r.encodePlain(w)
-	case respBulk:
+	case respNull:
+		_ = w.Write(respNullBytes)
+		err = w.Write(crlfBytes)
+	case respBulk, respVerbatim, respBlobError:
 		err = r.encodeBulk(w)
-	case respArray:
+	case respArray, respSet, respPush, respMap, respAttr:
 		err = r.encodeArray(w)
 	}
 	return
 }
 
 func (r *resp) encodePlain(w *bufio.Writer) (err error) {
-	switch r.rTp {
-	case respInt:
-		_ = w.Write(respIntBytes)
-	case respError:
-		_ = w.Write(respErrorBytes)
-	case respString:
-		_ = w.Write(respStringBytes)
-	}
+	_ = w.Write(respTypeBytes(r.rTp))
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	}
@@ -168,7 +379,7 @@ func (r *resp) encodePlain(w *bufio.Writer) (err error) {
 }
 
 func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
-	_ = w.Write(respBulkBytes)
+	_ = w.Write(respTypeBytes(r.rTp))
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
@@ -178,8 +389,9 @@ func (r *resp) encodeBulk(w *bufio.Writer) (err error) {
 	return
 }
 
+// encodeArray encodes array, set, push and map, data of map is the count of pairs.
 func (r *resp) encodeArray(w *bufio.Writer) (err error) {
-	_ = w.Write(respArrayBytes)
+	_ = w.Write(respTypeBytes(r.rTp))
 	if len(r.data) > 0 {
 		_ = w.Write(r.data)
 	} else {
@@ -194,6 +406,73 @@ func (r *resp) encodeArray(w *bufio.Writer) (err error) {
 	return
 }
 
+func respTypeBytes(rTp respType) []byte {
+	switch rTp {
+	case respString:
+		return respStringBytes
+	case respError:
+		return respErrorBytes
+	case respInt:
+		return respIntBytes
+	case respBulk:
+		return respBulkBytes
+	case respArray:
+		return respArrayBytes
+	case respMap:
+		return respMapBytes
+	case respSet:
+		return respSetBytes
+	case respDouble:
+		return respDoubleBytes
+	case respBool:
+		return respBoolBytes
+	case respNull:
+		return respNullBytes
+	case respVerbatim:
+		return respVerbatimBytes
+	case respBigNumber:
+		return respBigNumberBytes
+	case respPush:
+		return respPushBytes
+	case respAttr:
+		return respAttrBytes
+	case respBlobError:
+		return respBlobErrorBytes
+	}
+	return nil
+}
+
+// toResp3 converts the RESP2 reply into RESP3 where the semantics require it:
+// nulls become null, and the reply becomes rTp of command like map, set or double.
+func (r *resp) toResp3(rTp respType) {
+	switch r.rTp {
+	case respBulk:
+		if r.data == nil {
+			r.rTp = respNull
+		} else if rTp == respDouble {
+			r.data = r.payload()
+			r.rTp = respDouble
+		}
+		return
+	case respArray:
+	default:
+		return
+	}
+	if r.data == nil {
+		r.rTp = respNull
+		return
+	}
+	for i := 0; i < r.arrayn; i++ {
+		r.array[i].toResp3(0)
+	}
+	if rTp == respMap && r.arrayn%2 == 0 {
+		r.rTp = respMap
+		r.data = []byte(strconv.Itoa(r.arrayn / 2))
+	} else if rTp == respSet {
+		r.rTp = respSet
+	}
+}
+
 // func (r *resp) String() string {
 // 	var sb strings.Builder
 // 	sb.Write([]byte{r.rTp})
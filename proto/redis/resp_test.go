@@ -68,6 +68,59 @@ func TestRespDecode(t *testing.T) {
 				[]byte("3"),
 			},
 		},
+		{
+			Name:       "map",
+			Bytes:      []byte("%2\r\n+a\r\n:1\r\n+b\r\n_\r\n"),
+			ExpectTp:   respMap,
+			ExpectLen:  4,
+			ExpectData: []byte("2"),
+			ExpectArr: [][]byte{
+				[]byte("a"),
+				[]byte("1"),
+				[]byte("b"),
+				nil,
+			},
+		},
+		{
+			Name:       "set",
+			Bytes:      []byte("~2\r\n,3.14\r\n#t\r\n"),
+			ExpectTp:   respSet,
+			ExpectLen:  2,
+			ExpectData: []byte("2"),
+			ExpectArr: [][]byte{
+				[]byte("3.14"),
+				[]byte("t"),
+			},
+		},
+		{
+			Name:       "verbatim",
+			Bytes:      []byte("=8\r\ntxt:abcd\r\n"),
+			ExpectTp:   respVerbatim,
+			ExpectData: []byte("8\r\ntxt:abcd"),
+		},
+		{
+			Name:       "bignumber",
+			Bytes:      []byte("(3492890328409238509324850943850943825024385\r\n"),
+			ExpectTp:   respBigNumber,
+			ExpectData: []byte("3492890328409238509324850943850943825024385"),
+		},
+		{
+			Name:       "push",
+			Bytes:      []byte(">2\r\n+message\r\n+hi\r\n"),
+			ExpectTp:   respPush,
+			ExpectLen:  2,
+			ExpectData: []byte("2"),
+			ExpectArr: [][]byte{
+				[]byte("message"),
+				[]byte("hi"),
+			},
+		},
+		{
+			Name:       "attr",
+			Bytes:      []byte("|1\r\n+ttl\r\n:3600\r\n:10\r\n"),
+			ExpectTp:   respInt,
+			ExpectData: []byte("10"),
+		},
 		{
 			Name:       "array3",
 			Bytes:      []byte("*2\r\n*3\r\n:1\r\n:2\r\n:3\r\n*2\r\n+Foo\r\n-Bar\r\n"),
@@ -102,6 +155,100 @@ func TestRespDecode(t *testing.T) {
 	}
 }
 
+func TestRespCodecResp3(t *testing.T) {
+	for _, data := range []string{
+		"%1\r\n$1\r\na\r\n_\r\n",
+		"~2\r\n,1.5\r\n#f\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n=8\r\ntxt:abcd\r\n",
+		">2\r\n(12345678901234567890\r\n!3\r\nERR\r\n",
+	} {
+		conn := _createConn([]byte(data))
+		br := bufio.NewReader(conn, bufio.Get(1024))
+		br.Read()
+		r := &resp{}
+		assert.NoError(t, r.decode(br))
+
+		conn, buf := _createDownStreamConn()
+		bw := bufio.NewWriter(conn)
+		assert.NoError(t, r.encode(bw))
+		assert.NoError(t, bw.Flush())
+		assert.Equal(t, data, buf.String())
+	}
+}
+
+func TestRespToResp3(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Bytes  string
+		Type   respType
+		Expect string
+	}{
+		{Name: "nullBulk", Bytes: "$-1\r\n", Type: 0, Expect: "_\r\n"},
+		{Name: "nullArray", Bytes: "*-1\r\n", Type: 0, Expect: "_\r\n"},
+		{Name: "nested", Bytes: "*2\r\n$1\r\na\r\n$-1\r\n", Type: 0, Expect: "*2\r\n$1\r\na\r\n_\r\n"},
+		{Name: "map", Bytes: "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", Type: respMap,
+			Expect: "%2\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{Name: "set", Bytes: "*1\r\n$1\r\na\r\n", Type: respSet, Expect: "~1\r\n$1\r\na\r\n"},
+		{Name: "double", Bytes: "$3\r\n1.5\r\n", Type: respDouble, Expect: ",1.5\r\n"},
+		{Name: "bulk", Bytes: "$3\r\n1.5\r\n", Type: 0, Expect: "$3\r\n1.5\r\n"},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			conn := _createConn([]byte(tt.Bytes))
+			br := bufio.NewReader(conn, bufio.Get(1024))
+			br.Read()
+			r := &resp{}
+			assert.NoError(t, r.decode(br))
+			r.toResp3(tt.Type)
+
+			conn, buf := _createDownStreamConn()
+			bw := bufio.NewWriter(conn)
+			assert.NoError(t, r.encode(bw))
+			assert.NoError(t, bw.Flush())
+			assert.Equal(t, tt.Expect, buf.String())
+		})
+	}
+}
+
+func TestRespDecodeInline(t *testing.T) {
+	ts := []struct {
+		Name      string
+		Bytes     []byte
+		ExpectArr []string
+		ExpectErr error
+	}{
+		{Name: "ping", Bytes: []byte("PING\r\n"), ExpectArr: []string{"PING"}},
+		{Name: "lf", Bytes: []byte("GET foo\n"), ExpectArr: []string{"GET", "foo"}},
+		{Name: "spaces", Bytes: []byte("\r\n  SET  foo   bar \r\n"), ExpectArr: []string{"SET", "foo", "bar"}},
+		{Name: "double", Bytes: []byte("SET \"a b\" \"\\x41\\n\\\"\"\r\n"), ExpectArr: []string{"SET", "a b", "A\n\""}},
+		{Name: "single", Bytes: []byte("SET 'it\\'s' ''\r\n"), ExpectArr: []string{"SET", "it's", ""}},
+		{Name: "unbalanced", Bytes: []byte("SET \"foo\r\n"), ExpectErr: ErrUnbalancedQuotes},
+		{Name: "closing", Bytes: []byte("SET \"foo\"bar\r\n"), ExpectErr: ErrUnbalancedQuotes},
+		{Name: "partial", Bytes: []byte("GET fo"), ExpectErr: bufio.ErrBufferFull},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := &resp{}
+			conn := _createConn(tt.Bytes)
+			br := bufio.NewReader(conn, bufio.Get(1024))
+			br.Read()
+			err := r.decodeInline(br)
+			if tt.ExpectErr != nil {
+				assert.Equal(t, tt.ExpectErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, respArray, r.rTp)
+			if assert.Equal(t, len(tt.ExpectArr), r.arrayn) {
+				for i, arg := range tt.ExpectArr {
+					assert.Equal(t, respBulk, r.array[i].rTp)
+					assert.Equal(t, arg, string(r.array[i].payload()))
+				}
+			}
+		})
+	}
+}
+
 func TestRespEncode(t *testing.T) {
 	ts := []struct {
 		Name   string
@@ -18,6 +18,8 @@ const (
 	CacheTypeMemcache       CacheType = "memcache"
 	CacheTypeMemcacheBinary CacheType = "memcache_binary"
 	CacheTypeRedis          CacheType = "redis"
+	// CacheTypeAuto detects the protocol from the first bytes of each client conn.
+	CacheTypeAuto CacheType = "auto"
 )
 
 // Request request interface.
@@ -25,6 +27,12 @@ type Request interface {
 	CmdString() string
 	Cmd() []byte
 	Key() []byte
+	// IsRead reports whether the request only reads, which is safe to retry on other nodes.
+	IsRead() bool
+	// IsMiss reports whether the read request is replied without value.
+	IsMiss() bool
+	// Clone returns a copy of the request which owns its data, the reply is not copied.
+	Clone() Request
 	Put()
 }
 
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"overlord/lib/hashkit"
+)
+
+// PoolBalance is the balance report of the ring of pool.
+type PoolBalance struct {
+	Pool string `json:"pool"`
+	*hashkit.BalanceReport
+}
+
+// Balance returns the balance reports of all the pools.
+func (c *Cluster) Balance() (pbs []*PoolBalance) {
+	for _, p := range c.pools() {
+		pbs = append(pbs, &PoolBalance{Pool: p.name, BalanceReport: p.ring.Balance()})
+	}
+	return
+}
+
+// BalanceReports returns the balance reports of pools by cluster config without connecting to servers.
+func BalanceReports(cc *ClusterConfig) []*PoolBalance {
+	c := newCluster(context.Background(), cc)
+	defer c.cancel()
+	return c.Balance()
+}
+
+type balanceStatus struct {
+	Cluster string         `json:"cluster"`
+	Pools   []*PoolBalance `json:"pools"`
+}
+
+// HandleBalance serves the balance reports of pools of cluster by http, e.g. GET /balance?cluster=mc.
+func (p *Proxy) HandleBalance(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("cluster")
+	clusters := p.clustersByName(name)
+	if len(clusters) == 0 {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&balanceStatus{Cluster: name, Pools: clusters[0].Balance()})
+}
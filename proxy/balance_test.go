@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterBalanceReports(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "mc",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		PointsPerServer:  400,
+		Servers:          []string{"127.0.0.1:11211:1 mc1", "127.0.0.1:11212:3 mc2"},
+		Routes:           []*RouteConfig{{Name: "user", Prefix: "user:*", HashDistribution: "maglev", Servers: []string{"127.0.0.1:11213:1", "127.0.0.1:11214:1"}}},
+	}
+	pbs := BalanceReports(cc)
+	assert.Len(t, pbs, 2)
+	assert.Equal(t, defaultPoolName, pbs[0].Pool)
+	assert.Equal(t, "ketama", pbs[0].Distribution)
+	assert.Equal(t, 400, pbs[0].Points)
+	assert.Len(t, pbs[0].Nodes, 2)
+	assert.Equal(t, "mc2", pbs[0].Nodes[1].Node)
+	assert.Equal(t, 0.75, pbs[0].Nodes[1].Expect)
+	assert.InDelta(t, 0.75, pbs[0].Nodes[1].Share, 0.1)
+	assert.Equal(t, "user", pbs[1].Pool)
+	assert.Equal(t, "maglev", pbs[1].Distribution)
+
+	p := &Proxy{clusters: map[string]*Cluster{"mc": _createCluster(cc)}}
+	w := httptest.NewRecorder()
+	p.HandleBalance(w, httptest.NewRequest("GET", "/balance?cluster=mc", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"pool":"default","distribution":"ketama","points":400`)
+	assert.Contains(t, w.Body.String(), `"node":"mc1","weight":1,"expect":0.25`)
+	w = httptest.NewRecorder()
+	p.HandleBalance(w, httptest.NewRequest("GET", "/balance?cluster=none", nil))
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bytes"
+	errs "errors"
+	"strings"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+)
+
+// results of broadcast write and read.
+const (
+	broadcastResultQuorumFail = "quorum_fail"
+	broadcastResultRecover    = "recover"
+)
+
+// broadcast errors
+var (
+	ErrBroadcastQuorum = errs.New("broadcast write not succeed on quorum nodes")
+)
+
+// broadcast sends the writes of keys matching prefixes or commands to all nodes.
+type broadcast struct {
+	bc       *BroadcastConfig
+	prefixes [][]byte
+}
+
+// initBroadcast inits the broadcast prefixes.
+func (c *Cluster) initBroadcast() {
+	bc := c.cc.Broadcast
+	if bc == nil {
+		return
+	}
+	b := &broadcast{bc: bc}
+	for _, prefix := range bc.Prefixes {
+		b.prefixes = append(b.prefixes, []byte(prefix))
+	}
+	c.broadcast = b
+}
+
+// match reports whether the key of req matches the prefixes or the command of req matches the commands.
+func (b *broadcast) match(req proto.Request) bool {
+	for _, prefix := range b.prefixes {
+		if bytes.HasPrefix(req.Key(), prefix) {
+			return true
+		}
+	}
+	cmd := req.CmdString()
+	for _, c := range b.bc.Commands {
+		if strings.EqualFold(c, cmd) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cluster) broadcasting(req proto.Request) bool {
+	return c.broadcast != nil && c.broadcast.match(req)
+}
+
+// dispatchIndex returns the index of node which req is dispatched to, the broadcast read is
+// dispatched to the least loaded node, -1 means no node.
+func (c *Cluster) dispatchIndex(req proto.Request) int {
+	if req.IsRead() && c.broadcasting(req) {
+		return c.leastLoadedIndex(c.route(req.Key()), req.Key())
+	}
+	return c.calculateBatchIndex(req.Key())
+}
+
+// leastLoadedIndex returns the index of the healthy node in pool p which queues the least batchs,
+// the node of key is preferred among the least loaded ones.
+func (c *Cluster) leastLoadedIndex(p *pool, key []byte) int {
+	idx, load := -1, 0
+	for _, node := range p.ring.GetNodes(c.hashKey(key), len(p.addrs)) {
+		i := p.nodeMap[node]
+		if l := c.nodeChan[i].load(); idx == -1 || l < load {
+			idx, load = i, l
+		}
+	}
+	return idx
+}
+
+// broadcastWrite is the broadcast write of sub and its copies sent to the other nodes.
+type broadcastWrite struct {
+	sub    *proto.Message
+	copies []*proto.Message
+	quorum int
+}
+
+// broadcastRound is the copies of broadcast writes in one round.
+type broadcastRound struct {
+	mbs    []*proto.MsgBatch
+	writes []*broadcastWrite
+	copies []*proto.Message
+	// reads are the copies of broadcast reads of subs, which fall back to another node when subs miss,
+	// since the node may miss the writes broadcast while it was down.
+	reads []*proto.Message
+	subs  []*proto.Message
+	// fmbs are the batchs of fallback reads, which must be put after msgs are encoded since the hit
+	// replies are read into them.
+	fmbs []*proto.MsgBatch
+}
+
+// broadcastDispatch copies the broadcast writes of msgs to the other healthy nodes and dispatches them
+// along with msgs.
+func (c *Cluster) broadcastDispatch(msgs []*proto.Message) (br *broadcastRound) {
+	if c.broadcast == nil {
+		return
+	}
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if !c.broadcast.match(req) || sub.Err() != nil {
+				continue
+			}
+			if req.IsRead() {
+				if br == nil {
+					br = &broadcastRound{mbs: proto.GetMsgBatchs(len(c.nodeChan))}
+				}
+				rm := c.copyMsg(sub)
+				br.reads = append(br.reads, rm)
+				br.subs = append(br.subs, sub)
+				br.copies = append(br.copies, rm)
+				continue
+			}
+			if br == nil {
+				br = &broadcastRound{mbs: proto.GetMsgBatchs(len(c.nodeChan))}
+			}
+			p := c.route(req.Key())
+			primary := c.poolIndex(p, req.Key())
+			bw := &broadcastWrite{sub: sub}
+			// NOTE: the ejected nodes are not returned by ring, so they are not written.
+			for _, node := range p.ring.GetNodes(c.hashKey(req.Key()), len(p.addrs)) {
+				idx := p.nodeMap[node]
+				if idx == primary {
+					continue
+				}
+				cm := c.copyMsg(sub)
+				bw.copies = append(bw.copies, cm)
+				br.copies = append(br.copies, cm)
+				br.mbs[idx].AddMsg(cm)
+			}
+			bw.quorum = c.broadcast.bc.quorum(1 + len(bw.copies))
+			br.writes = append(br.writes, bw)
+		}
+	}
+	if br != nil {
+		c.deliver(br.mbs)
+	}
+	return
+}
+
+// broadcastDone waits the copies of broadcast writes, and fails the writes not succeed on quorum nodes.
+// The reply of failed sub is replaced by a succeeded copy when quorum nodes succeed. The broadcast reads
+// of mbs missed are sent to another node, see broadcastFallback.
+func (c *Cluster) broadcastDone(br *broadcastRound, mbs []*proto.MsgBatch) {
+	if br == nil {
+		return
+	}
+	if len(br.reads) > 0 {
+		br.fmbs = c.broadcastFallback(br, mbs)
+	}
+	for _, mb := range br.mbs {
+		mb.Wait()
+	}
+	for _, bw := range br.writes {
+		var (
+			succeed int
+			ok      *proto.Message
+		)
+		if bw.sub.Err() == nil {
+			succeed++
+		}
+		for _, cm := range bw.copies {
+			if cm.Err() == nil {
+				succeed++
+				ok = cm
+			}
+		}
+		result := ""
+		if succeed < bw.quorum {
+			bw.sub.DoneWithError(ErrBroadcastQuorum)
+			result = broadcastResultQuorumFail
+		} else if err := bw.sub.Err(); err != nil {
+			if log.V(2) {
+				log.Warnf("cluster(%s) Msg(%s) broadcast write error:%v", c.cc.Name, bw.sub.Request().Key(), err)
+			}
+			bw.sub.DoneWithError(nil)
+			ok.ReplaceRequest(bw.sub.ReplaceRequest(ok.Request()))
+			result = broadcastResultRecover
+		}
+		if result != "" && prom.On {
+			prom.Broadcast(c.cc.Name, bw.sub.Request().CmdString(), result)
+		}
+	}
+}
+
+// broadcastFallback sends the copies of broadcast reads whose subs missed to the first node of key other
+// than the node of sub in mbs, which is the node of key unless sub was read from it, see fallback.
+func (c *Cluster) broadcastFallback(br *broadcastRound, mbs []*proto.MsgBatch) []*proto.MsgBatch {
+	read := make(map[*proto.Message]int, len(br.subs))
+	for _, sub := range br.subs {
+		read[sub] = -1
+	}
+	for i, mb := range mbs {
+		for _, m := range mb.Msgs() {
+			if _, ok := read[m]; ok {
+				read[m] = i
+			}
+		}
+	}
+	froms := make([]int, len(br.subs))
+	idxs := make([]int, len(br.subs))
+	for i, sub := range br.subs {
+		froms[i], idxs[i] = read[sub], -1
+		req := sub.Request()
+		if sub.Err() != nil || !req.IsMiss() {
+			continue
+		}
+		p := c.route(req.Key())
+		for _, node := range p.ring.GetNodes(c.hashKey(req.Key()), len(p.addrs)) {
+			if idx := p.nodeMap[node]; idx != froms[i] {
+				idxs[i] = idx
+				break
+			}
+		}
+	}
+	return c.fallback(br.reads, br.subs, froms, idxs, prom.Broadcast)
+}
+
+// broadcastRelease releases the copies of broadcast writes and reads after msgs are encoded, since the
+// replies of the copies replacing failed or missed subs are read into them.
+func (c *Cluster) broadcastRelease(br *broadcastRound) {
+	if br == nil {
+		return
+	}
+	proto.PutMsgs(br.copies)
+	proto.PutMsgBatchs(br.mbs)
+	if br.fmbs != nil {
+		proto.PutMsgBatchs(br.fmbs)
+	}
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterBroadcastQuorum(t *testing.T) {
+	l1, m1 := _serveMemcache(t, map[string]string{})
+	defer l1.Close()
+	l2, m2 := _serveMemcache(t, map[string]string{})
+	defer l2.Close()
+	bc := &BroadcastConfig{Prefixes: []string{"flag:"}, Quorum: 2}
+	cc := &ClusterConfig{
+		Name:             "broadcast",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{l1.Addr().String() + ":1 mc1", l2.Addr().String() + ":1 mc2", _deadAddr(t) + ":1 mc3"},
+		Broadcast:        bc,
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the broadcast writes succeed on 2 nodes even if the node of key is dead.
+	var cmds string
+	for i := 0; i < 10; i++ {
+		cmds += fmt.Sprintf("set flag:%d 0 0 1\r\n%d\r\n", i, i)
+	}
+	msgs := _createMemcacheMsgs(t, cmds, 10)
+	assert.Equal(t, strings.Repeat("STORED\r\n", 10), _handleRound(c, msgs))
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("flag:%d", i)
+		for _, mc := range []*_memcache{m1, m2} {
+			v, _ := mc.get(key)
+			assert.Equal(t, fmt.Sprint(i), v, key)
+		}
+	}
+
+	// NOTE: the writes not matched are not broadcast.
+	msgs = _createMemcacheMsgs(t, "set a 0 0 1\r\nx\r\n", 1)
+	assert.Nil(t, c.broadcastDispatch(msgs))
+	proto.PutMsgs(msgs)
+
+	bc.Quorum = 3
+	msgs = _createMemcacheMsgs(t, "set flag:a 0 0 1\r\nx\r\n", 1)
+	assert.Equal(t, "SERVER_ERROR "+ErrBroadcastQuorum.Error()+"\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterBroadcastEjected(t *testing.T) {
+	l1, m1 := _serveMemcache(t, map[string]string{})
+	defer l1.Close()
+	l2, m2 := _serveMemcache(t, map[string]string{})
+	defer l2.Close()
+	bc := &BroadcastConfig{Prefixes: []string{"flag:"}}
+	cc := &ClusterConfig{
+		Name:             "broadcast",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{l1.Addr().String() + ":1 mc1", l2.Addr().String() + ":1 mc2", _deadAddr(t) + ":1 mc3"},
+		Broadcast:        bc,
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the writes succeed on all nodes not ejected without quorum configured.
+	c.pool.ring.DelNode("mc3")
+	msgs := _createMemcacheMsgs(t, "set flag:a 0 0 1\r\nx\r\n", 1)
+	assert.Equal(t, "STORED\r\n", _handleRound(c, msgs))
+	for _, mc := range []*_memcache{m1, m2} {
+		v, _ := mc.get("flag:a")
+		assert.Equal(t, "x", v)
+	}
+
+	// NOTE: the quorum configured is not lowered by the nodes ejected.
+	bc.Quorum = 3
+	msgs = _createMemcacheMsgs(t, "set flag:b 0 0 1\r\nx\r\n", 1)
+	assert.Equal(t, "SERVER_ERROR "+ErrBroadcastQuorum.Error()+"\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterBroadcastReadFallback(t *testing.T) {
+	l1, m1 := _serveMemcache(t, map[string]string{})
+	defer l1.Close()
+	l2, m2 := _serveMemcache(t, map[string]string{})
+	defer l2.Close()
+	cc := &ClusterConfig{
+		Name:             "broadcast",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{l1.Addr().String() + ":1 mc1", l2.Addr().String() + ":1 mc2"},
+		Broadcast:        &BroadcastConfig{Prefixes: []string{"flag:"}},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the node of key missed the broadcast write while it was down, so the read falls back to the other.
+	mcs := map[string]*_memcache{"mc1": m1, "mc2": m2}
+	nodes := c.pool.ring.GetNodes([]byte("flag:a"), 2)
+	mc := mcs[nodes[1]]
+	mc.lock.Lock()
+	mc.values["flag:a"] = "x"
+	mc.lock.Unlock()
+	msgs := _createMemcacheMsgs(t, "get flag:a flag:b\r\n", 1)
+	assert.Equal(t, "VALUE flag:a 0 1\r\nx\r\nEND\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterBroadcastReadExists(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "broadcast",
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Broadcast:        &BroadcastConfig{Prefixes: []string{"flag:"}},
+	}
+	rds := map[string]*_redis{}
+	for _, name := range []string{"redis1", "redis2"} {
+		l, rd := _serveRedis(t, map[string]string{})
+		defer l.Close()
+		rds[name] = rd
+		cc.Servers = append(cc.Servers, l.Addr().String()+":1 "+name)
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the missing field of existing key is not a miss of broadcast, so it never falls back.
+	nodes := c.pool.ring.GetNodes([]byte("flag:h"), 2)
+	for i, node := range nodes {
+		rd := rds[node]
+		rd.lock.Lock()
+		rd.hashes["flag:h"] = map[string]string{"a": "1"}
+		if i == 1 {
+			rd.hashes["flag:h"]["b"] = "stale"
+		}
+		rd.lock.Unlock()
+	}
+	msgs := _createRedisMsgs(t, "HGET flag:h b\r\n", 1)
+	assert.Equal(t, "$-1\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterBroadcastLeastLoaded(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Servers:          []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"},
+		Broadcast:        &BroadcastConfig{Commands: []string{"get"}},
+	})
+	msgs := _createMemcacheMsgs(t, "get a\r\nset a 0 0 1\r\nx\r\n", 2)
+	idx := c.calculateBatchIndex([]byte("a"))
+	assert.Equal(t, idx, c.dispatchIndex(msgs[0].Request()))
+	c.nodeChan[idx].push(proto.NewMsgBatch())
+	assert.Equal(t, 1-idx, c.dispatchIndex(msgs[0].Request()))
+	assert.Equal(t, idx, c.dispatchIndex(msgs[1].Request()))
+}
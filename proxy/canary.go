@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"encoding/json"
+	errs "errors"
+	"hash/crc32"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"overlord/lib/log"
+)
+
+const (
+	canaryPoolName = "canary"
+	// canaryBuckets is the count of buckets keys are split into, so percent is precise to 0.01.
+	canaryBuckets = 10000
+)
+
+// canary errors
+var (
+	ErrCanaryNotFound = errs.New("cluster has no canary")
+	ErrCanaryPercent  = errs.New("canary percent must be in [0, 100]")
+)
+
+// canary splits the keys whose bucket is less than threshold to the canary pool.
+type canary struct {
+	pool      *pool
+	threshold uint32
+}
+
+// initCanary inits the canary pool.
+func (c *Cluster) initCanary() {
+	ca := c.cc.Canary
+	if ca == nil {
+		return
+	}
+	c.canary = &canary{pool: c.addPool(canaryPoolName, ca.clusterConfig(c.cc))}
+	c.canary.setPercent(ca.Percent)
+}
+
+// match reports whether the key hashed by hk is split to the canary pool.
+// NOTE: the bucket is independent of the hash ring, so keys are split evenly among nodes.
+func (ca *canary) match(hk []byte) bool {
+	return crc32.ChecksumIEEE(hk)%canaryBuckets < atomic.LoadUint32(&ca.threshold)
+}
+
+func (ca *canary) percent() float64 {
+	return float64(atomic.LoadUint32(&ca.threshold)) * 100 / canaryBuckets
+}
+
+func (ca *canary) setPercent(percent float64) {
+	atomic.StoreUint32(&ca.threshold, uint32(percent*canaryBuckets/100+0.5))
+}
+
+// SetCanaryPercent sets the percent of keys split to the canary servers.
+func (c *Cluster) SetCanaryPercent(percent float64) error {
+	if c.canary == nil {
+		return ErrCanaryNotFound
+	}
+	if !validCanaryPercent(percent) {
+		return ErrCanaryPercent
+	}
+	c.canary.setPercent(percent)
+	log.Infof("cluster(%s) canary percent is set to %v", c.cc.Name, percent)
+	return nil
+}
+
+// CanaryPercent returns the percent of keys split to the canary servers.
+func (c *Cluster) CanaryPercent() (float64, error) {
+	if c.canary == nil {
+		return 0, ErrCanaryNotFound
+	}
+	return c.canary.percent(), nil
+}
+
+type canaryStatus struct {
+	Cluster string  `json:"cluster"`
+	Percent float64 `json:"percent"`
+}
+
+// HandleCanary serves the canary percent of cluster by http, e.g.
+// GET /canary?cluster=mc shows the percent and POST /canary?cluster=mc&percent=5 sets it.
+func (p *Proxy) HandleCanary(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("cluster")
+	clusters := p.clustersByName(name)
+	if len(clusters) == 0 {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		percent, err := strconv.ParseFloat(r.FormValue("percent"), 64)
+		if err != nil {
+			http.Error(w, ErrCanaryPercent.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, c := range clusters {
+			if err = c.SetCanaryPercent(percent); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	percent, err := clusters[0].CanaryPercent()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&canaryStatus{Cluster: name, Percent: percent})
+}
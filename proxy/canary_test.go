@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createCanaryCluster(percent float64) *Cluster {
+	return _createCluster(&ClusterConfig{
+		Name:             "mc",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		HashTag:          "{}",
+		Servers:          []string{"127.0.0.1:11211:1"},
+		Routes:           []*RouteConfig{{Name: "user", Prefix: "user:", Servers: []string{"127.0.0.1:11212:1"}}},
+		Canary:           &CanaryConfig{Percent: percent, Servers: []string{"127.0.0.1:11213:1"}},
+	})
+}
+
+func TestClusterCanarySplit(t *testing.T) {
+	c := _createCanaryCluster(5)
+	split := map[string]bool{}
+	cnt := 0
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("feed:%d", i))
+		p := c.route(key)
+		split[string(key)] = p == c.canary.pool
+		if p == c.canary.pool {
+			cnt++
+			assert.Equal(t, 2, c.calculateBatchIndex(key))
+		} else {
+			assert.Equal(t, c.pool, p)
+			assert.Equal(t, 0, c.calculateBatchIndex(key))
+		}
+		// NOTE: the key is always on the same side.
+		assert.Equal(t, p, c.route(key))
+	}
+	assert.InDelta(t, 500, cnt, 100)
+	// NOTE: the keys matching routes are not split.
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, 1, c.calculateBatchIndex([]byte(fmt.Sprintf("user:%d", i))))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, c.route([]byte(fmt.Sprintf("{feed:%d}", i))), c.route([]byte(fmt.Sprintf("a{feed:%d}b", i))))
+	}
+
+	// NOTE: raising percent only moves keys to canary.
+	assert.NoError(t, c.SetCanaryPercent(20))
+	for key, ok := range split {
+		if ok {
+			assert.Equal(t, c.canary.pool, c.route([]byte(key)), key)
+		}
+	}
+	assert.NoError(t, c.SetCanaryPercent(0))
+	for key := range split {
+		assert.Equal(t, c.pool, c.route([]byte(key)), key)
+	}
+	assert.Equal(t, ErrCanaryPercent, c.SetCanaryPercent(101))
+	percent, err := c.CanaryPercent()
+	assert.NoError(t, err)
+	assert.Equal(t, float64(0), percent)
+
+	_, err = _createCluster(&ClusterConfig{CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}}).CanaryPercent()
+	assert.Equal(t, ErrCanaryNotFound, err)
+}
+
+func TestProxyHandleCanary(t *testing.T) {
+	c := _createCanaryCluster(5)
+	p := &Proxy{clusters: map[string]*Cluster{"mc": c}}
+	var tests = []struct {
+		method string
+		query  string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "cluster=mc", http.StatusOK, `{"cluster":"mc","percent":5}`},
+		{http.MethodPost, "cluster=mc&percent=12.5", http.StatusOK, `{"cluster":"mc","percent":12.5}`},
+		{http.MethodGet, "cluster=mc", http.StatusOK, `{"cluster":"mc","percent":12.5}`},
+		{http.MethodPost, "cluster=mc&percent=abc", http.StatusBadRequest, ""},
+		{http.MethodPost, "cluster=mc&percent=-1", http.StatusBadRequest, ""},
+		{http.MethodGet, "cluster=redis", http.StatusNotFound, ""},
+		{http.MethodDelete, "cluster=mc", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		p.HandleCanary(w, httptest.NewRequest(tt.method, "/canary?"+tt.query, nil))
+		assert.Equal(t, tt.code, w.Code, tt.method+" "+tt.query)
+		if tt.body != "" {
+			assert.Equal(t, tt.body, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	percent, _ := c.CanaryPercent()
+	assert.Equal(t, 12.5, percent)
+}
@@ -32,6 +32,7 @@ var (
 type pinger struct {
 	ping   proto.NodeConn
 	cc     *ClusterConfig
+	ring   *hashkit.HashRing
 	node   string
 	weight int
 
@@ -39,23 +40,52 @@ type pinger struct {
 	retries int
 }
 
+// batchChanel is the chans of n node conns of each client protocol cts, the batchs are sent by the node
+// conns of the protocol of their msgs, or the first protocol for the others.
 type batchChanel struct {
 	idx int32
 	cnt int32
-	chs []chan *proto.MsgBatch
+	chs map[proto.CacheType][]chan *proto.MsgBatch
+	def []chan *proto.MsgBatch
 }
 
-func newBatchChanel(n int32) *batchChanel {
-	chs := make([]chan *proto.MsgBatch, n)
-	for i := int32(0); i < n; i++ {
-		chs[i] = make(chan *proto.MsgBatch, 1)
+func newBatchChanel(n int32, cts ...proto.CacheType) *batchChanel {
+	if len(cts) == 0 {
+		cts = []proto.CacheType{proto.CacheTypeUnknown}
 	}
-	return &batchChanel{cnt: n, chs: chs}
+	c := &batchChanel{cnt: n, chs: make(map[proto.CacheType][]chan *proto.MsgBatch, len(cts))}
+	for _, ct := range cts {
+		chs := make([]chan *proto.MsgBatch, n)
+		for i := int32(0); i < n; i++ {
+			chs[i] = make(chan *proto.MsgBatch, 1)
+		}
+		c.chs[ct] = chs
+		if c.def == nil {
+			c.def = chs
+		}
+	}
+	return c
 }
 
 func (c *batchChanel) push(m *proto.MsgBatch) {
+	chs := c.def
+	if msg := m.Nth(0); msg != nil {
+		if tchs, ok := c.chs[msg.Type]; ok {
+			chs = tchs
+		}
+	}
 	i := atomic.AddInt32(&c.idx, 1)
-	c.chs[i%c.cnt] <- m
+	chs[i%c.cnt] <- m
+}
+
+// load returns the count of batchs queued to the node.
+func (c *batchChanel) load() (n int) {
+	for _, chs := range c.chs {
+		for _, ch := range chs {
+			n += len(ch)
+		}
+	}
+	return
 }
 
 // Cluster is cache cluster.
@@ -66,11 +96,25 @@ type Cluster struct {
 
 	hashTag []byte
 
-	ring *hashkit.HashRing
+	// pool is the servers of cluster, which serves the keys not matching any route.
+	pool     *pool
+	routes   []*route
+	allPools []*pool
+	// canary splits a percent of keys not matching routes from pool.
+	canary *canary
+	// migration migrates the keys of pool to new servers.
+	migration *migration
+	reshard   *reshard
+	// warm is the pool of warm servers, warmers are the warming nodes by index.
+	warm    *pool
+	warmers map[int]*warmer
+	shadow  *shadow
+	// broadcast sends the matched writes to all nodes.
+	broadcast *broadcast
+	hedge     *hedge
+	// repairs is the queue of stale copies found by quorum read.
+	repairs *quorumRepairs
 
-	alias    bool
-	nodeMap  map[string]int
-	aliasMap map[string]int
 	nodeChan map[int]*batchChanel
 
 	lock   sync.Mutex
@@ -79,75 +123,87 @@ type Cluster struct {
 
 // NewCluster new a cluster by cluster config.
 func NewCluster(ctx context.Context, cc *ClusterConfig) (c *Cluster) {
-	c = &Cluster{cc: cc}
-	c.ctx, c.cancel = context.WithCancel(ctx)
-	// parse
-	addrs, ws, ans, alias, err := parseServers(cc.Servers)
-	if err != nil {
-		panic(err)
+	c = newCluster(ctx, cc)
+	c.nodeChan = make(map[int]*batchChanel)
+	for _, p := range c.pools() {
+		for i, addr := range p.addrs {
+			nbc := newBatchChanel(p.cc.NodeConnections, p.cc.clientCacheTypes()...)
+			go c.processBatch(nbc, p.cc, addr)
+			c.nodeChan[p.base+i] = nbc
+		}
+		if p.cc.PingAutoEject {
+			go c.startPinger(p)
+		}
 	}
-	if len(cc.HashTag) == 2 {
-		c.hashTag = []byte{cc.HashTag[0], cc.HashTag[1]}
+	if c.shadow != nil {
+		go c.processShadow()
 	}
-	c.alias = alias
-	// hash ring
-	ring := hashkit.NewRing(cc.HashDistribution, cc.HashMethod)
-	if cc.CacheType == proto.CacheTypeMemcache || cc.CacheType == proto.CacheTypeMemcacheBinary || cc.CacheType == proto.CacheTypeRedis {
-		if c.alias {
-			ring.Init(ans, ws)
-		} else {
-			ring.Init(addrs, ws)
-		}
-	} else {
-		panic("unsupported protocol")
+	if c.reshard != nil {
+		go c.processReshard()
 	}
-
-	nodeChan := make(map[int]*batchChanel)
-	nodeMap := make(map[string]int)
-	aliasMap := make(map[string]int)
-	for i := range addrs {
-		nbc := newBatchChanel(cc.NodeConnections)
-		go c.processBatch(nbc, addrs[i])
-		nodeChan[i] = nbc
-		nodeMap[addrs[i]] = i
-		if c.alias {
-			aliasMap[ans[i]] = i
-		}
+	if c.repairs != nil {
+		go c.processRepair()
 	}
+	return
+}
 
-	c.aliasMap = aliasMap
-	c.nodeChan = nodeChan
-	c.nodeMap = nodeMap
-	c.ring = ring
-	if c.cc.PingAutoEject {
-		go c.startPinger(c.cc, addrs, ws)
+// newCluster inits the pools and features of cluster without connecting to nodes.
+func newCluster(ctx context.Context, cc *ClusterConfig) (c *Cluster) {
+	c = &Cluster{cc: cc}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	if len(cc.clientCacheTypes()) == 0 {
+		panic("unsupported protocol")
 	}
+	if cc.HashTagMode == HashTagRedisCluster {
+		c.hashTag = []byte("{}")
+	} else if len(cc.HashTag) == 2 {
+		c.hashTag = []byte{cc.HashTag[0], cc.HashTag[1]}
+	}
+	c.initPools()
+	c.initCanary()
+	c.initMigration()
+	c.initReshard()
+	c.initWarmUp()
+	c.initShadow()
+	c.initBroadcast()
+	c.initHedge()
+	c.initQuorum()
 	return
 }
 
 func (c *Cluster) calculateBatchIndex(key []byte) int {
-	node, ok := c.hash(key)
+	return c.poolIndex(c.route(key), key)
+}
+
+// poolIndex returns the index of node in pool p which key is hashed to, -1 means no node.
+func (c *Cluster) poolIndex(p *pool, key []byte) int {
+	node, ok := p.ring.GetNode(c.hashKey(key))
 	if !ok {
 		if log.V(3) {
 			log.Warnf("cluster(%s) addr(%s) Msg(%s) hash node not ok", c.cc.Name, c.cc.ListenAddr, key)
 		}
 		return -1
 	}
-
-	if c.alias {
-		return c.aliasMap[node]
-	}
-	return c.nodeMap[node]
+	return p.nodeMap[node]
 }
 
-// DispatchBatch delivers all the messages to batch execute by hash
+// DispatchBatch delivers all the messages to batch execute by hash, except the hedged reads and
+// the msgs failed before dispatch, e.g. the writes whose keys failed to move while resharding.
+// NOTE: the subs of one msg are grouped by node and appended to the node batch contiguously,
+// so node conn can send them as one multi keys command.
 func (c *Cluster) DispatchBatch(mbs []*proto.MsgBatch, slice []*proto.Message) {
 	// TODO: dynamic update mbs by add more than configrured nodes
-	var bidx int
+	var (
+		bidx    int
+		written = c.hedgeWritten(slice)
+	)
 	for _, msg := range slice {
 		if msg.IsBatch() {
 			for _, sub := range msg.Batch() {
-				bidx = c.calculateBatchIndex(sub.Request().Key())
+				if sub.Err() != nil || c.hedging(sub.Request(), written) {
+					continue
+				}
+				bidx = c.dispatchIndex(sub.Request())
 				if bidx == -1 {
 					log.Errorf("cluster (%s) has not avaliable node ", c.cc.Name)
 					msg.DoneWithError(ErrNotAvaiableNode)
@@ -156,7 +212,10 @@ func (c *Cluster) DispatchBatch(mbs []*proto.MsgBatch, slice []*proto.Message) {
 				mbs[bidx].AddMsg(sub)
 			}
 		} else {
-			bidx = c.calculateBatchIndex(msg.Request().Key())
+			if msg.Err() != nil || c.hedging(msg.Request(), written) {
+				continue
+			}
+			bidx = c.dispatchIndex(msg.Request())
 			if bidx == -1 {
 				log.Errorf("cluster (%s) has not avaliable node ", c.cc.Name)
 				msg.DoneWithError(ErrNotAvaiableNode)
@@ -177,22 +236,24 @@ func (c *Cluster) deliver(mbs []*proto.MsgBatch) {
 	}
 }
 
-func (c *Cluster) processBatch(nbc *batchChanel, addr string) {
-	for i := int32(0); i < nbc.cnt; i++ {
-		go func(i int32) {
-			ch := nbc.chs[i]
-			w := newNodeConn(c.cc, addr)
-			c.processBatchIO(addr, ch, w)
-		}(i)
+func (c *Cluster) processBatch(nbc *batchChanel, cc *ClusterConfig, addr string) {
+	for _, pcc := range cc.clientConfigs() {
+		chs := nbc.chs[pcc.CacheType]
+		for i := int32(0); i < nbc.cnt; i++ {
+			go func(pcc *ClusterConfig, ch <-chan *proto.MsgBatch) {
+				w := newNodeConn(pcc, addr)
+				c.processBatchIO(pcc, addr, ch, w)
+			}(pcc, chs[i])
+		}
 	}
 }
 
-func (c *Cluster) processBatchIO(addr string, ch <-chan *proto.MsgBatch, nc proto.NodeConn) {
+func (c *Cluster) processBatchIO(cc *ClusterConfig, addr string, ch <-chan *proto.MsgBatch, nc proto.NodeConn) {
 	var err error
 	for {
 		if err != nil {
 			nc.Close()
-			nc = newNodeConn(c.cc, addr)
+			nc = newNodeConn(cc, addr)
 		}
 		var mb *proto.MsgBatch
 		select {
@@ -219,11 +280,13 @@ func (c *Cluster) processBatchIO(addr string, ch <-chan *proto.MsgBatch, nc prot
 	}
 }
 
-func (c *Cluster) startPinger(cc *ClusterConfig, addrs []string, ws []int) {
-	for idx, addr := range addrs {
-		w := ws[idx]
+func (c *Cluster) startPinger(pl *pool) {
+	// NOTE: the nodes are pinged by the conn of first client protocol.
+	cc := pl.cc.clientConfigs()[0]
+	for idx, addr := range pl.addrs {
+		w := pl.ws[idx]
 		nc := newNodeConn(cc, addr)
-		p := &pinger{ping: nc, cc: cc, node: addr, weight: w}
+		p := &pinger{ping: nc, cc: cc, ring: pl.ring, node: addr, weight: w}
 		go c.processPing(p)
 	}
 }
@@ -247,12 +310,20 @@ func (c *Cluster) processPing(p *pinger) {
 		} else {
 			p.failure = 0
 			if del {
-				c.ring.AddNode(p.node, p.weight)
+				if p.cc.PingEjectMode == EjectDoubleHash {
+					p.ring.Recover(p.node)
+				} else {
+					p.ring.AddNode(p.node, p.weight)
+				}
 				del = false
 			}
 		}
-		if c.cc.PingAutoEject && p.failure >= c.cc.PingFailLimit {
-			c.ring.DelNode(p.node)
+		if p.cc.PingAutoEject && p.failure >= p.cc.PingFailLimit {
+			if p.cc.PingEjectMode == EjectDoubleHash {
+				p.ring.Eject(p.node)
+			} else {
+				p.ring.DelNode(p.node)
+			}
 			del = true
 		}
 		select {
@@ -265,21 +336,27 @@ func (c *Cluster) processPing(p *pinger) {
 	}
 }
 
-// hash returns node by hash hit.
-func (c *Cluster) hash(key []byte) (node string, ok bool) {
-	var realKey []byte
+// copyMsg returns the copy of m whose request owns its data, which is sent to other nodes along
+// with m, e.g. the replicas, broadcast and hedge. The copies must be made before m is dispatched,
+// since the request may be overwritten by its reply, e.g. memcache.
+func (c *Cluster) copyMsg(m *proto.Message) *proto.Message {
+	cm := proto.NewMessage()
+	cm.Type = m.Type
+	cm.WithRequest(m.Request().Clone())
+	return cm
+}
+
+// hashKey returns the part of key used for hashing by hash tag, the first open char and the first
+// close char after it are matched, the same as Redis Cluster.
+func (c *Cluster) hashKey(key []byte) []byte {
 	if len(c.hashTag) == 2 {
 		if b := bytes.IndexByte(key, c.hashTag[0]); b >= 0 {
-			if e := bytes.IndexByte(key[b+1:], c.hashTag[1]); e >= 0 {
-				realKey = key[b+1 : b+1+e]
+			if e := bytes.IndexByte(key[b+1:], c.hashTag[1]); e > 0 {
+				return key[b+1 : b+1+e]
 			}
 		}
 	}
-	if len(realKey) == 0 {
-		realKey = key
-	}
-	node, ok = c.ring.GetNode(realKey)
-	return
+	return key
 }
 
 // Close closes resources.
@@ -336,12 +413,21 @@ func newNodeConn(cc *ClusterConfig, addr string) proto.NodeConn {
 	dto := time.Duration(cc.DialTimeout) * time.Millisecond
 	rto := time.Duration(cc.ReadTimeout) * time.Millisecond
 	wto := time.Duration(cc.WriteTimeout) * time.Millisecond
-	switch cc.CacheType {
+	switch cc.nodeCacheType() {
 	case proto.CacheTypeMemcache:
+		if cc.CacheType == proto.CacheTypeMemcacheBinary {
+			return mcbin.NewTextNodeConn(cc.Name, addr, dto, rto, wto)
+		}
 		return memcache.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case proto.CacheTypeMemcacheBinary:
+		if cc.CacheType == proto.CacheTypeMemcache {
+			return memcache.NewBinaryNodeConn(cc.Name, addr, dto, rto, wto)
+		}
 		return mcbin.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	case proto.CacheTypeRedis:
+		if cc.CacheType == proto.CacheTypeMemcache {
+			return memcache.NewRedisNodeConn(cc.Name, addr, dto, rto, wto)
+		}
 		return redis.NewNodeConn(cc.Name, addr, dto, rto, wto)
 	default:
 		panic(proto.ErrNoSupportCacheType)
@@ -1,12 +1,93 @@
 package proxy
 
 import (
+	errs "errors"
+	"regexp"
+	"strings"
+
+	"overlord/lib/hashkit"
 	"overlord/proto"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
 )
 
+// config errors
+var (
+	ErrConfigTranslate     = errs.New("unsupported protocol translation")
+	ErrConfigCrossNodeMode = errs.New("unsupported cross node mode or best_effort with replicas or broadcast")
+	ErrConfigRoute         = errs.New("route must have servers and one of prefix or regex")
+	ErrConfigFailover      = errs.New("unsupported failover mode or write policy")
+	ErrConfigWarmUp        = errs.New("warm up must have nodes of cluster, servers and duration or hit rate")
+	ErrConfigShadow        = errs.New("shadow must have servers and percent in (0, 100]")
+	ErrConfigCanary        = errs.New("canary must have servers and percent in [0, 100]")
+	ErrConfigMigration     = errs.New("migration must have servers and phase of dual_write, dual_read or new_only")
+	ErrConfigReshard       = errs.New("reshard must have nodes added to redis servers and no migration")
+	ErrConfigReplicas      = errs.New("replicas must be in [0, count of servers]")
+	ErrConfigEjectMode     = errs.New("unsupported ping eject mode or double_hash with replicas")
+	ErrConfigBroadcast     = errs.New("broadcast must have prefixes or commands and quorum in [0, count of servers]")
+	ErrConfigQuorumRead    = errs.New("quorum read must have reads in [2, replicas] of redis with version field")
+	ErrConfigDistribution  = errs.New("unsupported hash distribution")
+	ErrConfigPoints        = errs.New("points per server must be 0 or a positive multiple of 4")
+	ErrConfigHashTag       = errs.New("unsupported hash tag mode or hash tag of redis_cluster mode is not {}")
+	ErrConfigHedge         = errs.New("hedge must have percentile in [0, 100], min_delay not more than max_delay and no quorum read, failover, warm up or replicas")
+)
+
+// cross node modes of the redis commands whose keys span nodes.
+const (
+	// CrossNodeReject rejects the commands, it is the default.
+	CrossNodeReject = "reject"
+	// CrossNodeBestEffort emulates the commands by proxy without atomicity, see redis.Emulate.
+	CrossNodeBestEffort = "best_effort"
+
+	defaultCrossNodeMaxSize = 1 << 20
+)
+
+// failover modes and write policies of the requests failed by node errors.
+const (
+	// FailoverNextNode retries on the next nodes of ring.
+	FailoverNextNode = "next_node"
+	// FailoverPool retries on the nodes of failover servers.
+	FailoverPool = "pool"
+
+	// FailoverWriteFail fails the writes, it is the default.
+	FailoverWriteFail = "fail"
+	// FailoverWriteDual copies the writes to the first failover node too, so the failover reads can see
+	// them, and retries the failed writes on the failover nodes like reads.
+	FailoverWriteDual = "dual"
+
+	defaultFailoverRetries = 1
+)
+
+// eject modes of the nodes failed ping_fail_limit times.
+const (
+	// EjectRebuild deletes the node and rebuilds the ring, it is the default.
+	EjectRebuild = "rebuild"
+	// EjectDoubleHash keeps the ring intact and rehashes only the keys of ejected node to the others.
+	EjectDoubleHash = "double_hash"
+)
+
+// hash tag modes of the part of key used for hashing.
+const (
+	// HashTagSimple hashes the part between the two chars of hash_tag, or the whole key when the
+	// part is empty or hash_tag is not set, it is the default.
+	HashTagSimple = "simple"
+	// HashTagRedisCluster hashes the part between the first '{' and the first '}' after it like
+	// Redis Cluster, or the whole key when the part is empty, even if hash_tag is not set.
+	HashTagRedisCluster = "redis_cluster"
+)
+
+// migration phases
+const (
+	// MigrationDualWrite writes to both old and new servers, and reads from old servers.
+	MigrationDualWrite = "dual_write"
+	// MigrationDualRead writes to both old and new servers, and reads from new servers with
+	// fallback to old servers on miss.
+	MigrationDualRead = "dual_read"
+	// MigrationNewOnly reads and writes new servers only.
+	MigrationNewOnly = "new_only"
+)
+
 // Config proxy config.
 type Config struct {
 	Pprof string
@@ -54,7 +135,10 @@ type ClusterConfig struct {
 	HashMethod       string          `toml:"hash_method"`
 	HashDistribution string          `toml:"hash_distribution"`
 	HashTag          string          `toml:"hash_tag"`
+	HashTagMode      string          `toml:"hash_tag_mode"`
+	PointsPerServer  int             `toml:"points_per_server"`
 	CacheType        proto.CacheType `toml:"cache_type"`
+	NodeCacheType    proto.CacheType `toml:"node_cache_type"`
 	ListenProto      string          `toml:"listen_proto"`
 	ListenAddr       string          `toml:"listen_addr"`
 	RedisAuth        string          `toml:"redis_auth"`
@@ -64,15 +148,613 @@ type ClusterConfig struct {
 	NodeConnections  int32           `toml:"node_connections"`
 	PingFailLimit    int             `toml:"ping_fail_limit"`
 	PingAutoEject    bool            `toml:"ping_auto_eject"`
+	PingEjectMode    string          `toml:"ping_eject_mode"`
+	CrossNodeMode    string          `toml:"cross_node_mode"`
+	CrossNodeMaxSize int             `toml:"cross_node_max_size"`
+	Replicas         int             `toml:"replicas"`
 	Servers          []string
+	Routes           []*RouteConfig    `toml:"routes"`
+	Failover         *FailoverConfig   `toml:"failover"`
+	WarmUp           *WarmUpConfig     `toml:"warm_up"`
+	Shadow           *ShadowConfig     `toml:"shadow"`
+	Canary           *CanaryConfig     `toml:"canary"`
+	Migration        *MigrationConfig  `toml:"migration"`
+	Reshard          *ReshardConfig    `toml:"reshard"`
+	Broadcast        *BroadcastConfig  `toml:"broadcast"`
+	QuorumRead       *QuorumReadConfig `toml:"quorum_read"`
+	Hedge            *HedgeConfig      `toml:"hedge"`
+}
+
+// poolConfig returns the config of a pool serving servers inherited from cc,
+// the routes and the features of whole cluster are not inherited.
+func (cc *ClusterConfig) poolConfig(servers []string) *ClusterConfig {
+	ncc := *cc
+	ncc.Servers = servers
+	ncc.Routes = nil
+	ncc.WarmUp = nil
+	ncc.Shadow = nil
+	ncc.Canary = nil
+	ncc.Migration = nil
+	ncc.Reshard = nil
+	ncc.Broadcast = nil
+	ncc.QuorumRead = nil
+	ncc.Hedge = nil
+	return &ncc
+}
+
+// HedgeConfig sends the reads again to the alternate target when their node has not replied within
+// the percentile of recent read latencies bounded by min_delay and max_delay in msec, and the first
+// reply wins. The alternate target is the node of servers when set, or the next node of key on ring.
+type HedgeConfig struct {
+	Percentile float64 `toml:"percentile"`
+	MinDelay   int     `toml:"min_delay"`
+	MaxDelay   int     `toml:"max_delay"`
+	Servers    []string
+}
+
+// Validate validates hedge config of cc.
+// NOTE: the hedged reads are not sent by the msg batchs of round, so they are never failed over, retried
+// on replicas or warmed up, the node errors are retried on the alternate target by hedge instead.
+func (hc *HedgeConfig) Validate(cc *ClusterConfig) error {
+	if hc.Percentile < 0 || hc.Percentile > 100 || hc.MinDelay < 0 || hc.MinDelay > hc.maxDelay() {
+		return ErrConfigHedge
+	}
+	if cc.QuorumRead != nil || cc.Failover != nil || cc.WarmUp != nil || cc.replicas() > 1 {
+		return ErrConfigHedge
+	}
+	return nil
+}
+
+func (hc *HedgeConfig) percentile() float64 {
+	if hc.Percentile <= 0 {
+		return defaultHedgePercentile
+	}
+	return hc.Percentile
+}
+
+func (hc *HedgeConfig) maxDelay() int {
+	if hc.MaxDelay <= 0 {
+		return defaultHedgeMaxDelay
+	}
+	return hc.MaxDelay
+}
+
+// clusterConfig returns the config of hedge servers inherited from cc.
+func (hc *HedgeConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	ncc := cc.poolConfig(hc.Servers)
+	ncc.Failover = nil
+	return ncc
+}
+
+// QuorumReadConfig reads the key from its first reads replicas in parallel and replies the copy of the
+// most recent version, which is the integer of version_field of redis hash. The stale copies are
+// repaired asynchronously when repair is set, by a queue of repair_queue_size keys, and only the copies
+// whose version is still lower are replaced. Only redis is supported, and only the reads of hash are
+// quorum read, the reads of the other types are read from the first replica only.
+// NOTE: memcache is not supported, since the cas unique is assigned by each node and can not order
+// the copies of different nodes.
+type QuorumReadConfig struct {
+	Reads           int    `toml:"reads"`
+	VersionField    string `toml:"version_field"`
+	Repair          bool   `toml:"repair"`
+	RepairQueueSize int    `toml:"repair_queue_size"`
+}
+
+// Validate validates quorum read config of cc.
+func (qc *QuorumReadConfig) Validate(cc *ClusterConfig) error {
+	if cc.CacheType != proto.CacheTypeRedis || cc.nodeCacheType() != proto.CacheTypeRedis || qc.VersionField == "" {
+		return ErrConfigQuorumRead
+	}
+	if qc.Reads < 2 || qc.Reads > cc.replicas() {
+		return ErrConfigQuorumRead
+	}
+	return nil
+}
+
+// repairQueueSize returns the max count of keys waiting to be repaired, the others are dropped.
+func (qc *QuorumReadConfig) repairQueueSize() int {
+	if qc.RepairQueueSize <= 0 {
+		return defaultQuorumRepairQueueSize
+	}
+	return qc.RepairQueueSize
+}
+
+// BroadcastConfig sends the writes of keys matching prefixes or commands to all nodes of the pool
+// serving them, and serves the reads by the least loaded node. The write succeeds only if quorum
+// nodes succeed, which is all nodes written by default, the nodes ejected are not written. The missed
+// reads fall back to another node, since the nodes recovered may miss the writes broadcast while they
+// were down.
+type BroadcastConfig struct {
+	Prefixes []string `toml:"prefixes"`
+	Commands []string `toml:"commands"`
+	Quorum   int      `toml:"quorum"`
+}
+
+// Validate validates broadcast config of cc.
+func (bc *BroadcastConfig) Validate(cc *ClusterConfig) error {
+	if len(bc.Prefixes) == 0 && len(bc.Commands) == 0 || bc.Quorum < 0 || bc.Quorum > len(cc.Servers) {
+		return ErrConfigBroadcast
+	}
+	return nil
+}
+
+// quorum returns the count of nodes must succeed, n is the count of nodes written.
+func (bc *BroadcastConfig) quorum(n int) int {
+	if bc.Quorum <= 0 {
+		return n
+	}
+	return bc.Quorum
+}
+
+// ReshardConfig moves the keys of servers whose owner changed to the added nodes, which are the
+// ip:port of servers, by a background job. Until the job is done, the reads of added nodes fall back
+// to the old owner on miss, and the keys written are moved before the writes are sent to added nodes.
+// Only redis is supported.
+type ReshardConfig struct {
+	Nodes     []string
+	ScanCount int `toml:"scan_count"`
+}
+
+// Validate validates reshard config of cc.
+func (rc *ReshardConfig) Validate(cc *ClusterConfig) error {
+	if cc.CacheType != proto.CacheTypeRedis || cc.nodeCacheType() != proto.CacheTypeRedis || cc.Migration != nil {
+		return ErrConfigReshard
+	}
+	addrs, _, _, _, _ := parseServers(cc.Servers)
+	added := map[string]bool{}
+	for _, node := range rc.Nodes {
+		added[node] = true
+	}
+	old := 0
+	for _, addr := range addrs {
+		if added[addr] {
+			delete(added, addr)
+		} else {
+			old++
+		}
+	}
+	if len(rc.Nodes) == 0 || len(added) > 0 || old == 0 {
+		return ErrConfigReshard
+	}
+	return nil
+}
+
+// scanCount returns the count of keys scanned by one SCAN.
+func (rc *ReshardConfig) scanCount() int {
+	if rc.ScanCount <= 0 {
+		return defaultReshardScanCount
+	}
+	return rc.ScanCount
+}
+
+// MigrationConfig migrates the keys of cluster servers, which are the old servers, to the new servers
+// by phases. The keys matching routes or split to canary are not migrated. The phase can be switched
+// at runtime by the http endpoint /migration.
+type MigrationConfig struct {
+	Phase   string `toml:"phase"`
+	Servers []string
+}
+
+// Validate validates migration config.
+func (mc *MigrationConfig) Validate() error {
+	if len(mc.Servers) == 0 || migrationPhaseIndex(mc.Phase) == -1 {
+		return ErrConfigMigration
+	}
+	return nil
+}
+
+// clusterConfig returns the config of new servers inherited from cc.
+func (mc *MigrationConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	return cc.poolConfig(mc.Servers)
+}
+
+// CanaryConfig splits a stable percent of keys by key hash to the canary servers, the other keys
+// stay on the cluster servers. The keys matching routes are not split. The percent can be adjusted
+// at runtime by the http endpoint /canary, raising it only moves keys to the canary servers.
+type CanaryConfig struct {
+	Percent float64 `toml:"percent"`
+	Servers []string
+}
+
+// Validate validates canary config.
+func (ca *CanaryConfig) Validate() error {
+	if len(ca.Servers) == 0 || !validCanaryPercent(ca.Percent) {
+		return ErrConfigCanary
+	}
+	return nil
+}
+
+func validCanaryPercent(percent float64) bool {
+	return percent >= 0 && percent <= 100
+}
+
+// clusterConfig returns the config of canary servers inherited from cc.
+func (ca *CanaryConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	return cc.poolConfig(ca.Servers)
+}
+
+// ShadowConfig mirrors the sampled requests to shadow servers asynchronously, the requests are
+// filtered by key prefixes and commands when set. The shadow replies are discarded and only
+// compared with the primary ones by latency and error metrics.
+type ShadowConfig struct {
+	Servers   []string
+	Percent   float64  `toml:"percent"`
+	Prefixes  []string `toml:"prefixes"`
+	Commands  []string `toml:"commands"`
+	QueueSize int      `toml:"queue_size"`
+}
+
+// Validate validates shadow config.
+func (sc *ShadowConfig) Validate() error {
+	if len(sc.Servers) == 0 || sc.Percent <= 0 || sc.Percent > 100 {
+		return ErrConfigShadow
+	}
+	return nil
+}
+
+// queueSize returns the max count of requests waiting to be mirrored, the others are dropped.
+func (sc *ShadowConfig) queueSize() int {
+	if sc.QueueSize <= 0 {
+		return defaultShadowQueueSize
+	}
+	return sc.QueueSize
+}
+
+// clusterConfig returns the config of shadow servers inherited from cc.
+func (sc *ShadowConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	ncc := cc.poolConfig(sc.Servers)
+	ncc.Failover = nil
+	return ncc
+}
+
+// WarmUpConfig retries the get misses of warming nodes on the warm servers, usually the old cluster,
+// and writes the hits back to the warming nodes. Warming ends after duration seconds or when the
+// hit rate of warming node reaches hit_rate, only memcache text protocol is supported.
+// NOTE: the misses are fetched by meta get, so the warm servers must be memcached 1.6 or later.
+type WarmUpConfig struct {
+	Nodes      []string
+	Servers    []string
+	Duration   int     `toml:"duration"`
+	HitRate    float64 `toml:"hit_rate"`
+	DefaultTTL int     `toml:"default_ttl"`
+}
+
+// Validate validates warm up config of cc.
+func (wc *WarmUpConfig) Validate(cc *ClusterConfig) error {
+	if cc.CacheType != proto.CacheTypeMemcache || cc.nodeCacheType() != proto.CacheTypeMemcache {
+		return ErrConfigWarmUp
+	}
+	if len(wc.Nodes) == 0 || len(wc.Servers) == 0 || (wc.Duration <= 0 && wc.HitRate <= 0) || wc.HitRate > 1 {
+		return ErrConfigWarmUp
+	}
+	svrss := [][]string{cc.Servers}
+	if cc.Canary != nil {
+		svrss = append(svrss, cc.Canary.Servers)
+	}
+	if cc.Migration != nil {
+		svrss = append(svrss, cc.Migration.Servers)
+	}
+	for _, rc := range cc.Routes {
+		svrss = append(svrss, rc.Servers)
+	}
+	addrs := map[string]bool{}
+	for _, svrs := range svrss {
+		as, _, _, _, _ := parseServers(svrs)
+		for _, addr := range as {
+			addrs[addr] = true
+		}
+	}
+	for _, node := range wc.Nodes {
+		if !addrs[node] {
+			return ErrConfigWarmUp
+		}
+	}
+	return nil
+}
+
+// clusterConfig returns the config of warm servers inherited from cc.
+func (wc *WarmUpConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	ncc := cc.poolConfig(wc.Servers)
+	ncc.Failover = nil
+	return ncc
+}
+
+// FailoverConfig retries the requests failed by node errors, reads are always retried
+// and writes are retried by write policy.
+type FailoverConfig struct {
+	Mode        string `toml:"mode"`
+	Retries     int    `toml:"retries"`
+	WritePolicy string `toml:"write_policy"`
+	Servers     []string
+}
+
+// Validate validates failover config.
+func (fc *FailoverConfig) Validate() error {
+	switch fc.WritePolicy {
+	case "", FailoverWriteFail, FailoverWriteDual:
+	default:
+		return ErrConfigFailover
+	}
+	switch fc.Mode {
+	case FailoverNextNode:
+	case FailoverPool:
+		if len(fc.Servers) == 0 {
+			return ErrConfigFailover
+		}
+	default:
+		return ErrConfigFailover
+	}
+	return nil
+}
+
+// retries returns the max times of failover.
+func (fc *FailoverConfig) retries() int {
+	if fc.Retries <= 0 {
+		return defaultFailoverRetries
+	}
+	return fc.Retries
+}
+
+// clusterConfig returns the config of failover servers inherited from cc.
+func (fc *FailoverConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	ncc := cc.poolConfig(fc.Servers)
+	ncc.Failover = nil
+	return ncc
+}
+
+// RouteConfig routes the keys matching prefix or regex to its own servers,
+// the zero value fields are inherited from cluster config.
+type RouteConfig struct {
+	Name             string
+	Prefix           string `toml:"prefix"`
+	Regex            string `toml:"regex"`
+	HashMethod       string `toml:"hash_method"`
+	HashDistribution string `toml:"hash_distribution"`
+	DialTimeout      int    `toml:"dial_timeout"`
+	ReadTimeout      int    `toml:"read_timeout"`
+	WriteTimeout     int    `toml:"write_timeout"`
+	NodeConnections  int32  `toml:"node_connections"`
+	Servers          []string
+	// Failover is inherited from cluster config when not set.
+	Failover *FailoverConfig `toml:"failover"`
+}
+
+// Validate validates route config.
+func (rc *RouteConfig) Validate() error {
+	if len(rc.Servers) == 0 || (rc.Prefix == "") == (rc.Regex == "") {
+		return ErrConfigRoute
+	}
+	if rc.Regex != "" {
+		if _, err := regexp.Compile(rc.Regex); err != nil {
+			return errors.Wrapf(ErrConfigRoute, "regex(%s) %v", rc.Regex, err)
+		}
+	}
+	if !hashkit.ValidDistribution(rc.HashDistribution) {
+		return errors.Wrapf(ErrConfigDistribution, "hash_distribution(%s)", rc.HashDistribution)
+	}
+	if rc.Failover != nil {
+		return rc.Failover.Validate()
+	}
+	return nil
+}
+
+// prefix returns the prefix of keys, the trailing '*' is allowed, e.g. "user:*".
+func (rc *RouteConfig) prefix() string {
+	return strings.TrimSuffix(rc.Prefix, "*")
+}
+
+// clusterConfig returns the config of route servers inherited from cc.
+func (rc *RouteConfig) clusterConfig(cc *ClusterConfig) *ClusterConfig {
+	ncc := cc.poolConfig(rc.Servers)
+	if rc.HashMethod != "" {
+		ncc.HashMethod = rc.HashMethod
+	}
+	if rc.HashDistribution != "" {
+		ncc.HashDistribution = rc.HashDistribution
+	}
+	if rc.DialTimeout != 0 {
+		ncc.DialTimeout = rc.DialTimeout
+	}
+	if rc.ReadTimeout != 0 {
+		ncc.ReadTimeout = rc.ReadTimeout
+	}
+	if rc.WriteTimeout != 0 {
+		ncc.WriteTimeout = rc.WriteTimeout
+	}
+	if rc.NodeConnections != 0 {
+		ncc.NodeConnections = rc.NodeConnections
+	}
+	if rc.Failover != nil {
+		ncc.Failover = rc.Failover
+	}
+	return ncc
 }
 
 // Validate validate config field value.
 func (cc *ClusterConfig) Validate() error {
 	// TODO(felix): complete validates
+	nt := cc.nodeCacheType()
+	if cc.CacheType == proto.CacheTypeAuto {
+		if len(cc.autoCacheTypes()) == 0 {
+			return errors.Wrapf(ErrConfigTranslate, "cluster(%s) cache_type(%s) node_cache_type(%s)", cc.Name, cc.CacheType, nt)
+		}
+	} else if !canTranslate(cc.CacheType, nt) {
+		return errors.Wrapf(ErrConfigTranslate, "cluster(%s) cache_type(%s) node_cache_type(%s)", cc.Name, cc.CacheType, nt)
+	}
+	switch cc.CrossNodeMode {
+	case "", CrossNodeReject:
+	case CrossNodeBestEffort:
+		// NOTE: the emulated writes are sent to the node of each key only, so the other replicas and
+		// the broadcast copies would miss them.
+		if cc.Replicas > 1 || cc.Broadcast != nil {
+			return errors.Wrapf(ErrConfigCrossNodeMode, "cluster(%s) cross_node_mode(%s) replicas(%d) broadcast(%v)", cc.Name, cc.CrossNodeMode, cc.Replicas, cc.Broadcast != nil)
+		}
+	default:
+		return errors.Wrapf(ErrConfigCrossNodeMode, "cluster(%s) cross_node_mode(%s)", cc.Name, cc.CrossNodeMode)
+	}
+	if !hashkit.ValidDistribution(cc.HashDistribution) {
+		return errors.Wrapf(ErrConfigDistribution, "cluster(%s) hash_distribution(%s)", cc.Name, cc.HashDistribution)
+	}
+	// NOTE: ketama puts points by groups of 4, so fewer points leave no point on the ring.
+	if cc.PointsPerServer < 0 || cc.PointsPerServer%4 != 0 {
+		return errors.Wrapf(ErrConfigPoints, "cluster(%s) points_per_server(%d)", cc.Name, cc.PointsPerServer)
+	}
+	switch cc.HashTagMode {
+	case "", HashTagSimple:
+	case HashTagRedisCluster:
+		if cc.HashTag != "" && cc.HashTag != "{}" {
+			return errors.Wrapf(ErrConfigHashTag, "cluster(%s) hash_tag_mode(%s) hash_tag(%s)", cc.Name, cc.HashTagMode, cc.HashTag)
+		}
+	default:
+		return errors.Wrapf(ErrConfigHashTag, "cluster(%s) hash_tag_mode(%s) hash_tag(%s)", cc.Name, cc.HashTagMode, cc.HashTag)
+	}
+	switch cc.PingEjectMode {
+	case "", EjectRebuild:
+	case EjectDoubleHash:
+		// NOTE: the keys of ejected node are rehashed to a node holding no replica of them,
+		// so the replicas written clockwise on the ring are lost.
+		if cc.Replicas > 1 {
+			return errors.Wrapf(ErrConfigEjectMode, "cluster(%s) ping_eject_mode(%s) replicas(%d)", cc.Name, cc.PingEjectMode, cc.Replicas)
+		}
+	default:
+		return errors.Wrapf(ErrConfigEjectMode, "cluster(%s) ping_eject_mode(%s)", cc.Name, cc.PingEjectMode)
+	}
+	if cc.Replicas < 0 || cc.Replicas > len(cc.Servers) {
+		return errors.Wrapf(ErrConfigReplicas, "cluster(%s) replicas(%d)", cc.Name, cc.Replicas)
+	}
+	if cc.Failover != nil {
+		if err := cc.Failover.Validate(); err != nil {
+			return errors.Wrapf(err, "cluster(%s) failover mode(%s) write_policy(%s)", cc.Name, cc.Failover.Mode, cc.Failover.WritePolicy)
+		}
+	}
+	if cc.WarmUp != nil {
+		if err := cc.WarmUp.Validate(cc); err != nil {
+			return errors.Wrapf(err, "cluster(%s) warm up nodes(%v)", cc.Name, cc.WarmUp.Nodes)
+		}
+	}
+	if cc.Shadow != nil {
+		if err := cc.Shadow.Validate(); err != nil {
+			return errors.Wrapf(err, "cluster(%s) shadow percent(%v)", cc.Name, cc.Shadow.Percent)
+		}
+	}
+	if cc.Canary != nil {
+		if err := cc.Canary.Validate(); err != nil {
+			return errors.Wrapf(err, "cluster(%s) canary percent(%v)", cc.Name, cc.Canary.Percent)
+		}
+	}
+	if cc.Migration != nil {
+		if err := cc.Migration.Validate(); err != nil {
+			return errors.Wrapf(err, "cluster(%s) migration phase(%s)", cc.Name, cc.Migration.Phase)
+		}
+	}
+	if cc.Reshard != nil {
+		if err := cc.Reshard.Validate(cc); err != nil {
+			return errors.Wrapf(err, "cluster(%s) reshard nodes(%v)", cc.Name, cc.Reshard.Nodes)
+		}
+	}
+	if cc.Broadcast != nil {
+		if err := cc.Broadcast.Validate(cc); err != nil {
+			return errors.Wrapf(err, "cluster(%s) broadcast quorum(%d)", cc.Name, cc.Broadcast.Quorum)
+		}
+	}
+	if cc.QuorumRead != nil {
+		if err := cc.QuorumRead.Validate(cc); err != nil {
+			return errors.Wrapf(err, "cluster(%s) quorum read reads(%d) replicas(%d)", cc.Name, cc.QuorumRead.Reads, cc.Replicas)
+		}
+	}
+	if cc.Hedge != nil {
+		if err := cc.Hedge.Validate(cc); err != nil {
+			return errors.Wrapf(err, "cluster(%s) hedge percentile(%v) min_delay(%d) max_delay(%d)", cc.Name, cc.Hedge.Percentile, cc.Hedge.MinDelay, cc.Hedge.MaxDelay)
+		}
+	}
+	for _, rc := range cc.Routes {
+		if err := rc.Validate(); err != nil {
+			return errors.Wrapf(err, "cluster(%s) route(%s)", cc.Name, rc.Name)
+		}
+	}
+	return nil
+}
+
+// crossNodeMaxSize returns the max bytes read from source keys by cross node command.
+func (cc *ClusterConfig) crossNodeMaxSize() int {
+	if cc.CrossNodeMaxSize <= 0 {
+		return defaultCrossNodeMaxSize
+	}
+	return cc.CrossNodeMaxSize
+}
+
+// replicas returns the count of distinct nodes each key is stored on, 1 by default.
+func (cc *ClusterConfig) replicas() int {
+	if cc.Replicas <= 0 {
+		return 1
+	}
+	return cc.Replicas
+}
+
+// nodeCacheType returns the protocol speaking with nodes, same as client protocol by default.
+func (cc *ClusterConfig) nodeCacheType() proto.CacheType {
+	if cc.NodeCacheType == "" {
+		return cc.CacheType
+	}
+	return cc.NodeCacheType
+}
+
+// autoCacheTypes returns the client protocols accepted by auto cache type.
+func (cc *ClusterConfig) autoCacheTypes() (cts []proto.CacheType) {
+	nt := cc.nodeCacheType()
+	for _, ct := range []proto.CacheType{proto.CacheTypeMemcache, proto.CacheTypeMemcacheBinary, proto.CacheTypeRedis} {
+		if canTranslate(ct, nt) {
+			cts = append(cts, ct)
+		}
+	}
+	return
+}
+
+// clientCacheTypes returns the client protocols served by cc, the ones accepted by auto cache type or
+// cache type itself.
+func (cc *ClusterConfig) clientCacheTypes() []proto.CacheType {
+	switch cc.CacheType {
+	case proto.CacheTypeAuto:
+		return cc.autoCacheTypes()
+	case proto.CacheTypeMemcache, proto.CacheTypeMemcacheBinary, proto.CacheTypeRedis:
+		return []proto.CacheType{cc.CacheType}
+	}
 	return nil
 }
 
+// clientConfigs returns one config for each client protocol of cc, which shares the servers and node
+// protocol of cc, so the node conns of each protocol are made by them.
+func (cc *ClusterConfig) clientConfigs() (ccs []*ClusterConfig) {
+	if cc.CacheType != proto.CacheTypeAuto {
+		return []*ClusterConfig{cc}
+	}
+	nt := cc.nodeCacheType()
+	for _, ct := range cc.autoCacheTypes() {
+		ncc := *cc
+		ncc.CacheType = ct
+		ncc.NodeCacheType = nt
+		ccs = append(ccs, &ncc)
+	}
+	return
+}
+
+// canTranslate reports whether the client protocol can be served by nodes speaking node protocol.
+func canTranslate(ct, nt proto.CacheType) bool {
+	switch {
+	case ct == nt:
+		return ct != proto.CacheTypeAuto
+	case isMemcacheType(ct) && isMemcacheType(nt):
+		return true
+	case ct == proto.CacheTypeMemcache && nt == proto.CacheTypeRedis:
+		return true
+	}
+	return false
+}
+
+func isMemcacheType(ct proto.CacheType) bool {
+	return ct == proto.CacheTypeMemcache || ct == proto.CacheTypeMemcacheBinary
+}
+
 // ClusterConfigs cluster configs.
 type ClusterConfigs struct {
 	Clusters []*ClusterConfig
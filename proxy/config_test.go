@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterConfigValidateNodeCacheType(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, proto.CacheTypeMemcache, cc.nodeCacheType())
+
+	cc.NodeCacheType = proto.CacheTypeMemcacheBinary
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, proto.CacheTypeMemcacheBinary, cc.nodeCacheType())
+
+	cc.NodeCacheType = proto.CacheTypeRedis
+	assert.NoError(t, cc.Validate())
+
+	cc.CacheType = proto.CacheTypeMemcacheBinary
+	assert.Equal(t, ErrConfigTranslate, errors.Cause(cc.Validate()))
+
+	cc = &ClusterConfig{Name: "redis", CacheType: proto.CacheTypeRedis, NodeCacheType: proto.CacheTypeMemcache}
+	assert.Equal(t, ErrConfigTranslate, errors.Cause(cc.Validate()))
+
+	cc = &ClusterConfig{Name: "auto", CacheType: proto.CacheTypeAuto}
+	assert.Equal(t, ErrConfigTranslate, errors.Cause(cc.Validate()))
+
+	cc.NodeCacheType = proto.CacheTypeRedis
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, []proto.CacheType{proto.CacheTypeMemcache, proto.CacheTypeRedis}, cc.clientCacheTypes())
+	ccs := cc.clientConfigs()
+	if assert.Len(t, ccs, 2) {
+		assert.Equal(t, proto.CacheTypeMemcache, ccs[0].CacheType)
+		assert.Equal(t, proto.CacheTypeRedis, ccs[1].CacheType)
+		assert.Equal(t, proto.CacheTypeRedis, ccs[0].NodeCacheType)
+	}
+}
+
+func TestClusterConfigValidateCrossNodeMode(t *testing.T) {
+	cc := &ClusterConfig{Name: "redis", CacheType: proto.CacheTypeRedis}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, defaultCrossNodeMaxSize, cc.crossNodeMaxSize())
+
+	cc.CrossNodeMode = CrossNodeBestEffort
+	cc.CrossNodeMaxSize = 1024
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, 1024, cc.crossNodeMaxSize())
+
+	cc.Servers = []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1"}
+	cc.Replicas = 2
+	assert.Equal(t, ErrConfigCrossNodeMode, errors.Cause(cc.Validate()))
+	cc.Replicas = 1
+	cc.Broadcast = &BroadcastConfig{Prefixes: []string{"flag:"}}
+	assert.Equal(t, ErrConfigCrossNodeMode, errors.Cause(cc.Validate()))
+	cc.Broadcast = nil
+
+	cc.CrossNodeMode = "atomic"
+	assert.Equal(t, ErrConfigCrossNodeMode, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateRoutes(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, ReadTimeout: 100, NodeConnections: 2}
+	cc.Routes = []*RouteConfig{
+		{Name: "user", Prefix: "user:*", ReadTimeout: 50, Servers: []string{"127.0.0.1:11211:1"}},
+		{Name: "feed", Regex: "^feed:[0-9]+$", Servers: []string{"127.0.0.1:11212:1"}},
+	}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, "user:", cc.Routes[0].prefix())
+	rcc := cc.Routes[0].clusterConfig(cc)
+	assert.Equal(t, 50, rcc.ReadTimeout)
+	assert.Equal(t, int32(2), rcc.NodeConnections)
+	assert.Equal(t, []string{"127.0.0.1:11211:1"}, rcc.Servers)
+	assert.Nil(t, rcc.Routes)
+
+	cc.Routes[1].Regex = "feed:(["
+	assert.Equal(t, ErrConfigRoute, errors.Cause(cc.Validate()))
+	cc.Routes[1].Prefix = "feed:"
+	cc.Routes[1].Regex = ""
+	assert.NoError(t, cc.Validate())
+	cc.Routes[1].Regex = "feed:"
+	assert.Equal(t, ErrConfigRoute, errors.Cause(cc.Validate()))
+	cc.Routes[1] = &RouteConfig{Name: "empty", Prefix: "feed:"}
+	assert.Equal(t, ErrConfigRoute, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateFailover(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Failover: &FailoverConfig{Mode: FailoverNextNode}}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, defaultFailoverRetries, cc.Failover.retries())
+
+	cc.Failover.WritePolicy = "retry"
+	assert.Equal(t, ErrConfigFailover, errors.Cause(cc.Validate()))
+	cc.Failover = &FailoverConfig{Mode: FailoverPool, WritePolicy: FailoverWriteDual}
+	assert.Equal(t, ErrConfigFailover, errors.Cause(cc.Validate()))
+	cc.Failover.Servers = []string{"127.0.0.1:11212:1"}
+	assert.NoError(t, cc.Validate())
+
+	rc := &RouteConfig{Name: "user", Prefix: "user:", Servers: []string{"127.0.0.1:11213:1"}}
+	cc.Routes = []*RouteConfig{rc}
+	assert.Equal(t, cc.Failover, rc.clusterConfig(cc).Failover)
+	rc.Failover = &FailoverConfig{Mode: "random"}
+	assert.Equal(t, ErrConfigFailover, errors.Cause(cc.Validate()))
+	rc.Failover.Mode = FailoverNextNode
+	assert.Equal(t, rc.Failover, rc.clusterConfig(cc).Failover)
+	assert.Nil(t, cc.Failover.clusterConfig(cc).Failover)
+}
+
+func TestClusterConfigValidateWarmUp(t *testing.T) {
+	wc := &WarmUpConfig{Nodes: []string{"127.0.0.1:11212"}, Servers: []string{"127.0.0.1:11311:1"}, Duration: 600}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"}, WarmUp: wc}
+	assert.NoError(t, cc.Validate())
+	assert.Nil(t, wc.clusterConfig(cc).WarmUp)
+
+	wc.Nodes = []string{"127.0.0.1:11213"}
+	assert.Equal(t, ErrConfigWarmUp, errors.Cause(cc.Validate()))
+	cc.Routes = []*RouteConfig{{Name: "user", Prefix: "user:", Servers: []string{"127.0.0.1:11213:1"}}}
+	assert.NoError(t, cc.Validate())
+
+	wc.Duration = 0
+	assert.Equal(t, ErrConfigWarmUp, errors.Cause(cc.Validate()))
+	wc.HitRate = 0.9
+	assert.NoError(t, cc.Validate())
+	wc.HitRate = 1.5
+	assert.Equal(t, ErrConfigWarmUp, errors.Cause(cc.Validate()))
+
+	wc.HitRate = 0.9
+	cc.NodeCacheType = proto.CacheTypeRedis
+	assert.Equal(t, ErrConfigWarmUp, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateShadow(t *testing.T) {
+	sc := &ShadowConfig{Servers: []string{"127.0.0.1:11212:1"}, Percent: 10}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, Shadow: sc}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, defaultShadowQueueSize, sc.queueSize())
+	scc := sc.clusterConfig(cc)
+	assert.Nil(t, scc.Shadow)
+	assert.Equal(t, sc.Servers, scc.Servers)
+
+	sc.Percent = 0
+	assert.Equal(t, ErrConfigShadow, errors.Cause(cc.Validate()))
+	sc.Percent = 101
+	assert.Equal(t, ErrConfigShadow, errors.Cause(cc.Validate()))
+	cc.Shadow = &ShadowConfig{Percent: 100}
+	assert.Equal(t, ErrConfigShadow, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateCanary(t *testing.T) {
+	ca := &CanaryConfig{Servers: []string{"127.0.0.1:11212:1"}, Percent: 5}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, Canary: ca}
+	assert.NoError(t, cc.Validate())
+	cac := ca.clusterConfig(cc)
+	assert.Nil(t, cac.Canary)
+	assert.Equal(t, ca.Servers, cac.Servers)
+
+	ca.Percent = 0
+	assert.NoError(t, cc.Validate())
+	ca.Percent = 101
+	assert.Equal(t, ErrConfigCanary, errors.Cause(cc.Validate()))
+	cc.Canary = &CanaryConfig{Percent: 5}
+	assert.Equal(t, ErrConfigCanary, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateMigration(t *testing.T) {
+	mc := &MigrationConfig{Phase: MigrationDualWrite, Servers: []string{"127.0.0.1:11212:1"}}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, Migration: mc}
+	assert.NoError(t, cc.Validate())
+	mcc := mc.clusterConfig(cc)
+	assert.Nil(t, mcc.Migration)
+	assert.Equal(t, mc.Servers, mcc.Servers)
+
+	mc.Phase = ""
+	assert.Equal(t, ErrConfigMigration, errors.Cause(cc.Validate()))
+	cc.Migration = &MigrationConfig{Phase: MigrationNewOnly}
+	assert.Equal(t, ErrConfigMigration, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateReshard(t *testing.T) {
+	rc := &ReshardConfig{Nodes: []string{"127.0.0.1:6380"}}
+	cc := &ClusterConfig{Name: "redis", CacheType: proto.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1"}, Reshard: rc}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, defaultReshardScanCount, rc.scanCount())
+
+	rc.Nodes = []string{"127.0.0.1:6381"}
+	assert.Equal(t, ErrConfigReshard, errors.Cause(cc.Validate()))
+	rc.Nodes = []string{"127.0.0.1:6379", "127.0.0.1:6380"}
+	assert.Equal(t, ErrConfigReshard, errors.Cause(cc.Validate()))
+	rc.Nodes = []string{"127.0.0.1:6380"}
+	cc.CacheType = proto.CacheTypeMemcache
+	assert.Equal(t, ErrConfigReshard, errors.Cause(cc.Validate()))
+	cc.CacheType = proto.CacheTypeRedis
+	cc.Migration = &MigrationConfig{Phase: MigrationDualWrite, Servers: []string{"127.0.0.1:6381:1"}}
+	assert.Equal(t, ErrConfigReshard, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateReplicas(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"}}
+	for _, n := range []int{0, 1, 2} {
+		cc.Replicas = n
+		assert.NoError(t, cc.Validate())
+	}
+	assert.Equal(t, 1, (&ClusterConfig{}).replicas())
+	for _, n := range []int{-1, 3} {
+		cc.Replicas = n
+		assert.Equal(t, ErrConfigReplicas, errors.Cause(cc.Validate()))
+	}
+}
+
+func TestClusterConfigValidateEjectMode(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}}
+	for _, mode := range []string{"", EjectRebuild, EjectDoubleHash} {
+		cc.PingEjectMode = mode
+		assert.NoError(t, cc.Validate())
+	}
+	cc.PingEjectMode = "remove"
+	assert.Equal(t, ErrConfigEjectMode, errors.Cause(cc.Validate()))
+	cc.Servers = append(cc.Servers, "127.0.0.1:11212:1")
+	cc.Replicas = 2
+	cc.PingEjectMode = EjectRebuild
+	assert.NoError(t, cc.Validate())
+	cc.PingEjectMode = EjectDoubleHash
+	assert.Equal(t, ErrConfigEjectMode, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateBroadcast(t *testing.T) {
+	bc := &BroadcastConfig{Prefixes: []string{"flag:"}}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"}, Broadcast: bc}
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, 2, bc.quorum(2))
+	bc.Quorum = 1
+	assert.NoError(t, cc.Validate())
+	assert.Equal(t, 1, bc.quorum(2))
+
+	bc.Quorum = 3
+	assert.Equal(t, ErrConfigBroadcast, errors.Cause(cc.Validate()))
+	bc.Quorum, bc.Prefixes = 0, nil
+	assert.Equal(t, ErrConfigBroadcast, errors.Cause(cc.Validate()))
+	bc.Commands = []string{"set"}
+	assert.NoError(t, cc.Validate())
+}
+
+func TestClusterConfigValidateQuorumRead(t *testing.T) {
+	qc := &QuorumReadConfig{Reads: 2, VersionField: "version"}
+	cc := &ClusterConfig{Name: "redis", CacheType: proto.CacheTypeRedis, Replicas: 2, Servers: []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1"}, QuorumRead: qc}
+	assert.NoError(t, cc.Validate())
+	for _, n := range []int{1, 3} {
+		qc.Reads = n
+		assert.Equal(t, ErrConfigQuorumRead, errors.Cause(cc.Validate()))
+	}
+	qc.Reads = 2
+	qc.VersionField = ""
+	assert.Equal(t, ErrConfigQuorumRead, errors.Cause(cc.Validate()))
+	// NOTE: the cas unique of memcache is counted by each node, so the copies of replicas are not ordered.
+	qc.VersionField = "version"
+	for _, typ := range []proto.CacheType{proto.CacheTypeMemcache, proto.CacheTypeMemcacheBinary} {
+		cc.CacheType = typ
+		assert.Equal(t, ErrConfigQuorumRead, errors.Cause(cc.Validate()))
+	}
+}
+
+func TestClusterConfigValidateHedge(t *testing.T) {
+	hc := &HedgeConfig{Percentile: 95, MinDelay: 5, MaxDelay: 50}
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"}, Hedge: hc}
+	assert.NoError(t, cc.Validate())
+	hc.Percentile = 101
+	assert.Equal(t, ErrConfigHedge, errors.Cause(cc.Validate()))
+	hc.Percentile = 95
+	hc.MinDelay = 60
+	assert.Equal(t, ErrConfigHedge, errors.Cause(cc.Validate()))
+	hc.MinDelay = 5
+	cc.Replicas = 2
+	assert.Equal(t, ErrConfigHedge, errors.Cause(cc.Validate()))
+	cc.Replicas = 0
+	cc.Failover = &FailoverConfig{Mode: FailoverNextNode}
+	assert.Equal(t, ErrConfigHedge, errors.Cause(cc.Validate()))
+	cc.Failover = nil
+	cc.WarmUp = &WarmUpConfig{Nodes: []string{"127.0.0.1:11212"}, Servers: []string{"127.0.0.1:11311:1"}, Duration: 600}
+	assert.Equal(t, ErrConfigHedge, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateHashTagMode(t *testing.T) {
+	cc := &ClusterConfig{Name: "redis", CacheType: proto.CacheTypeRedis, Servers: []string{"127.0.0.1:6379:1"}, HashTagMode: HashTagRedisCluster}
+	assert.NoError(t, cc.Validate())
+	cc.HashTag = "{}"
+	assert.NoError(t, cc.Validate())
+	cc.HashTag = "[]"
+	assert.Equal(t, ErrConfigHashTag, errors.Cause(cc.Validate()))
+	cc.HashTagMode = HashTagSimple
+	assert.NoError(t, cc.Validate())
+	cc.HashTagMode = "unknown"
+	assert.Equal(t, ErrConfigHashTag, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidateDistribution(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}}
+	for _, des := range []string{"", "ketama", "jump", "rendezvous", "maglev"} {
+		cc.HashDistribution = des
+		assert.NoError(t, cc.Validate())
+	}
+	cc.HashDistribution = "modula"
+	assert.Equal(t, ErrConfigDistribution, errors.Cause(cc.Validate()))
+}
+
+func TestClusterConfigValidatePoints(t *testing.T) {
+	cc := &ClusterConfig{Name: "mc", CacheType: proto.CacheTypeMemcache, Servers: []string{"127.0.0.1:11211:1"}, PointsPerServer: 1000}
+	assert.NoError(t, cc.Validate())
+	for _, n := range []int{-1, 2, 3, 162} {
+		cc.PointsPerServer = n
+		assert.Equal(t, ErrConfigPoints, errors.Cause(cc.Validate()))
+	}
+	cc.PointsPerServer = 4
+	assert.NoError(t, cc.Validate())
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bytes"
+	errs "errors"
+	"net"
+	"strconv"
+	"time"
+
+	"overlord/proto"
+	"overlord/proto/memcache"
+)
+
+const (
+	// detectMaxBytes is the max bytes peeked before the first command name ends.
+	detectMaxBytes = 64
+
+	detectMagicBinary = 0x80
+	detectMagicRESP   = '*'
+)
+
+// detectTextCommands are the commands of memcache text protocol which are not requests of keys.
+var detectTextCommands = [][]byte{[]byte("version"), []byte("stats"), []byte("flush_all"), []byte("quit"), []byte("verbosity")}
+
+// detectShapes are the count of integer arguments after key of memcache text commands whose lowercase
+// names are redis commands too, e.g. "set a 0 0 1" is memcache while "set a 1" is redis inline.
+var detectShapes = map[string]int{"set": 3, "append": 3, "incr": 1, "decr": 1, "touch": 1}
+
+// detect errors
+var (
+	ErrDetectCacheType = errs.New("can not detect protocol of client conn")
+)
+
+// peekConn replays the peeked bytes before reading from conn.
+type peekConn struct {
+	net.Conn
+	peeked []byte
+}
+
+func (c *peekConn) Read(b []byte) (n int, err error) {
+	if len(c.peeked) > 0 {
+		n = copy(b, c.peeked)
+		c.peeked = c.peeked[n:]
+		return
+	}
+	return c.Conn.Read(b)
+}
+
+// detectConn peeks the first bytes of client conn and returns the protocol it speaks:
+// 0x80 is memcache binary, '*' is redis, and the first line decides between memcache
+// text and redis inline command. The command names of memcache text protocol are
+// lowercase, so "get a" is memcache text while "GET a" is redis, and the lowercase names
+// of both are decided by the arguments, see detectShapes.
+//
+// NOTE: "get a" of one key is always memcache text, redis inline clients must use uppercase.
+func detectConn(conn net.Conn, timeout time.Duration) (ct proto.CacheType, pc net.Conn, err error) {
+	if timeout > 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	var (
+		buf = make([]byte, detectMaxBytes)
+		n   int
+	)
+	for ct == "" {
+		var rn int
+		if rn, err = conn.Read(buf[n:]); err != nil {
+			return
+		}
+		n += rn
+		ct = detectCacheType(buf[:n])
+	}
+	if ct == proto.CacheTypeUnknown {
+		err = ErrDetectCacheType
+		return
+	}
+	pc = &peekConn{Conn: conn, peeked: buf[:n]}
+	return
+}
+
+// detectCacheType returns the protocol of first bytes, empty means more bytes are needed.
+func detectCacheType(bs []byte) proto.CacheType {
+	if len(bs) == 0 {
+		return ""
+	}
+	switch bs[0] {
+	case detectMagicBinary:
+		return proto.CacheTypeMemcacheBinary
+	case detectMagicRESP:
+		return proto.CacheTypeRedis
+	}
+	end := bytes.IndexAny(bs, " \r\n")
+	if end == -1 {
+		if len(bs) >= detectMaxBytes {
+			return proto.CacheTypeUnknown
+		}
+		return ""
+	}
+	if memcache.IsCommand(bs[:end]) {
+		n, ok := detectShapes[string(bs[:end])]
+		if !ok {
+			return proto.CacheTypeMemcache
+		}
+		eol := bytes.IndexByte(bs, '\n')
+		if eol == -1 {
+			// NOTE: the line longer than peeked is decided by name.
+			if len(bs) >= detectMaxBytes {
+				return proto.CacheTypeMemcache
+			}
+			return ""
+		}
+		if detectShape(bytes.Fields(bs[end:eol]), n) {
+			return proto.CacheTypeMemcache
+		}
+		return proto.CacheTypeRedis
+	}
+	for _, cmd := range detectTextCommands {
+		if bytes.Equal(cmd, bs[:end]) {
+			return proto.CacheTypeMemcache
+		}
+	}
+	return proto.CacheTypeRedis
+}
+
+// detectShape reports whether args are key, n integers and an optional noreply like memcache text command.
+func detectShape(args [][]byte, n int) bool {
+	switch len(args) {
+	case n + 1:
+	case n + 2:
+		if !bytes.Equal(args[n+1], []byte("noreply")) {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, arg := range args[1 : n+1] {
+		if _, err := strconv.ParseInt(string(arg), 10, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
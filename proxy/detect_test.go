@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	libnet "overlord/lib/net"
+	"overlord/proto"
+	mcbin "overlord/proto/memcache/binary"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectCacheType(t *testing.T) {
+	ts := []struct {
+		Name   string
+		Data   string
+		Except proto.CacheType
+	}{
+		{Name: "Empty", Data: "", Except: ""},
+		{Name: "Binary", Data: "\x80\x00", Except: proto.CacheTypeMemcacheBinary},
+		{Name: "RESP", Data: "*2\r\n", Except: proto.CacheTypeRedis},
+		{Name: "Text", Data: "get a\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextMeta", Data: "mn\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextPartial", Data: "gets", Except: ""},
+		{Name: "TextVersion", Data: "version\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextStats", Data: "stats items\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextFlushAll", Data: "flush_all\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextQuit", Data: "quit\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "InlineQuit", Data: "QUIT\r\n", Except: proto.CacheTypeRedis},
+		{Name: "Inline", Data: "PING\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineUpper", Data: "GET a\r\n", Except: proto.CacheTypeRedis},
+		{Name: "TextSet", Data: "set a 0 0 1\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextSetNoreply", Data: "set a 0 -1 1 noreply\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextSetPartial", Data: "set a 0 0", Except: ""},
+		{Name: "TextIncr", Data: "incr a 1\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextTouch", Data: "touch a 10\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "TextDelete", Data: "delete a\r\n", Except: proto.CacheTypeMemcache},
+		{Name: "InlineLowerSet", Data: "set a 1\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineLowerSetEx", Data: "set a 1 ex 10\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineLowerAppend", Data: "append a b\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineLowerIncr", Data: "incr a\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineLowerDecr", Data: "decr a\r\n", Except: proto.CacheTypeRedis},
+		{Name: "InlineLowerTouch", Data: "touch a b\r\n", Except: proto.CacheTypeRedis},
+		{Name: "TooLong", Data: string(make([]byte, detectMaxBytes)), Except: proto.CacheTypeUnknown},
+	}
+	for _, tt := range ts {
+		t.Run(tt.Name, func(t *testing.T) {
+			assert.Equal(t, tt.Except, detectCacheType([]byte(tt.Data)))
+		})
+	}
+}
+
+func TestDetectConnReplay(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		_, _ = client.Write([]byte("ge"))
+		_, _ = client.Write([]byte("t a b\r\n"))
+		_ = client.Close()
+	}()
+	ct, pc, err := detectConn(server, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, proto.CacheTypeMemcache, ct)
+	bs, err := ioutil.ReadAll(pc)
+	assert.NoError(t, err)
+	assert.Equal(t, "get a b\r\n", string(bs))
+}
+
+func TestClusterAutoProtocols(t *testing.T) {
+	l, _ := _serveMemcache(t, map[string]string{})
+	defer l.Close()
+	cc := &ClusterConfig{
+		Name:             "auto",
+		CacheType:        proto.CacheTypeAuto,
+		NodeCacheType:    proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{l.Addr().String() + ":1 mc1"},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	// NOTE: one ring serves all protocols, and each protocol owns its node conns.
+	assert.Len(t, c.nodeChan[0].chs, 2)
+
+	round := func(msgs []*proto.Message) {
+		mbs := proto.GetMsgBatchs(len(c.nodeChan))
+		c.DispatchBatch(mbs, msgs)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+		proto.PutMsgBatchs(mbs)
+	}
+	msgs := _createMemcacheMsgs(t, "set k 0 0 1\r\nx\r\n", 1)
+	round(msgs)
+	assert.NoError(t, msgs[0].Err())
+	proto.PutMsgs(msgs)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	// NOTE: the binary get of key k.
+	go client.Write([]byte("\x80\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00k"))
+	msgs, err := mcbin.NewProxyConn(libnet.NewConn(server, 0, 0)).Decode(proto.GetMsgs(1))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+	round(msgs)
+	assert.NoError(t, msgs[0].Err())
+	assert.False(t, msgs[0].Request().IsMiss())
+	assert.Contains(t, msgs[0].Request().(*mcbin.MCRequest).String(), "x")
+	proto.PutMsgs(msgs)
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	errs "errors"
+	"sort"
+
+	"overlord/proto"
+	"overlord/proto/redis"
+)
+
+// emulate errors
+var (
+	ErrCrossNode = errs.New("ERR keys of command span nodes, cross_node_mode is reject")
+)
+
+// isCross reports whether the msg is a redis command whose keys span nodes. With replicas or dual
+// writes, the keys span nodes unless they share all the nodes written, since the command is copied to the
+// nodes of its first key only.
+func (c *Cluster) isCross(m *proto.Message) bool {
+	if m.Type != proto.CacheTypeRedis {
+		return false
+	}
+	req, ok := m.Request().(*redis.Request)
+	if !ok {
+		return false
+	}
+	keys := req.CrossKeys()
+	if len(keys) < 2 {
+		return false
+	}
+	first := c.writeIndexes(keys[0])
+	if first == nil {
+		return false
+	}
+	for _, key := range keys[1:] {
+		if idxs := c.writeIndexes(key); idxs != nil && !sameIndexes(idxs, first) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeIndexes returns the sorted indexes of nodes which the write of key is sent to, nil means no node.
+func (c *Cluster) writeIndexes(key []byte) []int {
+	idx := c.calculateBatchIndex(key)
+	if idx == -1 {
+		return nil
+	}
+	p := c.route(key)
+	idxs := append([]int{idx}, c.replicaIndexes(p, key)...)
+	if dual := c.dualIndex(p, key); dual != -1 && !containsIndex(idxs, dual) {
+		idxs = append(idxs, dual)
+	}
+	sort.Ints(idxs)
+	return idxs
+}
+
+func sameIndexes(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// nextRound returns msgs before the first cross node msg, or the cross node msg itself
+// when it is the first, so the msgs are executed in order of client.
+func (c *Cluster) nextRound(msgs []*proto.Message) (round, rest []*proto.Message, cross bool) {
+	for i, m := range msgs {
+		if !c.isCross(m) {
+			continue
+		}
+		if i == 0 {
+			return msgs[:1], msgs[1:], true
+		}
+		return msgs[:i], msgs[i:], false
+	}
+	return msgs, nil, false
+}
+
+// emulate evaluates the cross node msg by proxy, see redis.Emulate. The rounds of emulation are
+// migrated and hedged as the msgs of client.
+func (c *Cluster) emulate(m *proto.Message) {
+	if c.cc.CrossNodeMode != CrossNodeBestEffort {
+		m.DoneWithError(ErrCrossNode)
+		return
+	}
+	var (
+		msgs []*proto.Message
+		mbss [][]*proto.MsgBatch
+		hrs  []*hedgeRound
+		mrs  []*migrateRound
+	)
+	defer func() {
+		// NOTE: replies are referenced by emulator until it returns.
+		for _, hr := range hrs {
+			c.hedgeRelease(hr)
+		}
+		for _, mr := range mrs {
+			c.migrateRelease(mr)
+		}
+		proto.PutMsgs(msgs)
+		for _, mbs := range mbss {
+			proto.PutMsgBatchs(mbs)
+		}
+	}()
+	exec := func(reqs ...*redis.Request) error {
+		mbs := proto.GetMsgBatchs(len(c.nodeChan))
+		mbss = append(mbss, mbs)
+		round := make([]*proto.Message, len(reqs))
+		for i, req := range reqs {
+			round[i] = proto.NewMessage()
+			round[i].Type = proto.CacheTypeRedis
+			round[i].WithRequest(req)
+		}
+		msgs = append(msgs, round...)
+		mr := c.migrateDispatch(round)
+		mrs = append(mrs, mr)
+		c.DispatchBatch(mbs, round)
+		hr := c.hedgeDispatch(round)
+		hrs = append(hrs, hr)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+		c.hedgeDone(hr)
+		c.migrateDone(mr)
+		for _, rm := range round {
+			if err := rm.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	req := m.Request().(*redis.Request)
+	m.MarkWrite()
+	if err := redis.Emulate(req, exec, c.cc.crossNodeMaxSize()); err != nil {
+		m.DoneWithError(err)
+	}
+	m.MarkRead()
+}
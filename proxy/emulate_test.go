@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	libnet "overlord/lib/net"
+	"overlord/proto"
+	"overlord/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createRedisMsgs(t *testing.T, cmds string, n int) []*proto.Message {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte(cmds))
+	msgs, err := redis.NewProxyConn(libnet.NewConn(server, 0, 0)).Decode(proto.GetMsgs(n))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, n)
+	return msgs
+}
+
+func TestClusterSplitRoundsByCross(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		HashTag:          "{}",
+		Servers:          []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1"},
+	})
+	// NOTE: find the key on the other node of key a.
+	na := c.calculateBatchIndex([]byte("a"))
+	other := ""
+	for i := 0; other == ""; i++ {
+		if idx := c.calculateBatchIndex([]byte(fmt.Sprint(i))); idx != na {
+			other = fmt.Sprint(i)
+		}
+	}
+	msgs := _createRedisMsgs(t, "SET a 1\r\nRENAME a {a}b\r\nGET a\r\nRENAME a "+other+"\r\nGET b\r\n", 5)
+	assert.False(t, c.isCross(msgs[1]))
+	assert.True(t, c.isCross(msgs[3]))
+
+	round, rest, cross := c.nextRound(msgs)
+	assert.Len(t, round, 3)
+	assert.False(t, cross)
+	round, rest, cross = c.nextRound(rest)
+	assert.Len(t, round, 1)
+	assert.True(t, cross)
+	round, rest, cross = c.nextRound(rest)
+	assert.Len(t, round, 1)
+	assert.Len(t, rest, 0)
+	assert.False(t, cross)
+
+	c.emulate(msgs[3])
+	assert.Equal(t, ErrCrossNode, msgs[3].Err())
+}
+
+func TestClusterCrossByReplicas(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Replicas:         2,
+		Servers:          []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1", "127.0.0.1:6381:1"},
+	})
+	// NOTE: find the keys on the node of key a, whose replicas are the same as or differ from key a.
+	first := c.writeIndexes([]byte("a"))
+	same, diff := "", ""
+	for i := 0; same == "" || diff == ""; i++ {
+		key := fmt.Sprint(i)
+		idxs := c.writeIndexes([]byte(key))
+		if c.calculateBatchIndex([]byte(key)) != c.calculateBatchIndex([]byte("a")) {
+			continue
+		}
+		if sameIndexes(idxs, first) {
+			same = key
+		} else {
+			diff = key
+		}
+	}
+	msgs := _createRedisMsgs(t, "RENAME a "+same+"\r\nRENAME a "+diff+"\r\n", 2)
+	assert.False(t, c.isCross(msgs[0]))
+	assert.True(t, c.isCross(msgs[1]))
+	proto.PutMsgs(msgs)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+)
+
+// failoverIndex returns the index of node to retry key on the nth failover, -1 means no more node.
+func (c *Cluster) failoverIndex(key []byte, n int) int {
+	p := c.route(key)
+	fc := p.cc.Failover
+	hk := c.hashKey(key)
+	if fc == nil {
+		// NOTE: only reads are retried without failover, they are retried on the other replicas.
+		if n < p.cc.replicas() {
+			if nodes := p.ring.GetNodes(hk, n+1); len(nodes) > n {
+				return p.nodeMap[nodes[n]]
+			}
+		}
+		return -1
+	}
+	if n > fc.retries() {
+		return -1
+	}
+	switch fc.Mode {
+	case FailoverNextNode:
+		// NOTE: the first node is the failed one.
+		if nodes := p.ring.GetNodes(hk, n+1); len(nodes) > n {
+			return p.nodeMap[nodes[n]]
+		}
+	case FailoverPool:
+		if nodes := p.failover.ring.GetNodes(hk, n); len(nodes) >= n {
+			return p.failover.nodeMap[nodes[n-1]]
+		}
+	}
+	return -1
+}
+
+// canFailover reports whether the msg failed by node error can be retried on other nodes.
+// NOTE: the msg replied before node error is not retried, since the reply may overwrite its request.
+func (c *Cluster) canFailover(m *proto.Message) bool {
+	if m.Err() == nil || m.Replied() {
+		return false
+	}
+	req := m.Request()
+	if req.IsRead() {
+		return true
+	}
+	fc := c.route(req.Key()).cc.Failover
+	return fc != nil && fc.WritePolicy == FailoverWriteDual
+}
+
+// failover retries the msgs of mbs failed by node errors on the failover nodes until they succeed
+// or run out of retries. The nth retry is sent by fmbs[n-1], which is allocated when absent,
+// and fmbs must be reset after the msgs are encoded since the replies are read into them.
+func (c *Cluster) failover(mbs []*proto.MsgBatch, fmbs [][]*proto.MsgBatch) [][]*proto.MsgBatch {
+	for n := 1; ; n++ {
+		retried := false
+		for _, mb := range mbs {
+			for _, m := range mb.Msgs() {
+				if !c.canFailover(m) {
+					continue
+				}
+				req := m.Request()
+				idx := c.failoverIndex(req.Key(), n)
+				if idx == -1 {
+					continue
+				}
+				if len(fmbs) < n {
+					fmbs = append(fmbs, proto.GetMsgBatchs(len(c.nodeChan)))
+				}
+				if log.V(2) {
+					log.Warnf("cluster(%s) Msg(%s) failover %d times with error:%v", c.cc.Name, req.Key(), n, m.Err())
+				}
+				if prom.On {
+					prom.Failover(c.cc.Name, c.route(req.Key()).name, req.CmdString())
+				}
+				m.DoneWithError(nil)
+				fmbs[n-1][idx].AddMsg(m)
+				retried = true
+			}
+		}
+		if !retried {
+			return fmbs
+		}
+		mbs = fmbs[n-1]
+		c.deliver(mbs)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+	}
+}
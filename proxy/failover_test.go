@@ -0,0 +1,292 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	libnet "overlord/lib/net"
+	"overlord/proto"
+	"overlord/proto/memcache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _memcache is the values of keys served by _serveMemcache.
+type _memcache struct {
+	lock   sync.Mutex
+	values map[string]string
+	cas    map[string]uint64
+	// delay delays the replies of get.
+	delay time.Duration
+}
+
+func (m *_memcache) get(key string) (v string, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	v, ok = m.values[key]
+	return
+}
+
+// _serveMemcache serves get, gets, meta get, set, add, cas, incr and delete of values until listener closed, the cas of values
+// replied to meta get is 0 unless set in cas, and get is replied after delay.
+func _serveMemcache(t *testing.T, values map[string]string) (net.Listener, *_memcache) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	mc := &_memcache{values: values}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fs := strings.Fields(line)
+					switch fs[0] {
+					case "get", "gets":
+						mc.lock.Lock()
+						delay := mc.delay
+						mc.lock.Unlock()
+						time.Sleep(delay)
+						for _, key := range fs[1:] {
+							v, ok := mc.get(key)
+							if !ok {
+								continue
+							}
+							if fs[0] == "gets" {
+								mc.lock.Lock()
+								fmt.Fprintf(conn, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(v), mc.cas[key], v)
+								mc.lock.Unlock()
+							} else {
+								fmt.Fprintf(conn, "VALUE %s 0 %d\r\n%s\r\n", key, len(v), v)
+							}
+						}
+						fmt.Fprint(conn, "END\r\n")
+					case "mg":
+						if v, ok := mc.get(fs[1]); ok {
+							mc.lock.Lock()
+							cas := mc.cas[fs[1]]
+							mc.lock.Unlock()
+							fmt.Fprintf(conn, "VA %d f0 t100 c%d\r\n%s\r\n", len(v), cas, v)
+						} else {
+							fmt.Fprint(conn, "EN\r\n")
+						}
+					case "set":
+						v, _ := br.ReadString('\n')
+						mc.lock.Lock()
+						mc.values[fs[1]] = strings.TrimSuffix(v, "\r\n")
+						mc.lock.Unlock()
+						fmt.Fprint(conn, "STORED\r\n")
+					case "add":
+						v, _ := br.ReadString('\n')
+						mc.lock.Lock()
+						_, ok := mc.values[fs[1]]
+						if !ok {
+							mc.values[fs[1]] = strings.TrimSuffix(v, "\r\n")
+						}
+						mc.lock.Unlock()
+						if ok {
+							fmt.Fprint(conn, "NOT_STORED\r\n")
+						} else {
+							fmt.Fprint(conn, "STORED\r\n")
+						}
+					case "cas":
+						v, _ := br.ReadString('\n')
+						cas, _ := strconv.ParseUint(fs[5], 10, 64)
+						mc.lock.Lock()
+						_, ok := mc.values[fs[1]]
+						if ok && mc.cas[fs[1]] == cas {
+							mc.values[fs[1]] = strings.TrimSuffix(v, "\r\n")
+							fmt.Fprint(conn, "STORED\r\n")
+						} else if ok {
+							fmt.Fprint(conn, "EXISTS\r\n")
+						} else {
+							fmt.Fprint(conn, "NOT_FOUND\r\n")
+						}
+						mc.lock.Unlock()
+					case "incr":
+						delta, _ := strconv.Atoi(fs[2])
+						mc.lock.Lock()
+						if v, ok := mc.values[fs[1]]; ok {
+							n, _ := strconv.Atoi(v)
+							mc.values[fs[1]] = strconv.Itoa(n + delta)
+							fmt.Fprintf(conn, "%d\r\n", n+delta)
+						} else {
+							fmt.Fprint(conn, "NOT_FOUND\r\n")
+						}
+						mc.lock.Unlock()
+					case "delete":
+						mc.lock.Lock()
+						if _, ok := mc.values[fs[1]]; ok {
+							delete(mc.values, fs[1])
+							fmt.Fprint(conn, "DELETED\r\n")
+						} else {
+							fmt.Fprint(conn, "NOT_FOUND\r\n")
+						}
+						mc.lock.Unlock()
+					}
+				}
+			}(conn)
+		}
+	}()
+	return l, mc
+}
+
+// _deadAddr returns the addr no one listening on.
+func _deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	l.Close()
+	return l.Addr().String()
+}
+
+func _createMemcacheMsgs(t *testing.T, cmds string, n int) []*proto.Message {
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte(cmds))
+	msgs, err := memcache.NewProxyConn(libnet.NewConn(server, 0, 0)).Decode(proto.GetMsgs(n))
+	assert.NoError(t, err)
+	assert.Len(t, msgs, n)
+	return msgs
+}
+
+func TestClusterFailoverToPool(t *testing.T) {
+	l, _ := _serveMemcache(t, map[string]string{"a": "x"})
+	defer l.Close()
+	fc := &FailoverConfig{Mode: FailoverPool, Servers: []string{l.Addr().String() + ":1"}}
+	cc := &ClusterConfig{
+		Name:             "failover",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{_deadAddr(t) + ":1"},
+		Failover:         fc,
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	assert.Len(t, c.nodeChan, 2)
+
+	ts := []struct {
+		Policy   string
+		WriteErr bool
+	}{
+		{Policy: FailoverWriteFail, WriteErr: true},
+		{Policy: FailoverWriteDual, WriteErr: false},
+	}
+	for _, tt := range ts {
+		fc.WritePolicy = tt.Policy
+		msgs := _createMemcacheMsgs(t, "get a\r\nset b 0 0 1\r\nx\r\n", 2)
+		mbs := proto.GetMsgBatchs(len(c.nodeChan))
+		c.DispatchBatch(mbs, msgs)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+		assert.Error(t, msgs[0].Err())
+		fmbs := c.failover(mbs, nil)
+		assert.Len(t, fmbs, 1)
+		assert.NoError(t, msgs[0].Err(), tt.Policy)
+		assert.Equal(t, tt.WriteErr, msgs[1].Err() != nil, tt.Policy)
+	}
+}
+
+func TestClusterFailoverIndexNextNode(t *testing.T) {
+	cc := &ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Servers:          []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1", "127.0.0.1:11213:1"},
+		Failover:         &FailoverConfig{Mode: FailoverNextNode, Retries: 2},
+	}
+	c := _createCluster(cc)
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint(i))
+		idx := []int{c.calculateBatchIndex(key), c.failoverIndex(key, 1), c.failoverIndex(key, 2)}
+		assert.NotEqual(t, idx[0], idx[1])
+		assert.NotEqual(t, idx[0], idx[2])
+		assert.NotEqual(t, idx[1], idx[2])
+		assert.Equal(t, -1, c.failoverIndex(key, 3))
+	}
+	cc.Failover = nil
+	assert.Equal(t, -1, c.failoverIndex([]byte("a"), 1))
+}
+
+func TestClusterFailoverSkipReplied(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Servers:          []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"},
+		Failover:         &FailoverConfig{Mode: FailoverNextNode},
+	})
+	msgs := _createMemcacheMsgs(t, "get a\r\nget b\r\n", 2)
+	for _, m := range msgs {
+		m.DoneWithError(ErrNotAvaiableNode)
+	}
+	msgs[1].MarkRead()
+	assert.True(t, c.canFailover(msgs[0]))
+	assert.False(t, c.canFailover(msgs[1]))
+}
+
+func TestClusterFailoverDualWrite(t *testing.T) {
+	l1, mc1 := _serveMemcache(t, map[string]string{})
+	defer l1.Close()
+	l2, mc2 := _serveMemcache(t, map[string]string{})
+	defer l2.Close()
+	fc := &FailoverConfig{Mode: FailoverPool, WritePolicy: FailoverWriteDual, Servers: []string{l2.Addr().String() + ":1"}}
+	cc := &ClusterConfig{
+		Name:             "failover",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{l1.Addr().String() + ":1"},
+		Failover:         fc,
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	msgs := _createMemcacheMsgs(t, "set a 0 0 1\r\nx\r\n", 1)
+	rr := c.replicate(msgs)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.replicaDone(rr)
+	assert.NoError(t, msgs[0].Err())
+	// NOTE: the successful write is applied on the failover node too.
+	v, ok := mc1.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "x", v)
+	v, ok = mc2.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "x", v)
+
+	fc.WritePolicy = FailoverWriteFail
+	msgs = _createMemcacheMsgs(t, "set b 0 0 1\r\nx\r\n", 1)
+	assert.Nil(t, c.replicate(msgs))
+}
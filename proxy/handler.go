@@ -41,6 +41,8 @@ type Handler struct {
 
 	cluster *Cluster
 	msgCh   *proto.MsgChan
+	// fmbatch is the msg batchs of each failover retry.
+	fmbatch [][]*proto.MsgBatch
 
 	closed int32
 	err    error
@@ -48,6 +50,11 @@ type Handler struct {
 
 // NewHandler new a conn handler.
 func NewHandler(ctx context.Context, c *Config, conn net.Conn, cluster *Cluster) (h *Handler) {
+	return newHandler(ctx, c, conn, cluster, cluster.cc.CacheType)
+}
+
+// newHandler new a conn handler of client protocol ct, which is one of the protocols of auto cluster.
+func newHandler(ctx context.Context, c *Config, conn net.Conn, cluster *Cluster, ct proto.CacheType) (h *Handler) {
 	h = &Handler{
 		c:       c,
 		cluster: cluster,
@@ -55,7 +62,7 @@ func NewHandler(ctx context.Context, c *Config, conn net.Conn, cluster *Cluster)
 	h.ctx, h.cancel = context.WithCancel(ctx)
 	h.conn = libnet.NewConn(conn, time.Second*time.Duration(h.c.Proxy.ReadTimeout), time.Second*time.Duration(h.c.Proxy.WriteTimeout))
 	// cache type
-	switch cluster.cc.CacheType {
+	switch ct {
 	case proto.CacheTypeMemcache:
 		h.pc = memcache.NewProxyConn(h.conn)
 	case proto.CacheTypeMemcacheBinary:
@@ -79,7 +86,7 @@ func (h *Handler) Handle() {
 func (h *Handler) handle() {
 	var (
 		messages = proto.GetMsgs(defaultConcurrent)
-		mbatch   = proto.GetMsgBatchs(len(h.cluster.nodeMap))
+		mbatch   = proto.GetMsgBatchs(len(h.cluster.nodeChan))
 		msgs     []*proto.Message
 		err      error
 	)
@@ -89,44 +96,89 @@ func (h *Handler) handle() {
 			h.deferHandle(messages, mbatch, err)
 			return
 		}
-		// 2. send to cluster
-		h.cluster.DispatchBatch(mbatch, msgs)
-		// 3. wait to done
-		for _, mb := range mbatch {
-			mb.Wait()
-		}
-		// 4. encode
-		for _, msg := range msgs {
-			if err = h.pc.Encode(msg); err != nil {
-				h.pc.Flush()
+		// 2. handle by rounds, the cross node msg is a round by itself to keep the order.
+		for rest := msgs; len(rest) > 0; {
+			var (
+				round []*proto.Message
+				cross bool
+			)
+			round, rest, cross = h.cluster.nextRound(rest)
+			if err = h.handleRound(mbatch, round, cross); err != nil {
 				h.deferHandle(messages, mbatch, err)
 				return
 			}
-			msg.MarkEnd()
-			msg.ResetSubs()
-			if prom.On {
-				prom.ProxyTime(h.cluster.cc.Name, msg.Request().CmdString(), int64(msg.TotalDur()/time.Microsecond))
-			}
 		}
-		if err = h.pc.Flush(); err != nil {
-			h.deferHandle(messages, mbatch, err)
+		// 3. reset MaxConcurrent
+		messages = h.resetMaxConcurrent(messages, len(msgs))
+	}
+}
+
+// handleRound sends msgs to cluster, waits until done and writes responses into client connection.
+func (h *Handler) handleRound(mbatch []*proto.MsgBatch, msgs []*proto.Message, cross bool) (err error) {
+	// 1. send to cluster
+	if cross {
+		h.cluster.emulate(msgs[0])
+	} else {
+		shadows := h.cluster.shadowCopy(msgs)
+		mr := h.cluster.migrateDispatch(msgs)
+		defer h.cluster.migrateRelease(mr)
+		rr := h.cluster.replicate(msgs)
+		br := h.cluster.broadcastDispatch(msgs)
+		defer h.cluster.broadcastRelease(br)
+		qr := h.cluster.quorumDispatch(msgs)
+		defer h.cluster.quorumRelease(qr)
+		h.cluster.DispatchBatch(mbatch, msgs)
+		hr := h.cluster.hedgeDispatch(msgs)
+		defer h.cluster.hedgeRelease(hr)
+		// 2. wait to done
+		for _, mb := range mbatch {
+			mb.Wait()
+		}
+		h.cluster.hedgeDone(hr)
+		h.fmbatch = h.cluster.failover(mbatch, h.fmbatch)
+		h.cluster.warmUp(mbatch)
+		h.cluster.replicaDone(rr)
+		h.cluster.broadcastDone(br, mbatch)
+		h.cluster.quorumDone(qr)
+		h.cluster.migrateDone(mr)
+		h.cluster.mirror(shadows)
+	}
+	// 3. encode
+	for _, msg := range msgs {
+		if err = h.pc.Encode(msg); err != nil {
+			h.pc.Flush()
 			return
 		}
-		// 4. release resource
-		for _, msg := range msgs {
-			msg.Reset()
+		msg.MarkEnd()
+		msg.ResetSubs()
+		if prom.On {
+			prom.ProxyTime(h.cluster.cc.Name, msg.Request().CmdString(), int64(msg.TotalDur()/time.Microsecond))
 		}
-		for _, mb := range mbatch {
+	}
+	if err = h.pc.Flush(); err != nil {
+		return
+	}
+	// 4. release resource
+	for _, msg := range msgs {
+		msg.Reset()
+	}
+	for _, mb := range mbatch {
+		mb.Reset()
+	}
+	for _, mbs := range h.fmbatch {
+		for _, mb := range mbs {
 			mb.Reset()
 		}
-		// 5. reset MaxConcurrent
-		messages = h.resetMaxConcurrent(messages, len(msgs))
 	}
+	return
 }
 
 func (h *Handler) deferHandle(msgs []*proto.Message, mbs []*proto.MsgBatch, err error) {
 	proto.PutMsgs(msgs)
 	proto.PutMsgBatchs(mbs)
+	for _, fmbs := range h.fmbatch {
+		proto.PutMsgBatchs(fmbs)
+	}
 	h.closeWithError(err)
 	return
 }
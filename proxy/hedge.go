@@ -0,0 +1,362 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/lib/prom"
+	"overlord/proto"
+)
+
+const (
+	hedgePoolName = "hedge"
+
+	defaultHedgePercentile = 99
+	defaultHedgeMaxDelay   = 100
+	// hedgeWindow is the count of recent read latencies which the hedge delay is calculated from.
+	hedgeWindow = 1024
+	// hedgeRefresh is the count of read latencies observed between two calculations of hedge delay.
+	hedgeRefresh = 128
+)
+
+// results of hedge.
+const (
+	hedgeResultSent = "sent"
+	hedgeResultWon  = "won"
+)
+
+// hedge sends the reads again to the alternate target after the delay of recent read latencies.
+type hedge struct {
+	hc *HedgeConfig
+	// pool is the alternate target, nil means the next node of key on ring.
+	pool *pool
+
+	lock  sync.Mutex
+	lats  []time.Duration
+	n     int
+	delay int64
+}
+
+// initHedge inits the hedge pool, the delay is max_delay until enough latencies are observed.
+func (c *Cluster) initHedge() {
+	hc := c.cc.Hedge
+	if hc == nil {
+		return
+	}
+	h := &hedge{hc: hc, lats: make([]time.Duration, hedgeWindow)}
+	h.delay = int64(time.Duration(hc.maxDelay()) * time.Millisecond)
+	if len(hc.Servers) > 0 {
+		h.pool = c.addPool(hedgePoolName, hc.clusterConfig(c.cc))
+	}
+	c.hedge = h
+}
+
+// observe observes one read latency, the delay is recalculated every hedgeRefresh latencies.
+func (h *hedge) observe(d time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.lats[h.n%hedgeWindow] = d
+	h.n++
+	if h.n%hedgeRefresh != 0 {
+		return
+	}
+	n := h.n
+	if n > hedgeWindow {
+		n = hedgeWindow
+	}
+	lats := append([]time.Duration(nil), h.lats[:n]...)
+	sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
+	d = lats[int(float64(n-1)*h.hc.percentile()/100)]
+	if min := time.Duration(h.hc.MinDelay) * time.Millisecond; d < min {
+		d = min
+	}
+	if max := time.Duration(h.hc.maxDelay()) * time.Millisecond; d > max {
+		d = max
+	}
+	atomic.StoreInt64(&h.delay, int64(d))
+}
+
+func (h *hedge) after() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.delay))
+}
+
+// hedging reports whether req is hedged, the hedged reads are dispatched by hedgeDispatch instead of DispatchBatch.
+// The reads of keys written in the same round are not hedged, since the batchs of hedged reads may be sent by
+// other connections of the node and executed before or after the writes.
+func (c *Cluster) hedging(req proto.Request, written map[string]bool) bool {
+	return c.hedge != nil && req.IsRead() && !c.broadcasting(req) && !written[string(req.Key())]
+}
+
+// hedgeWritten returns the keys written by msgs in one round, nil when hedge is off.
+func (c *Cluster) hedgeWritten(msgs []*proto.Message) map[string]bool {
+	if c.hedge == nil {
+		return nil
+	}
+	return writtenKeys(msgs)
+}
+
+// writtenKeys returns the keys written by msgs in one round.
+func writtenKeys(msgs []*proto.Message) (keys map[string]bool) {
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			if req := sub.Request(); !req.IsRead() {
+				if keys == nil {
+					keys = make(map[string]bool)
+				}
+				keys[string(req.Key())] = true
+			}
+		}
+	}
+	return
+}
+
+// hedgeIndex returns the index of alternate node of key whose node is idx, -1 means no node.
+func (c *Cluster) hedgeIndex(key []byte, idx int) int {
+	hk := c.hashKey(key)
+	if p := c.hedge.pool; p != nil {
+		if node, ok := p.ring.GetNode(hk); ok {
+			return p.nodeMap[node]
+		}
+		return -1
+	}
+	p := c.route(key)
+	for _, node := range p.ring.GetNodes(hk, 2) {
+		if i := p.nodeMap[node]; i != idx {
+			return i
+		}
+	}
+	return -1
+}
+
+// hedgeGroup is the copies of hedged subs sent to one node, and the hedges sent to alternate nodes.
+type hedgeGroup struct {
+	idx     int
+	subs    []*proto.Message
+	copies  []*proto.Message
+	done    chan struct{}
+	hmbs    []*proto.MsgBatch
+	hcopies []*proto.Message
+	hdone   chan struct{}
+}
+
+// hedgeRound is the hedged reads in one round.
+type hedgeRound struct {
+	start  time.Time
+	mbs    []*proto.MsgBatch
+	groups []*hedgeGroup
+	// parents are the parents of copies by the parents of subs, so the copies of subs split from
+	// the same msg are still sent as one multi keys command.
+	parents map[*proto.Message]*proto.Message
+}
+
+// copyMsg returns the copy of hedged sub grouped by the parent of sub.
+// NOTE: the parent of copies carries no request, so the request replaced by copy is never put into the msg.
+func (hr *hedgeRound) copyMsg(c *Cluster, sub *proto.Message) *proto.Message {
+	cm := c.copyMsg(sub)
+	if parent := sub.Parent(); parent != nil {
+		p, ok := hr.parents[parent]
+		if !ok {
+			p = new(proto.Message)
+			hr.parents[parent] = p
+		}
+		cm.WithParent(p)
+	}
+	return cm
+}
+
+func waitBatchs(done chan struct{}, mbs []*proto.MsgBatch) {
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	close(done)
+}
+
+// hedgeDispatch dispatches the copies of hedged reads of msgs, the subs are replied by the copies in hedgeDone.
+// The reads of keys written by msgs are dispatched by DispatchBatch instead, so they keep the order of writes.
+// NOTE: the subs are never sent, so the slower copy can be discarded safely after it is replied.
+func (c *Cluster) hedgeDispatch(msgs []*proto.Message) (hr *hedgeRound) {
+	if c.hedge == nil {
+		return
+	}
+	var (
+		groups  map[int]*hedgeGroup
+		written = c.hedgeWritten(msgs)
+	)
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if !c.hedging(req, written) {
+				continue
+			}
+			idx := c.calculateBatchIndex(req.Key())
+			if idx == -1 {
+				sub.DoneWithError(ErrNotAvaiableNode)
+				continue
+			}
+			if hr == nil {
+				hr = &hedgeRound{mbs: proto.GetMsgBatchs(len(c.nodeChan)), parents: make(map[*proto.Message]*proto.Message)}
+				groups = make(map[int]*hedgeGroup)
+			}
+			g, ok := groups[idx]
+			if !ok {
+				g = &hedgeGroup{idx: idx, done: make(chan struct{})}
+				groups[idx] = g
+				hr.groups = append(hr.groups, g)
+			}
+			cm := hr.copyMsg(c, sub)
+			g.subs = append(g.subs, sub)
+			g.copies = append(g.copies, cm)
+			hr.mbs[idx].AddMsg(cm)
+		}
+	}
+	if hr == nil {
+		return
+	}
+	hr.start = time.Now()
+	c.deliver(hr.mbs)
+	for _, g := range hr.groups {
+		go waitBatchs(g.done, hr.mbs[g.idx:g.idx+1])
+	}
+	return
+}
+
+// hedgeDone sends the hedges of the groups not replied after delay to the alternate nodes, and replies
+// the subs by the first replied copies. The copies failed by node errors before delay are hedged at once.
+func (c *Cluster) hedgeDone(hr *hedgeRound) {
+	if hr == nil {
+		return
+	}
+	timer := time.NewTimer(c.hedge.after() - time.Since(hr.start))
+	defer timer.Stop()
+	expired := false
+	for _, g := range hr.groups {
+		if !expired {
+			select {
+			case <-g.done:
+				c.hedgeSend(hr, g, true)
+				continue
+			case <-timer.C:
+				expired = true
+			}
+		}
+		select {
+		case <-g.done:
+			c.hedgeSend(hr, g, true)
+		default:
+			c.hedgeSend(hr, g, false)
+		}
+	}
+	for _, g := range hr.groups {
+		c.hedgeReply(g)
+	}
+}
+
+// hedgeSend sends the copies of subs of slow group g to their alternate nodes, only the copies
+// failed are sent when failed is true.
+func (c *Cluster) hedgeSend(hr *hedgeRound, g *hedgeGroup, failed bool) {
+	for i, sub := range g.subs {
+		if failed && g.copies[i].Err() == nil {
+			continue
+		}
+		idx := c.hedgeIndex(sub.Request().Key(), g.idx)
+		if idx == -1 {
+			continue
+		}
+		if g.hmbs == nil {
+			g.hmbs = proto.GetMsgBatchs(len(c.nodeChan))
+			g.hcopies = make([]*proto.Message, len(g.subs))
+		}
+		g.hcopies[i] = hr.copyMsg(c, sub)
+		g.hmbs[idx].AddMsg(g.hcopies[i])
+		if prom.On {
+			prom.Hedge(c.cc.Name, sub.Request().CmdString(), hedgeResultSent)
+		}
+	}
+	if g.hmbs == nil {
+		return
+	}
+	g.hdone = make(chan struct{})
+	c.deliver(g.hmbs)
+	go waitBatchs(g.hdone, g.hmbs)
+}
+
+// hedgeReply replies the subs of g by the copies of the first replied node or alternate nodes,
+// the other copy is used when the first one failed.
+// NOTE: the request of sub is swapped with the copy, so the replaced one is put with copies.
+func (c *Cluster) hedgeReply(g *hedgeGroup) {
+	won := false
+	if g.hdone != nil {
+		select {
+		case <-g.done:
+		case <-g.hdone:
+			won = true
+		}
+	} else {
+		<-g.done
+	}
+	for i, sub := range g.subs {
+		cm := g.copies[i]
+		var hm *proto.Message
+		if g.hcopies != nil {
+			hm = g.hcopies[i]
+		}
+		switch {
+		case hm == nil:
+			<-g.done
+		case won && hm.Err() == nil:
+			cm = hm
+			if prom.On {
+				prom.Hedge(c.cc.Name, sub.Request().CmdString(), hedgeResultWon)
+			}
+		case won:
+			<-g.done
+		case cm.Err() != nil:
+			<-g.hdone
+			if hm.Err() == nil {
+				cm = hm
+			}
+		}
+		sub.DoneWithError(cm.Err())
+		cm.ReplaceRequest(sub.ReplaceRequest(cm.Request()))
+	}
+}
+
+// hedgeRelease releases the copies after msgs are encoded, since the replies of copies are read into them.
+// The slower copies are released after they are replied, whose latencies are observed as well.
+func (c *Cluster) hedgeRelease(hr *hedgeRound) {
+	if hr == nil {
+		return
+	}
+	go func() {
+		for _, g := range hr.groups {
+			<-g.done
+			for _, cm := range g.copies {
+				if cm.Err() == nil {
+					c.hedge.observe(cm.RemoteDur())
+				}
+			}
+			proto.PutMsgs(g.copies)
+			if g.hdone == nil {
+				continue
+			}
+			<-g.hdone
+			for _, hm := range g.hcopies {
+				if hm != nil {
+					proto.PutMsgs([]*proto.Message{hm})
+				}
+			}
+			proto.PutMsgBatchs(g.hmbs)
+		}
+		proto.PutMsgBatchs(hr.mbs)
+	}()
+}
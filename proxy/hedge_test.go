@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"overlord/proto"
+	"overlord/proto/memcache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func _createHedgeCluster(t *testing.T, hc *HedgeConfig) (c *Cluster, mcs map[string]*_memcache, cancel func()) {
+	cc := &ClusterConfig{
+		Name:             "hedge",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      1000,
+		ReadTimeout:      1000,
+		WriteTimeout:     1000,
+		NodeConnections:  1,
+		Hedge:            hc,
+	}
+	mcs = map[string]*_memcache{}
+	var closes []func() error
+	for _, name := range []string{"mc1", "mc2"} {
+		l, mc := _serveMemcache(t, map[string]string{"k": name})
+		closes = append(closes, l.Close)
+		mcs[name] = mc
+		cc.Servers = append(cc.Servers, l.Addr().String()+":1 "+name)
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cf := context.WithCancel(context.Background())
+	c = NewCluster(ctx, cc)
+	cancel = func() {
+		cf()
+		for _, cl := range closes {
+			cl()
+		}
+	}
+	return
+}
+
+// _handleRound handles msgs as one round of a client handler of c and returns the replies written to
+// client, the msgs are put back after the round.
+func _handleRound(c *Cluster, msgs []*proto.Message) string {
+	client, server := net.Pipe()
+	defer client.Close()
+	replies := make(chan []byte, 1)
+	go func() {
+		bs, _ := ioutil.ReadAll(client)
+		replies <- bs
+	}()
+	h := newHandler(context.Background(), &Config{}, server, c, c.cc.CacheType)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	// NOTE: the error of msg is also returned by memcache encoding after it is written to client.
+	err := h.handleRound(mbs, msgs, false)
+	if err == nil {
+		err = io.EOF
+	}
+	h.deferHandle(msgs, mbs, err)
+	return string(<-replies)
+}
+
+func TestClusterHedgeSlowNode(t *testing.T) {
+	c, mcs, cancel := _createHedgeCluster(t, &HedgeConfig{MaxDelay: 20})
+	defer cancel()
+	nodes := c.pool.ring.GetNodes([]byte("k"), 2)
+	mcs[nodes[0]].lock.Lock()
+	mcs[nodes[0]].delay = 200 * time.Millisecond
+	mcs[nodes[0]].lock.Unlock()
+
+	msgs := _createMemcacheMsgs(t, "get k\r\n", 1)
+	start := time.Now()
+	reply := _handleRound(c, msgs)
+	assert.True(t, time.Since(start) < 150*time.Millisecond, "expect hedge replied before slow node")
+	assert.Equal(t, "VALUE k 0 3\r\n"+nodes[1]+"\r\nEND\r\n", reply)
+
+	// NOTE: the slower reply is discarded, so the pipeline of slow node is still in order.
+	mcs[nodes[0]].lock.Lock()
+	mcs[nodes[0]].delay = 0
+	mcs[nodes[0]].lock.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	msgs = _createMemcacheMsgs(t, "set k 0 0 1\r\nv\r\nget k\r\n", 2)
+	assert.Equal(t, "STORED\r\nVALUE k 0 1\r\nv\r\nEND\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterHedgeDelayPercentile(t *testing.T) {
+	hc := &HedgeConfig{Percentile: 90, MinDelay: 5, MaxDelay: 50}
+	h := &hedge{hc: hc, lats: make([]time.Duration, hedgeWindow)}
+	h.delay = int64(time.Duration(hc.maxDelay()) * time.Millisecond)
+	for i := 1; i <= hedgeRefresh; i++ {
+		h.observe(time.Duration(i%10) * time.Millisecond)
+	}
+	assert.Equal(t, 8*time.Millisecond, h.after())
+	for i := 0; i < hedgeRefresh; i++ {
+		h.observe(time.Second)
+	}
+	assert.Equal(t, 50*time.Millisecond, h.after())
+	for i := 0; i < hedgeWindow; i++ {
+		h.observe(0)
+	}
+	assert.Equal(t, 5*time.Millisecond, h.after())
+}
+
+func TestClusterHedgeNodeError(t *testing.T) {
+	l, mc := _serveMemcache(t, map[string]string{})
+	defer l.Close()
+	cc := &ClusterConfig{
+		Name:             "hedge",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{_deadAddr(t) + ":1 dead", l.Addr().String() + ":1 alive"},
+		Hedge:            &HedgeConfig{MinDelay: 500, MaxDelay: 500},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("k%d", i); c.pool.ring.GetNodes([]byte(k), 1)[0] == "dead" {
+			key = k
+		}
+	}
+	mc.lock.Lock()
+	mc.values[key] = "v"
+	mc.lock.Unlock()
+
+	msgs := _createMemcacheMsgs(t, "get "+key+"\r\n", 1)
+	start := time.Now()
+	reply := _handleRound(c, msgs)
+	assert.True(t, time.Since(start) < 400*time.Millisecond, "expect node error hedged before delay")
+	assert.Equal(t, "VALUE "+key+" 0 1\r\nv\r\nEND\r\n", reply)
+}
+
+func TestClusterHedgeWrittenAndGroup(t *testing.T) {
+	c, _, cancel := _createHedgeCluster(t, &HedgeConfig{MaxDelay: 20})
+	defer cancel()
+	// NOTE: the read of key written in the same round keeps the order of write.
+	msgs := _createMemcacheMsgs(t, "set k 0 0 1\r\nv\r\nget k\r\n", 2)
+	hr := c.hedgeDispatch(msgs)
+	assert.Nil(t, hr)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	assert.Contains(t, msgs[1].Request().(*memcache.MCRequest).String(), "VALUE k 0 1\r\nv\r\nEND\r\n")
+	proto.PutMsgBatchs(mbs)
+	proto.PutMsgs(msgs)
+
+	msgs = _createMemcacheMsgs(t, "get a b c d\r\n", 1)
+	hr = c.hedgeDispatch(msgs)
+	for _, g := range hr.groups {
+		parent := g.copies[0].Parent()
+		assert.NotNil(t, parent)
+		for _, cm := range g.copies {
+			assert.True(t, cm.Parent() == parent, "expect copies of one msg grouped")
+		}
+	}
+	c.hedgeDone(hr)
+	assert.NoError(t, msgs[0].Err())
+	c.hedgeRelease(hr)
+	proto.PutMsgs(msgs)
+}
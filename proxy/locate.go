@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"overlord/lib/hashkit"
+)
+
+// KeyLocation is the tag extracted from key for hashing and the node chosen for key.
+type KeyLocation struct {
+	Key string `json:"key"`
+	Tag string `json:"tag"`
+	// Slot is the Redis Cluster slot of tag, which the node is chosen by when hash_method is crc16.
+	Slot int    `json:"slot"`
+	Pool string `json:"pool"`
+	Node string `json:"node"`
+	Addr string `json:"addr"`
+}
+
+// Locate returns the locations of keys, the node is empty when no node is available.
+func (c *Cluster) Locate(keys []string) (locs []*KeyLocation) {
+	for _, key := range keys {
+		hk := c.hashKey([]byte(key))
+		p := c.route([]byte(key))
+		loc := &KeyLocation{Key: key, Tag: string(hk), Slot: hashkit.Slot(hk), Pool: p.name}
+		if node, ok := p.ring.GetNode(hk); ok {
+			loc.Node = node
+			loc.Addr = p.addrs[p.nodeMap[node]-p.base]
+		}
+		locs = append(locs, loc)
+	}
+	return
+}
+
+// LocateKeys returns the locations of keys by cluster config without connecting to servers.
+func LocateKeys(cc *ClusterConfig, keys []string) []*KeyLocation {
+	c := newCluster(context.Background(), cc)
+	defer c.cancel()
+	return c.Locate(keys)
+}
+
+type locateStatus struct {
+	Cluster string         `json:"cluster"`
+	Keys    []*KeyLocation `json:"keys"`
+}
+
+// HandleLocate serves the tags and nodes of keys by http, e.g. GET /locate?cluster=redis&key=a&key=b.
+// NOTE: the form is parsed by FormValue.
+func (p *Proxy) HandleLocate(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("cluster")
+	clusters := p.clustersByName(name)
+	if len(clusters) == 0 {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&locateStatus{Cluster: name, Keys: clusters[0].Locate(r.Form["key"])})
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"overlord/lib/hashkit"
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterHashTagRedisCluster(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		Name:             "redis",
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       hashkit.HashMethodCrc16,
+		HashDistribution: "ketama",
+		HashTagMode:      HashTagRedisCluster,
+		Servers:          []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1", "127.0.0.1:6381:1"},
+	})
+	for key, tag := range map[string]string{
+		"{user1000}.following": "user1000",
+		"foo{}{bar}":           "foo{}{bar}",
+		"foo{{bar}}zap":        "{bar",
+		"foo{bar}{zap}":        "bar",
+		"foo":                  "foo",
+	} {
+		assert.Equal(t, tag, string(c.hashKey([]byte(key))), key)
+	}
+	locs := c.Locate([]string{"{user1000}.following", "{user1000}.followers", "foo"})
+	assert.Len(t, locs, 3)
+	assert.Equal(t, "user1000", locs[0].Tag)
+	assert.Equal(t, hashkit.Slot([]byte("user1000")), locs[0].Slot)
+	assert.Equal(t, 12182, locs[2].Slot)
+	assert.Equal(t, locs[0].Node, locs[1].Node)
+	assert.Equal(t, locs[0].Node, locs[0].Addr)
+	assert.Equal(t, defaultPoolName, locs[0].Pool)
+}
+
+func TestClusterHashTagLocateKeys(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "mc",
+		CacheType:        proto.CacheTypeAuto,
+		NodeCacheType:    proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		HashTag:          "{}",
+		Servers:          []string{"127.0.0.1:11211:1 mc1", "127.0.0.1:11212:1 mc2"},
+	}
+	locs := LocateKeys(cc, []string{"a{b}c"})
+	assert.Len(t, locs, 1)
+	assert.Equal(t, "b", locs[0].Tag)
+	addrs := map[string]string{"mc1": "127.0.0.1:11211", "mc2": "127.0.0.1:11212"}
+	assert.Equal(t, addrs[locs[0].Node], locs[0].Addr)
+
+	p := &Proxy{clusters: map[string]*Cluster{"mc": _createCluster(cc)}}
+	w := httptest.NewRecorder()
+	p.HandleLocate(w, httptest.NewRequest("GET", "/locate?cluster=mc&key=a{b}c&key=b", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"a{b}c","tag":"b"`)
+	assert.Contains(t, w.Body.String(), `"node":"`+locs[0].Node+`"`)
+	w = httptest.NewRecorder()
+	p.HandleLocate(w, httptest.NewRequest("GET", "/locate?cluster=none&key=a", nil))
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,346 @@
+package proxy
+
+import (
+	"encoding/json"
+	errs "errors"
+	"net/http"
+	"sync/atomic"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/redis"
+)
+
+const migrationPoolName = "migration"
+
+// results of migrate.
+const (
+	migrateResultWriteError = "write_error"
+)
+
+// results of the fallback reads of migration, resharding and broadcast.
+const (
+	fallbackResultHit  = "fallback_hit"
+	fallbackResultMiss = "fallback_miss"
+)
+
+// migration errors
+var (
+	ErrMigrationNotFound = errs.New("cluster has no migration")
+	ErrMigrationPhase    = errs.New("migration phase must be dual_write, dual_read or new_only")
+)
+
+var migrationPhases = []string{MigrationDualWrite, MigrationDualRead, MigrationNewOnly}
+
+func migrationPhaseIndex(phase string) int {
+	for i, p := range migrationPhases {
+		if p == phase {
+			return i
+		}
+	}
+	return -1
+}
+
+// migration migrates the keys of default pool, the old servers, to pool, the new servers.
+type migration struct {
+	pool *pool
+	idx  int32
+}
+
+// initMigration inits the pool of new servers.
+func (c *Cluster) initMigration() {
+	mc := c.cc.Migration
+	if mc == nil {
+		return
+	}
+	c.migration = &migration{pool: c.addPool(migrationPoolName, mc.clusterConfig(c.cc))}
+	c.migration.idx = int32(migrationPhaseIndex(mc.Phase))
+}
+
+func (m *migration) phase() string {
+	return migrationPhases[atomic.LoadInt32(&m.idx)]
+}
+
+// SetMigrationPhase switches the phase of migration.
+func (c *Cluster) SetMigrationPhase(phase string) error {
+	if c.migration == nil {
+		return ErrMigrationNotFound
+	}
+	idx := migrationPhaseIndex(phase)
+	if idx == -1 {
+		return ErrMigrationPhase
+	}
+	atomic.StoreInt32(&c.migration.idx, int32(idx))
+	log.Infof("cluster(%s) migration phase is switched to %s", c.cc.Name, phase)
+	return nil
+}
+
+// MigrationPhase returns the phase of migration.
+func (c *Cluster) MigrationPhase() (string, error) {
+	if c.migration == nil {
+		return "", ErrMigrationNotFound
+	}
+	return c.migration.phase(), nil
+}
+
+// migrateRound is the copies of msgs sent to the other servers of migration or resharding in one round.
+type migrateRound struct {
+	mbs    []*proto.MsgBatch
+	writes []*proto.Message
+	// reads are the copies of reads on new servers, which fall back to the old node of idxs when subs miss.
+	reads []*proto.Message
+	subs  []*proto.Message
+	idxs  []int
+	// fmbs are the batchs of fallback reads, which must be put after msgs are encoded since
+	// the hit replies are read into them.
+	fmbs []*proto.MsgBatch
+	// moves are the writes of keys not moved yet while resharding, which are moved from the old
+	// owners of midxs before msgs are dispatched.
+	moves []*proto.Message
+	midxs []int
+}
+
+func (mr *migrateRound) addRead(c *Cluster, sub *proto.Message, idx int) {
+	mr.reads = append(mr.reads, c.copyMsg(sub))
+	mr.subs = append(mr.subs, sub)
+	mr.idxs = append(mr.idxs, idx)
+}
+
+func (mr *migrateRound) addWrite(c *Cluster, wm *proto.Message, idx int) {
+	if mr.mbs == nil {
+		mr.mbs = proto.GetMsgBatchs(len(c.nodeChan))
+	}
+	mr.writes = append(mr.writes, wm)
+	mr.mbs[idx].AddMsg(wm)
+}
+
+// migrateDispatch copies the writes of migrating keys and dispatches them to the other servers
+// along with msgs, and copies the reads of new servers for fallback.
+// While resharding, the keys written are moved from old owners at first, so the partial writes,
+// e.g. HSET and APPEND, are applied on the whole value moved to new owners.
+// NOTE: the switching phase may write a key twice to the same servers in this round, which is harmless.
+func (c *Cluster) migrateDispatch(msgs []*proto.Message) (mr *migrateRound) {
+	var phase string
+	if c.migration != nil {
+		phase = c.migration.phase()
+	}
+	resharding := c.reshard != nil && c.reshard.moving()
+	if (phase == "" || phase == MigrationNewOnly) && !resharding {
+		return
+	}
+	mr = &migrateRound{}
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if resharding {
+				idx := c.reshardIndex(req.Key())
+				if idx == -1 {
+					continue
+				}
+				if req.IsRead() {
+					mr.addRead(c, sub, idx)
+				} else {
+					mr.moves = append(mr.moves, sub)
+					mr.midxs = append(mr.midxs, idx)
+				}
+				continue
+			}
+			p := c.route(req.Key())
+			other := c.pool
+			if p == c.pool {
+				other = c.migration.pool
+			} else if p != c.migration.pool {
+				continue
+			}
+			if req.IsRead() {
+				if phase == MigrationDualRead && p == c.migration.pool {
+					if idx := c.poolIndex(c.pool, req.Key()); idx != -1 {
+						mr.addRead(c, sub, idx)
+					}
+				}
+				continue
+			}
+			idx := c.poolIndex(other, req.Key())
+			if idx == -1 {
+				continue
+			}
+			mr.addWrite(c, c.copyMsg(sub), idx)
+		}
+	}
+	if len(mr.moves) > 0 {
+		c.reshardMove(mr.moves, mr.midxs)
+	}
+	if mr.mbs != nil {
+		c.deliver(mr.mbs)
+	}
+	return
+}
+
+// migrateDone waits the writes copied to the other servers, and falls back the read misses of
+// new servers to old servers, whose hits replace the replies of new servers.
+func (c *Cluster) migrateDone(mr *migrateRound) {
+	if mr == nil {
+		return
+	}
+	if mr.mbs != nil {
+		for _, mb := range mr.mbs {
+			mb.Wait()
+		}
+		for _, wm := range mr.writes {
+			if err := wm.Err(); err != nil {
+				if log.V(2) {
+					log.Warnf("cluster(%s) Msg(%s) migrate write error:%v", c.cc.Name, wm.Request().Key(), err)
+				}
+				if prom.On {
+					prom.Migrate(c.cc.Name, wm.Request().CmdString(), migrateResultWriteError)
+				}
+			}
+		}
+		proto.PutMsgs(mr.writes)
+		proto.PutMsgBatchs(mr.mbs)
+	}
+	if len(mr.reads) > 0 {
+		mr.fmbs = c.fallback(mr.reads, mr.subs, nil, mr.idxs, prom.Migrate)
+		proto.PutMsgs(mr.reads)
+	}
+}
+
+// migrateRelease releases the batchs of fallback reads after msgs are encoded.
+func (c *Cluster) migrateRelease(mr *migrateRound) {
+	if mr != nil && mr.fmbs != nil {
+		proto.PutMsgBatchs(mr.fmbs)
+	}
+}
+
+// fallback sends the reads whose subs missed on the nodes of froms to the nodes of idxs, -1 means no
+// node to fall back to. froms nil means the nodes of keys, e.g. the new servers. The results are
+// counted by count, e.g. prom.Migrate.
+// NOTE: the hit request is swapped with the request of sub, so the replaced one is put with reads.
+func (c *Cluster) fallback(reads, subs []*proto.Message, froms, idxs []int, count func(cluster, cmd, result string)) (mbs []*proto.MsgBatch) {
+	misses := c.missed(subs, froms)
+	sent := make([]bool, len(reads))
+	for i := range subs {
+		if !misses[i] || idxs[i] == -1 {
+			continue
+		}
+		if mbs == nil {
+			mbs = proto.GetMsgBatchs(len(c.nodeChan))
+		}
+		mbs[idxs[i]].AddMsg(reads[i])
+		sent[i] = true
+	}
+	if mbs == nil {
+		return
+	}
+	c.deliver(mbs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	for i, rm := range reads {
+		if !sent[i] {
+			continue
+		}
+		result := fallbackResultMiss
+		if rm.Err() == nil && !rm.Request().IsMiss() {
+			rm.ReplaceRequest(subs[i].ReplaceRequest(rm.Request()))
+			result = fallbackResultHit
+		}
+		if prom.On {
+			count(c.cc.Name, rm.Request().CmdString(), result)
+		}
+	}
+	return
+}
+
+// missed reports whether the keys of subs do not exist on the nodes of froms, nil means the nodes of
+// keys. The redis misses are checked by EXISTS on the nodes read, since the reply of an existing key
+// may look like a miss as well, e.g. the empty array of LRANGE out of range.
+func (c *Cluster) missed(subs []*proto.Message, froms []int) (misses []bool) {
+	misses = make([]bool, len(subs))
+	var (
+		mbs    []*proto.MsgBatch
+		checks = make([]*proto.Message, len(subs))
+	)
+	for i, sub := range subs {
+		req := sub.Request()
+		if sub.Err() != nil || !req.IsMiss() {
+			continue
+		}
+		if _, ok := req.(*redis.Request); !ok {
+			misses[i] = true
+			continue
+		}
+		idx := c.calculateBatchIndex(req.Key())
+		if froms != nil {
+			idx = froms[i]
+		}
+		if idx == -1 {
+			continue
+		}
+		if mbs == nil {
+			mbs = proto.GetMsgBatchs(len(c.nodeChan))
+		}
+		em := proto.NewMessage()
+		em.Type = proto.CacheTypeRedis
+		em.WithRequest(redis.NewExistsRequest(req.Key()))
+		checks[i] = em
+		mbs[idx].AddMsg(em)
+	}
+	if mbs == nil {
+		return
+	}
+	c.deliver(mbs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	for i, em := range checks {
+		if em == nil {
+			continue
+		}
+		misses[i] = em.Err() == nil && !redis.Exists(em.Request().(*redis.Request))
+		proto.PutMsgs([]*proto.Message{em})
+	}
+	proto.PutMsgBatchs(mbs)
+	return
+}
+
+type migrationStatus struct {
+	Cluster string `json:"cluster"`
+	Phase   string `json:"phase"`
+}
+
+// HandleMigration serves the migration phase of cluster by http, e.g.
+// GET /migration?cluster=mc shows the phase and POST /migration?cluster=mc&phase=dual_read switches it.
+func (p *Proxy) HandleMigration(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("cluster")
+	clusters := p.clustersByName(name)
+	if len(clusters) == 0 {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPut:
+		for _, c := range clusters {
+			if err := c.SetMigrationPhase(r.FormValue("phase")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	phase, err := clusters[0].MigrationPhase()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&migrationStatus{Cluster: name, Phase: phase})
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterMigrationPhases(t *testing.T) {
+	ol, old := _serveMemcache(t, map[string]string{"a": "x", "b": "y"})
+	defer ol.Close()
+	nl, nw := _serveMemcache(t, map[string]string{"b": "z"})
+	defer nl.Close()
+	cc := &ClusterConfig{
+		Name:             "migrate",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{ol.Addr().String() + ":1"},
+		Migration:        &MigrationConfig{Phase: MigrationDualWrite, Servers: []string{nl.Addr().String() + ":1"}},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: dual_write writes both and reads old.
+	msgs := _createMemcacheMsgs(t, "set c 0 0 1\r\nw\r\nget a b\r\n", 2)
+	assert.Equal(t, "STORED\r\nVALUE a 0 1\r\nx\r\nVALUE b 0 1\r\ny\r\nEND\r\n", _handleRound(c, msgs))
+	for _, m := range []*_memcache{old, nw} {
+		v, _ := m.get("c")
+		assert.Equal(t, "w", v)
+	}
+
+	// NOTE: dual_read reads new and falls back to old on miss.
+	assert.NoError(t, c.SetMigrationPhase(MigrationDualRead))
+	msgs = _createMemcacheMsgs(t, "set d 0 0 1\r\nv\r\nget a b e\r\n", 2)
+	assert.Equal(t, "STORED\r\nVALUE a 0 1\r\nx\r\nVALUE b 0 1\r\nz\r\nEND\r\n", _handleRound(c, msgs))
+	for _, m := range []*_memcache{old, nw} {
+		v, _ := m.get("d")
+		assert.Equal(t, "v", v)
+	}
+
+	// NOTE: new_only reads and writes new only.
+	assert.NoError(t, c.SetMigrationPhase(MigrationNewOnly))
+	msgs = _createMemcacheMsgs(t, "set f 0 0 1\r\nu\r\nget a\r\n", 2)
+	assert.Equal(t, "STORED\r\nEND\r\n", _handleRound(c, msgs))
+	_, ok := old.get("f")
+	assert.False(t, ok)
+	v, _ := nw.get("f")
+	assert.Equal(t, "u", v)
+
+	assert.Equal(t, ErrMigrationPhase, c.SetMigrationPhase("old_only"))
+	phase, err := c.MigrationPhase()
+	assert.NoError(t, err)
+	assert.Equal(t, MigrationNewOnly, phase)
+}
+
+func TestProxyHandleMigration(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		Name:      "mc",
+		CacheType: proto.CacheTypeMemcache,
+		Servers:   []string{"127.0.0.1:11211:1"},
+		Migration: &MigrationConfig{Phase: MigrationDualWrite, Servers: []string{"127.0.0.1:11212:1"}},
+	})
+	p := &Proxy{clusters: map[string]*Cluster{"mc": c}}
+	var tests = []struct {
+		method string
+		query  string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "cluster=mc", http.StatusOK, `{"cluster":"mc","phase":"dual_write"}`},
+		{http.MethodPost, "cluster=mc&phase=dual_read", http.StatusOK, `{"cluster":"mc","phase":"dual_read"}`},
+		{http.MethodPost, "cluster=mc&phase=old_only", http.StatusBadRequest, ""},
+		{http.MethodGet, "cluster=redis", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		p.HandleMigration(w, httptest.NewRequest(tt.method, "/migration?"+tt.query, nil))
+		assert.Equal(t, tt.code, w.Code, tt.method+" "+tt.query)
+		if tt.body != "" {
+			assert.Equal(t, tt.body, strings.TrimSpace(w.Body.String()))
+		}
+	}
+	assert.Equal(t, c.migration.pool, c.route([]byte("a")))
+}
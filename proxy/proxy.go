@@ -3,6 +3,7 @@ package proxy
 import (
 	"context"
 	errs "errors"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -67,6 +68,7 @@ func (p *Proxy) Serve(ccs []*ClusterConfig) {
 }
 
 func (p *Proxy) serve(cc *ClusterConfig) {
+	// NOTE: the auto cluster serves all protocols by one ring, and owns the node conns of each protocol.
 	cluster := NewCluster(p.ctx, cc)
 	p.lock.Lock()
 	p.clusters[cc.Name] = cluster
@@ -88,30 +90,68 @@ func (p *Proxy) serve(cc *ClusterConfig) {
 		}
 		if p.c.Proxy.MaxConnections > 0 {
 			if conns := atomic.AddInt32(&p.conns, 1); conns > p.c.Proxy.MaxConnections {
-				// cache type
-				var encoder proto.ProxyConn
-				switch cc.CacheType {
-				case proto.CacheTypeMemcache:
-					encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				case proto.CacheTypeMemcacheBinary:
-					encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				case proto.CacheTypeRedis:
-					encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
-				}
-				if encoder != nil {
-					_ = encoder.Encode(proto.ErrMessage(ErrProxyMoreMaxConns))
-				}
-				_ = conn.Close()
+				rejectConn(cc.CacheType, conn, ErrProxyMoreMaxConns)
 				if log.V(3) {
 					log.Warnf("proxy reject connection count(%d) due to more than max(%d)", conns, p.c.Proxy.MaxConnections)
 				}
 				continue
 			}
 		}
+		if cc.CacheType == proto.CacheTypeAuto {
+			go p.serveAuto(cc, conn, cluster)
+			continue
+		}
 		NewHandler(p.ctx, p.c, conn, cluster).Handle()
 	}
 }
 
+// serveAuto detects the protocol of conn and hands it to the cluster if the protocol is served.
+func (p *Proxy) serveAuto(cc *ClusterConfig, conn net.Conn, cluster *Cluster) {
+	ct, pc, err := detectConn(conn, time.Second*time.Duration(p.c.Proxy.ReadTimeout))
+	if err != nil {
+		_ = conn.Close()
+		if log.V(3) {
+			log.Warnf("cluster(%s) addr(%s) remoteAddr(%s) detect protocol error:%+v", cc.Name, cc.ListenAddr, conn.RemoteAddr(), err)
+		}
+		return
+	}
+	if !canTranslate(ct, cc.nodeCacheType()) {
+		rejectConn(ct, pc, errors.Wrapf(ErrConfigTranslate, "cache_type(%s) node_cache_type(%s)", ct, cc.nodeCacheType()))
+		return
+	}
+	newHandler(p.ctx, p.c, pc, cluster, ct).Handle()
+}
+
+// rejectConn replies the error in protocol of cache type and closes the conn.
+func rejectConn(ct proto.CacheType, conn net.Conn, err error) {
+	var encoder proto.ProxyConn
+	switch ct {
+	case proto.CacheTypeMemcache:
+		encoder = memcache.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	case proto.CacheTypeMemcacheBinary:
+		encoder = mcbin.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	case proto.CacheTypeRedis:
+		encoder = redis.NewProxyConn(libnet.NewConn(conn, time.Second, time.Second))
+	}
+	if encoder != nil {
+		_ = encoder.Encode(proto.ErrMessage(err))
+		_ = encoder.Flush()
+	}
+	_ = conn.Close()
+}
+
+// clustersByName returns the clusters of cluster config name.
+func (p *Proxy) clustersByName(name string) (clusters []*Cluster) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, c := range p.clusters {
+		if c.cc.Name == name {
+			clusters = append(clusters, c)
+		}
+	}
+	return
+}
+
 // Close close proxy resource.
 func (p *Proxy) Close() error {
 	p.lock.Lock()
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	errs "errors"
+	"sync"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/redis"
+)
+
+const defaultQuorumRepairQueueSize = 1024
+
+// results of quorum read.
+const (
+	quorumResultDiverge     = "diverge"
+	quorumResultReplace     = "replace"
+	quorumResultRepairDrop  = "repair_drop"
+	quorumResultRepairError = "repair_error"
+)
+
+// quorum errors
+var (
+	ErrQuorumRepair = errs.New("quorum repair restore failed")
+)
+
+// quorumRepair is the repair of key from the node src of the most recent copy to the stale nodes of idxs.
+type quorumRepair struct {
+	key  []byte
+	src  int
+	idxs []int
+}
+
+// quorumRepairs is the bounded queue of repairs, a key is queued at most once until it is repaired.
+type quorumRepairs struct {
+	lock    sync.Mutex
+	pending map[string]bool
+	ch      chan *quorumRepair
+}
+
+// initQuorum inits the repair queue.
+func (c *Cluster) initQuorum() {
+	qc := c.cc.QuorumRead
+	if qc == nil || !qc.Repair {
+		return
+	}
+	c.repairs = &quorumRepairs{pending: make(map[string]bool), ch: make(chan *quorumRepair, qc.repairQueueSize())}
+}
+
+// quorumRead is the version reads of sub on the replicas of idxs, the first replica is the node of sub.
+type quorumRead struct {
+	sub      *proto.Message
+	idxs     []int
+	versions []*proto.Message
+	// reads are the copies of sub on idxs[1:], which reply the value since the version request
+	// carries no value.
+	reads []*proto.Message
+}
+
+// quorumRound is the quorum reads in one round.
+type quorumRound struct {
+	mbs   []*proto.MsgBatch
+	reads []*quorumRead
+	msgs  []*proto.Message
+	// deferred is whether the reads are delivered after the writes of round are done, since the keys
+	// of reads are written in the round by the other batchs, which may be sent by the other connections.
+	deferred bool
+}
+
+func (qr *quorumRound) add(idx int, req proto.Request, typ proto.CacheType) *proto.Message {
+	m := proto.NewMessage()
+	m.Type = typ
+	m.WithRequest(req)
+	qr.mbs[idx].AddMsg(m)
+	qr.msgs = append(qr.msgs, m)
+	return m
+}
+
+// newVersionRequest returns the request of version of key read by req.
+func (c *Cluster) newVersionRequest(req proto.Request) proto.Request {
+	return redis.NewVersionRequest(req.Key(), []byte(c.cc.QuorumRead.VersionField))
+}
+
+// quorumDispatch sends the version requests of reads to their first replicas and dispatches them along
+// with msgs, the reads are copied to the other replicas too. Only the reads of hash are quorum read,
+// since the version is the field of hash. The reads of keys written in the round are delivered by
+// quorumDone after the writes are done.
+func (c *Cluster) quorumDispatch(msgs []*proto.Message) (qr *quorumRound) {
+	qc := c.cc.QuorumRead
+	if qc == nil {
+		return
+	}
+	written := writtenKeys(msgs)
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if !req.IsRead() || c.broadcasting(req) {
+				continue
+			}
+			if rr, ok := req.(*redis.Request); !ok || !redis.IsHashRead(rr) {
+				continue
+			}
+			p := c.route(req.Key())
+			nodes := p.ring.GetNodes(c.hashKey(req.Key()), qc.Reads)
+			if len(nodes) < 2 {
+				continue
+			}
+			if qr == nil {
+				qr = &quorumRound{mbs: proto.GetMsgBatchs(len(c.nodeChan))}
+			}
+			q := &quorumRead{sub: sub}
+			for i, node := range nodes {
+				idx := p.nodeMap[node]
+				q.idxs = append(q.idxs, idx)
+				q.versions = append(q.versions, qr.add(idx, c.newVersionRequest(req), sub.Type))
+				if i > 0 {
+					q.reads = append(q.reads, qr.add(idx, req.Clone(), sub.Type))
+				}
+			}
+			qr.reads = append(qr.reads, q)
+			if written[string(req.Key())] {
+				qr.deferred = true
+			}
+		}
+	}
+	if qr != nil && !qr.deferred {
+		c.deliver(qr.mbs)
+	}
+	return
+}
+
+// quorumDone waits the version reads, and replaces the reply of sub by the copy of the most recent
+// version when its node is stale. The stale copies are queued to be repaired when repair is set.
+func (c *Cluster) quorumDone(qr *quorumRound) {
+	if qr == nil {
+		return
+	}
+	if qr.deferred {
+		c.deliver(qr.mbs)
+	}
+	for _, mb := range qr.mbs {
+		mb.Wait()
+	}
+	for _, q := range qr.reads {
+		c.reconcile(q)
+	}
+}
+
+func (c *Cluster) reconcile(q *quorumRead) {
+	var (
+		best     = -1
+		diverged bool
+		oks      = make([]bool, len(q.idxs))
+		vs       = make([]uint64, len(q.idxs))
+	)
+	for i, vm := range q.versions {
+		if vm.Err() != nil {
+			continue
+		}
+		if vs[i], oks[i] = redis.Version(vm.Request().(*redis.Request)); !oks[i] {
+			continue
+		}
+		if best == -1 {
+			best = i
+			continue
+		}
+		if vs[i] != vs[best] {
+			diverged = true
+		}
+		if vs[i] > vs[best] {
+			best = i
+		}
+	}
+	if best == -1 {
+		return
+	}
+	cmd := q.sub.Request().CmdString()
+	if best > 0 && c.replaceReply(q, best) {
+		if prom.On {
+			prom.Quorum(c.cc.Name, cmd, quorumResultReplace)
+		}
+	}
+	if !diverged {
+		return
+	}
+	if prom.On {
+		prom.Quorum(c.cc.Name, cmd, quorumResultDiverge)
+	}
+	if c.repairs == nil {
+		return
+	}
+	var stales []int
+	for i, idx := range q.idxs {
+		if oks[i] && vs[i] < vs[best] {
+			stales = append(stales, idx)
+		}
+	}
+	c.queueRepair(&quorumRepair{key: append([]byte(nil), q.sub.Request().Key()...), src: q.idxs[best], idxs: stales}, cmd)
+}
+
+// replaceReply replaces the reply of sub by the copy of replica best.
+// NOTE: the replaced request is put with the reads after msgs are encoded.
+func (c *Cluster) replaceReply(q *quorumRead, best int) bool {
+	rm := q.reads[best-1]
+	if rm.Err() != nil {
+		return false
+	}
+	rm.ReplaceRequest(q.sub.ReplaceRequest(rm.Request()))
+	q.sub.DoneWithError(nil)
+	return true
+}
+
+// queueRepair queues r unless its key is queued already, r is dropped when queue is full.
+// NOTE: it never blocks, since it is called by the handler of client.
+func (c *Cluster) queueRepair(r *quorumRepair, cmd string) {
+	rs := c.repairs
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	if rs.pending[string(r.key)] {
+		return
+	}
+	select {
+	case rs.ch <- r:
+		rs.pending[string(r.key)] = true
+	default:
+		if prom.On {
+			prom.Quorum(c.cc.Name, cmd, quorumResultRepairDrop)
+		}
+	}
+}
+
+// processRepair repairs the queued keys one by one until cluster closed.
+func (c *Cluster) processRepair() {
+	for {
+		select {
+		case r := <-c.repairs.ch:
+			c.repairKey(r)
+			c.repairs.lock.Lock()
+			delete(c.repairs.pending, string(r.key))
+			c.repairs.lock.Unlock()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// repairKey copies the key of r from its node of the most recent copy to the stale nodes, whose
+// version is still lower when restored.
+func (c *Cluster) repairKey(r *quorumRepair) {
+	exec, release := c.nodeExecutor()
+	defer release()
+	_, failed, err := redis.RepairKey(exec, r.src, r.key, []byte(c.cc.QuorumRead.VersionField), r.idxs)
+	if err == nil && failed > 0 {
+		err = ErrQuorumRepair
+	}
+	if err != nil {
+		c.repairError(r.key, "restore", err)
+	}
+}
+
+func (c *Cluster) repairError(key []byte, cmd string, err error) {
+	if log.V(2) {
+		log.Warnf("cluster(%s) Msg(%s) quorum repair error:%v", c.cc.Name, key, err)
+	}
+	if prom.On {
+		prom.Quorum(c.cc.Name, cmd, quorumResultRepairError)
+	}
+}
+
+// quorumRelease releases the version reads after msgs are encoded, since the replies of the copies
+// replacing the stale subs are read into them.
+func (c *Cluster) quorumRelease(qr *quorumRound) {
+	if qr == nil {
+		return
+	}
+	proto.PutMsgs(qr.msgs)
+	proto.PutMsgBatchs(qr.mbs)
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"overlord/proto"
+	"overlord/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterQuorumReadLatest(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "quorum",
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Replicas:         3,
+		QuorumRead:       &QuorumReadConfig{Reads: 3, VersionField: "version", Repair: true},
+	}
+	rds := map[string]*_redis{}
+	for _, name := range []string{"redis1", "redis2", "redis3"} {
+		l, rd := _serveRedis(t, map[string]string{})
+		defer l.Close()
+		rd.lock.Lock()
+		rd.hashes["t"] = map[string]string{"version": "1", "v": "same"}
+		rd.lock.Unlock()
+		rds[name] = rd
+		cc.Servers = append(cc.Servers, l.Addr().String()+":1 "+name)
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the node of key s is stale.
+	nodes := c.pool.ring.GetNodes([]byte("s"), 3)
+	for i, node := range nodes {
+		rd := rds[node]
+		rd.lock.Lock()
+		rd.hashes["s"] = map[string]string{"version": "5", "v": "new"}
+		if i == 0 {
+			rd.hashes["s"] = map[string]string{"version": "1", "v": "old"}
+		}
+		rd.lock.Unlock()
+	}
+	msgs := _createRedisMsgs(t, "HGETALL s\r\nHGETALL t\r\n", 2)
+	qr := c.quorumDispatch(msgs)
+	assert.Len(t, qr.reads, 2)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.quorumDone(qr)
+	reply := func(m *proto.Message) (s []string) {
+		for _, item := range m.Request().(*redis.Request).ReplyArray() {
+			s = append(s, string(item))
+		}
+		return
+	}
+	assert.Equal(t, []string{"v", "new", "version", "5"}, reply(msgs[0]))
+	assert.Equal(t, []string{"v", "same", "version", "1"}, reply(msgs[1]))
+	c.quorumRelease(qr)
+	proto.PutMsgBatchs(mbs)
+	proto.PutMsgs(msgs)
+
+	// NOTE: the stale copy is repaired asynchronously.
+	stale := rds[nodes[0]]
+	for i := 0; i < 100; i++ {
+		stale.lock.Lock()
+		v := stale.hashes["s"]["v"]
+		stale.lock.Unlock()
+		if v == "new" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expect stale copy repaired")
+}
+
+func TestClusterQuorumReadAfterWrite(t *testing.T) {
+	cc := &ClusterConfig{
+		Name:             "quorum",
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  2,
+		Replicas:         3,
+		QuorumRead:       &QuorumReadConfig{Reads: 3, VersionField: "version"},
+	}
+	for _, name := range []string{"redis1", "redis2", "redis3"} {
+		l, rd := _serveRedis(t, map[string]string{"x": "1"})
+		defer l.Close()
+		rd.lock.Lock()
+		rd.hashes["s"] = map[string]string{"version": "1"}
+		rd.lock.Unlock()
+		cc.Servers = append(cc.Servers, l.Addr().String()+":1 "+name)
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	// NOTE: the read of string x is not quorum read, and the version of s is read after it is written.
+	msgs := _createRedisMsgs(t, "HSET s version 2\r\nHGET s version\r\nGET x\r\n", 3)
+	rr := c.replicate(msgs)
+	qr := c.quorumDispatch(msgs)
+	assert.Len(t, qr.reads, 1)
+	assert.True(t, qr.deferred)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.replicaDone(rr)
+	c.quorumDone(qr)
+	for _, vm := range qr.reads[0].versions {
+		v, ok := redis.Version(vm.Request().(*redis.Request))
+		assert.True(t, ok)
+		assert.Equal(t, uint64(2), v)
+	}
+	assert.Equal(t, "2", string(msgs[1].Request().(*redis.Request).ReplyData()))
+	c.quorumRelease(qr)
+	proto.PutMsgBatchs(mbs)
+	proto.PutMsgs(msgs)
+}
+
+func TestClusterQuorumRepairQueue(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Replicas:         2,
+		Servers:          []string{"127.0.0.1:6379:1", "127.0.0.1:6380:1"},
+		QuorumRead:       &QuorumReadConfig{Reads: 2, VersionField: "version", Repair: true, RepairQueueSize: 1},
+	})
+	c.queueRepair(&quorumRepair{key: []byte("a"), idxs: []int{1}}, "HGETALL")
+	// NOTE: the key queued already is not queued again, and the others are dropped when queue is full.
+	c.queueRepair(&quorumRepair{key: []byte("a"), idxs: []int{1}}, "HGETALL")
+	c.queueRepair(&quorumRepair{key: []byte("b"), idxs: []int{1}}, "HGETALL")
+	assert.Len(t, c.repairs.ch, 1)
+	assert.Equal(t, map[string]bool{"a": true}, c.repairs.pending)
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"bytes"
+
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/memcache"
+	mcbin "overlord/proto/memcache/binary"
+	"overlord/proto/redis"
+)
+
+// results of replica write.
+const (
+	replicaResultWriteError = "write_error"
+	replicaResultDiverge    = "diverge"
+)
+
+// replicaWrite is the copy of write sub sent to one replica.
+type replicaWrite struct {
+	sub *proto.Message
+	wm  *proto.Message
+	idx int
+	// same is whether wm is the same command as sub, whose reply must be the same as sub.
+	same bool
+}
+
+// replicaRound is the copies of writes sent to the other replicas of keys in one round.
+type replicaRound struct {
+	mbs    []*proto.MsgBatch
+	writes []*replicaWrite
+	// deferred are the copies sent only after their subs are stored, e.g. the cas translated into set.
+	deferred []*replicaWrite
+}
+
+// replicaIndexes returns the indexes of the other replicas of key in pool p, which are the
+// next distinct nodes clockwise on the ring after the node of key.
+// NOTE: the ejected nodes are not on the ring, so the replicas move on to the next healthy nodes.
+func (c *Cluster) replicaIndexes(p *pool, key []byte) (idxs []int) {
+	n := p.cc.replicas()
+	if n <= 1 {
+		return
+	}
+	nodes := p.ring.GetNodes(c.hashKey(key), n)
+	for i := 1; i < len(nodes); i++ {
+		idxs = append(idxs, p.nodeMap[nodes[i]])
+	}
+	return
+}
+
+// dualIndex returns the index of the first failover node of key when the writes of its pool are
+// applied on the failover nodes too, -1 means no node.
+func (c *Cluster) dualIndex(p *pool, key []byte) int {
+	if fc := p.cc.Failover; fc == nil || fc.WritePolicy != FailoverWriteDual {
+		return -1
+	}
+	return c.failoverIndex(key, 1)
+}
+
+// dualWrites reports whether any pool applies the writes on the failover nodes too.
+func (c *Cluster) dualWrites() bool {
+	for _, p := range c.pools() {
+		if fc := p.cc.Failover; fc != nil && fc.WritePolicy == FailoverWriteDual {
+			return true
+		}
+	}
+	return false
+}
+
+// replicate copies the writes of msgs to the other replicas of keys and the failover nodes of dual write
+// policy, and dispatches them along with msgs, the reads are served by the first replica.
+// NOTE: the cross node commands emulated by proxy and the broadcast writes are not replicated.
+func (c *Cluster) replicate(msgs []*proto.Message) (rr *replicaRound) {
+	if c.cc.replicas() <= 1 && !c.dualWrites() {
+		return
+	}
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if req.IsRead() || c.broadcasting(req) || sub.Err() != nil {
+				continue
+			}
+			p := c.route(req.Key())
+			idxs := c.replicaIndexes(p, req.Key())
+			if idx := c.dualIndex(p, req.Key()); idx != -1 && !containsIndex(idxs, idx) {
+				idxs = append(idxs, idx)
+			}
+			for _, idx := range idxs {
+				if rr == nil {
+					rr = &replicaRound{mbs: proto.GetMsgBatchs(len(c.nodeChan))}
+				}
+				req, deferred := replicaRequest(sub.Request())
+				wm := proto.NewMessage()
+				wm.Type = sub.Type
+				wm.WithRequest(req)
+				rw := &replicaWrite{sub: sub, wm: wm, idx: idx, same: bytes.Equal(req.Cmd(), sub.Request().Cmd())}
+				if deferred {
+					rr.deferred = append(rr.deferred, rw)
+					continue
+				}
+				rr.writes = append(rr.writes, rw)
+				rr.mbs[idx].AddMsg(wm)
+			}
+		}
+	}
+	if rr != nil {
+		c.deliver(rr.mbs)
+	}
+	return
+}
+
+// replicaRequest returns the request applying the write req to the other replicas, and whether it is
+// deferred until req is stored, see memcache.ReplicaRequest.
+func replicaRequest(req proto.Request) (proto.Request, bool) {
+	switch req.(type) {
+	case *memcache.MCRequest:
+		return memcache.ReplicaRequest(req)
+	case *mcbin.MCRequest:
+		return mcbin.ReplicaRequest(req)
+	}
+	return req.Clone(), false
+}
+
+// stored reports whether the deferred write req is stored on its node.
+func stored(req proto.Request) bool {
+	switch req.(type) {
+	case *memcache.MCRequest:
+		return memcache.Stored(req)
+	case *mcbin.MCRequest:
+		return mcbin.Stored(req)
+	}
+	return false
+}
+
+// sameReply reports whether the write requests a and b are replied the same.
+func sameReply(a, b proto.Request) bool {
+	switch ra := a.(type) {
+	case *memcache.MCRequest:
+		return memcache.SameReply(a, b)
+	case *mcbin.MCRequest:
+		return mcbin.SameReply(a, b)
+	case *redis.Request:
+		rb, ok := b.(*redis.Request)
+		return ok && ra.ReplyType() == rb.ReplyType() && bytes.Equal(ra.ReplyData(), rb.ReplyData())
+	}
+	return true
+}
+
+// replicaDone waits the writes copied to the other replicas, and sends the deferred ones whose subs are
+// stored. The replicas failed or replied differently from their subs are logged and counted, they are not
+// rolled back.
+func (c *Cluster) replicaDone(rr *replicaRound) {
+	if rr == nil {
+		return
+	}
+	for _, mb := range rr.mbs {
+		mb.Wait()
+	}
+	c.replicaCheck(rr.writes)
+	writes := rr.writes
+	if len(rr.deferred) > 0 {
+		var (
+			mbs  []*proto.MsgBatch
+			sent []*replicaWrite
+		)
+		for _, rw := range rr.deferred {
+			writes = append(writes, rw)
+			if rw.sub.Err() != nil || !stored(rw.sub.Request()) {
+				continue
+			}
+			if mbs == nil {
+				mbs = proto.GetMsgBatchs(len(c.nodeChan))
+			}
+			mbs[rw.idx].AddMsg(rw.wm)
+			sent = append(sent, rw)
+		}
+		if mbs != nil {
+			c.deliver(mbs)
+			for _, mb := range mbs {
+				mb.Wait()
+			}
+			c.replicaCheck(sent)
+			proto.PutMsgBatchs(mbs)
+		}
+	}
+	for _, rw := range writes {
+		proto.PutMsgs([]*proto.Message{rw.wm})
+	}
+	proto.PutMsgBatchs(rr.mbs)
+}
+
+// replicaCheck logs and counts the replica writes failed by errors, or replied differently from their subs.
+func (c *Cluster) replicaCheck(writes []*replicaWrite) {
+	for _, rw := range writes {
+		result := ""
+		if err := rw.wm.Err(); err != nil {
+			if log.V(2) {
+				log.Warnf("cluster(%s) Msg(%s) replica write error:%v", c.cc.Name, rw.wm.Request().Key(), err)
+			}
+			result = replicaResultWriteError
+		} else if rw.same && rw.sub.Err() == nil && !sameReply(rw.sub.Request(), rw.wm.Request()) {
+			if log.V(2) {
+				log.Warnf("cluster(%s) Msg(%s) replica replied differently from node", c.cc.Name, rw.wm.Request().Key())
+			}
+			result = replicaResultDiverge
+		}
+		if result != "" && prom.On {
+			prom.Replica(c.cc.Name, rw.wm.Request().CmdString(), result)
+		}
+	}
+}
+
+func containsIndex(idxs []int, idx int) bool {
+	for _, i := range idxs {
+		if i == idx {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"overlord/proto"
+	"overlord/proto/memcache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterReplicaWriteAndRead(t *testing.T) {
+	l1, m1 := _serveMemcache(t, map[string]string{})
+	defer l1.Close()
+	l2, m2 := _serveMemcache(t, map[string]string{})
+	defer l2.Close()
+	cc := &ClusterConfig{
+		Name:             "replica",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Replicas:         2,
+		Servers:          []string{l1.Addr().String() + ":1 mc1", l2.Addr().String() + ":1 mc2", _deadAddr(t) + ":1 mc3"},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	var cmds string
+	for i := 0; i < 20; i++ {
+		cmds += fmt.Sprintf("set k%d 0 0 1\r\n%d\r\n", i, i%10)
+	}
+	msgs := _createMemcacheMsgs(t, cmds, 20)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	rr := c.replicate(msgs)
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.replicaDone(rr)
+	proto.PutMsgBatchs(mbs)
+	proto.PutMsgs(msgs)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%d", i)
+		nodes := c.pool.ring.GetNodes([]byte(key), 3)
+		assert.Len(t, nodes, 3)
+		for j, m := range map[string]*_memcache{"mc1": m1, "mc2": m2} {
+			_, ok := m.get(key)
+			assert.Equal(t, j != nodes[2], ok, key+" "+j)
+		}
+	}
+
+	// NOTE: the reads failed on the dead node are retried on the next replica.
+	cmds = ""
+	for i := 0; i < 20; i++ {
+		cmds += fmt.Sprintf("get k%d\r\n", i)
+	}
+	msgs = _createMemcacheMsgs(t, cmds, 20)
+	mbs = proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	fmbs := c.failover(mbs, nil)
+	for i, m := range msgs {
+		assert.NoError(t, m.Err())
+		assert.Contains(t, m.Request().(*memcache.MCRequest).String(), fmt.Sprintf("VALUE k%d 0 1\r\n%d\r\n", i, i%10))
+	}
+	for _, fmb := range fmbs {
+		proto.PutMsgBatchs(fmb)
+	}
+	proto.PutMsgBatchs(mbs)
+	proto.PutMsgs(msgs)
+}
+
+func TestClusterReplicaCasAndIncr(t *testing.T) {
+	l1, m1 := _serveMemcache(t, map[string]string{"k": "1"})
+	defer l1.Close()
+	l2, m2 := _serveMemcache(t, map[string]string{"k": "1"})
+	defer l2.Close()
+	for mc, cas := range map[*_memcache]uint64{m1: 5, m2: 7} {
+		mc.lock.Lock()
+		mc.cas = map[string]uint64{"k": cas}
+		mc.lock.Unlock()
+	}
+	cc := &ClusterConfig{
+		Name:             "replica",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Replicas:         2,
+		Servers:          []string{l1.Addr().String() + ":1 mc1", l2.Addr().String() + ":1 mc2"},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	mcs := map[string]*_memcache{"mc1": m1, "mc2": m2}
+	primary, replica := c.pool.ring.GetNodes([]byte("k"), 2)[0], c.pool.ring.GetNodes([]byte("k"), 2)[1]
+	round := func(cmd string) *proto.Message {
+		msgs := _createMemcacheMsgs(t, cmd, 1)
+		mbs := proto.GetMsgBatchs(len(c.nodeChan))
+		rr := c.replicate(msgs)
+		c.DispatchBatch(mbs, msgs)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+		c.replicaDone(rr)
+		proto.PutMsgBatchs(mbs)
+		return msgs[0]
+	}
+
+	// NOTE: the cas unique of primary does not match the replica, which is stored by set.
+	mcs[primary].lock.Lock()
+	cas := mcs[primary].cas["k"]
+	mcs[primary].lock.Unlock()
+	m := round(fmt.Sprintf("cas k 0 0 1 %d\r\n2\r\n", cas))
+	assert.Contains(t, m.Request().(*memcache.MCRequest).String(), "data:STORED")
+	proto.PutMsgs([]*proto.Message{m})
+	for _, mc := range mcs {
+		v, _ := mc.get("k")
+		assert.Equal(t, "2", v)
+	}
+
+	// NOTE: the replica of incr is deleted instead of incremented again.
+	m = round("incr k 3\r\n")
+	assert.Contains(t, m.Request().(*memcache.MCRequest).String(), "data:5")
+	proto.PutMsgs([]*proto.Message{m})
+	v, _ := mcs[primary].get("k")
+	assert.Equal(t, "5", v)
+	_, ok := mcs[replica].get("k")
+	assert.False(t, ok)
+}
+
+func TestClusterReplicaIndexes(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Replicas:         3,
+		Servers:          []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1", "127.0.0.1:11213:1"},
+	})
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint(i))
+		idxs := append([]int{c.calculateBatchIndex(key)}, c.replicaIndexes(c.pool, key)...)
+		assert.Len(t, idxs, 3)
+		assert.NotEqual(t, idxs[0], idxs[1])
+		assert.NotEqual(t, idxs[0], idxs[2])
+		assert.NotEqual(t, idxs[1], idxs[2])
+		assert.Equal(t, idxs[1], c.failoverIndex(key, 1))
+		assert.Equal(t, idxs[2], c.failoverIndex(key, 2))
+		assert.Equal(t, -1, c.failoverIndex(key, 3))
+	}
+	// NOTE: the ejected node is skipped by replicas.
+	c.pool.ring.DelNode("127.0.0.1:11211")
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprint(i))
+		idxs := append([]int{c.calculateBatchIndex(key)}, c.replicaIndexes(c.pool, key)...)
+		assert.Len(t, idxs, 2)
+		assert.NotContains(t, idxs, 0)
+	}
+}
@@ -0,0 +1,331 @@
+package proxy
+
+import (
+	"encoding/json"
+	errs "errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"overlord/lib/backoff"
+	"overlord/lib/hashkit"
+	"overlord/lib/log"
+	"overlord/lib/prom"
+	"overlord/proto"
+	"overlord/proto/redis"
+)
+
+const defaultReshardScanCount = 100
+
+// reshard errors
+var (
+	ErrReshardMove = errs.New("reshard move written key failed")
+)
+
+// results of reshard.
+const (
+	reshardResultScanned = "scanned"
+	reshardResultMoved   = "moved"
+	reshardResultFailed  = "failed"
+)
+
+// reshardNode is the progress of moving keys out of an old node.
+type reshardNode struct {
+	// NOTE: the counters are first for 64 bits alignment of atomic.
+	scanned int64
+	moved   int64
+	failed  int64
+	done    int32
+	addr    string
+	idx     int
+}
+
+func (n *reshardNode) count(cluster, result string, cnt *int64, delta int) {
+	if delta == 0 {
+		return
+	}
+	atomic.AddInt64(cnt, int64(delta))
+	if prom.On {
+		prom.Reshard(cluster, n.addr, result, delta)
+	}
+}
+
+// reshard moves the keys of old nodes whose owner changed to the added nodes.
+type reshard struct {
+	rc *ReshardConfig
+	// old is the ring without added nodes.
+	old   *hashkit.HashRing
+	added map[int]bool
+	nodes []*reshardNode
+	done  int32
+}
+
+// initReshard inits the ring of old nodes.
+func (c *Cluster) initReshard() {
+	rc := c.cc.Reshard
+	if rc == nil {
+		return
+	}
+	rs := &reshard{rc: rc, added: make(map[int]bool)}
+	p := c.pool
+	for _, node := range rc.Nodes {
+		for i, addr := range p.addrs {
+			if addr == node {
+				rs.added[p.base+i] = true
+			}
+		}
+	}
+	names := make([]string, len(p.addrs))
+	for name, idx := range p.nodeMap {
+		names[idx-p.base] = name
+	}
+	var (
+		nodes []string
+		ws    []int
+	)
+	for i, addr := range p.addrs {
+		if rs.added[p.base+i] {
+			continue
+		}
+		nodes = append(nodes, names[i])
+		ws = append(ws, p.ws[i])
+		rs.nodes = append(rs.nodes, &reshardNode{addr: addr, idx: p.base + i})
+	}
+	rs.old = hashkit.NewRingWithPoints(p.cc.HashDistribution, p.cc.HashMethod, p.cc.PointsPerServer)
+	rs.old.Init(nodes, ws)
+	c.reshard = rs
+}
+
+func (rs *reshard) moving() bool {
+	return atomic.LoadInt32(&rs.done) == 0
+}
+
+// reshardIndex returns the index of old owner which the read of key falls back to while moving,
+// -1 means the owner of key is not changed.
+func (c *Cluster) reshardIndex(key []byte) int {
+	if c.route(key) != c.pool || !c.reshard.added[c.poolIndex(c.pool, key)] {
+		return -1
+	}
+	node, ok := c.reshard.old.GetNode(c.hashKey(key))
+	if !ok {
+		return -1
+	}
+	return c.pool.nodeMap[node]
+}
+
+// reshardMove moves the keys written by subs from their old owners of idxs before the writes are
+// dispatched to new owners. The subs whose keys failed to move are done with error and never sent,
+// since the partial writes on new owners would hide the whole value left on old owners.
+func (c *Cluster) reshardMove(subs []*proto.Message, idxs []int) {
+	exec, release := c.nodeExecutor()
+	defer release()
+	var (
+		srcs  []int
+		keys  = map[int][][]byte{}
+		dsts  = map[int][]string{}
+		group = map[int][]*proto.Message{}
+		seen  = map[string]bool{}
+	)
+	for i, sub := range subs {
+		src, key := idxs[i], sub.Request().Key()
+		if _, ok := group[src]; !ok {
+			srcs = append(srcs, src)
+		}
+		group[src] = append(group[src], sub)
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		keys[src] = append(keys[src], key)
+		dsts[src] = append(dsts[src], c.reshardAddr(key))
+	}
+	for _, src := range srcs {
+		n := c.reshard.node(src)
+		moved, failed, err := redis.MoveKeys(exec, src, keys[src], dsts[src], c.cc.ReadTimeout)
+		n.count(c.cc.Name, reshardResultMoved, &n.moved, moved)
+		n.count(c.cc.Name, reshardResultFailed, &n.failed, failed)
+		if err == nil && failed > 0 {
+			err = ErrReshardMove
+		}
+		if err == nil {
+			continue
+		}
+		if log.V(2) {
+			log.Warnf("cluster(%s) node(%s) reshard move written keys error:%v", c.cc.Name, n.addr, err)
+		}
+		for _, sub := range group[src] {
+			sub.DoneWithError(ErrReshardMove)
+		}
+	}
+}
+
+// reshardAddr returns the address of new owner of key, which the old owner migrates the key to.
+func (c *Cluster) reshardAddr(key []byte) string {
+	return c.pool.addrs[c.poolIndex(c.pool, key)-c.pool.base]
+}
+
+// node returns the progress of old node idx.
+func (rs *reshard) node(idx int) *reshardNode {
+	for _, n := range rs.nodes {
+		if n.idx == idx {
+			return n
+		}
+	}
+	return nil
+}
+
+// processReshard moves the keys of old nodes one by one until done or cluster closed.
+func (c *Cluster) processReshard() {
+	start := time.Now()
+	for _, n := range c.reshard.nodes {
+		if !c.reshardNode(n) {
+			return
+		}
+	}
+	atomic.StoreInt32(&c.reshard.done, 1)
+	log.Infof("cluster(%s) reshard is done after %v", c.cc.Name, time.Since(start))
+}
+
+// reshardNode scans the keys of old node n and moves the keys owned by added nodes, the failed scan
+// is retried with backoff. It returns false when cluster closed.
+func (c *Cluster) reshardNode(n *reshardNode) bool {
+	var (
+		cursor  = []byte("0")
+		retries int
+	)
+	for {
+		select {
+		case <-c.ctx.Done():
+			return false
+		default:
+		}
+		next, err := c.reshardPage(n, cursor)
+		if err != nil {
+			log.Warnf("cluster(%s) node(%s) reshard cursor(%s) error:%v", c.cc.Name, n.addr, cursor, err)
+			select {
+			case <-time.After(backoff.Backoff(retries)):
+				retries++
+				continue
+			case <-c.ctx.Done():
+				return false
+			}
+		}
+		retries = 0
+		if next == nil {
+			atomic.StoreInt32(&n.done, 1)
+			log.Infof("cluster(%s) node(%s) reshard is done, moved %d keys", c.cc.Name, n.addr, atomic.LoadInt64(&n.moved))
+			return true
+		}
+		cursor = next
+	}
+}
+
+// reshardPage scans one page of keys from cursor and moves the keys owned by added nodes.
+func (c *Cluster) reshardPage(n *reshardNode, cursor []byte) (next []byte, err error) {
+	exec, release := c.nodeExecutor()
+	defer release()
+	next, keys, err := redis.Scan(exec, n.idx, cursor, c.reshard.rc.scanCount())
+	if err != nil {
+		return
+	}
+	n.count(c.cc.Name, reshardResultScanned, &n.scanned, len(keys))
+	var (
+		moves [][]byte
+		dsts  []string
+	)
+	for _, key := range keys {
+		if c.route(key) != c.pool {
+			continue
+		}
+		if idx := c.poolIndex(c.pool, key); idx != n.idx && c.reshard.added[idx] {
+			moves = append(moves, key)
+			dsts = append(dsts, c.reshardAddr(key))
+		}
+	}
+	if len(moves) == 0 {
+		return
+	}
+	moved, failed, err := redis.MoveKeys(exec, n.idx, moves, dsts, c.cc.ReadTimeout)
+	n.count(c.cc.Name, reshardResultMoved, &n.moved, moved)
+	n.count(c.cc.Name, reshardResultFailed, &n.failed, failed)
+	return
+}
+
+// nodeExecutor returns the executor of redis requests on cluster nodes, release must be called
+// after the replies are used.
+func (c *Cluster) nodeExecutor() (exec redis.NodeExecutor, release func()) {
+	var (
+		msgs []*proto.Message
+		mbss [][]*proto.MsgBatch
+	)
+	exec = func(nodes []int, reqs []*redis.Request) error {
+		mbs := proto.GetMsgBatchs(len(c.nodeChan))
+		mbss = append(mbss, mbs)
+		round := make([]*proto.Message, len(reqs))
+		for i, req := range reqs {
+			round[i] = proto.NewMessage()
+			round[i].Type = proto.CacheTypeRedis
+			round[i].WithRequest(req)
+			mbs[nodes[i]].AddMsg(round[i])
+		}
+		msgs = append(msgs, round...)
+		c.deliver(mbs)
+		for _, mb := range mbs {
+			mb.Wait()
+		}
+		for _, rm := range round {
+			if err := rm.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	release = func() {
+		proto.PutMsgs(msgs)
+		for _, mbs := range mbss {
+			proto.PutMsgBatchs(mbs)
+		}
+	}
+	return
+}
+
+type reshardNodeStatus struct {
+	Addr    string `json:"addr"`
+	Scanned int64  `json:"scanned"`
+	Moved   int64  `json:"moved"`
+	Failed  int64  `json:"failed"`
+	Done    bool   `json:"done"`
+}
+
+type reshardStatus struct {
+	Cluster string               `json:"cluster"`
+	Done    bool                 `json:"done"`
+	Nodes   []*reshardNodeStatus `json:"nodes"`
+}
+
+// HandleReshard serves the reshard progress of cluster by http, e.g. GET /reshard?cluster=redis.
+func (p *Proxy) HandleReshard(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("cluster")
+	clusters := p.clustersByName(name)
+	if len(clusters) == 0 {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	}
+	rs := clusters[0].reshard
+	if rs == nil {
+		http.Error(w, "cluster has no reshard", http.StatusBadRequest)
+		return
+	}
+	status := &reshardStatus{Cluster: name, Done: !rs.moving()}
+	for _, n := range rs.nodes {
+		status.Nodes = append(status.Nodes, &reshardNodeStatus{
+			Addr:    n.addr,
+			Scanned: atomic.LoadInt64(&n.scanned),
+			Moved:   atomic.LoadInt64(&n.moved),
+			Failed:  atomic.LoadInt64(&n.failed),
+			Done:    atomic.LoadInt32(&n.done) == 1,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,411 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"overlord/proto"
+	"overlord/proto/redis"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _redisAddrs is the fake redis of address, which MIGRATE moves keys to.
+var _redisAddrs sync.Map
+
+type _redis struct {
+	lock   sync.Mutex
+	values map[string]string
+	hashes map[string]map[string]string
+	noScan bool
+}
+
+func (r *_redis) get(key string) (v string, ok bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	v, ok = r.values[key]
+	return
+}
+
+func (r *_redis) exec(args []string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	bulk := func(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+	switch strings.ToUpper(args[0]) {
+	case "SCAN":
+		if r.noScan {
+			return "-ERR scan disabled\r\n"
+		}
+		reply := fmt.Sprintf("*2\r\n%s*%d\r\n", bulk("0"), len(r.values))
+		for k := range r.values {
+			reply += bulk(k)
+		}
+		return reply
+	case "GET", "DUMP":
+		if v, ok := r.values[args[1]]; ok {
+			return bulk(v)
+		}
+		if h, ok := r.hashes[args[1]]; ok && args[0] == "DUMP" {
+			return bulk(_dumpHash(h))
+		}
+		return "$-1\r\n"
+	case "HGET":
+		if v, ok := r.hashes[args[1]][args[2]]; ok {
+			return bulk(v)
+		}
+		return "$-1\r\n"
+	case "HGETALL":
+		h := r.hashes[args[1]]
+		fields := make([]string, 0, len(h))
+		for f := range h {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		reply := fmt.Sprintf("*%d\r\n", 2*len(fields))
+		for _, f := range fields {
+			reply += bulk(f) + bulk(h[f])
+		}
+		return reply
+	case "PTTL":
+		return ":-1\r\n"
+	case "MEMORY":
+		v, ok := r.values[args[2]]
+		if h, hok := r.hashes[args[2]]; hok {
+			v, ok = _dumpHash(h), true
+		}
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", len(v))
+	case "EXISTS":
+		_, ok := r.values[args[1]]
+		if _, hok := r.hashes[args[1]]; ok || hok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "RESTORE":
+		_, ok := r.values[args[1]]
+		if _, hok := r.hashes[args[1]]; (ok || hok) && (len(args) < 5 || args[4] != "REPLACE") {
+			return "-BUSYKEY Target key name already exists.\r\n"
+		}
+		delete(r.values, args[1])
+		delete(r.hashes, args[1])
+		if strings.HasPrefix(args[3], "hash:") {
+			r.hashes[args[1]] = _restoreHash(args[3])
+		} else {
+			r.values[args[1]] = args[3]
+		}
+		return "+OK\r\n"
+	case "EVAL":
+		// NOTE: only the repair script of quorum read is served, args are script, numkeys, key, field, version, payload and ttl.
+		stored, _ := strconv.ParseUint(r.hashes[args[3]][args[4]], 10, 64)
+		if version, _ := strconv.ParseUint(args[5], 10, 64); stored >= version {
+			return ":0\r\n"
+		}
+		r.hashes[args[3]] = _restoreHash(args[6])
+		return ":1\r\n"
+	case "MIGRATE":
+		v, ok := r.values[args[3]]
+		h, hok := r.hashes[args[3]]
+		if !ok && !hok {
+			return "+NOKEY\r\n"
+		}
+		d, _ := _redisAddrs.Load(args[1] + ":" + args[2])
+		dst := d.(*_redis)
+		dst.lock.Lock()
+		defer dst.lock.Unlock()
+		_, dok := dst.values[args[3]]
+		if _, dhok := dst.hashes[args[3]]; dok || dhok {
+			return "-ERR Target instance replied with error: BUSYKEY Target key name already exists.\r\n"
+		}
+		if ok {
+			dst.values[args[3]] = v
+		} else {
+			dst.hashes[args[3]] = h
+		}
+		delete(r.values, args[3])
+		delete(r.hashes, args[3])
+		return "+OK\r\n"
+	case "SET":
+		r.values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "HSET":
+		h, ok := r.hashes[args[1]]
+		if !ok {
+			h = map[string]string{}
+			r.hashes[args[1]] = h
+		}
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				added++
+			}
+			h[args[i]] = args[i+1]
+		}
+		return fmt.Sprintf(":%d\r\n", added)
+	case "DEL":
+		delete(r.values, args[1])
+		delete(r.hashes, args[1])
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+// _dumpHash returns the dumped payload of hash h.
+func _dumpHash(h map[string]string) string {
+	fields := make([]string, 0, len(h))
+	for f, v := range h {
+		fields = append(fields, f+"="+v)
+	}
+	sort.Strings(fields)
+	return "hash:" + strings.Join(fields, ",")
+}
+
+func _restoreHash(payload string) map[string]string {
+	h := map[string]string{}
+	for _, fv := range strings.Split(strings.TrimPrefix(payload, "hash:"), ",") {
+		if kv := strings.SplitN(fv, "=", 2); len(kv) == 2 {
+			h[kv[0]] = kv[1]
+		}
+	}
+	return h
+}
+
+// _serveRedis serves SCAN, GET, HGET, HGETALL, DUMP, PTTL, MEMORY, EXISTS, RESTORE, EVAL, MIGRATE, SET, HSET and DEL
+// of values and hashes until listener closed, the dumped payload is the value itself or the fields of hash, whose
+// length is the memory usage.
+func _serveRedis(t *testing.T, values map[string]string) (net.Listener, *_redis) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	rd := &_redis{values: values, hashes: map[string]map[string]string{}}
+	_redisAddrs.Store(l.Addr().String(), rd)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						return
+					}
+					n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+					args := make([]string, n)
+					for i := range args {
+						line, _ = br.ReadString('\n')
+						size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+						buf := make([]byte, size+2)
+						if _, err = io.ReadFull(br, buf); err != nil {
+							return
+						}
+						args[i] = string(buf[:size])
+					}
+					fmt.Fprint(conn, rd.exec(args))
+				}
+			}(conn)
+		}
+	}()
+	return l, rd
+}
+
+func _reshardConfig(old, added string) *ClusterConfig {
+	return &ClusterConfig{
+		Name:             "reshard",
+		CacheType:        proto.CacheTypeRedis,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{old + ":1 redis-old-1", added + ":1 redis-new-1"},
+		Reshard:          &ReshardConfig{Nodes: []string{added}, ScanCount: 10},
+	}
+}
+
+func TestClusterReshardMoveKeys(t *testing.T) {
+	values := map[string]string{}
+	for i := 0; i < 50; i++ {
+		values[fmt.Sprintf("key-%d", i)] = fmt.Sprintf("v%d", i)
+	}
+	ol, old := _serveRedis(t, values)
+	defer ol.Close()
+	al, added := _serveRedis(t, map[string]string{})
+	defer al.Close()
+	cc := _reshardConfig(ol.Addr().String(), al.Addr().String())
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	for i := 0; i < 100 && c.reshard.moving(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, c.reshard.moving())
+
+	moved := 0
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owner, other := old, added
+		if c.calculateBatchIndex([]byte(key)) == 1 {
+			owner, other = added, old
+			moved++
+		}
+		v, ok := owner.get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, fmt.Sprintf("v%d", i), v)
+		_, ok = other.get(key)
+		assert.False(t, ok, key)
+	}
+	assert.True(t, moved > 0)
+	n := c.reshard.nodes[0]
+	assert.Equal(t, int64(50), n.scanned)
+	assert.Equal(t, int64(moved), n.moved)
+
+	p := &Proxy{clusters: map[string]*Cluster{"reshard": c}}
+	w := httptest.NewRecorder()
+	p.HandleReshard(w, httptest.NewRequest(http.MethodGet, "/reshard?cluster=reshard", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), fmt.Sprintf(`"done":true,"nodes":[{"addr":"%s","scanned":50,"moved":%d`, ol.Addr().String(), moved))
+}
+
+func TestClusterReshardFallback(t *testing.T) {
+	ol, old := _serveRedis(t, map[string]string{})
+	defer ol.Close()
+	// NOTE: the old node can not be scanned, so keys are never moved.
+	old.noScan = true
+	al, added := _serveRedis(t, map[string]string{})
+	defer al.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, _reshardConfig(ol.Addr().String(), al.Addr().String()))
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); c.calculateBatchIndex([]byte(k)) == 1 {
+			key = k
+		}
+	}
+	assert.Equal(t, 0, c.reshardIndex([]byte(key)))
+	old.lock.Lock()
+	old.values[key] = "v"
+	old.lock.Unlock()
+
+	msgs := _createRedisMsgs(t, "GET "+key+"\r\nGET none\r\n", 2)
+	assert.Equal(t, "$1\r\nv\r\n$-1\r\n", _handleRound(c, msgs))
+	assert.True(t, c.reshard.moving())
+
+	// NOTE: the empty array of missing key falls back, but the missing field of existing key does not.
+	var hkey string
+	for i := 0; hkey == ""; i++ {
+		if k := fmt.Sprintf("hash-%d", i); c.calculateBatchIndex([]byte(k)) == 1 {
+			hkey = k
+		}
+	}
+	old.lock.Lock()
+	old.hashes[hkey] = map[string]string{"a": "1", "b": "2"}
+	old.hashes[key] = map[string]string{"b": "stale"}
+	old.lock.Unlock()
+	added.lock.Lock()
+	added.hashes[key] = map[string]string{"a": "new"}
+	added.lock.Unlock()
+	msgs = _createRedisMsgs(t, "HGETALL "+hkey+"\r\nHGET "+key+" b\r\n", 2)
+	assert.Equal(t, "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$-1\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterReshardWriteMove(t *testing.T) {
+	ol, old := _serveRedis(t, map[string]string{})
+	defer ol.Close()
+	old.noScan = true
+	al, added := _serveRedis(t, map[string]string{})
+	defer al.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, _reshardConfig(ol.Addr().String(), al.Addr().String()))
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		if k := fmt.Sprintf("key-%d", i); c.calculateBatchIndex([]byte(k)) == 1 {
+			keys = append(keys, k)
+		}
+	}
+	old.lock.Lock()
+	old.values[keys[0]] = "v0"
+	old.values[keys[1]] = "v1"
+	old.hashes[keys[2]] = map[string]string{"a": "1", "b": "2"}
+	old.lock.Unlock()
+
+	msgs := _createRedisMsgs(t, "SET "+keys[0]+" n0\r\nDEL "+keys[1]+"\r\nHSET "+keys[2]+" c 3\r\n", 3)
+	assert.Equal(t, "+OK\r\n:1\r\n:1\r\n", _handleRound(c, msgs))
+	v, ok := added.get(keys[0])
+	assert.True(t, ok)
+	assert.Equal(t, "n0", v)
+	_, ok = old.get(keys[0])
+	assert.False(t, ok, "the old value of written key must be moved")
+	_, ok = old.get(keys[1])
+	assert.False(t, ok, "the deleted key must be moved and deleted")
+	_, ok = added.get(keys[1])
+	assert.False(t, ok, "the deleted key must be deleted on new node")
+	added.lock.Lock()
+	assert.Equal(t, map[string]string{"a": "1", "b": "2", "c": "3"}, added.hashes[keys[2]], "the partial write must apply on the moved value")
+	added.lock.Unlock()
+	old.lock.Lock()
+	_, ok = old.hashes[keys[2]]
+	old.lock.Unlock()
+	assert.False(t, ok)
+
+	msgs = _createRedisMsgs(t, "GET "+keys[1]+"\r\n", 1)
+	assert.Equal(t, "$-1\r\n", _handleRound(c, msgs))
+}
+
+func TestClusterReshardEmulate(t *testing.T) {
+	ol, old := _serveRedis(t, map[string]string{})
+	defer ol.Close()
+	old.noScan = true
+	al, added := _serveRedis(t, map[string]string{})
+	defer al.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cc := _reshardConfig(ol.Addr().String(), al.Addr().String())
+	cc.CrossNodeMode = CrossNodeBestEffort
+	c := NewCluster(ctx, cc)
+	var src, dst string
+	for i := 0; src == "" || dst == ""; i++ {
+		k := fmt.Sprintf("key-%d", i)
+		if c.calculateBatchIndex([]byte(k)) == 0 {
+			src = k
+		} else {
+			dst = k
+		}
+	}
+	old.lock.Lock()
+	old.values[src] = "v"
+	old.values[dst] = "stale"
+	old.lock.Unlock()
+
+	msgs := _createRedisMsgs(t, "RENAME "+src+" "+dst+"\r\n", 1)
+	assert.True(t, c.isCross(msgs[0]))
+	c.emulate(msgs[0])
+	assert.NoError(t, msgs[0].Err())
+	assert.Equal(t, "OK", string(msgs[0].Request().(*redis.Request).ReplyData()))
+	proto.PutMsgs(msgs)
+	v, ok := added.get(dst)
+	assert.True(t, ok)
+	assert.Equal(t, "v", v)
+	_, ok = old.get(src)
+	assert.False(t, ok)
+	_, ok = old.get(dst)
+	assert.False(t, ok, "the old value of renamed key must be deleted on old node")
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"regexp"
+
+	"overlord/lib/hashkit"
+)
+
+// defaultPoolName is the name of pool serving the keys not matching any route.
+const defaultPoolName = "default"
+
+// pool is a group of servers with its own hash ring and config.
+type pool struct {
+	name  string
+	cc    *ClusterConfig
+	ring  *hashkit.HashRing
+	addrs []string
+	ws    []int
+	// nodeMap maps the node of ring, which is alias when servers are aliased, to index of cluster nodes.
+	nodeMap map[string]int
+	// base is the index of the first addr in cluster nodes.
+	base int
+	// failover is the pool of failover servers.
+	failover *pool
+}
+
+func newPool(name string, cc *ClusterConfig, base int) *pool {
+	addrs, ws, ans, alias, err := parseServers(cc.Servers)
+	if err != nil {
+		panic(err)
+	}
+	p := &pool{name: name, cc: cc, addrs: addrs, ws: ws, nodeMap: make(map[string]int), base: base}
+	p.ring = hashkit.NewRingWithPoints(cc.HashDistribution, cc.HashMethod, cc.PointsPerServer)
+	nodes := addrs
+	if alias {
+		nodes = ans
+	}
+	p.ring.Init(nodes, ws)
+	for i, node := range nodes {
+		p.nodeMap[node] = base + i
+	}
+	return p
+}
+
+// route sends the keys matching prefix or regex to pool.
+type route struct {
+	name   string
+	prefix []byte
+	regex  *regexp.Regexp
+	pool   *pool
+}
+
+func (r *route) match(key []byte) bool {
+	if r.regex != nil {
+		return r.regex.Match(key)
+	}
+	return bytes.HasPrefix(key, r.prefix)
+}
+
+// initPools inits the default pool and the pools of routes, nodes of all pools are indexed in order.
+func (c *Cluster) initPools() {
+	c.pool = c.addPool(defaultPoolName, c.cc)
+	for _, rc := range c.cc.Routes {
+		r := &route{name: rc.Name, pool: c.addPool(rc.Name, rc.clusterConfig(c.cc))}
+		if rc.Regex != "" {
+			r.regex = regexp.MustCompile(rc.Regex)
+		} else {
+			r.prefix = []byte(rc.prefix())
+		}
+		c.routes = append(c.routes, r)
+	}
+}
+
+// addPool adds the pool and its failover pool, whose nodes are indexed after the added pools.
+func (c *Cluster) addPool(name string, cc *ClusterConfig) *pool {
+	base := 0
+	for _, p := range c.allPools {
+		base += len(p.addrs)
+	}
+	p := newPool(name, cc, base)
+	c.allPools = append(c.allPools, p)
+	if fc := cc.Failover; fc != nil && fc.Mode == FailoverPool {
+		p.failover = c.addPool(name+"-failover", fc.clusterConfig(cc))
+	}
+	return p
+}
+
+// pools returns all the pools, including the pools of routes and failover.
+func (c *Cluster) pools() []*pool {
+	return c.allPools
+}
+
+// route returns the pool of the first route matching key, or the canary pool when key is split
+// to canary, or the new pool of migration when its phase reads new servers, or the default pool.
+func (c *Cluster) route(key []byte) *pool {
+	for _, r := range c.routes {
+		if r.match(key) {
+			return r.pool
+		}
+	}
+	if c.canary != nil && c.canary.match(c.hashKey(key)) {
+		return c.canary.pool
+	}
+	if c.migration != nil && c.migration.phase() != MigrationDualWrite {
+		return c.migration.pool
+	}
+	return c.pool
+}
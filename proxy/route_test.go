@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// _createCluster creates the cluster without connecting to nodes.
+func _createCluster(cc *ClusterConfig) *Cluster {
+	c := newCluster(context.Background(), cc)
+	c.nodeChan = make(map[int]*batchChanel)
+	for _, p := range c.pools() {
+		for i := range p.addrs {
+			c.nodeChan[p.base+i] = newBatchChanel(1)
+		}
+	}
+	return c
+}
+
+func TestClusterRouteByPrefixAndRegex(t *testing.T) {
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		HashTag:          "{}",
+		Servers:          []string{"127.0.0.1:11211:1", "127.0.0.1:11212:1"},
+		Routes: []*RouteConfig{
+			{Name: "user", Prefix: "user:*", ReadTimeout: 50, Servers: []string{"127.0.0.1:11213:1 user-1", "127.0.0.1:11214:1 user-2"}},
+			{Name: "feed", Regex: "^feed:[0-9]+$", HashDistribution: "ketama", Servers: []string{"127.0.0.1:11215:1"}},
+		},
+	})
+	assert.Len(t, c.pools(), 3)
+	assert.Len(t, c.nodeChan, 5)
+	assert.Equal(t, 50, c.routes[0].pool.cc.ReadTimeout)
+
+	ts := []struct {
+		Key    string
+		Expect []int
+	}{
+		{Key: "user:1", Expect: []int{2, 3}},
+		{Key: "user:{a}", Expect: []int{2, 3}},
+		{Key: "feed:1", Expect: []int{4}},
+		{Key: "feed:x", Expect: []int{0, 1}},
+		{Key: "a", Expect: []int{0, 1}},
+	}
+	for _, tt := range ts {
+		assert.Contains(t, tt.Expect, c.calculateBatchIndex([]byte(tt.Key)), tt.Key)
+	}
+	// NOTE: keys of user pool are hashed over both nodes.
+	hit := map[int]bool{}
+	for i := 0; i < 1000; i++ {
+		hit[c.calculateBatchIndex([]byte(fmt.Sprintf("user:%d", i)))] = true
+	}
+	assert.Equal(t, map[int]bool{2: true, 3: true}, hit)
+}
@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"time"
+
+	"overlord/lib/prom"
+	"overlord/proto"
+)
+
+const (
+	shadowPoolName = "shadow"
+
+	defaultShadowQueueSize = 1024
+	// shadowBatchSize is the max count of queued msgs mirrored as one batch.
+	shadowBatchSize = 128
+)
+
+// results of shadow compared with primary.
+const (
+	shadowResultOK      = "ok"
+	shadowResultDrop    = "drop"
+	shadowResultError   = "error"
+	shadowResultRecover = "recover"
+	shadowResultSlower  = "slower"
+)
+
+// shadowMsg is the copy of msg mirrored to shadow with the result of primary.
+type shadowMsg struct {
+	msg        *proto.Message
+	primary    *proto.Message
+	primaryDur time.Duration
+	primaryErr bool
+}
+
+// shadow mirrors the sampled msgs to the shadow pool by a bounded queue.
+type shadow struct {
+	sc       *ShadowConfig
+	pool     *pool
+	prefixes [][]byte
+	ch       chan *shadowMsg
+}
+
+// initShadow inits the shadow pool and queue.
+func (c *Cluster) initShadow() {
+	sc := c.cc.Shadow
+	if sc == nil {
+		return
+	}
+	s := &shadow{sc: sc, ch: make(chan *shadowMsg, sc.queueSize())}
+	for _, prefix := range sc.Prefixes {
+		s.prefixes = append(s.prefixes, []byte(prefix))
+	}
+	s.pool = c.addPool(shadowPoolName, sc.clusterConfig(c.cc))
+	c.shadow = s
+}
+
+// match reports whether req is sampled and matches the prefixes and commands.
+func (s *shadow) match(req proto.Request) bool {
+	if s.sc.Percent < 100 && rand.Float64()*100 >= s.sc.Percent {
+		return false
+	}
+	if len(s.prefixes) > 0 {
+		matched := false
+		for _, prefix := range s.prefixes {
+			if bytes.HasPrefix(req.Key(), prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(s.sc.Commands) > 0 {
+		cmd := req.CmdString()
+		for _, c := range s.sc.Commands {
+			if strings.EqualFold(c, cmd) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// shadowCopy copies the matched msgs before they are sent to primary.
+func (c *Cluster) shadowCopy(msgs []*proto.Message) (sms []*shadowMsg) {
+	if c.shadow == nil {
+		return
+	}
+	for _, m := range msgs {
+		subs := []*proto.Message{m}
+		if m.IsBatch() {
+			subs = m.Batch()
+		}
+		for _, sub := range subs {
+			req := sub.Request()
+			if req == nil || !c.shadow.match(req) {
+				continue
+			}
+			sms = append(sms, &shadowMsg{msg: c.copyMsg(sub), primary: sub})
+		}
+	}
+	return
+}
+
+// mirror puts the copied msgs into shadow queue with the result of primary, the msgs are dropped
+// when queue is full. NOTE: it never blocks, the primary msgs must be done.
+func (c *Cluster) mirror(sms []*shadowMsg) {
+	for _, sm := range sms {
+		sm.primaryDur = sm.primary.RemoteDur()
+		sm.primaryErr = sm.primary.Err() != nil
+		sm.primary = nil
+		select {
+		case c.shadow.ch <- sm:
+		default:
+			if prom.On {
+				prom.Shadow(c.cc.Name, sm.msg.Request().CmdString(), shadowResultDrop)
+			}
+			proto.PutMsgs([]*proto.Message{sm.msg})
+		}
+	}
+}
+
+// processShadow sends the queued msgs to shadow nodes and reports the results until cluster closed.
+func (c *Cluster) processShadow() {
+	var (
+		mbs = proto.GetMsgBatchs(len(c.nodeChan))
+		sms = make([]*shadowMsg, 0, shadowBatchSize)
+	)
+	defer proto.PutMsgBatchs(mbs)
+	for {
+		select {
+		case sm := <-c.shadow.ch:
+			sms = append(sms, sm)
+		case <-c.ctx.Done():
+			return
+		}
+	drain:
+		for len(sms) < shadowBatchSize {
+			select {
+			case sm := <-c.shadow.ch:
+				sms = append(sms, sm)
+			default:
+				break drain
+			}
+		}
+		c.shadowBatch(mbs, sms)
+		for i := range sms {
+			sms[i] = nil
+		}
+		sms = sms[:0]
+	}
+}
+
+func (c *Cluster) shadowBatch(mbs []*proto.MsgBatch, sms []*shadowMsg) {
+	p := c.shadow.pool
+	for _, sm := range sms {
+		if node, ok := p.ring.GetNode(c.hashKey(sm.msg.Request().Key())); ok {
+			mbs[p.nodeMap[node]].AddMsg(sm.msg)
+		} else {
+			sm.msg.DoneWithError(ErrNotAvaiableNode)
+		}
+	}
+	c.deliver(mbs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	for _, sm := range sms {
+		c.reportShadow(sm)
+		proto.PutMsgs([]*proto.Message{sm.msg})
+	}
+	for _, mb := range mbs {
+		mb.Reset()
+	}
+}
+
+// reportShadow reports the latency of shadow and the result compared with primary.
+func (c *Cluster) reportShadow(sm *shadowMsg) {
+	if !prom.On {
+		return
+	}
+	cmd := sm.msg.Request().CmdString()
+	dur := sm.msg.RemoteDur()
+	prom.ShadowTime(c.cc.Name, cmd, int64(dur/time.Microsecond))
+	prom.Shadow(c.cc.Name, cmd, shadowResult(sm.primaryErr, sm.msg.Err() != nil, sm.primaryDur, dur))
+}
+
+// shadowResult compares the shadow with primary, errors are compared before latency.
+func shadowResult(primaryErr, shadowErr bool, primaryDur, shadowDur time.Duration) string {
+	switch {
+	case shadowErr && !primaryErr:
+		return shadowResultError
+	case primaryErr && !shadowErr:
+		return shadowResultRecover
+	case shadowDur > primaryDur:
+		return shadowResultSlower
+	}
+	return shadowResultOK
+}
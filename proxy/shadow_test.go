@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"overlord/proto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterShadowMirror(t *testing.T) {
+	pl, _ := _serveMemcache(t, map[string]string{})
+	defer pl.Close()
+	sl, shadowMC := _serveMemcache(t, map[string]string{})
+	defer sl.Close()
+	cc := &ClusterConfig{
+		Name:             "shadow",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{pl.Addr().String() + ":1"},
+		Shadow:           &ShadowConfig{Servers: []string{sl.Addr().String() + ":1"}, Percent: 100, Commands: []string{"SET"}},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+
+	msgs := _createMemcacheMsgs(t, "set a 0 0 1\r\nx\r\nset b 0 0 1\r\ny\r\nget a\r\n", 3)
+	sms := c.shadowCopy(msgs)
+	assert.Len(t, sms, 2)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.mirror(sms)
+	// NOTE: the mirrored requests own their data.
+	proto.PutMsgs(msgs)
+	for i := 0; i < 100; i++ {
+		if _, ok := shadowMC.get("b"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for key, expect := range map[string]string{"a": "x", "b": "y"} {
+		v, ok := shadowMC.get(key)
+		assert.True(t, ok)
+		assert.Equal(t, expect, v)
+	}
+}
+
+func TestClusterShadowMatchAndDrop(t *testing.T) {
+	sc := &ShadowConfig{Servers: []string{"127.0.0.1:11212:1"}, Percent: 100, Prefixes: []string{"user:"}, QueueSize: 1}
+	c := _createCluster(&ClusterConfig{
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		Servers:          []string{"127.0.0.1:11211:1"},
+		Shadow:           sc,
+	})
+	assert.Len(t, c.nodeChan, 2)
+
+	msgs := _createMemcacheMsgs(t, "get user:1 feed:1\r\nget user:2\r\n", 2)
+	sms := c.shadowCopy(msgs)
+	assert.Len(t, sms, 2)
+	for _, sm := range sms {
+		sm.primary.DoneWithError(ErrNotAvaiableNode)
+	}
+	c.mirror(sms)
+	// NOTE: user:2 is dropped by queue size.
+	if assert.Len(t, c.shadow.ch, 1) {
+		sm := <-c.shadow.ch
+		assert.Equal(t, "user:1", string(sm.msg.Request().Key()))
+		assert.True(t, sm.primaryErr)
+		assert.Nil(t, sm.primary)
+	}
+
+	sc.Commands = []string{"set"}
+	assert.Len(t, c.shadowCopy(msgs), 0)
+}
+
+func TestClusterShadowResult(t *testing.T) {
+	ts := []struct {
+		PrimaryErr, ShadowErr bool
+		PrimaryDur, ShadowDur time.Duration
+		Expect                string
+	}{
+		{Expect: shadowResultOK},
+		{ShadowDur: time.Millisecond, Expect: shadowResultSlower},
+		{ShadowErr: true, Expect: shadowResultError},
+		{PrimaryErr: true, ShadowDur: time.Millisecond, Expect: shadowResultRecover},
+		{PrimaryErr: true, ShadowErr: true, Expect: shadowResultOK},
+	}
+	for _, tt := range ts {
+		assert.Equal(t, tt.Expect, shadowResult(tt.PrimaryErr, tt.ShadowErr, tt.PrimaryDur, tt.ShadowDur))
+	}
+}
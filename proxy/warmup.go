@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"overlord/lib/log"
+	"overlord/proto"
+	"overlord/proto/memcache"
+)
+
+const (
+	warmPoolName = "warm"
+	// warmUpWindow is the count of gets to calculate hit rate of warming node.
+	warmUpWindow = 1000
+)
+
+// warmer counts the gets of warming node until warming ends.
+type warmer struct {
+	addr  string
+	start time.Time
+
+	lock sync.Mutex
+	gets int
+	hits int
+	done int32
+}
+
+func (w *warmer) warming() bool {
+	return atomic.LoadInt32(&w.done) == 0
+}
+
+// count counts one get of node, warming ends by duration or the hit rate of the last window.
+func (w *warmer) count(hit bool, wc *WarmUpConfig) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.gets++
+	if hit {
+		w.hits++
+	}
+	if wc.Duration > 0 && time.Since(w.start) >= time.Duration(wc.Duration)*time.Second {
+		w.end("duration")
+		return
+	}
+	if w.gets < warmUpWindow {
+		return
+	}
+	if wc.HitRate > 0 && float64(w.hits)/float64(w.gets) >= wc.HitRate {
+		w.end("hit rate")
+		return
+	}
+	w.gets, w.hits = 0, 0
+}
+
+func (w *warmer) end(reason string) {
+	if atomic.CompareAndSwapInt32(&w.done, 0, 1) {
+		log.Infof("node(%s) warm up ends by %s after %v", w.addr, reason, time.Since(w.start))
+	}
+}
+
+// initWarmUp inits the warm pool and the warmers of warming nodes.
+func (c *Cluster) initWarmUp() {
+	wc := c.cc.WarmUp
+	if wc == nil {
+		return
+	}
+	c.warmers = make(map[int]*warmer)
+	for _, node := range wc.Nodes {
+		for _, p := range c.pools() {
+			for i, addr := range p.addrs {
+				if addr == node {
+					c.warmers[p.base+i] = &warmer{addr: addr, start: time.Now()}
+				}
+			}
+		}
+	}
+	c.warm = c.addPool(warmPoolName, wc.clusterConfig(c.cc))
+}
+
+// warmUp retries the get misses of warming nodes in mbs on the warm nodes, the hits are replied
+// to client and written back to the warming nodes asynchronously.
+func (c *Cluster) warmUp(mbs []*proto.MsgBatch) {
+	var (
+		gets, warms []*proto.Message
+		nodes       []int
+	)
+	for idx, w := range c.warmers {
+		if !w.warming() {
+			continue
+		}
+		for _, m := range mbs[idx].Msgs() {
+			miss, ok := memcache.IsGet(m.Request())
+			if !ok || m.Err() != nil {
+				continue
+			}
+			w.count(!miss, c.cc.WarmUp)
+			if !miss {
+				continue
+			}
+			wm := proto.NewMessage()
+			wm.Type = proto.CacheTypeMemcache
+			wm.WithRequest(memcache.NewWarmRequest(m.Request()))
+			gets = append(gets, m)
+			warms = append(warms, wm)
+			nodes = append(nodes, idx)
+		}
+	}
+	if len(warms) == 0 {
+		return
+	}
+	wmbs := proto.GetMsgBatchs(len(c.nodeChan))
+	defer func() {
+		proto.PutMsgs(warms)
+		proto.PutMsgBatchs(wmbs)
+	}()
+	for _, wm := range warms {
+		node, ok := c.warm.ring.GetNode(c.hashKey(wm.Request().Key()))
+		if !ok {
+			return
+		}
+		wmbs[c.warm.nodeMap[node]].AddMsg(wm)
+	}
+	c.deliver(wmbs)
+	for _, mb := range wmbs {
+		mb.Wait()
+	}
+	var (
+		adds []*proto.Message
+		idxs []int
+	)
+	for i, wm := range warms {
+		if wm.Err() != nil {
+			continue
+		}
+		add := memcache.Warm(gets[i].Request(), wm.Request(), c.cc.WarmUp.DefaultTTL)
+		if add == nil {
+			continue
+		}
+		am := proto.NewMessage()
+		am.Type = proto.CacheTypeMemcache
+		am.WithRequest(add)
+		adds = append(adds, am)
+		idxs = append(idxs, nodes[i])
+	}
+	if len(adds) > 0 {
+		go c.writeBack(adds, idxs)
+	}
+}
+
+// writeBack writes the adds back to the warming nodes of idxs, the keys written meanwhile are not stored.
+func (c *Cluster) writeBack(adds []*proto.Message, idxs []int) {
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	for i, am := range adds {
+		mbs[idxs[i]].AddMsg(am)
+	}
+	c.deliver(mbs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	for _, am := range adds {
+		if err := am.Err(); err != nil && log.V(2) {
+			log.Warnf("cluster(%s) Msg(%s) warm up write back error:%v", c.cc.Name, am.Request().Key(), err)
+		}
+	}
+	proto.PutMsgs(adds)
+	proto.PutMsgBatchs(mbs)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"overlord/proto"
+	"overlord/proto/memcache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterWarmUpMiss(t *testing.T) {
+	nl, node := _serveMemcache(t, map[string]string{"b": "y"})
+	defer nl.Close()
+	wl, _ := _serveMemcache(t, map[string]string{"a": "x", "d": "w"})
+	defer wl.Close()
+	cc := &ClusterConfig{
+		Name:             "warm",
+		CacheType:        proto.CacheTypeMemcache,
+		HashMethod:       "fnv1a_64",
+		HashDistribution: "ketama",
+		DialTimeout:      100,
+		ReadTimeout:      100,
+		WriteTimeout:     100,
+		NodeConnections:  1,
+		Servers:          []string{nl.Addr().String() + ":1"},
+		WarmUp:           &WarmUpConfig{Nodes: []string{nl.Addr().String()}, Servers: []string{wl.Addr().String() + ":1"}, Duration: 60},
+	}
+	assert.NoError(t, cc.Validate())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCluster(ctx, cc)
+	assert.Len(t, c.warmers, 1)
+
+	msgs := _createMemcacheMsgs(t, "get a b c\r\n", 1)
+	mbs := proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.warmUp(mbs)
+	for i, key := range []string{"a", "b", "c"} {
+		miss, ok := memcache.IsGet(msgs[0].Batch()[i].Request())
+		assert.True(t, ok)
+		assert.Equal(t, key == "c", miss, key)
+	}
+	// NOTE: the hit of warm nodes is written back asynchronously.
+	for i := 0; i < 100; i++ {
+		if _, ok := node.get("a"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, ok := node.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "x", v)
+	proto.PutMsgs(msgs)
+	proto.PutMsgBatchs(mbs)
+
+	msgs = _createMemcacheMsgs(t, "gets d\r\n", 1)
+	mbs = proto.GetMsgBatchs(len(c.nodeChan))
+	c.DispatchBatch(mbs, msgs)
+	for _, mb := range mbs {
+		mb.Wait()
+	}
+	c.warmUp(mbs)
+	assert.Contains(t, msgs[0].Request().(*memcache.MCRequest).String(), "VALUE d 0 1 0\r\nw\r\nEND\r\n")
+	for i := 0; i < 100; i++ {
+		if _, ok = node.get("d"); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	v, ok = node.get("d")
+	assert.True(t, ok)
+	assert.Equal(t, "w", v)
+	proto.PutMsgs(msgs)
+	proto.PutMsgBatchs(mbs)
+}
+
+func TestClusterWarmUpEnd(t *testing.T) {
+	wc := &WarmUpConfig{HitRate: 0.5}
+	w := &warmer{start: time.Now()}
+	for i := 0; i < warmUpWindow; i++ {
+		w.count(i%3 == 0, wc)
+	}
+	assert.True(t, w.warming())
+	for i := 0; i < warmUpWindow; i++ {
+		w.count(i%3 != 0, wc)
+	}
+	assert.False(t, w.warming())
+
+	w = &warmer{start: time.Now().Add(-time.Minute)}
+	w.count(false, &WarmUpConfig{Duration: 30})
+	assert.False(t, w.warming())
+}